@@ -0,0 +1,46 @@
+// Command mfa-enrollment issues a one-time 2FA enrolment code for an account,
+// for operators bootstrapping the first admin when no admin can sign in to
+// issue one from the API:
+//
+//	go run ./cmd/mfa-enrollment -email admin@example.com
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/joho/godotenv"
+
+	"trumall/internal/db"
+	"trumall/internal/models"
+	"trumall/internal/services"
+)
+
+func main() {
+	email := flag.String("email", "", "email of the account to enrol")
+	flag.Parse()
+	if *email == "" {
+		log.Fatal("-email is required")
+	}
+
+	if err := godotenv.Load(".env"); err != nil {
+		log.Println("Using system environment variables")
+	}
+
+	dbConn, err := db.Connect()
+	if err != nil {
+		log.Fatalf("failed to connect to db: %v", err)
+	}
+
+	var user models.User
+	if err := dbConn.Where("email = ?", *email).First(&user).Error; err != nil {
+		log.Fatalf("failed to find %s: %v", *email, err)
+	}
+
+	code, expiresAt, err := services.NewMFAService(dbConn).IssueEnrollmentCode(&user)
+	if err != nil {
+		log.Fatalf("failed to issue enrolment code: %v", err)
+	}
+	fmt.Printf("Enrolment code for %s: %s (expires %s)\n", *email, code, expiresAt.Format("2006-01-02 15:04 MST"))
+}
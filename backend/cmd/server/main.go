@@ -3,14 +3,20 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/joho/godotenv"
 
+	"trumall/internal/cache"
+	"trumall/internal/courier"
 	"trumall/internal/db"
 	"trumall/internal/handlers"
 	"trumall/internal/middleware"
+	"trumall/internal/notify"
+	"trumall/internal/services"
+	"trumall/internal/sms"
 	"trumall/mpesa"
 	"trumall/payments"
 )
@@ -36,13 +42,41 @@ func main() {
 		log.Fatalf("failed to connect to db: %v", err)
 	}
 
+	// Shipping quote cache: in-memory by default, Redis when CACHE_BACKEND=redis
+	shippingCache, err := cache.NewFromEnv[services.ShippingCalculation]("shipping")
+	if err != nil {
+		log.Fatalf("failed to set up shipping cache: %v", err)
+	}
+	services.ShippingCache = shippingCache
+
+	// Evict cached shipping quotes whenever rates, stores or addresses change
+	if err := services.RegisterShippingCacheInvalidation(dbConn); err != nil {
+		log.Fatalf("failed to register shipping cache hooks: %v", err)
+	}
+
+	// SMS gateway (console stand-in unless SMS_PROVIDER is configured)
+	smsSender := sms.NewSenderFromEnv()
+	couriers := courier.NewRegistryFromEnv()
+
+	// Deliver queued work (M-Pesa STK pushes) once the transactions that queued it commit
+	services.NewOutbox(dbConn).Start(5 * time.Second)
+
+	// Abandoned cart reminders go out through the configured notifier
+	notifier := notify.NewNotifierFromEnv(smsSender)
+	abandonedCarts := services.NewAbandonedCartService(dbConn, notifier, services.AbandonedCartAfterFromEnv())
+	abandonedCarts.StartSweeper(15 * time.Minute)
+
+	// Guest carts left past their expiry are cleared out
+	services.NewGuestCartService(dbConn).StartPurger(time.Hour)
+
 	// Fiber app
 	app := fiber.New()
 
 	// Enable CORS for all origins
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
+		AllowOrigins:  "*",
+		AllowHeaders:  "Origin, Content-Type, Accept, Authorization, X-Cart-Token, Idempotency-Key",
+		ExposeHeaders: "X-Cart-Token, Idempotent-Replayed",
 	}))
 
 	// Serve static files
@@ -60,6 +94,26 @@ func main() {
 	app.Post("/api/auth/register", handlers.RegisterHandler(dbConn))
 	app.Post("/api/auth/login", handlers.LoginHandler(dbConn))
 	app.Get("/api/me", middleware.RequireAuth(dbConn), handlers.GetMeHandler(dbConn))
+	app.Get("/api/me/export", middleware.RequireAuth(dbConn), handlers.ExportAccountDataHandler(dbConn))
+	app.Delete("/api/me", middleware.RequireAuth(dbConn), handlers.DeleteAccountHandler(dbConn))
+	app.Put("/api/me/cart-reminders", middleware.RequireAuth(dbConn), handlers.UpdateCartRemindersHandler(dbConn))
+
+	// Phone number (SMS OTP) login and verification
+	app.Post("/api/auth/phone/request-otp", handlers.RequestPhoneOTPHandler(dbConn, smsSender))
+	app.Post("/api/auth/phone/verify", handlers.PhoneLoginHandler(dbConn, smsSender))
+	app.Post("/api/me/phone/request-otp", middleware.RequireAuth(dbConn), handlers.RequestPhoneVerificationHandler(dbConn, smsSender))
+	app.Post("/api/me/phone/verify", middleware.RequireAuth(dbConn), handlers.VerifyPhoneHandler(dbConn, smsSender))
+
+	// Two-factor authentication: second login step (MFA pending token)
+	app.Post("/api/auth/mfa/verify", middleware.RequireMFAPending(dbConn), handlers.VerifyMFAHandler(dbConn))
+	app.Post("/api/auth/mfa/enroll", middleware.RequireMFAPending(dbConn), handlers.BeginMFAEnrollmentHandler(dbConn))
+	app.Post("/api/auth/mfa/enroll/confirm", middleware.RequireMFAPending(dbConn), handlers.ConfirmMFAEnrollmentHandler(dbConn))
+	// Two-factor authentication: account settings (session token)
+	app.Get("/api/me/mfa", middleware.RequireAuth(dbConn), handlers.GetMFAStatusHandler(dbConn))
+	app.Post("/api/me/mfa/enroll", middleware.RequireAuth(dbConn), handlers.BeginMFAEnrollmentHandler(dbConn))
+	app.Post("/api/me/mfa/enroll/confirm", middleware.RequireAuth(dbConn), handlers.ConfirmMFAEnrollmentHandler(dbConn))
+	app.Post("/api/me/mfa/recovery-codes", middleware.RequireAuth(dbConn), handlers.RegenerateRecoveryCodesHandler(dbConn))
+	app.Delete("/api/me/mfa", middleware.RequireAuth(dbConn), handlers.DisableMFAHandler(dbConn))
 
 	// STORES
 	app.Post("/api/stores", middleware.RequireAuth(dbConn), handlers.CreateStoreHandler(dbConn))
@@ -72,41 +126,87 @@ func main() {
 	app.Get("/api/my-stores", middleware.RequireAuth(dbConn), handlers.GetMyStoresHandler(dbConn))
 	app.Put("/api/stores/:id", middleware.RequireAuth(dbConn), handlers.UpdateStoreHandler(dbConn))
 
+	// Store staff (store-scoped roles are enforced by the authz policy in each handler,
+	// so these and the /api/seller routes no longer require the global seller role)
+	app.Get("/api/stores/:id/staff", middleware.RequireAuth(dbConn), handlers.ListStoreStaffHandler(dbConn))
+	app.Put("/api/stores/:id/staff/:userId", middleware.RequireAuth(dbConn), handlers.UpdateStoreStaffHandler(dbConn))
+	app.Delete("/api/stores/:id/staff/:userId", middleware.RequireAuth(dbConn), handlers.RemoveStoreStaffHandler(dbConn))
+	app.Post("/api/stores/:id/invitations", middleware.RequireAuth(dbConn), handlers.CreateStoreInvitationHandler(dbConn))
+	app.Delete("/api/stores/:id/invitations/:invitationId", middleware.RequireAuth(dbConn), handlers.RevokeStoreInvitationHandler(dbConn))
+
+	// Store shipping profiles (the store's own rates and delivery area)
+	app.Get("/api/stores/:id/shipping-profile", handlers.GetStoreShippingProfileHandler(dbConn))
+	app.Put("/api/stores/:id/shipping-profile", middleware.RequireAuth(dbConn), handlers.UpdateStoreShippingProfileHandler(dbConn))
+	app.Delete("/api/stores/:id/shipping-profile", middleware.RequireAuth(dbConn), handlers.DeleteStoreShippingProfileHandler(dbConn))
+	app.Get("/api/stores/:id/coupons", middleware.RequireAuth(dbConn), handlers.ListStoreCouponsHandler(dbConn))
+	app.Post("/api/stores/:id/coupons", middleware.RequireAuth(dbConn), middleware.Idempotency(dbConn), handlers.CreateStoreCouponHandler(dbConn))
+	app.Put("/api/stores/:id/coupons/:couponId", middleware.RequireAuth(dbConn), handlers.UpdateStoreCouponHandler(dbConn))
+	app.Delete("/api/stores/:id/coupons/:couponId", middleware.RequireAuth(dbConn), handlers.DeleteStoreCouponHandler(dbConn))
+	app.Get("/api/stores/:id/abandoned-carts", middleware.RequireAuth(dbConn), handlers.StoreAbandonedCartMetricsHandler(dbConn, abandonedCarts))
+	app.Post("/api/invitations/accept", middleware.RequireAuth(dbConn), handlers.AcceptStoreInvitationHandler(dbConn))
+	app.Get("/api/me/staff-stores", middleware.RequireAuth(dbConn), handlers.GetMyStaffMembershipsHandler(dbConn))
+
 	// Products
 	app.Post("/api/products", middleware.RequireAuth(dbConn), handlers.CreateProductHandler(dbConn))
 	app.Get("/api/products", handlers.ListProductsHandler(dbConn))
 	app.Get("/api/products/search", handlers.SearchProductsHandler(dbConn))
 	app.Get("/api/products/:id", handlers.GetProductHandler(dbConn))
 	app.Get("/api/stores/:id/products", handlers.ListProductsByStoreHandler(dbConn))
-	app.Post("/api/stores/:id/products", middleware.RequireAuth(dbConn), middleware.RequireRole("seller"), handlers.CreateProductHandler(dbConn))
+	app.Post("/api/stores/:id/products", middleware.RequireAuth(dbConn), handlers.CreateProductHandler(dbConn))
 
-	app.Put("/api/products/:id", middleware.RequireAuth(dbConn), handlers.UpdateProductHandler(dbConn))
+	app.Put("/api/products/:id", middleware.RequireAuthOrAPIKey(dbConn, services.ScopeProductsWrite), handlers.UpdateProductHandler(dbConn))
 	app.Delete("/api/products/:id", middleware.RequireAuth(dbConn), handlers.DeleteProductHandler(dbConn))
 
 	// Seller Products
-	app.Get("/api/seller/products", middleware.RequireAuth(dbConn), middleware.RequireRole("seller"), handlers.GetSellerProductsHandler(dbConn))
-	app.Delete("/api/seller/products/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("seller"), handlers.DeleteSellerProductHandler(dbConn))
+	app.Get("/api/products/:id/sales", middleware.RequireAuthOrAPIKey(dbConn, services.ScopeProductsRead), handlers.ListProductSalesHandler(dbConn))
+	app.Post("/api/products/:id/sales", middleware.RequireAuthOrAPIKey(dbConn, services.ScopeProductsWrite), middleware.Idempotency(dbConn), handlers.CreateProductSaleHandler(dbConn))
+	app.Put("/api/products/:id/sales/:saleId", middleware.RequireAuthOrAPIKey(dbConn, services.ScopeProductsWrite), handlers.UpdateProductSaleHandler(dbConn))
+	app.Delete("/api/products/:id/sales/:saleId", middleware.RequireAuthOrAPIKey(dbConn, services.ScopeProductsWrite), handlers.DeleteProductSaleHandler(dbConn))
+	app.Get("/api/sales/active", handlers.ListActiveSalesHandler(dbConn))
+	app.Get("/api/seller/products", middleware.RequireAuthOrAPIKey(dbConn, services.ScopeProductsRead), handlers.GetSellerProductsHandler(dbConn))
+	app.Delete("/api/seller/products/:id", middleware.RequireAuth(dbConn), handlers.DeleteSellerProductHandler(dbConn))
+
+	// Seller integrations: API keys and inventory sync
+	app.Post("/api/seller/api-keys", middleware.RequireAuth(dbConn), middleware.RequireRole("seller"), handlers.CreateAPIKeyHandler(dbConn))
+	app.Get("/api/seller/api-keys", middleware.RequireAuth(dbConn), middleware.RequireRole("seller"), handlers.ListAPIKeysHandler(dbConn))
+	app.Delete("/api/seller/api-keys/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("seller"), handlers.RevokeAPIKeyHandler(dbConn))
+	app.Put("/api/seller/inventory", middleware.RequireAuthOrAPIKey(dbConn, services.ScopeInventoryWrite), handlers.UpdateInventoryHandler(dbConn))
 
 	// Orders
 	app.Get("/api/orders", middleware.RequireAuth(dbConn), handlers.GetOrdersHandler(dbConn))
-	app.Get("/api/seller/orders", middleware.RequireAuth(dbConn), middleware.RequireRole("seller"), handlers.GetSellerOrdersHandler(dbConn))
-	app.Put("/api/seller/orders/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("seller"), handlers.UpdateOrderStatusHandler(dbConn))
+	app.Get("/api/seller/orders", middleware.RequireAuthOrAPIKey(dbConn, services.ScopeOrdersRead), handlers.GetSellerOrdersHandler(dbConn))
+	app.Put("/api/seller/orders/:id", middleware.RequireAuth(dbConn), handlers.UpdateOrderStatusHandler(dbConn))
+
+	// Shipments and tracking
+	app.Get("/api/couriers", middleware.RequireAuth(dbConn), handlers.ListCouriersHandler(couriers))
+	app.Get("/api/orders/:id/shipments", middleware.RequireAuth(dbConn), handlers.ListOrderShipmentsHandler(dbConn, couriers))
+	app.Post("/api/seller/orders/:id/shipments", middleware.RequireAuth(dbConn), handlers.CreateShipmentHandler(dbConn, couriers))
+	app.Get("/api/seller/shipments/:id/label", middleware.RequireAuth(dbConn), handlers.ShipmentLabelHandler(dbConn, couriers))
+	app.Post("/api/seller/shipments/:id/events", middleware.RequireAuth(dbConn), handlers.AddShipmentEventHandler(dbConn, couriers))
+	app.Post("/api/webhooks/couriers/:courier", handlers.CourierWebhookHandler(dbConn, couriers))
 
 	// Payments / Webhooks
 
 	app.Get("/api/payments/status", middleware.RequireAuth(dbConn), handlers.GetPaymentStatusHandler(dbConn))
 
 	//cart
-	app.Post("/api/cart/add", middleware.RequireAuth(dbConn), handlers.AddToCartHandler(dbConn))
-	app.Post("/api/cart/increase", middleware.RequireAuth(dbConn), handlers.IncreaseCartItemQuantityHandler(dbConn))
-	app.Post("/api/cart/decrease", middleware.RequireAuth(dbConn), handlers.DecreaseCartItemQuantityHandler(dbConn))
-	app.Get("/api/cart", middleware.RequireAuth(dbConn), handlers.GetCartHandler(dbConn))
-	app.Delete("/api/cart/:id", middleware.RequireAuth(dbConn), handlers.RemoveFromCartHandler(dbConn))
-	app.Post("/api/cart/checkout", middleware.RequireAuth(dbConn), handlers.CheckoutHandler(dbConn))
+	// Guests may build a cart too; it is merged into their account when they sign in
+	app.Post("/api/cart/add", middleware.OptionalAuth(dbConn), handlers.AddToCartHandler(dbConn))
+	app.Post("/api/cart/increase", middleware.OptionalAuth(dbConn), handlers.IncreaseCartItemQuantityHandler(dbConn))
+	app.Post("/api/cart/decrease", middleware.OptionalAuth(dbConn), handlers.DecreaseCartItemQuantityHandler(dbConn))
+	app.Get("/api/cart", middleware.OptionalAuth(dbConn), handlers.GetCartHandler(dbConn))
+	app.Put("/api/cart/items", middleware.OptionalAuth(dbConn), handlers.UpdateCartItemsHandler(dbConn))
+	app.Put("/api/cart/items/:id", middleware.OptionalAuth(dbConn), handlers.SetCartItemQuantityHandler(dbConn))
+	app.Post("/api/cart/items/:id/save-for-later", middleware.OptionalAuth(dbConn), handlers.SaveCartItemForLaterHandler(dbConn))
+	app.Post("/api/cart/items/:id/move-to-cart", middleware.OptionalAuth(dbConn), handlers.MoveCartItemToCartHandler(dbConn))
+	app.Get("/api/cart/saved", middleware.OptionalAuth(dbConn), handlers.GetSavedForLaterHandler(dbConn))
+	app.Delete("/api/cart/:id", middleware.OptionalAuth(dbConn), handlers.RemoveFromCartHandler(dbConn))
+	app.Post("/api/cart/apply-coupon", middleware.RequireAuth(dbConn), handlers.ApplyCouponHandler(dbConn))
+	app.Post("/api/cart/checkout", middleware.RequireAuth(dbConn), middleware.Idempotency(dbConn), handlers.CheckoutHandler(dbConn))
 
 	//mpesa API
 	app.Post("/api/mpesa/callback", mpesa.StkCallbackHandler(dbConn))
-	app.Post("/api/payments/mpesa", payments.CreateMpesaPaymentHandler(dbConn))
+	app.Post("/api/payments/mpesa", middleware.RequireAuth(dbConn), middleware.Idempotency(dbConn), payments.CreateMpesaPaymentHandler(dbConn))
 
 	// Addresses
 	app.Post("/api/addresses", middleware.RequireAuth(dbConn), handlers.CreateAddress)
@@ -120,23 +220,56 @@ func main() {
 	app.Post("/api/shipping/calculate", middleware.RequireAuth(dbConn), handlers.CalculateShippingHandler(dbConn))
 	app.Get("/api/shipping/methods", middleware.RequireAuth(dbConn), handlers.GetAvailableShippingMethodsHandler(dbConn))
 	app.Get("/api/shipping/methods/all", handlers.ListShippingMethodsHandler(dbConn))
+	app.Get("/api/shipping/zones", handlers.ListActiveShippingZonesHandler(dbConn))
+	app.Get("/api/pickup-points/nearest", handlers.NearestPickupPointsHandler(dbConn))
 
 	// Admin: Shipping Management
 	app.Post("/api/admin/shipping/methods", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.CreateShippingMethodHandler(dbConn))
 	app.Get("/api/admin/shipping/methods", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.AdminListShippingMethodsHandler(dbConn))
 	app.Put("/api/admin/shipping/methods/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.UpdateShippingMethodHandler(dbConn))
 	app.Delete("/api/admin/shipping/methods/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.DeleteShippingMethodHandler(dbConn))
+	app.Get("/api/admin/shipping/methods/:id/distance-bands", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.ListDistanceBandsHandler(dbConn))
+	app.Put("/api/admin/shipping/methods/:id/distance-bands", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.ReplaceDistanceBandsHandler(dbConn))
 
 	app.Post("/api/admin/shipping/zones", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.CreateShippingZoneHandler(dbConn))
 	app.Get("/api/admin/shipping/zones", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.ListShippingZonesHandler(dbConn))
+	app.Get("/api/admin/shipping/cache", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.ShippingCacheStatsHandler())
+	app.Delete("/api/admin/shipping/cache", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.FlushShippingCacheHandler())
+	app.Post("/api/admin/shipping/zones/test", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.TestShippingZoneAddressHandler(dbConn))
 	app.Put("/api/admin/shipping/zones/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.UpdateShippingZoneHandler(dbConn))
 	app.Delete("/api/admin/shipping/zones/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.DeleteShippingZoneHandler(dbConn))
 
+	app.Get("/api/admin/pickup-points", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.AdminListPickupPointsHandler(dbConn))
+	app.Post("/api/admin/pickup-points", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.CreatePickupPointHandler(dbConn))
+	app.Put("/api/admin/pickup-points/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.UpdatePickupPointHandler(dbConn))
+	app.Delete("/api/admin/pickup-points/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.DeletePickupPointHandler(dbConn))
+
+	app.Get("/api/admin/delivery/holidays", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.ListDeliveryHolidaysHandler(dbConn))
+	app.Post("/api/admin/delivery/holidays", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.CreateDeliveryHolidayHandler(dbConn))
+	app.Delete("/api/admin/delivery/holidays/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.DeleteDeliveryHolidayHandler(dbConn))
+
 	app.Post("/api/admin/shipping/rules", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.CreateShippingRuleHandler(dbConn))
 	app.Get("/api/admin/shipping/rules", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.ListShippingRulesHandler(dbConn))
 	app.Put("/api/admin/shipping/rules/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.UpdateShippingRuleHandler(dbConn))
 	app.Delete("/api/admin/shipping/rules/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.DeleteShippingRuleHandler(dbConn))
 
+	app.Get("/api/admin/coupons", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.AdminListCouponsHandler(dbConn))
+	app.Post("/api/admin/coupons", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), middleware.Idempotency(dbConn), handlers.AdminCreateCouponHandler(dbConn))
+	app.Put("/api/admin/coupons/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.AdminUpdateCouponHandler(dbConn))
+	app.Delete("/api/admin/coupons/:id", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.AdminDeleteCouponHandler(dbConn))
+
+	app.Get("/api/admin/abandoned-carts", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.AdminAbandonedCartMetricsHandler(abandonedCarts))
+	app.Post("/api/admin/abandoned-carts/sweep", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.AdminSweepAbandonedCartsHandler(abandonedCarts))
+
+	app.Get("/api/admin/shipping/rates/export", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.ExportShippingRatesHandler(dbConn))
+	app.Post("/api/admin/shipping/rates/import/preview", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.PreviewShippingRatesHandler(dbConn))
+	app.Post("/api/admin/shipping/rates/import", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.ImportShippingRatesHandler(dbConn))
+
+	// Admin: Security
+	app.Get("/api/admin/security/mfa-policies", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.ListMFAPoliciesHandler(dbConn))
+	app.Put("/api/admin/security/mfa-policies/:role", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.UpdateMFAPolicyHandler(dbConn))
+	app.Post("/api/admin/users/:id/mfa-enrollment-code", middleware.RequireAuth(dbConn), middleware.RequireRole("admin"), handlers.IssueMFAEnrollmentCodeHandler(dbConn))
+
 	// Favorites/Wishlist
 	app.Post("/api/favorites", middleware.RequireAuth(dbConn), handlers.AddToFavoritesHandler(dbConn))
 	app.Delete("/api/favorites/:productId", middleware.RequireAuth(dbConn), handlers.RemoveFromFavoritesHandler(dbConn))
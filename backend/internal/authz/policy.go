@@ -0,0 +1,146 @@
+package authz
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+// Action is something a user can do within a store
+type Action string
+
+const (
+	ActionStoreUpdate    Action = "store:update"
+	ActionStaffManage    Action = "staff:manage"
+	ActionProductsRead   Action = "products:read"
+	ActionProductsWrite  Action = "products:write"
+	ActionProductsDelete Action = "products:delete"
+	ActionInventoryWrite Action = "inventory:write"
+	ActionOrdersRead     Action = "orders:read"
+	ActionOrdersUpdate   Action = "orders:update"
+)
+
+// Store staff roles
+const (
+	RoleManager        = "manager"
+	RoleInventoryClerk = "inventory_clerk"
+	RoleFulfilment     = "fulfilment"
+)
+
+// rolePermissions lists what each staff role may do. Store owners (and platform
+// admins) may do everything, including managing staff.
+var rolePermissions = map[string][]Action{
+	RoleManager: {
+		ActionStoreUpdate,
+		ActionProductsRead, ActionProductsWrite, ActionProductsDelete,
+		ActionInventoryWrite,
+		ActionOrdersRead, ActionOrdersUpdate,
+	},
+	RoleInventoryClerk: {
+		ActionProductsRead,
+		ActionInventoryWrite,
+	},
+	RoleFulfilment: {
+		ActionProductsRead,
+		ActionOrdersRead, ActionOrdersUpdate,
+	},
+}
+
+// ValidStaffRole reports whether role is a known staff role
+func ValidStaffRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleAllows reports whether a staff role grants the action
+func RoleAllows(role string, action Action) bool {
+	for _, a := range rolePermissions[role] {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// rolesAllowing returns every staff role that grants the action
+func rolesAllowing(action Action) []string {
+	var roles []string
+	for role := range rolePermissions {
+		if RoleAllows(role, action) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// Policy answers "can user U do action A on store S"
+type Policy struct {
+	db *gorm.DB
+}
+
+func NewPolicy(db *gorm.DB) *Policy {
+	return &Policy{db: db}
+}
+
+func isAdmin(user models.User) bool {
+	for _, r := range user.Roles {
+		if r == "admin" {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the user may perform the action on the store
+func (p *Policy) Can(user models.User, action Action, storeID uuid.UUID) (bool, error) {
+	if isAdmin(user) {
+		return true, nil
+	}
+
+	var store models.Store
+	if err := p.db.Select("id", "owner_id").First(&store, "id = ?", storeID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if store.OwnerID == user.ID {
+		return true, nil
+	}
+
+	roles := rolesAllowing(action)
+	if len(roles) == 0 {
+		return false, nil
+	}
+
+	var count int64
+	if err := p.db.Model(&models.StoreStaff{}).
+		Where("store_id = ? AND user_id = ? AND role IN ?", storeID, user.ID, roles).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// StoresFor returns the IDs of every store where the user may perform the action:
+// stores they own plus stores where their staff role grants it
+func (p *Policy) StoresFor(user models.User, action Action) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	if err := p.db.Model(&models.Store{}).Where("owner_id = ?", user.ID).Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	roles := rolesAllowing(action)
+	if len(roles) > 0 {
+		var staffIDs []uuid.UUID
+		if err := p.db.Model(&models.StoreStaff{}).
+			Where("user_id = ? AND role IN ?", user.ID, roles).
+			Pluck("store_id", &staffIDs).Error; err != nil {
+			return nil, err
+		}
+		ids = append(ids, staffIDs...)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,95 @@
+package authz
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"trumall/internal/models"
+	"trumall/internal/testdb"
+)
+
+func TestRoleAllows(t *testing.T) {
+	cases := []struct {
+		role   string
+		action Action
+		want   bool
+	}{
+		{RoleManager, ActionStoreUpdate, true},
+		{RoleManager, ActionOrdersUpdate, true},
+		{RoleManager, ActionStaffManage, false},
+		{RoleInventoryClerk, ActionInventoryWrite, true},
+		{RoleInventoryClerk, ActionProductsWrite, false},
+		{RoleInventoryClerk, ActionOrdersRead, false},
+		{RoleFulfilment, ActionOrdersUpdate, true},
+		{RoleFulfilment, ActionInventoryWrite, false},
+		{"owner", ActionProductsRead, false},
+	}
+	for _, tc := range cases {
+		if got := RoleAllows(tc.role, tc.action); got != tc.want {
+			t.Errorf("RoleAllows(%s, %s) = %v; want %v", tc.role, tc.action, got, tc.want)
+		}
+	}
+	if ValidStaffRole("owner") || !ValidStaffRole(RoleFulfilment) {
+		t.Error("ValidStaffRole accepts only the staff roles")
+	}
+}
+
+func TestPolicyCan(t *testing.T) {
+	db := testdb.Open(t, &models.User{}, &models.Store{}, &models.StoreStaff{})
+	owner := models.User{ID: uuid.New(), Roles: pq.StringArray{"seller"}}
+	clerk := models.User{ID: uuid.New(), Roles: pq.StringArray{"seller"}}
+	stranger := models.User{ID: uuid.New(), Roles: pq.StringArray{"seller"}}
+	admin := models.User{ID: uuid.New(), Roles: pq.StringArray{"admin"}}
+
+	store := models.Store{OwnerID: owner.ID, Name: "Store"}
+	other := models.Store{OwnerID: stranger.ID, Name: "Other"}
+	db.Create(&store)
+	db.Create(&other)
+	db.Create(&models.StoreStaff{StoreID: store.ID, UserID: clerk.ID, Role: RoleInventoryClerk, InvitedBy: owner.ID})
+
+	policy := NewPolicy(db)
+	cases := []struct {
+		name    string
+		user    models.User
+		action  Action
+		storeID uuid.UUID
+		want    bool
+	}{
+		{"owner manages staff", owner, ActionStaffManage, store.ID, true},
+		{"clerk writes inventory", clerk, ActionInventoryWrite, store.ID, true},
+		{"clerk can't update orders", clerk, ActionOrdersUpdate, store.ID, false},
+		{"clerk can't manage staff", clerk, ActionStaffManage, store.ID, false},
+		{"clerk has no role in another store", clerk, ActionInventoryWrite, other.ID, false},
+		{"stranger", stranger, ActionProductsRead, store.ID, false},
+		{"admin anywhere", admin, ActionStaffManage, store.ID, true},
+		{"unknown store", owner, ActionProductsRead, uuid.New(), false},
+	}
+	for _, tc := range cases {
+		got, err := policy.Can(tc.user, tc.action, tc.storeID)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: Can = %v; want %v", tc.name, got, tc.want)
+		}
+	}
+
+	ids, err := policy.StoresFor(clerk, ActionInventoryWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != store.ID {
+		t.Errorf("StoresFor(clerk, inventory) = %v; want [%s]", ids, store.ID)
+	}
+	if ids, _ := policy.StoresFor(clerk, ActionOrdersRead); len(ids) != 0 {
+		t.Errorf("StoresFor(clerk, orders) = %v; want none", ids)
+	}
+	ids, _ = policy.StoresFor(stranger, ActionOrdersRead)
+	sort.Slice(ids, func(a, b int) bool { return ids[a].String() < ids[b].String() })
+	if len(ids) != 1 || ids[0] != other.ID {
+		t.Errorf("StoresFor(owner of other) = %v; want [%s]", ids, other.ID)
+	}
+}
@@ -1,72 +1,191 @@
 package cache
 
 import (
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type CacheItem struct {
-	Value      interface{}
+// Cache is a typed key/value cache whose entries can be invalidated by tag or
+// key prefix as well as by key
+type Cache[V any] interface {
+	Get(key string) (V, bool)
+	Set(key string, value V, ttl time.Duration, tags ...string)
+	Delete(key string)
+	// InvalidateTag removes every entry set with the tag and returns how many
+	InvalidateTag(tag string) int
+	// InvalidatePrefix removes every entry whose key starts with prefix
+	InvalidatePrefix(prefix string) int
+	Clear()
+	Stats() Stats
+}
+
+// Stats are a cache's counters since start-up
+type Stats struct {
+	Name          string  `json:"name"`
+	Backend       string  `json:"backend"`
+	Entries       int     `json:"entries"`
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	HitRate       float64 `json:"hit_rate"`
+	Sets          uint64  `json:"sets"`
+	Invalidations uint64  `json:"invalidations"`
+}
+
+// counters are shared by cache implementations
+type counters struct {
+	hits, misses, sets, invalidations atomic.Uint64
+}
+
+func (c *counters) stats(name, backend string, entries int) Stats {
+	s := Stats{
+		Name:          name,
+		Backend:       backend,
+		Entries:       entries,
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Sets:          c.sets.Load(),
+		Invalidations: c.invalidations.Load(),
+	}
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRate = float64(s.Hits) / float64(total)
+	}
+	return s
+}
+
+type CacheItem[V any] struct {
+	Value      V
 	Expiration time.Time
+	Tags       []string
 }
 
-type InMemoryCache struct {
-	items map[string]CacheItem
+// InMemoryCache is a process-local Cache
+type InMemoryCache[V any] struct {
+	name  string
+	items map[string]CacheItem[V]
+	tags  map[string]map[string]struct{} // tag -> keys
 	mu    sync.RWMutex
+	counters
 }
 
-func NewInMemoryCache() *InMemoryCache {
-	cache := &InMemoryCache{
-		items: make(map[string]CacheItem),
+func NewInMemoryCache[V any](name string) *InMemoryCache[V] {
+	cache := &InMemoryCache[V]{
+		name:  name,
+		items: make(map[string]CacheItem[V]),
+		tags:  make(map[string]map[string]struct{}),
 	}
 	// Start cleanup goroutine
 	go cache.cleanup()
 	return cache
 }
 
-func (c *InMemoryCache) Set(key string, value interface{}, duration time.Duration) {
+func (c *InMemoryCache[V]) Set(key string, value V, duration time.Duration, tags ...string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.items[key] = CacheItem{
+	c.deleteLocked(key)
+	c.items[key] = CacheItem[V]{
 		Value:      value,
 		Expiration: time.Now().Add(duration),
+		Tags:       tags,
+	}
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]struct{})
+		}
+		c.tags[tag][key] = struct{}{}
 	}
+	c.sets.Add(1)
 }
 
-func (c *InMemoryCache) Get(key string) (interface{}, bool) {
+func (c *InMemoryCache[V]) Get(key string) (V, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	item, exists := c.items[key]
-	if !exists {
-		return nil, false
-	}
-
 	// Check if expired
-	if time.Now().After(item.Expiration) {
-		return nil, false
+	if !exists || time.Now().After(item.Expiration) {
+		c.misses.Add(1)
+		var zero V
+		return zero, false
 	}
 
+	c.hits.Add(1)
 	return item.Value, true
 }
 
-func (c *InMemoryCache) Delete(key string) {
+func (c *InMemoryCache[V]) Delete(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	delete(c.items, key)
+	c.deleteLocked(key)
+}
+
+func (c *InMemoryCache[V]) InvalidateTag(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := c.tags[tag]
+	n := len(keys)
+	for key := range keys {
+		c.deleteLocked(key)
+	}
+	delete(c.tags, tag)
+	c.invalidations.Add(uint64(n))
+	return n
 }
 
-func (c *InMemoryCache) Clear() {
+func (c *InMemoryCache[V]) InvalidatePrefix(prefix string) int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.items = make(map[string]CacheItem)
+	n := 0
+	for key := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.deleteLocked(key)
+			n++
+		}
+	}
+	c.invalidations.Add(uint64(n))
+	return n
+}
+
+func (c *InMemoryCache[V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidations.Add(uint64(len(c.items)))
+	c.items = make(map[string]CacheItem[V])
+	c.tags = make(map[string]map[string]struct{})
+}
+
+func (c *InMemoryCache[V]) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.stats(c.name, "memory", len(c.items))
+}
+
+// deleteLocked removes a key and its tag memberships; c.mu must be held
+func (c *InMemoryCache[V]) deleteLocked(key string) {
+	item, exists := c.items[key]
+	if !exists {
+		return
+	}
+	for _, tag := range item.Tags {
+		if keys := c.tags[tag]; keys != nil {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(c.tags, tag)
+			}
+		}
+	}
+	delete(c.items, key)
 }
 
 // cleanup runs periodically to remove expired items
-func (c *InMemoryCache) cleanup() {
+func (c *InMemoryCache[V]) cleanup() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 
@@ -75,12 +194,9 @@ func (c *InMemoryCache) cleanup() {
 		now := time.Now()
 		for key, item := range c.items {
 			if now.After(item.Expiration) {
-				delete(c.items, key)
+				c.deleteLocked(key)
 			}
 		}
 		c.mu.Unlock()
 	}
 }
-
-// Global cache instance
-var ShippingCache = NewInMemoryCache()
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInMemoryCacheSetGet(t *testing.T) {
+	c := NewInMemoryCache[int]("test")
+	c.Set("a", 1, time.Minute)
+	c.Set("stale", 2, -time.Second)
+
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %d, %v; want 1, true", v, ok)
+	}
+	if _, ok := c.Get("stale"); ok {
+		t.Error("expired entry was returned")
+	}
+	if _, ok := c.Get("missing"); ok {
+		t.Error("missing entry was returned")
+	}
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Error("deleted entry was returned")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Sets != 2 {
+		t.Errorf("hits %d, misses %d, sets %d; want 1, 3, 2", stats.Hits, stats.Misses, stats.Sets)
+	}
+}
+
+func TestInMemoryCacheInvalidation(t *testing.T) {
+	cases := []struct {
+		name       string
+		invalidate func(c *InMemoryCache[int]) int
+		evicted    int
+		left       []string
+	}{
+		{"tag", func(c *InMemoryCache[int]) int { return c.InvalidateTag("store:1") }, 2, []string{"quote:3"}},
+		{"tag shared by all", func(c *InMemoryCache[int]) int { return c.InvalidateTag("rates") }, 3, nil},
+		{"unknown tag", func(c *InMemoryCache[int]) int { return c.InvalidateTag("store:9") }, 0, []string{"quote:1", "quote:2", "quote:3"}},
+		{"prefix", func(c *InMemoryCache[int]) int { return c.InvalidatePrefix("quote:1") }, 1, []string{"quote:2", "quote:3"}},
+	}
+	for _, tc := range cases {
+		c := NewInMemoryCache[int]("test")
+		c.Set("quote:1", 1, time.Minute, "rates", "store:1")
+		c.Set("quote:2", 2, time.Minute, "rates", "store:1")
+		c.Set("quote:3", 3, time.Minute, "rates", "store:2")
+
+		if n := tc.invalidate(c); n != tc.evicted {
+			t.Errorf("%s: evicted %d; want %d", tc.name, n, tc.evicted)
+		}
+		if entries := c.Stats().Entries; entries != len(tc.left) {
+			t.Errorf("%s: %d entries left; want %d", tc.name, entries, len(tc.left))
+		}
+		for _, key := range tc.left {
+			if _, ok := c.Get(key); !ok {
+				t.Errorf("%s: %s was evicted", tc.name, key)
+			}
+		}
+	}
+}
+
+func TestInMemoryCacheSetReplacesTags(t *testing.T) {
+	c := NewInMemoryCache[int]("test")
+	c.Set("quote", 1, time.Minute, "store:1")
+	c.Set("quote", 2, time.Minute, "store:2")
+
+	if n := c.InvalidateTag("store:1"); n != 0 {
+		t.Errorf("old tag evicted %d entries; want 0", n)
+	}
+	if v, ok := c.Get("quote"); !ok || v != 2 {
+		t.Errorf("Get(quote) = %d, %v; want 2, true", v, ok)
+	}
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache backends selectable with CACHE_BACKEND
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+// defaultLocalTTL is how long a Redis-backed instance may serve its local copy
+const defaultLocalTTL = 30 * time.Second
+
+var (
+	redisOnce   sync.Once
+	redisClient *redis.Client
+	redisErr    error
+)
+
+// RedisClientFromEnv returns the shared client for REDIS_URL
+// (default redis://localhost:6379/0), checked with a PING
+func RedisClientFromEnv() (*redis.Client, error) {
+	redisOnce.Do(func() {
+		url := os.Getenv("REDIS_URL")
+		if url == "" {
+			url = "redis://localhost:6379/0"
+		}
+		opts, err := redis.ParseURL(url)
+		if err != nil {
+			redisErr = fmt.Errorf("invalid REDIS_URL: %w", err)
+			return
+		}
+		client := redis.NewClient(opts)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Ping(ctx).Err(); err != nil {
+			redisErr = fmt.Errorf("redis ping failed: %w", err)
+			return
+		}
+		redisClient = client
+	})
+	return redisClient, redisErr
+}
+
+// NewFromEnv builds a named cache using CACHE_BACKEND (memory or redis, default
+// memory). CACHE_LOCAL_TTL (e.g. "30s", "0" to disable) sets how long Redis-backed
+// instances keep a local copy of values.
+func NewFromEnv[V any](name string) (Cache[V], error) {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("CACHE_BACKEND")))
+	switch backend {
+	case "", BackendMemory:
+		return NewInMemoryCache[V](name), nil
+	case BackendRedis:
+		client, err := RedisClientFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		localTTL := defaultLocalTTL
+		if v := os.Getenv("CACHE_LOCAL_TTL"); v != "" {
+			if localTTL, err = time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf("invalid CACHE_LOCAL_TTL: %w", err)
+			}
+		}
+		return NewRedisCache[V](client, name, localTTL), nil
+	default:
+		return nil, fmt.Errorf("unknown CACHE_BACKEND %q (want memory or redis)", backend)
+	}
+}
@@ -0,0 +1,247 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// tagTTL bounds how long a tag's key set outlives its entries. Stale members are
+// harmless: invalidating them deletes keys that have already expired.
+const tagTTL = 24 * time.Hour
+
+// invalidateTagScript takes a tag's key set and deletes it and its entries in
+// one step. Done as separate commands, a Set landing in between adds its key to
+// a set that is then deleted, and that entry could not be invalidated by tag
+// again until it expired.
+var invalidateTagScript = redis.NewScript(`
+local keys = redis.call('SMEMBERS', KEYS[1])
+redis.call('DEL', KEYS[1])
+local deleted = 0
+for _, key in ipairs(keys) do
+	deleted = deleted + redis.call('DEL', ARGV[1] .. key)
+end
+return deleted
+`)
+
+// invalidation is broadcast so other instances evict their local copies
+type invalidation struct {
+	Origin string `json:"origin"`
+	Op     string `json:"op"` // key | tag | prefix | clear
+	Value  string `json:"value,omitempty"`
+}
+
+// RedisCache stores JSON-encoded values in Redis so every API instance shares
+// them. Reads are served from a short-lived local copy when possible; writes and
+// invalidations are published on a channel so other instances drop theirs.
+type RedisCache[V any] struct {
+	name     string
+	client   *redis.Client
+	prefix   string
+	channel  string
+	instance string
+	local    *InMemoryCache[V]
+	localTTL time.Duration
+	counters
+}
+
+// NewRedisCache creates a cache named name on client. localTTL caps how long an
+// instance serves a value without going back to Redis; 0 disables the local copy.
+func NewRedisCache[V any](client *redis.Client, name string, localTTL time.Duration) *RedisCache[V] {
+	c := &RedisCache[V]{
+		name:     name,
+		client:   client,
+		prefix:   "cache:" + name + ":",
+		channel:  "cache:" + name + ":invalidate",
+		instance: uuid.NewString(),
+		localTTL: localTTL,
+	}
+	if localTTL > 0 {
+		c.local = NewInMemoryCache[V](name + "-local")
+		go c.subscribe()
+	}
+	return c
+}
+
+func (c *RedisCache[V]) key(key string) string { return c.prefix + "k:" + key }
+func (c *RedisCache[V]) tag(tag string) string { return c.prefix + "t:" + tag }
+
+func (c *RedisCache[V]) Get(key string) (V, bool) {
+	if c.local != nil {
+		if v, ok := c.local.Get(key); ok {
+			c.hits.Add(1)
+			return v, true
+		}
+	}
+
+	var value V
+	raw, err := c.client.Get(context.Background(), c.key(key)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("Cache %s: redis get failed: %v", c.name, err)
+		}
+		c.misses.Add(1)
+		return value, false
+	}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		log.Printf("Cache %s: dropping undecodable entry %s: %v", c.name, key, err)
+		c.client.Del(context.Background(), c.key(key))
+		c.misses.Add(1)
+		return value, false
+	}
+
+	c.hits.Add(1)
+	if c.local != nil {
+		c.local.Set(key, value, c.localTTL)
+	}
+	return value, true
+}
+
+func (c *RedisCache[V]) Set(key string, value V, ttl time.Duration, tags ...string) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Cache %s: cannot encode %s: %v", c.name, key, err)
+		return
+	}
+
+	ctx := context.Background()
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, c.key(key), raw, ttl)
+		for _, tag := range tags {
+			pipe.SAdd(ctx, c.tag(tag), key)
+			pipe.Expire(ctx, c.tag(tag), tagTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Cache %s: redis set failed: %v", c.name, err)
+		return
+	}
+	c.sets.Add(1)
+
+	if c.local != nil {
+		localTTL := c.localTTL
+		if ttl < localTTL {
+			localTTL = ttl
+		}
+		c.local.Set(key, value, localTTL)
+		// Another instance may hold an older copy of this key
+		c.publish("key", key)
+	}
+}
+
+func (c *RedisCache[V]) Delete(key string) {
+	if err := c.client.Del(context.Background(), c.key(key)).Err(); err != nil {
+		log.Printf("Cache %s: redis delete failed: %v", c.name, err)
+	}
+	c.evictLocal("key", key)
+	c.publish("key", key)
+}
+
+func (c *RedisCache[V]) InvalidateTag(tag string) int {
+	deleted, err := invalidateTagScript.Run(context.Background(), c.client, []string{c.tag(tag)}, c.key("")).Int()
+	if err != nil {
+		log.Printf("Cache %s: redis tag invalidation failed: %v", c.name, err)
+	}
+
+	c.invalidations.Add(uint64(deleted))
+	c.evictLocal("tag", tag)
+	c.publish("tag", tag)
+	return deleted
+}
+
+func (c *RedisCache[V]) InvalidatePrefix(prefix string) int {
+	n := c.deleteMatching(c.key(prefix) + "*")
+	c.invalidations.Add(uint64(n))
+	c.evictLocal("prefix", prefix)
+	c.publish("prefix", prefix)
+	return n
+}
+
+func (c *RedisCache[V]) Clear() {
+	n := c.deleteMatching(c.prefix + "*")
+	c.invalidations.Add(uint64(n))
+	c.evictLocal("clear", "")
+	c.publish("clear", "")
+}
+
+// Stats reports this instance's hit/miss counters and the shared entry count
+func (c *RedisCache[V]) Stats() Stats {
+	entries := 0
+	iter := c.client.Scan(context.Background(), 0, c.key("")+"*", 500).Iterator()
+	for iter.Next(context.Background()) {
+		entries++
+	}
+	if err := iter.Err(); err != nil {
+		log.Printf("Cache %s: redis scan failed: %v", c.name, err)
+	}
+	return c.stats(c.name, "redis", entries)
+}
+
+// deleteMatching removes keys matching a glob and returns how many cache entries
+// (not tag sets) were removed
+func (c *RedisCache[V]) deleteMatching(pattern string) int {
+	ctx := context.Background()
+	entryPrefix := c.key("")
+	n := 0
+	iter := c.client.Scan(ctx, 0, pattern, 500).Iterator()
+	for iter.Next(ctx) {
+		k := iter.Val()
+		if err := c.client.Del(ctx, k).Err(); err != nil {
+			log.Printf("Cache %s: redis delete failed: %v", c.name, err)
+			continue
+		}
+		if strings.HasPrefix(k, entryPrefix) {
+			n++
+		}
+	}
+	if err := iter.Err(); err != nil {
+		log.Printf("Cache %s: redis scan failed: %v", c.name, err)
+	}
+	return n
+}
+
+func (c *RedisCache[V]) evictLocal(op, value string) {
+	if c.local == nil {
+		return
+	}
+	switch op {
+	case "key":
+		c.local.Delete(value)
+	case "tag":
+		// Local copies don't carry tags, so any tag drops them all
+		c.local.Clear()
+	case "prefix":
+		c.local.InvalidatePrefix(value)
+	case "clear":
+		c.local.Clear()
+	}
+}
+
+func (c *RedisCache[V]) publish(op, value string) {
+	if c.local == nil {
+		return
+	}
+	msg, _ := json.Marshal(invalidation{Origin: c.instance, Op: op, Value: value})
+	if err := c.client.Publish(context.Background(), c.channel, msg).Err(); err != nil {
+		log.Printf("Cache %s: publish invalidation failed: %v", c.name, err)
+	}
+}
+
+// subscribe applies other instances' invalidations to the local copy
+func (c *RedisCache[V]) subscribe() {
+	sub := c.client.Subscribe(context.Background(), c.channel)
+	for msg := range sub.Channel() {
+		var inv invalidation
+		if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil || inv.Origin == c.instance {
+			continue
+		}
+		c.evictLocal(inv.Op, inv.Value)
+	}
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+// eventually polls cond until it holds or a second has passed
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForSubscribers waits until n instances are listening for invalidations
+func waitForSubscribers(t *testing.T, mr *miniredis.Miniredis, channel string, n int) {
+	t.Helper()
+	eventually(t, "subscribers", func() bool {
+		return mr.PubSubNumSub(channel)[channel] >= n
+	})
+}
+
+func TestRedisCacheSetGet(t *testing.T) {
+	mr, client := newTestRedis(t)
+	a := NewRedisCache[string](client, "test", 0)
+	b := NewRedisCache[string](client, "test", 0)
+
+	if _, ok := a.Get("missing"); ok {
+		t.Fatal("Get of a missing key reported a hit")
+	}
+
+	a.Set("greeting", "hello", time.Minute)
+	if v, ok := b.Get("greeting"); !ok || v != "hello" {
+		t.Fatalf("Get from another instance = %q, %v; want hello, true", v, ok)
+	}
+
+	mr.FastForward(2 * time.Minute)
+	if _, ok := b.Get("greeting"); ok {
+		t.Fatal("Get after the TTL reported a hit")
+	}
+
+	stats := b.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("stats = %d hits, %d misses; want 1, 1", stats.Hits, stats.Misses)
+	}
+}
+
+func TestRedisCacheInvalidateTag(t *testing.T) {
+	mr, client := newTestRedis(t)
+	c := NewRedisCache[int](client, "test", 0)
+
+	c.Set("a", 1, time.Minute, "rates")
+	c.Set("b", 2, time.Minute, "rates", "store:1")
+	c.Set("c", 3, time.Minute, "store:1")
+
+	if n := c.InvalidateTag("rates"); n != 2 {
+		t.Fatalf("InvalidateTag(rates) = %d; want 2", n)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, ok := c.Get(key); ok {
+			t.Fatalf("%s survived invalidation of its tag", key)
+		}
+	}
+	if v, ok := c.Get("c"); !ok || v != 3 {
+		t.Fatalf("Get(c) = %d, %v; want 3, true", v, ok)
+	}
+	if mr.Exists(c.tag("rates")) {
+		t.Fatal("the tag's key set was not removed")
+	}
+
+	// An entry set after an invalidation belongs to a fresh tag set and is
+	// invalidated by the next one
+	c.Set("a", 4, time.Minute, "rates")
+	if n := c.InvalidateTag("rates"); n != 1 {
+		t.Fatalf("second InvalidateTag(rates) = %d; want 1", n)
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("an entry set after an invalidation survived the next one")
+	}
+
+	if n := c.InvalidateTag("unknown"); n != 0 {
+		t.Fatalf("InvalidateTag(unknown) = %d; want 0", n)
+	}
+}
+
+func TestRedisCachePubSubEviction(t *testing.T) {
+	mr, client := newTestRedis(t)
+	a := NewRedisCache[string](client, "test", time.Minute)
+	b := NewRedisCache[string](client, "test", time.Minute)
+	waitForSubscribers(t, mr, a.channel, 2)
+
+	a.Set("k", "v1", time.Minute, "rates")
+	if v, ok := b.Get("k"); !ok || v != "v1" {
+		t.Fatalf("b.Get(k) = %q, %v; want v1, true", v, ok)
+	}
+
+	// b now serves k from its local copy; a's write must evict it there
+	a.Set("k", "v2", time.Minute, "rates")
+	eventually(t, "b to see the new value", func() bool {
+		v, ok := b.Get("k")
+		return ok && v == "v2"
+	})
+
+	a.InvalidateTag("rates")
+	eventually(t, "b to drop the invalidated entry", func() bool {
+		_, ok := b.Get("k")
+		return !ok
+	})
+
+	a.Set("other", "x", time.Minute)
+	if _, ok := b.Get("other"); !ok {
+		t.Fatal("b.Get(other) missed")
+	}
+	a.Delete("other")
+	eventually(t, "b to drop the deleted entry", func() bool {
+		_, ok := b.Get("other")
+		return !ok
+	})
+}
@@ -0,0 +1,151 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Normalised shipment statuses. Couriers map their own codes onto these.
+const (
+	StatusLabelCreated   = "label_created"
+	StatusPickedUp       = "picked_up"
+	StatusInTransit      = "in_transit"
+	StatusOutForDelivery = "out_for_delivery"
+	StatusDelivered      = "delivered"
+	StatusException      = "exception"
+	StatusReturned       = "returned"
+)
+
+var validStatuses = map[string]bool{
+	StatusLabelCreated: true, StatusPickedUp: true, StatusInTransit: true, StatusOutForDelivery: true,
+	StatusDelivered: true, StatusException: true, StatusReturned: true,
+}
+
+// ValidStatus reports whether status is one of the normalised statuses
+func ValidStatus(status string) bool { return validStatuses[status] }
+
+var (
+	ErrUnknownCourier       = errors.New("unknown courier")
+	ErrLabelUnsupported     = errors.New("this courier does not issue labels")
+	ErrWebhookUnsupported   = errors.New("this courier does not send tracking webhooks")
+	ErrInvalidSignature     = errors.New("invalid webhook signature")
+	ErrTrackingNumberNeeded = errors.New("tracking_number is required for this courier")
+)
+
+// Address is a shipment's origin or destination
+type Address struct {
+	Name       string `json:"name"`
+	Phone      string `json:"phone,omitempty"`
+	Street     string `json:"street"`
+	City       string `json:"city"`
+	State      string `json:"state,omitempty"`
+	Country    string `json:"country"`
+	PostalCode string `json:"postal_code,omitempty"`
+}
+
+// ShipmentRequest asks a courier to carry an order's parcel
+type ShipmentRequest struct {
+	Reference   string // our order ID, echoed back by couriers
+	Origin      Address
+	Destination Address
+	WeightGrams int
+	// TrackingNumber is supplied by the seller for couriers that don't issue one
+	TrackingNumber string
+	CarrierName    string
+}
+
+// Booking is a courier's answer to a ShipmentRequest
+type Booking struct {
+	TrackingNumber string
+	CarrierName    string
+	ProviderRef    string // the courier's own shipment ID, if different
+	Status         string // initial status; defaults to label_created
+}
+
+// Label is a printable shipping label
+type Label struct {
+	ContentType string
+	Data        []byte
+}
+
+// TrackingEvent is one status update for a parcel
+type TrackingEvent struct {
+	TrackingNumber string    `json:"tracking_number"`
+	Status         string    `json:"status"`
+	Description    string    `json:"description"`
+	Location       string    `json:"location,omitempty"`
+	OccurredAt     time.Time `json:"occurred_at"`
+	// EventID identifies the event at the courier so redelivered webhooks are ignored
+	EventID string `json:"event_id,omitempty"`
+}
+
+// Courier books shipments with a carrier and decodes its tracking webhooks
+type Courier interface {
+	Code() string
+	Name() string
+	CreateShipment(ctx context.Context, req ShipmentRequest) (*Booking, error)
+	Label(ctx context.Context, trackingNumber string) (*Label, error)
+	// ParseWebhook verifies and decodes a tracking webhook
+	ParseWebhook(headers http.Header, body []byte) ([]TrackingEvent, error)
+}
+
+// Registry holds the couriers sellers can choose from
+type Registry struct {
+	couriers map[string]Courier
+}
+
+func NewRegistry(couriers ...Courier) *Registry {
+	r := &Registry{couriers: map[string]Courier{}}
+	for _, c := range couriers {
+		r.couriers[c.Code()] = c
+	}
+	return r
+}
+
+// Get returns the courier with the given code
+func (r *Registry) Get(code string) (Courier, error) {
+	c, ok := r.couriers[code]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCourier, code)
+	}
+	return c, nil
+}
+
+// Codes lists the registered couriers
+func (r *Registry) Codes() []string {
+	codes := make([]string, 0, len(r.couriers))
+	for code := range r.couriers {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// NewRegistryFromEnv registers the manual courier plus any listed in COURIERS
+// (comma-separated). Only "fake" is built in besides manual for now.
+func NewRegistryFromEnv() *Registry {
+	couriers := []Courier{ManualCourier{}}
+	for _, code := range strings.Split(os.Getenv("COURIERS"), ",") {
+		switch strings.TrimSpace(code) {
+		case "", ManualCode:
+		case FakeCode:
+			// Its webhooks can move any order along, so it needs a secret
+			secret := os.Getenv("COURIER_FAKE_SECRET")
+			if secret == "" {
+				log.Printf("[courier] COURIER_FAKE_SECRET is not set, skipping the fake courier")
+				continue
+			}
+			couriers = append(couriers, NewFakeCourier(secret))
+		default:
+			log.Printf("[courier] unknown courier %q in COURIERS, skipping", code)
+		}
+	}
+	return NewRegistry(couriers...)
+}
@@ -0,0 +1,55 @@
+package courier
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func signed(f *FakeCourier, body string) http.Header {
+	h := http.Header{}
+	h.Set(FakeSignatureHeader, f.Sign([]byte(body)))
+	return h
+}
+
+func TestFakeCourierParseWebhook(t *testing.T) {
+	f := NewFakeCourier("secret")
+	body := `{"events":[{"tracking_number":"FAKE1","status":"in_transit","event_id":"e1"}]}`
+
+	events, err := f.ParseWebhook(signed(f, body), []byte(body))
+	if err != nil {
+		t.Fatalf("ParseWebhook: %v", err)
+	}
+	if len(events) != 1 || events[0].TrackingNumber != "FAKE1" || events[0].Status != StatusInTransit || events[0].EventID != "e1" {
+		t.Fatalf("events = %+v", events)
+	}
+	if events[0].OccurredAt.IsZero() {
+		t.Error("an event without occurred_at was not given the receipt time")
+	}
+
+	invalid := `{"events":[{"tracking_number":"FAKE1","status":"lost"}]}`
+	if _, err := f.ParseWebhook(signed(f, invalid), []byte(invalid)); err == nil || errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("unknown status: err = %v; want a validation error", err)
+	}
+}
+
+func TestFakeCourierRejectsBadSignatures(t *testing.T) {
+	f := NewFakeCourier("secret")
+	body := `{"events":[{"tracking_number":"FAKE1","status":"delivered"}]}`
+
+	cases := map[string]struct {
+		courier *FakeCourier
+		headers http.Header
+	}{
+		"unsigned":        {f, http.Header{}},
+		"wrong secret":    {f, signed(NewFakeCourier("other"), body)},
+		"tampered body":   {f, signed(f, body+" ")},
+		"no secret":       {NewFakeCourier(""), signed(NewFakeCourier(""), body)},
+		"no secret, bare": {NewFakeCourier(""), http.Header{}},
+	}
+	for name, tc := range cases {
+		if _, err := tc.courier.ParseWebhook(tc.headers, []byte(body)); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: err = %v; want ErrInvalidSignature", name, err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+const ManualCode = "manual"
+
+// ManualCourier records parcels a seller sends with any carrier themselves. The
+// seller supplies the tracking number and posts status updates by hand.
+type ManualCourier struct{}
+
+func (ManualCourier) Code() string { return ManualCode }
+func (ManualCourier) Name() string { return "Manual" }
+
+func (ManualCourier) CreateShipment(ctx context.Context, req ShipmentRequest) (*Booking, error) {
+	tracking := strings.TrimSpace(req.TrackingNumber)
+	if tracking == "" {
+		return nil, ErrTrackingNumberNeeded
+	}
+	carrier := strings.TrimSpace(req.CarrierName)
+	if carrier == "" {
+		carrier = "Seller arranged"
+	}
+	// Sellers record a manual shipment once the parcel has left
+	return &Booking{TrackingNumber: tracking, CarrierName: carrier, Status: StatusInTransit}, nil
+}
+
+func (ManualCourier) Label(ctx context.Context, trackingNumber string) (*Label, error) {
+	return nil, ErrLabelUnsupported
+}
+
+func (ManualCourier) ParseWebhook(headers http.Header, body []byte) ([]TrackingEvent, error) {
+	return nil, ErrWebhookUnsupported
+}
@@ -0,0 +1,28 @@
+package geo
+
+import "math"
+
+// EarthRadiusKm is the mean Earth radius used for great-circle distances
+const EarthRadiusKm = 6371.0
+
+// DistanceKm returns the great-circle (Haversine) distance between two points
+// given in decimal degrees
+func DistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	lat1Rad := lat1 * math.Pi / 180
+	lat2Rad := lat2 * math.Pi / 180
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1Rad)*math.Cos(lat2Rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// HasCoordinates reports whether a lat/lng pair has been set. The models store
+// coordinates as plain floats, so 0,0 means "unknown".
+func HasCoordinates(lat, lng float64) bool {
+	if lat == 0 && lng == 0 {
+		return false
+	}
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/authz"
+	"trumall/internal/models"
+	"trumall/internal/services"
+)
+
+// abandonmentPeriodDays is the metrics period when no dates are given
+const abandonmentPeriodDays = 30
+
+// StoreAbandonedCartMetricsHandler reports a store's abandoned carts, reminders
+// and recoveries, for carts found abandoned between ?from= and ?to=
+// (YYYY-MM-DD, both inclusive; the last 30 days by default)
+func StoreAbandonedCartMetricsHandler(db *gorm.DB, carts *services.AbandonedCartService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		storeID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid store ID"})
+		}
+		user := c.Locals("user").(models.User)
+		if ok, resp := authorizeStore(c, db, user, authz.ActionOrdersRead, storeID); !ok {
+			return resp
+		}
+
+		from, to, err := abandonmentPeriod(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		metrics, err := carts.Metrics(&storeID, from, to)
+		if err != nil {
+			log.Printf("Error loading abandoned cart metrics for store %s: %v", storeID, err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to load abandoned cart metrics"})
+		}
+		return c.JSON(fiber.Map{"from": from, "to": to, "metrics": metrics[0]})
+	}
+}
+
+// Admin: abandoned cart metrics for every store, most abandoned first
+func AdminAbandonedCartMetricsHandler(carts *services.AbandonedCartService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		from, to, err := abandonmentPeriod(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		metrics, err := carts.Metrics(nil, from, to)
+		if err != nil {
+			log.Printf("Error loading abandoned cart metrics: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to load abandoned cart metrics"})
+		}
+		return c.JSON(fiber.Map{"from": from, "to": to, "stores": metrics})
+	}
+}
+
+// Admin: run the abandoned cart sweep now rather than waiting for the next one
+func AdminSweepAbandonedCartsHandler(carts *services.AbandonedCartService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sweep, err := carts.Sweep()
+		if err != nil {
+			log.Printf("Error sweeping abandoned carts: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to sweep abandoned carts"})
+		}
+		return c.JSON(sweep)
+	}
+}
+
+// UpdateCartRemindersHandler turns the buyer's abandoned cart reminders on or off
+func UpdateCartRemindersHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := c.Locals("user").(models.User)
+
+		var body struct {
+			CartReminders *bool `json:"cart_reminders"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.CartReminders == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "cart_reminders required"})
+		}
+		if err := db.Model(&user).Update("cart_reminders", *body.CartReminders).Error; err != nil {
+			log.Printf("Error updating cart reminders for user %s: %v", user.ID, err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to update preferences"})
+		}
+		return c.JSON(fiber.Map{"cart_reminders": *body.CartReminders})
+	}
+}
+
+// abandonmentPeriod reads ?from= and ?to= as whole days
+func abandonmentPeriod(c *fiber.Ctx) (time.Time, time.Time, error) {
+	today := time.Now().Truncate(24 * time.Hour)
+	from := today.AddDate(0, 0, -abandonmentPeriodDays+1)
+	to := today.AddDate(0, 0, 1)
+	if raw := c.Query("from"); raw != "" {
+		day, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return from, to, errors.New("from must be a date (YYYY-MM-DD)")
+		}
+		from = day
+	}
+	if raw := c.Query("to"); raw != "" {
+		day, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return from, to, errors.New("to must be a date (YYYY-MM-DD)")
+		}
+		to = day.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		return from, to, errors.New("to must not be before from")
+	}
+	return from, to, nil
+}
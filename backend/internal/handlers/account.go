@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/services"
+)
+
+// accountDeletionConfirmation must be sent verbatim to delete an account
+const accountDeletionConfirmation = "DELETE MY ACCOUNT"
+
+// ExportAccountDataHandler returns everything held about the user as a downloadable
+// JSON file (default) or zip archive (?format=zip)
+func ExportAccountDataHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		format := c.Query("format", "json")
+		if format != "json" && format != "zip" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "format must be json or zip"})
+		}
+
+		export, err := services.NewAccountDataService(db).Export(user.ID)
+		if err != nil {
+			log.Printf("Error exporting data for user %s: %v", user.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to export account data"})
+		}
+
+		filename := fmt.Sprintf("trustmall-export-%s", time.Now().Format("20060102"))
+		if format == "zip" {
+			var buf bytes.Buffer
+			if err := export.WriteZip(&buf); err != nil {
+				log.Printf("Error writing export archive for user %s: %v", user.ID, err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to build archive"})
+			}
+			c.Set(fiber.HeaderContentType, "application/zip")
+			c.Attachment(filename + ".zip")
+			return c.Send(buf.Bytes())
+		}
+
+		c.Attachment(filename + ".json")
+		return c.JSON(export)
+	}
+}
+
+// DeleteAccountHandler permanently deletes the signed-in account. Orders and payments
+// are anonymised rather than deleted so financial records stay intact.
+func DeleteAccountHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		var body struct {
+			Confirm  string `json:"confirm"`
+			Password string `json:"password"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if body.Confirm != accountDeletionConfirmation {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("confirm must be %q", accountDeletionConfirmation)})
+		}
+
+		// Password accounts must re-enter their password; phone-only accounts rely on the session
+		if user.PasswordHash != "" {
+			if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(body.Password)); err != nil {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid password"})
+			}
+		}
+
+		if err := services.NewAccountDataService(db).DeleteAccount(user); err != nil {
+			if errors.Is(err, services.ErrAccountOwnsStores) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+			}
+			log.Printf("Error deleting account %s: %v", user.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to delete account"})
+		}
+
+		return c.JSON(fiber.Map{"message": "account deleted"})
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"trumall/internal/db"
 	"trumall/internal/models"
+	"trumall/internal/services"
 	"trumall/internal/validation"
 )
 
@@ -37,7 +38,7 @@ func CreateAddress(c *fiber.Ctx) error {
 	input.UserID = userID
 
 	// Use a transaction to handle default address logic
-	err := db.DB.Transaction(func(tx *gorm.DB) error {
+	err := services.ShippingTransaction(db.DB, func(tx *gorm.DB) error {
 		// If first address for user, set default automatically
 		var count int64
 		if err := tx.Model(&models.Address{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
@@ -1,23 +1,29 @@
 package handlers
 
 import (
+	"errors"
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 
 	"trumall/internal/models"
+	"trumall/internal/services"
 )
 
 // Admin: Create Shipping Method
 func CreateShippingMethodHandler(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var method models.ShippingMethod
-		if err := c.BodyParser(&method); err != nil {
+		var input services.ShippingMethodInput
+		if err := c.BodyParser(&input); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
 		}
 
-		if err := db.Create(&method).Error; err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "failed to create shipping method"})
+		method := models.ShippingMethod{Type: services.ShippingTypeDelivery, DeliveryDaysMin: 1, DeliveryDaysMax: 3, IsActive: true}
+		input.Apply(&method)
+		if err := services.NewShippingService(db).SaveShippingMethod(&method); err != nil {
+			return shippingAdminError(c, err, "failed to create shipping method")
 		}
 
 		return c.Status(201).JSON(method)
@@ -38,12 +44,14 @@ func UpdateShippingMethodHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(404).JSON(fiber.Map{"error": "shipping method not found"})
 		}
 
-		if err := c.BodyParser(&method); err != nil {
+		var input services.ShippingMethodInput
+		if err := c.BodyParser(&input); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
 		}
 
-		if err := db.Save(&method).Error; err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "failed to update shipping method"})
+		input.Apply(&method)
+		if err := services.NewShippingService(db).SaveShippingMethod(&method); err != nil {
+			return shippingAdminError(c, err, "failed to update shipping method")
 		}
 
 		return c.JSON(method)
@@ -82,13 +90,15 @@ func AdminListShippingMethodsHandler(db *gorm.DB) fiber.Handler {
 // Admin: Create Shipping Zone
 func CreateShippingZoneHandler(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var zone models.ShippingZone
-		if err := c.BodyParser(&zone); err != nil {
+		var input services.ShippingZoneInput
+		if err := c.BodyParser(&input); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
 		}
 
-		if err := db.Create(&zone).Error; err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "failed to create shipping zone"})
+		zone := models.ShippingZone{Country: "Kenya", IsActive: true}
+		input.Apply(&zone)
+		if err := services.NewShippingService(db).SaveShippingZone(&zone); err != nil {
+			return shippingAdminError(c, err, "failed to create shipping zone")
 		}
 
 		return c.Status(201).JSON(zone)
@@ -109,12 +119,14 @@ func UpdateShippingZoneHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(404).JSON(fiber.Map{"error": "shipping zone not found"})
 		}
 
-		if err := c.BodyParser(&zone); err != nil {
+		var input services.ShippingZoneInput
+		if err := c.BodyParser(&input); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
 		}
 
-		if err := db.Save(&zone).Error; err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "failed to update shipping zone"})
+		input.Apply(&zone)
+		if err := services.NewShippingService(db).SaveShippingZone(&zone); err != nil {
+			return shippingAdminError(c, err, "failed to update shipping zone")
 		}
 
 		return c.JSON(zone)
@@ -130,8 +142,8 @@ func DeleteShippingZoneHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid zone id"})
 		}
 
-		if err := db.Delete(&models.ShippingZone{}, "id = ?", zoneID).Error; err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "failed to delete shipping zone"})
+		if err := services.NewShippingService(db).DeleteShippingZone(zoneID); err != nil {
+			return shippingAdminError(c, err, "failed to delete shipping zone")
 		}
 
 		return c.JSON(fiber.Map{"message": "shipping zone deleted"})
@@ -150,16 +162,66 @@ func ListShippingZonesHandler(db *gorm.DB) fiber.Handler {
 	}
 }
 
+// Admin: Test which Shipping Zone an Address resolves to, and why
+func TestShippingZoneAddressHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body struct {
+			AddressID  *uuid.UUID `json:"address_id"`
+			City       string     `json:"city"`
+			State      string     `json:"state"`
+			Country    string     `json:"country"`
+			PostalCode string     `json:"postal_code"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+
+		address := models.Address{
+			City:       strings.TrimSpace(body.City),
+			State:      strings.TrimSpace(body.State),
+			Country:    strings.TrimSpace(body.Country),
+			PostalCode: strings.TrimSpace(body.PostalCode),
+		}
+		if body.AddressID != nil {
+			if err := db.First(&address, "id = ?", *body.AddressID).Error; err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": "address not found"})
+			}
+		}
+		if address.Country == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "country is required"})
+		}
+
+		match, candidates, err := services.NewShippingService(db).ExplainZone(address)
+		if err != nil && !errors.Is(err, services.ErrNoMatchingZone) {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to resolve shipping zone"})
+		}
+
+		return c.JSON(fiber.Map{
+			"address": fiber.Map{
+				"city":        address.City,
+				"state":       address.State,
+				"country":     address.Country,
+				"postal_code": address.PostalCode,
+			},
+			"matched":    match != nil,
+			"match":      match,
+			"candidates": candidates,
+		})
+	}
+}
+
 // Admin: Create Shipping Rule
 func CreateShippingRuleHandler(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var rule models.ShippingRule
-		if err := c.BodyParser(&rule); err != nil {
+		var input services.ShippingRuleInput
+		if err := c.BodyParser(&input); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
 		}
 
-		if err := db.Create(&rule).Error; err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "failed to create shipping rule"})
+		rule := models.ShippingRule{IsAvailable: true}
+		input.Apply(&rule)
+		if err := services.NewShippingService(db).SaveShippingRule(&rule); err != nil {
+			return shippingAdminError(c, err, "failed to create shipping rule")
 		}
 
 		return c.Status(201).JSON(rule)
@@ -180,12 +242,14 @@ func UpdateShippingRuleHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(404).JSON(fiber.Map{"error": "shipping rule not found"})
 		}
 
-		if err := c.BodyParser(&rule); err != nil {
+		var input services.ShippingRuleInput
+		if err := c.BodyParser(&input); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
 		}
 
-		if err := db.Save(&rule).Error; err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "failed to update shipping rule"})
+		input.Apply(&rule)
+		if err := services.NewShippingService(db).SaveShippingRule(&rule); err != nil {
+			return shippingAdminError(c, err, "failed to update shipping rule")
 		}
 
 		return c.JSON(rule)
@@ -220,3 +284,92 @@ func ListShippingRulesHandler(db *gorm.DB) fiber.Handler {
 		return c.JSON(rules)
 	}
 }
+
+// Admin: List a Shipping Method's Distance Rate Table
+func ListDistanceBandsHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		methodID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid method id"})
+		}
+
+		bands, err := services.NewShippingService(db).ListDistanceBands(methodID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch distance bands"})
+		}
+
+		return c.JSON(bands)
+	}
+}
+
+// Admin: Replace a Shipping Method's Distance Rate Table
+func ReplaceDistanceBandsHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		methodID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid method id"})
+		}
+
+		var body struct {
+			Bands []struct {
+				MinKm     float64  `json:"min_km"`
+				MaxKm     *float64 `json:"max_km"`
+				CostCents int64    `json:"cost_cents"`
+			} `json:"bands"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+
+		bands := make([]models.ShippingDistanceBand, 0, len(body.Bands))
+		for _, b := range body.Bands {
+			bands = append(bands, models.ShippingDistanceBand{
+				MinKm:     b.MinKm,
+				MaxKm:     b.MaxKm,
+				CostCents: b.CostCents,
+			})
+		}
+		if err := services.ValidateDistanceBands(bands); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		saved, err := services.NewShippingService(db).ReplaceDistanceBands(methodID, bands)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": "shipping method not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "failed to save distance bands"})
+		}
+
+		return c.JSON(saved)
+	}
+}
+
+// Admin: Shipping Quote Cache Stats
+func ShippingCacheStatsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(services.ShippingCache.Stats())
+	}
+}
+
+// Admin: Flush the Shipping Quote Cache
+func FlushShippingCacheHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		services.ShippingCache.Clear()
+		return c.JSON(fiber.Map{"message": "shipping cache cleared"})
+	}
+}
+
+// shippingAdminError maps rate configuration errors to responses
+func shippingAdminError(c *fiber.Ctx, err error, fallback string) error {
+	var invalid services.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error(), "field": invalid.Field})
+	case errors.Is(err, services.ErrShippingMethodCodeTaken), errors.Is(err, services.ErrShippingMethodNameTaken),
+		errors.Is(err, services.ErrShippingZoneNameTaken), errors.Is(err, services.ErrShippingZoneInUse),
+		errors.Is(err, services.ErrShippingRuleExists):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": fallback})
+}
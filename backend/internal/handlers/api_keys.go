@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/authz"
+	"trumall/internal/middleware"
+	"trumall/internal/models"
+	"trumall/internal/services"
+)
+
+// CreateAPIKeyHandler issues a scoped API key bound to some of the seller's stores.
+// The plaintext key is only returned in this response.
+func CreateAPIKeyHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		var body struct {
+			Name          string      `json:"name"`
+			Scopes        []string    `json:"scopes"`
+			StoreIDs      []uuid.UUID `json:"store_ids"`
+			ExpiresAt     *time.Time  `json:"expires_at"`
+			ExpiresInDays *int        `json:"expires_in_days"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+
+		expiresAt := body.ExpiresAt
+		if expiresAt == nil && body.ExpiresInDays != nil {
+			if *body.ExpiresInDays <= 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "expires_in_days must be positive"})
+			}
+			t := time.Now().Add(time.Duration(*body.ExpiresInDays) * 24 * time.Hour)
+			expiresAt = &t
+		}
+
+		key, raw, err := services.NewAPIKeyService(db).Create(user.ID, services.CreateAPIKeyInput{
+			Name:      body.Name,
+			Scopes:    body.Scopes,
+			StoreIDs:  body.StoreIDs,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"api_key": key,
+			"key":     raw,
+		})
+	}
+}
+
+// ListAPIKeysHandler lists the seller's API keys (without secrets)
+func ListAPIKeysHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		keys, err := services.NewAPIKeyService(db).List(user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch api keys"})
+		}
+
+		return c.JSON(keys)
+	}
+}
+
+// RevokeAPIKeyHandler revokes one of the seller's API keys
+func RevokeAPIKeyHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		keyID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid api key id"})
+		}
+
+		if err := services.NewAPIKeyService(db).Revoke(user.ID, keyID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "api key not found"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to revoke api key"})
+		}
+
+		return c.JSON(fiber.Map{"message": "api key revoked"})
+	}
+}
+
+// UpdateInventoryHandler sets stock levels for several products at once, identified
+// by product_id or sku. Intended for sellers syncing from their own inventory systems.
+func UpdateInventoryHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		var body struct {
+			Items []struct {
+				ProductID *uuid.UUID `json:"product_id"`
+				SKU       *string    `json:"sku"`
+				StoreID   *uuid.UUID `json:"store_id"` // required with sku, since skus are per store
+				Stock     int        `json:"stock"`
+			} `json:"items"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if len(body.Items) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "items required"})
+		}
+
+		var updated []models.Product
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for i, item := range body.Items {
+				if item.Stock < 0 {
+					return fiber.NewError(fiber.StatusBadRequest, "stock cannot be negative")
+				}
+
+				var product models.Product
+				query := tx
+				switch {
+				case item.ProductID != nil:
+					query = query.Where("id = ?", *item.ProductID)
+				case item.SKU != nil && item.StoreID != nil:
+					query = query.Where("sku = ? AND store_id = ?", *item.SKU, *item.StoreID)
+				default:
+					return fiber.NewError(fiber.StatusBadRequest, "each item needs product_id, or sku and store_id")
+				}
+				if err := query.First(&product).Error; err != nil {
+					if errors.Is(err, gorm.ErrRecordNotFound) {
+						return fiber.NewError(fiber.StatusNotFound, "product not found for item "+strconv.Itoa(i))
+					}
+					return err
+				}
+
+				allowed, err := authz.NewPolicy(tx).Can(user, authz.ActionInventoryWrite, product.StoreID)
+				if err != nil {
+					return err
+				}
+				if !allowed || !middleware.StoreAllowed(c, product.StoreID) {
+					return fiber.NewError(fiber.StatusForbidden, "you cannot manage stock for item "+strconv.Itoa(i))
+				}
+
+				if err := tx.Model(&product).Update("stock", item.Stock).Error; err != nil {
+					return err
+				}
+				product.Stock = item.Stock
+				updated = append(updated, product)
+			}
+			return nil
+		})
+		if err != nil {
+			var fe *fiber.Error
+			if errors.As(err, &fe) {
+				return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+			}
+			log.Printf("Error updating inventory for seller %s: %v", user.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update inventory"})
+		}
+
+		result := make([]fiber.Map, 0, len(updated))
+		for _, p := range updated {
+			result = append(result, fiber.Map{"product_id": p.ID, "sku": p.SKU, "stock": p.Stock})
+		}
+		return c.JSON(fiber.Map{"updated": result})
+	}
+}
@@ -12,7 +12,9 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"trumall/internal/middleware"
 	"trumall/internal/models"
+	"trumall/internal/services"
 )
 
 // RegisterHandler supports optional role field ("buyer" or "seller")
@@ -60,7 +62,7 @@ func RegisterHandler(db *gorm.DB) fiber.Handler {
 		// create user
 		user := models.User{
 			ID:           uuid.New(),
-			Email:        body.Email,
+			Email:        &body.Email,
 			PasswordHash: string(pwHash),
 			Name:         body.Name,
 			Roles:        pq.StringArray{role},
@@ -74,11 +76,11 @@ func RegisterHandler(db *gorm.DB) fiber.Handler {
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "failed to create token"})
 		}
-		return c.JSON(fiber.Map{
-			"data": fiber.Map{
-				"token": token,
-			},
-		})
+		data := fiber.Map{"token": token}
+		if merged := mergeGuestCart(c, db, user.ID); merged != nil {
+			data["cart"] = merged
+		}
+		return c.JSON(fiber.Map{"data": data})
 	}
 }
 
@@ -101,16 +103,43 @@ func LoginHandler(db *gorm.DB) fiber.Handler {
 		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(body.Password)); err != nil {
 			return c.Status(401).JSON(fiber.Map{"error": "invalid credentials"})
 		}
-		token, err := generateToken(user.ID.String(), user.Roles)
+
+		return loginResponse(c, db, user)
+	}
+}
+
+// loginResponse finishes a successful first-factor login (password or phone OTP).
+// Enrolled users, and roles where 2FA is mandatory, get a short-lived pending
+// token instead of a session token. A guest cart is merged once the session
+// token is issued.
+func loginResponse(c *fiber.Ctx, db *gorm.DB, user models.User) error {
+	mfaRequired, err := services.NewMFAService(db).IsRequired(user.Roles)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "db error"})
+	}
+	if user.TOTPEnabled || mfaRequired {
+		mfaToken, err := generateMFAPendingToken(user.ID.String())
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "token"})
 		}
 		return c.JSON(fiber.Map{
 			"data": fiber.Map{
-				"token": token,
+				"mfa_required":        true,
+				"enrollment_required": !user.TOTPEnabled,
+				"mfa_token":           mfaToken,
 			},
 		})
 	}
+
+	token, err := generateToken(user.ID.String(), user.Roles)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "token"})
+	}
+	data := fiber.Map{"token": token}
+	if merged := mergeGuestCart(c, db, user.ID); merged != nil {
+		data["cart"] = merged
+	}
+	return c.JSON(fiber.Map{"data": data})
 }
 
 func generateToken(sub string, roles []string) (string, error) {
@@ -129,6 +158,24 @@ func generateToken(sub string, roles []string) (string, error) {
 	return t.SignedString([]byte(secret))
 }
 
+// generateMFAPendingToken issues a short-lived token that only unlocks the
+// two-factor verification and enrolment endpoints
+func generateMFAPendingToken(sub string) (string, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		secret = "devsecret"
+	}
+
+	claims := jwt.MapClaims{
+		"sub": sub,
+		"typ": middleware.TokenTypeMFAPending,
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}
+
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return t.SignedString([]byte(secret))
+}
+
 func GetMeHandler(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		user, ok := c.Locals("user").(models.User)
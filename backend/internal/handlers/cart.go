@@ -1,20 +1,21 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
-	// Add this import
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 
 	"trumall/internal/models"
 	"trumall/internal/services"
-	"trumall/mpesa" // Add this import
+	"trumall/internal/validation"
 )
 
-// Add product to cart
+// Add product to cart. Guests get a guest cart, and its token, once their
+// first item goes in.
 func AddToCartHandler(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		type request struct {
@@ -25,70 +26,92 @@ func AddToCartHandler(db *gorm.DB) fiber.Handler {
 		if err := c.BodyParser(&body); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
 		}
-
-		// Get user ID from JWT
-		user, ok := c.Locals("user").(models.User)
-		if !ok {
-			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		if body.Quantity < 1 {
+			return c.Status(400).JSON(fiber.Map{"error": "quantity must be at least 1"})
 		}
-		userID := user.ID // Extract userID from the user object
 
 		// Check if product exists
 		var product models.Product
-		if err := db.First(&product, "id = ?", body.ProductID).Error; err != nil {
+		if err := db.Preload("Sales", services.ActiveSales).First(&product, "id = ?", body.ProductID).Error; err != nil {
 			return c.Status(404).JSON(fiber.Map{"error": "product not found"})
 		}
 
+		// The signed-in buyer's cart, or the guest's
+		owner, err := resolveCartOwner(c, db)
+		if err != nil {
+			log.Printf("Error resolving cart: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to open cart"})
+		}
+
 		// Check if cart item already exists
 		var cartItem models.CartItem
-		fmt.Println("userID:", userID.String(), "productID:", body.ProductID.String())
-
-		err := db.Where("user_id = ? AND product_id = ?", userID, body.ProductID).First(&cartItem).Error
-		if err == nil {
-			if cartItem.Quantity+body.Quantity > product.Stock {
-				return c.Status(400).JSON(fiber.Map{
-					"error": fmt.Sprintf("Out of stock. %d more items can be added.", product.Stock-cartItem.Quantity),
-				})
-			}
+		err = owner.scope(db).Where("product_id = ?", body.ProductID).First(&cartItem).Error
+		exists := err == nil
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch cart"})
+		}
+
+		// The new total must fit the stock left and the product's order limit
+		limits, err := services.StockLimits(db, []models.Product{product}, owner.userID)
+		if err != nil {
+			log.Printf("Error checking stock for product %s: %v", product.ID, err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to check stock"})
+		}
+		if err := limits[product.ID].Check(product, cartItem.Quantity+body.Quantity); err != nil {
+			return cartQuantityError(c, err)
+		}
+
+		if exists {
 			cartItem.Quantity += body.Quantity
+			cartItem.Price = services.CurrentPriceCents(product) // the buyer has just seen this price
+			cartItem.SavedForLater = false                       // adding it again means they want it now
 			db.Save(&cartItem)
 		} else {
-			// Create new cart item
-			if body.Quantity > product.Stock {
-				return c.Status(400).JSON(fiber.Map{
-					"error": fmt.Sprintf("only %d items available", product.Stock),
-				})
+			// A guest's first item starts their cart
+			if owner.userID == nil && owner.guestCart == nil {
+				if owner, err = createGuestCart(c, db); err != nil {
+					log.Printf("Error creating guest cart: %v", err)
+					return c.Status(500).JSON(fiber.Map{"error": "failed to open cart"})
+				}
 			}
+
+			// Create new cart item
 			cartItem = models.CartItem{
-				UserID:    userID,
 				ProductID: product.ID,
 				Quantity:  body.Quantity,
-				Price:     product.PriceCents, // Set price from product
+				Price:     services.CurrentPriceCents(product), // Snapshot of the price the buyer saw, sale price included
 			}
+			owner.assign(&cartItem)
 			db.Create(&cartItem)
 		}
+		touchCart(c, db, owner)
 
 		// Preload product for the response
-		db.Preload("Product").First(&cartItem, "id = ?", cartItem.ID)
-		return c.JSON(cartItem)
+		db.Preload("Product.Sales", services.ActiveSales).First(&cartItem, "id = ?", cartItem.ID)
+		return c.JSON(newCartLine(cartItem, limits[product.ID]))
 	}
 }
 
-// Get user cart
+// Get the buyer's or guest's cart
 func GetCartHandler(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		user := c.Locals("user").(models.User)
-		var items []models.CartItem
-		db.Preload("Product.Images").Where("user_id = ?", user.ID).Find(&items)
-		return c.JSON(items)
+		owner, err := resolveCartOwner(c, db)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to open cart"})
+		}
+		return cartResponse(c, db, owner)
 	}
 }
 
 func RemoveFromCartHandler(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		user := c.Locals("user").(models.User)
+		owner, err := resolveCartOwner(c, db)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to open cart"})
+		}
 		id := c.Params("id")
-		db.Where("id = ? AND user_id = ?", id, user.ID).Delete(&models.CartItem{})
+		owner.scope(db).Where("id = ?", id).Delete(&models.CartItem{})
+		touchCart(c, db, owner)
 		return c.JSON(fiber.Map{"message": "removed"})
 	}
 }
@@ -104,13 +127,13 @@ func IncreaseCartItemQuantityHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
 		}
 
-		user, ok := c.Locals("user").(models.User)
-		if !ok {
-			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		owner, err := resolveCartOwner(c, db)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to open cart"})
 		}
 
 		var cartItem models.CartItem
-		if err := db.Where("user_id = ? AND product_id = ?", user.ID, body.ProductID).First(&cartItem).Error; err != nil {
+		if err := owner.scope(db).Where("product_id = ?", body.ProductID).First(&cartItem).Error; err != nil {
 			return c.Status(404).JSON(fiber.Map{"error": "item not found in cart"})
 		}
 
@@ -119,16 +142,15 @@ func IncreaseCartItemQuantityHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(404).JSON(fiber.Map{"error": "product not found"})
 		}
 
-		if cartItem.Quantity+1 > product.Stock {
-			return c.Status(400).JSON(fiber.Map{"error": "not enough stock"})
+		if err := services.CheckCartQuantity(db, product, cartItem.Quantity+1, owner.userID); err != nil {
+			return cartQuantityError(c, err)
 		}
 
 		cartItem.Quantity++
 		db.Save(&cartItem)
+		touchCart(c, db, owner)
 
-		var items []models.CartItem
-		db.Preload("Product.Images").Where("user_id = ?", user.ID).Find(&items)
-		return c.JSON(items)
+		return cartResponse(c, db, owner)
 	}
 }
 
@@ -143,13 +165,13 @@ func DecreaseCartItemQuantityHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
 		}
 
-		user, ok := c.Locals("user").(models.User)
-		if !ok {
-			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		owner, err := resolveCartOwner(c, db)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to open cart"})
 		}
 
 		var cartItem models.CartItem
-		if err := db.Where("user_id = ? AND product_id = ?", user.ID, body.ProductID).First(&cartItem).Error; err != nil {
+		if err := owner.scope(db).Where("product_id = ?", body.ProductID).First(&cartItem).Error; err != nil {
 			return c.Status(404).JSON(fiber.Map{"error": "item not found in cart"})
 		}
 
@@ -159,36 +181,353 @@ func DecreaseCartItemQuantityHandler(db *gorm.DB) fiber.Handler {
 		} else {
 			db.Save(&cartItem)
 		}
+		touchCart(c, db, owner)
+
+		return cartResponse(c, db, owner)
+	}
+}
+
+// Set a cart item's quantity; 0 removes the item
+func SetCartItemQuantityHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		type request struct {
+			Quantity *int `json:"quantity"`
+		}
+		var body request
+		if err := c.BodyParser(&body); err != nil || body.Quantity == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "quantity required"})
+		}
+		if *body.Quantity < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "quantity cannot be negative"})
+		}
+
+		owner, err := resolveCartOwner(c, db)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to open cart"})
+		}
+
+		var cartItem models.CartItem
+		if err := owner.scope(db).Preload("Product").Where("id = ?", c.Params("id")).First(&cartItem).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "item not found in cart"})
+		}
+
+		if *body.Quantity == 0 {
+			db.Delete(&cartItem)
+		} else {
+			if err := services.CheckCartQuantity(db, cartItem.Product, *body.Quantity, owner.userID); err != nil {
+				return cartQuantityError(c, err)
+			}
+			db.Model(&cartItem).Update("quantity", *body.Quantity)
+		}
+		touchCart(c, db, owner)
+
+		return cartResponse(c, db, owner)
+	}
+}
+
+// Set the quantities of several cart items at once. Nothing changes unless
+// every quantity can be supplied; the failures are listed otherwise.
+func UpdateCartItemsHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		type request struct {
+			Items []struct {
+				ID       uuid.UUID `json:"id"`
+				Quantity int       `json:"quantity"`
+			} `json:"items"`
+		}
+		var body request
+		if err := c.BodyParser(&body); err != nil || len(body.Items) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "items required"})
+		}
+
+		quantities := make(map[uuid.UUID]int, len(body.Items))
+		ids := make([]uuid.UUID, 0, len(body.Items))
+		for _, item := range body.Items {
+			if item.Quantity < 0 {
+				return c.Status(400).JSON(fiber.Map{"error": "quantity cannot be negative", "cart_item_id": item.ID})
+			}
+			if _, dup := quantities[item.ID]; dup {
+				return c.Status(400).JSON(fiber.Map{"error": "cart item listed twice", "cart_item_id": item.ID})
+			}
+			quantities[item.ID] = item.Quantity
+			ids = append(ids, item.ID)
+		}
+
+		owner, err := resolveCartOwner(c, db)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to open cart"})
+		}
 
 		var items []models.CartItem
-		db.Preload("Product.Images").Where("user_id = ?", user.ID).Find(&items)
-		return c.JSON(items)
+		if err := owner.scope(db).Preload("Product").Where("id IN ?", ids).Find(&items).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch cart"})
+		}
+		if len(items) != len(ids) {
+			return c.Status(404).JSON(fiber.Map{"error": "item not found in cart"})
+		}
+
+		products := make([]models.Product, 0, len(items))
+		for _, item := range items {
+			products = append(products, item.Product)
+		}
+		limits, err := services.StockLimits(db, products, owner.userID)
+		if err != nil {
+			log.Printf("Error checking stock for cart update: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to check stock"})
+		}
+
+		type itemError struct {
+			CartItemID  uuid.UUID `json:"cart_item_id"`
+			ProductID   uuid.UUID `json:"product_id"`
+			Error       string    `json:"error"`
+			MaxQuantity int       `json:"max_quantity"`
+		}
+		var failures []itemError
+		for _, item := range items {
+			quantity := quantities[item.ID]
+			if quantity == 0 {
+				continue
+			}
+			if err := limits[item.ProductID].Check(item.Product, quantity); err != nil {
+				var qerr *services.CartQuantityError
+				errors.As(err, &qerr)
+				failures = append(failures, itemError{CartItemID: item.ID, ProductID: item.ProductID, Error: err.Error(), MaxQuantity: qerr.Max})
+			}
+		}
+		if len(failures) > 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "some quantities are not available", "items": failures})
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, item := range items {
+				quantity := quantities[item.ID]
+				if quantity == 0 {
+					if err := tx.Delete(&item).Error; err != nil {
+						return err
+					}
+					continue
+				}
+				if err := tx.Model(&item).Update("quantity", quantity).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error updating cart quantities: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to update cart"})
+		}
+		touchCart(c, db, owner)
+
+		return cartResponse(c, db, owner)
 	}
 }
 
+// Move a cart item to the saved-for-later list. Saved items stay with the cart
+// but are left out of its total and of checkout.
+func SaveCartItemForLaterHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		owner, err := resolveCartOwner(c, db)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to open cart"})
+		}
+
+		result := owner.scope(db.Model(&models.CartItem{})).Where("id = ?", c.Params("id")).Update("saved_for_later", true)
+		if result.Error != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to update cart"})
+		}
+		if result.RowsAffected == 0 {
+			return c.Status(404).JSON(fiber.Map{"error": "item not found in cart"})
+		}
+		touchCart(c, db, owner)
+
+		return cartResponse(c, db, owner)
+	}
+}
+
+// Move a saved-for-later item back into the cart, as long as its quantity is
+// still available
+func MoveCartItemToCartHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		owner, err := resolveCartOwner(c, db)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to open cart"})
+		}
+
+		var cartItem models.CartItem
+		if err := owner.scope(db).Preload("Product").Where("id = ?", c.Params("id")).First(&cartItem).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "item not found in cart"})
+		}
+		if cartItem.SavedForLater {
+			if err := services.CheckCartQuantity(db, cartItem.Product, cartItem.Quantity, owner.userID); err != nil {
+				return cartQuantityError(c, err)
+			}
+			db.Model(&cartItem).Update("saved_for_later", false)
+		}
+		touchCart(c, db, owner)
+
+		return cartResponse(c, db, owner)
+	}
+}
+
+// List the buyer's or guest's saved-for-later items
+func GetSavedForLaterHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		owner, err := resolveCartOwner(c, db)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to open cart"})
+		}
+		lines, err := cartLines(db, owner, true)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch saved items"})
+		}
+		return c.JSON(lines)
+	}
+}
+
+// cartQuantityError answers a cart change the product's stock or order limit
+// cannot cover, telling the buyer the most they can have
+func cartQuantityError(c *fiber.Ctx, err error) error {
+	var qerr *services.CartQuantityError
+	if errors.As(err, &qerr) {
+		return c.Status(400).JSON(fiber.Map{"error": qerr.Error(), "max_quantity": qerr.Max})
+	}
+	log.Printf("Error checking cart quantity: %v", err)
+	return c.Status(500).JSON(fiber.Map{"error": "failed to check stock"})
+}
+
+// cartLine is a cart item as the buyer sees it, flagged when the product's
+// price has moved since the item was added or the stock left or order limit
+// no longer covers it
+type cartLine struct {
+	models.CartItem
+	CurrentPriceCents int64 `json:"current_price_cents"`
+	PriceChanged      bool  `json:"price_changed"`
+	AvailableStock    int   `json:"available_stock"` // net of other buyers' unpaid orders
+	MaxQuantity       int   `json:"max_quantity"`    // the most the buyer can have, order limit included
+	InsufficientStock bool  `json:"insufficient_stock"`
+	OverOrderLimit    bool  `json:"over_order_limit"`
+}
+
+func newCartLine(item models.CartItem, limit services.StockLimit) cartLine {
+	current := services.CurrentPriceCents(item.Product)
+	return cartLine{
+		CartItem:          item,
+		CurrentPriceCents: current,
+		PriceChanged:      current != item.Price,
+		AvailableStock:    max(limit.Available, 0),
+		MaxQuantity:       limit.Max(),
+		InsufficientStock: item.Quantity > limit.Available,
+		OverOrderLimit:    limit.MaxOrderQuantity != nil && item.Quantity > *limit.MaxOrderQuantity,
+	}
+}
+
+// cartResponse sends the owner's cart, saved-for-later items aside, with its
+// price and stock flags
+func cartResponse(c *fiber.Ctx, db *gorm.DB, owner cartOwner) error {
+	lines, err := cartLines(db, owner, false)
+	if err != nil {
+		log.Printf("Error fetching cart: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch cart"})
+	}
+	return c.JSON(lines)
+}
+
+// cartLines loads the owner's cart items, or their saved-for-later items, as
+// the buyer sees them
+func cartLines(db *gorm.DB, owner cartOwner, saved bool) ([]cartLine, error) {
+	var items []models.CartItem
+	if err := owner.scope(db).Preload("Product.Images").Preload("Product.Sales", services.ActiveSales).
+		Where("saved_for_later = ?", saved).Order("created_at").Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	products := make([]models.Product, 0, len(items))
+	for _, item := range items {
+		products = append(products, item.Product)
+	}
+	limits, err := services.StockLimits(db, products, owner.userID)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]cartLine, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, newCartLine(item, limits[item.ProductID]))
+	}
+	return lines, nil
+}
+
+// cartPriceChange is a cart item whose price differs from when it was added
+type cartPriceChange struct {
+	CartItemID    uuid.UUID `json:"cart_item_id"`
+	ProductID     uuid.UUID `json:"product_id"`
+	Title         string    `json:"title"`
+	OldPriceCents int64     `json:"old_price_cents"`
+	NewPriceCents int64     `json:"new_price_cents"`
+}
+
+func cartPriceChanges(cart []models.CartItem) []cartPriceChange {
+	var changes []cartPriceChange
+	for _, item := range cart {
+		if current := services.CurrentPriceCents(item.Product); current != item.Price {
+			changes = append(changes, cartPriceChange{
+				CartItemID:    item.ID,
+				ProductID:     item.ProductID,
+				Title:         item.Product.Title,
+				OldPriceCents: item.Price,
+				NewPriceCents: current,
+			})
+		}
+	}
+	return changes
+}
+
 // Checkout: create order & deduct stock
 func CheckoutHandler(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		user := c.Locals("user").(models.User)
 
 		type CheckoutRequest struct {
-			Phone           string    `json:"phone"`
-			AddressID       uuid.UUID `json:"address_id"`
-			ShippingMethod  string    `json:"shipping_method"`
+			Phone           string     `json:"phone"`
+			AddressID       uuid.UUID  `json:"address_id"`
+			PickupPointID   *uuid.UUID `json:"pickup_point_id"` // click-and-collect instead of address_id
+			ShippingMethod  string     `json:"shipping_method"`
+			ShippingQuoteID string     `json:"shipping_quote_id"` // from /api/shipping/calculate or /api/shipping/methods
+			CouponCode      string     `json:"coupon_code"`
+			// The cart total the buyer agreed to after being shown price changes
+			AcceptedCartTotalCents *int64 `json:"accepted_cart_total_cents"`
 		}
 		var checkoutReq CheckoutRequest
 		if err := c.BodyParser(&checkoutReq); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
 		}
 
-		// Fetch the selected address
-		var shippingAddress models.Address
-		if err := db.Where("id = ? AND user_id = ?", checkoutReq.AddressID, user.ID).First(&shippingAddress).Error; err != nil {
-			return c.Status(400).JSON(fiber.Map{"error": "invalid shipping address"})
+		// Pre-fill the M-Pesa number from the buyer's verified phone
+		if checkoutReq.Phone == "" && user.Phone != nil && user.PhoneVerifiedAt != nil {
+			checkoutReq.Phone = *user.Phone
+		}
+		if checkoutReq.Phone == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "phone number required for M-Pesa payment"})
+		}
+		phone, err := validation.NormalizePhone(checkoutReq.Phone)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		checkoutReq.Phone = phone
+
+		// Fetch the selected address, unless collecting from a pickup point
+		var shippingAddressID *uuid.UUID
+		if checkoutReq.PickupPointID == nil {
+			var shippingAddress models.Address
+			if err := db.Where("id = ? AND user_id = ?", checkoutReq.AddressID, user.ID).First(&shippingAddress).Error; err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid shipping address"})
+			}
+			shippingAddressID = &shippingAddress.ID
 		}
 
 		var cart []models.CartItem
-		if err := db.Preload("Product").Where("user_id = ?", user.ID).Find(&cart).Error; err != nil {
+		if err := db.Preload("Product.Sales", services.ActiveSales).Where("user_id = ? AND saved_for_later = ?", user.ID, false).Find(&cart).Error; err != nil {
 			log.Printf("Error fetching cart for user %s: %v", user.ID, err)
 			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch cart"})
 		}
@@ -203,21 +542,37 @@ func CheckoutHandler(db *gorm.DB) fiber.Handler {
 		}
 
 		// ✅ Calculate cart total (before shipping)
-		var cartTotalCents int64
-		for _, item := range cart {
-			cartTotalCents += int64(item.Quantity) * int64(item.Product.PriceCents)
+		quoteReq := cartQuoteRequest(user.ID, checkoutReq.AddressID, checkoutReq.ShippingMethod, cart)
+		quoteReq.PickupPointID = checkoutReq.PickupPointID
+		cartTotalCents := quoteReq.CartTotalCents
+
+		// ✅ Never charge more (or less) than the buyer saw without them agreeing
+		priceChanges := cartPriceChanges(cart)
+		if len(priceChanges) > 0 && (checkoutReq.AcceptedCartTotalCents == nil || *checkoutReq.AcceptedCartTotalCents != cartTotalCents) {
+			return c.Status(409).JSON(fiber.Map{
+				"error":            "prices in your cart have changed; confirm the new total to continue",
+				"price_changes":    priceChanges,
+				"cart_total_cents": cartTotalCents,
+			})
 		}
 
-		// ✅ Calculate shipping using shipping service
+		// ✅ Charge exactly the shipping price the buyer was quoted
+		if checkoutReq.ShippingQuoteID == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "shipping_quote_id required; quote shipping before checkout"})
+		}
 		shippingService := services.NewShippingService(db)
-		shippingCalc, err := shippingService.CalculateShipping(checkoutReq.AddressID, checkoutReq.ShippingMethod, cartTotalCents)
+		shippingCalc, err := shippingService.RedeemQuote(checkoutReq.ShippingQuoteID, quoteReq)
 		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrQuoteExpired), errors.Is(err, services.ErrQuoteMismatch),
+				errors.Is(err, services.ErrStoreDoesNotShip), errors.Is(err, services.ErrStoreMethodUnavailable):
+				return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+			case errors.Is(err, services.ErrQuoteInvalid):
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
 			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("shipping calculation failed: %v", err)})
 		}
 
-		// ✅ Calculate total including shipping
-		totalCents := cartTotalCents + shippingCalc.ShippingCostCents
-
 		// Start a transaction
 		tx := db.Begin()
 		if tx.Error != nil {
@@ -230,36 +585,105 @@ func CheckoutHandler(db *gorm.DB) fiber.Handler {
 			}
 		}()
 
+		// Checking the same products out again replaces the buyer's unpaid
+		// orders for them
+		if err := services.ReplacePendingOrders(tx, user.ID, cart); err != nil {
+			tx.Rollback()
+			if errors.Is(err, services.ErrPaymentInProgress) {
+				return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+			}
+			log.Printf("Error replacing pending orders for user %s: %v", user.ID, err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to create order"})
+		}
+
+		// Hold a slot at the pickup point; the row lock serialises checkouts to it
+		if checkoutReq.PickupPointID != nil {
+			if _, err := services.CheckPickupAvailability(tx, *checkoutReq.PickupPointID, storeID, true); err != nil {
+				tx.Rollback()
+				if errors.Is(err, services.ErrPickupPointFull) || errors.Is(err, services.ErrPickupPointInactive) {
+					return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+				}
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+
+		// Hold the cart's units; the product rows stay locked until commit so
+		// two checkouts cannot promise the same units
+		if err := services.ReserveStock(tx, cart); err != nil {
+			tx.Rollback()
+			return cartQuantityError(c, err)
+		}
+
+		// Hold the cart's sale prices; sold-out flash sales or sales that have
+		// since ended send the buyer back to their cart
+		if err := services.ReserveSales(tx, cart); err != nil {
+			tx.Rollback()
+			if errors.Is(err, services.ErrSaleEnded) || errors.Is(err, services.ErrSaleSoldOut) {
+				return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+			}
+			log.Printf("Error reserving sale prices for user %s: %v", user.ID, err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to check sale prices"})
+		}
+
+		// Apply the coupon; its row stays locked until commit so it cannot be
+		// redeemed past its limits
+		var coupon *services.CouponQuote
+		if checkoutReq.CouponCode != "" {
+			coupon, err = services.NewCouponService(db).Apply(tx, checkoutReq.CouponCode, user,
+				services.CouponItemsForCart(cart), shippingCalc.ShippingCostCents)
+			if err != nil {
+				tx.Rollback()
+				return couponError(c, err, "failed to apply coupon")
+			}
+		}
+
+		// ✅ Calculate total including shipping, less any discount
+		totalCents := cartTotalCents + shippingCalc.ShippingCostCents
+		var discountCents int64
+		if coupon != nil {
+			discountCents = coupon.TotalDiscountCents()
+			totalCents -= discountCents
+		}
+
 		// ✅ Create order with storeID and shipping details
 		order := models.Order{
-			BuyerID:           user.ID,
-			StoreID:           storeID,
-			ShippingAddressID: shippingAddress.ID,
-			TotalCents:        totalCents,
-			ShippingCostCents: shippingCalc.ShippingCostCents,
-			Currency:          "KES",
-			Status:            "pending", // Status is pending until payment is confirmed
-			ShippingMethod:    checkoutReq.ShippingMethod,
-			EstimatedDelivery: shippingCalc.EstimatedDelivery,
+			BuyerID:                   user.ID,
+			StoreID:                   storeID,
+			ShippingAddressID:         shippingAddressID,
+			PickupPointID:             checkoutReq.PickupPointID,
+			TotalCents:                totalCents,
+			ShippingCostCents:         shippingCalc.ShippingCostCents,
+			DiscountCents:             discountCents,
+			Currency:                  "KES",
+			Status:                    "pending", // Status is pending until payment is confirmed
+			ShippingMethod:            checkoutReq.ShippingMethod,
+			EstimatedDelivery:         shippingCalc.EstimatedDelivery,
+			EstimatedDeliveryEarliest: &shippingCalc.DeliveryWindow.Earliest,
 		}
 		if err := tx.Create(&order).Error; err != nil {
 			tx.Rollback()
 			log.Printf("Error creating order for user %s: %v", user.ID, err)
 			return c.Status(500).JSON(fiber.Map{"error": "failed to create order"})
 		}
-
-		// ✅ Create order items (stock deduction and cart clearing moved to M-Pesa callback)
-		for _, item := range cart {
-			if item.Quantity > item.Product.Stock {
+		if coupon != nil {
+			if err := services.NewCouponService(db).Record(tx, order.ID, user, coupon); err != nil {
 				tx.Rollback()
-				return c.Status(400).JSON(fiber.Map{"error": "not enough stock for " + item.Product.Title})
+				log.Printf("Error recording coupon for order %s: %v", order.ID, err)
+				return c.Status(500).JSON(fiber.Map{"error": "failed to record coupon"})
 			}
+		}
 
+		// ✅ Create order items (stock deduction and cart clearing moved to M-Pesa callback)
+		for _, item := range cart {
+			unitPriceCents, sale := services.CurrentPrice(item.Product)
 			orderItem := models.OrderItem{
 				OrderID:        order.ID,
 				ProductID:      item.ProductID,
 				Quantity:       item.Quantity,
-				UnitPriceCents: item.Product.PriceCents,
+				UnitPriceCents: unitPriceCents,
+			}
+			if sale != nil {
+				orderItem.SaleID = &sale.ID
 			}
 			if err := tx.Create(&orderItem).Error; err != nil {
 				tx.Rollback()
@@ -268,7 +692,24 @@ func CheckoutHandler(db *gorm.DB) fiber.Handler {
 			}
 		}
 
-		// ✅ Create Payment record
+		// The buyer accepted the new prices; they are the cart's snapshot now
+		for _, change := range priceChanges {
+			if err := tx.Model(&models.CartItem{}).Where("id = ?", change.CartItemID).Update("price", change.NewPriceCents).Error; err != nil {
+				tx.Rollback()
+				log.Printf("Error updating cart prices for user %s: %v", user.ID, err)
+				return c.Status(500).JSON(fiber.Map{"error": "failed to update cart prices"})
+			}
+		}
+
+		// Check for minimum M-Pesa amount (1 KES = 100 cents)
+		if totalCents < 100 {
+			tx.Rollback()
+			return c.Status(400).JSON(fiber.Map{"error": "M-Pesa minimum amount is 1 KES"})
+		}
+
+		// ✅ Create Payment record and queue its STK push. The push is only sent
+		// once the order has committed, so a buyer is never prompted to pay for
+		// an order that doesn't exist.
 		payment := models.Payment{
 			ID:          uuid.New(),
 			OrderID:     order.ID,
@@ -283,25 +724,11 @@ func CheckoutHandler(db *gorm.DB) fiber.Handler {
 			log.Printf("Error creating payment for order %s: %v", order.ID, err)
 			return c.Status(500).JSON(fiber.Map{"error": "failed to create payment"})
 		}
-
-		// Check for minimum M-Pesa amount (1 KES = 100 cents)
-		if totalCents < 100 {
-			tx.Rollback()
-			return c.Status(400).JSON(fiber.Map{"error": "M-Pesa minimum amount is 1 KES"})
-		}
-
-		// ✅ Initiate M-Pesa STK Push
-		checkoutRequestID, err := mpesa.InitiateSTK(checkoutReq.Phone, int(totalCents/100), order.ID.String(), order.ID.String())
+		stkPush, err := services.EnqueueSTKPush(tx, payment.ID)
 		if err != nil {
 			tx.Rollback()
-			log.Printf("Error initiating M-Pesa STK push for order %s: %v", order.ID, err)
-			return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("failed to initiate M-Pesa STK push: %v", err)})
-		}
-		payment.CheckoutRequestID = &checkoutRequestID
-		if err := tx.Save(&payment).Error; err != nil {
-			tx.Rollback()
-			log.Printf("Error saving payment with checkout request ID for order %s: %v", order.ID, err)
-			return c.Status(500).JSON(fiber.Map{"error": "failed to update payment with checkout request ID"})
+			log.Printf("Error queueing M-Pesa STK push for order %s: %v", order.ID, err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to queue M-Pesa payment"})
 		}
 
 		if err := tx.Commit().Error; err != nil {
@@ -309,11 +736,34 @@ func CheckoutHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(500).JSON(fiber.Map{"error": "failed to commit transaction"})
 		}
 
+		// ✅ Send the M-Pesa prompt straight away
+		if sent, err := services.SendSTKPush(db, stkPush, payment.ID); err != nil {
+			log.Printf("Error reloading payment for order %s: %v", order.ID, err)
+		} else {
+			payment = *sent
+		}
+		if payment.Status == "failed" {
+			reason := "unknown error"
+			if payment.FailureReason != nil {
+				reason = *payment.FailureReason
+			}
+			return c.Status(502).JSON(fiber.Map{
+				"error":      "failed to send the M-Pesa payment prompt: " + reason,
+				"order_id":   order.ID,
+				"payment_id": payment.ID,
+			})
+		}
+
 		return c.JSON(fiber.Map{
 			"order_id":            order.ID,
-			"checkout_request_id": checkoutRequestID,
+			"payment_id":          payment.ID,
+			"payment_status":      payment.Status,            // "pending" once the prompt is sent, "initiated" while it is retried
+			"checkout_request_id": payment.CheckoutRequestID, // null until the prompt is sent
 			"shipping_cost_cents": order.ShippingCostCents,
+			"discount_cents":      order.DiscountCents,
+			"total_cents":         order.TotalCents,
 			"estimated_delivery":  order.EstimatedDelivery,
+			"delivery_window":     shippingCalc.DeliveryWindow,
 		})
 	}
 }
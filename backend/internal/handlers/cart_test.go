@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/testdb"
+)
+
+// newCartApp serves the cart and checkout to a signed-in buyer
+func newCartApp(t *testing.T) (*fiber.App, *gorm.DB, models.User) {
+	t.Helper()
+	db := testdb.Open(t, &models.Product{}, &models.ProductImage{}, &models.ProductSale{}, &models.Order{}, &models.OrderItem{},
+		&models.CartItem{}, &models.GuestCart{}, &models.Address{})
+	buyer := models.User{ID: uuid.New(), Name: "Buyer"}
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", buyer)
+		return c.Next()
+	})
+	app.Get("/api/cart", GetCartHandler(db))
+	app.Post("/api/cart/add", AddToCartHandler(db))
+	app.Post("/api/checkout", CheckoutHandler(db))
+	return app, db, buyer
+}
+
+func send(t *testing.T, app *fiber.App, method, path, body string, out any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestCartFlagsPriceAndStockChanges(t *testing.T) {
+	app, db, _ := newCartApp(t)
+	kettle := models.Product{StoreID: uuid.New(), Title: "Kettle", PriceCents: 3000, Stock: 5}
+	speaker := models.Product{StoreID: uuid.New(), Title: "Speaker", PriceCents: 6000, Stock: 5}
+	lamp := models.Product{StoreID: uuid.New(), Title: "Lamp", PriceCents: 2000, Stock: 5}
+	for _, p := range []*models.Product{&kettle, &speaker, &lamp} {
+		db.Create(p)
+	}
+	sale := models.ProductSale{ProductID: speaker.ID, SalePriceCents: 4000, StartsAt: time.Now().Add(-time.Hour), EndsAt: time.Now().Add(time.Hour)}
+	db.Create(&sale)
+
+	for _, p := range []models.Product{kettle, speaker, lamp} {
+		if status := send(t, app, "POST", "/api/cart/add", `{"product_id":"`+p.ID.String()+`","quantity":2}`, nil); status != 200 {
+			t.Fatalf("adding %s: status %d", p.Title, status)
+		}
+	}
+
+	// The seller raises the kettle's price, the speaker's sale ends and
+	// another buyer's unpaid order takes most of the lamps
+	db.Model(&kettle).Update("price_cents", 3500)
+	db.Model(&sale).Update("ends_at", time.Now().Add(-time.Minute))
+	order := models.Order{BuyerID: uuid.New(), StoreID: lamp.StoreID, Status: "pending", TotalCents: 8000, Currency: "KES"}
+	db.Create(&order)
+	db.Create(&models.OrderItem{OrderID: order.ID, ProductID: lamp.ID, Quantity: 4, UnitPriceCents: 2000})
+
+	var lines []cartLine
+	if status := send(t, app, "GET", "/api/cart", "", &lines); status != 200 {
+		t.Fatalf("status %d", status)
+	}
+	got := map[uuid.UUID]cartLine{}
+	for _, line := range lines {
+		got[line.ProductID] = line
+	}
+
+	cases := []struct {
+		product          models.Product
+		snapshot, price  int64
+		changed, noStock bool
+	}{
+		{kettle, 3000, 3500, true, false},
+		{speaker, 4000, 6000, true, false},
+		{lamp, 2000, 2000, false, true},
+	}
+	for _, tc := range cases {
+		line := got[tc.product.ID]
+		if line.Price != tc.snapshot || line.CurrentPriceCents != tc.price || line.PriceChanged != tc.changed || line.InsufficientStock != tc.noStock {
+			t.Errorf("%s: added at %d, now %d, changed %v, short of stock %v; want %d, %d, %v, %v", tc.product.Title,
+				line.Price, line.CurrentPriceCents, line.PriceChanged, line.InsufficientStock, tc.snapshot, tc.price, tc.changed, tc.noStock)
+		}
+	}
+	if lamp := got[lamp.ID]; lamp.AvailableStock != 1 || lamp.MaxQuantity != 1 {
+		t.Errorf("lamp: %d available, at most %d; want 1, 1", lamp.AvailableStock, lamp.MaxQuantity)
+	}
+}
+
+func TestCheckoutAsksBuyerToAcceptChangedPrices(t *testing.T) {
+	app, db, buyer := newCartApp(t)
+	kettle := models.Product{StoreID: uuid.New(), Title: "Kettle", PriceCents: 3500, Stock: 5}
+	db.Create(&kettle)
+	address := models.Address{UserID: buyer.ID, Street: "1 Main Street", City: "Nairobi", Country: "Kenya"}
+	db.Create(&address)
+	item := models.CartItem{UserID: &buyer.ID, ProductID: kettle.ID, Quantity: 2, Price: 3000}
+	db.Create(&item)
+
+	// Past the price check, checkout stops at the missing shipping quote
+	const reachedShipping = 400
+	cases := []struct {
+		name     string
+		snapshot int64
+		accepted string
+		status   int
+	}{
+		{"price changed", 3000, "", 409},
+		{"accepted the old total", 3000, `,"accepted_cart_total_cents":6000`, 409},
+		{"accepted the new total", 3000, `,"accepted_cart_total_cents":7000`, reachedShipping},
+		{"price unchanged", 3500, "", reachedShipping},
+	}
+	for _, tc := range cases {
+		db.Model(&item).Update("price", tc.snapshot)
+		body := `{"phone":"0712345678","address_id":"` + address.ID.String() + `","shipping_method":"standard"` + tc.accepted + `}`
+		var resp struct {
+			Error          string            `json:"error"`
+			PriceChanges   []cartPriceChange `json:"price_changes"`
+			CartTotalCents int64             `json:"cart_total_cents"`
+		}
+		status := send(t, app, "POST", "/api/checkout", body, &resp)
+		if status != tc.status {
+			t.Errorf("%s: status %d (%s); want %d", tc.name, status, resp.Error, tc.status)
+			continue
+		}
+		if status == 409 && (resp.CartTotalCents != 7000 || len(resp.PriceChanges) != 1 ||
+			resp.PriceChanges[0].OldPriceCents != 3000 || resp.PriceChanges[0].NewPriceCents != 3500) {
+			t.Errorf("%s: total %d with changes %+v; want 7000 with the kettle from 3000 to 3500", tc.name, resp.CartTotalCents, resp.PriceChanges)
+		}
+		if status == reachedShipping && !strings.Contains(resp.Error, "shipping_quote_id") {
+			t.Errorf("%s: %q; want checkout to ask for a shipping quote", tc.name, resp.Error)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/authz"
+	"trumall/internal/models"
+	"trumall/internal/services"
+)
+
+// ApplyCouponHandler previews what a coupon takes off the buyer's cart. Pass
+// the shipping_quote_id (with the address or pickup point and method it was
+// quoted for) to see free-shipping coupons and the final total too.
+func ApplyCouponHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := c.Locals("user").(models.User)
+
+		var req struct {
+			Code            string     `json:"code"`
+			AddressID       uuid.UUID  `json:"address_id"`
+			PickupPointID   *uuid.UUID `json:"pickup_point_id"`
+			ShippingMethod  string     `json:"shipping_method"`
+			ShippingQuoteID string     `json:"shipping_quote_id"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if req.Code == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "code is required"})
+		}
+
+		var cart []models.CartItem
+		if err := db.Preload("Product.Sales", services.ActiveSales).Where("user_id = ? AND saved_for_later = ?", user.ID, false).Find(&cart).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch cart"})
+		}
+		if len(cart) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "cart is empty"})
+		}
+
+		var shippingCents int64
+		if req.ShippingQuoteID != "" {
+			quoteReq := cartQuoteRequest(user.ID, req.AddressID, req.ShippingMethod, cart)
+			quoteReq.PickupPointID = req.PickupPointID
+			calc, err := services.NewShippingService(db).RedeemQuote(req.ShippingQuoteID, quoteReq)
+			if err != nil {
+				if errors.Is(err, services.ErrQuoteExpired) || errors.Is(err, services.ErrQuoteMismatch) {
+					return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+				}
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			shippingCents = calc.ShippingCostCents
+		}
+
+		quote, err := services.NewCouponService(db).Preview(req.Code, user, services.CouponItemsForCart(cart), shippingCents)
+		if err != nil {
+			return couponError(c, err, "failed to apply coupon")
+		}
+
+		return c.JSON(fiber.Map{
+			"coupon":              quote,
+			"subtotal_cents":      quote.SubtotalCents,
+			"discount_cents":      quote.TotalDiscountCents(),
+			"shipping_cost_cents": shippingCents,
+			"total_cents":         quote.SubtotalCents + shippingCents - quote.TotalDiscountCents(),
+		})
+	}
+}
+
+// ListStoreCouponsHandler lists a store's coupons
+func ListStoreCouponsHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		storeID, ok, resp := couponStore(c, db)
+		if !ok {
+			return resp
+		}
+
+		coupons, err := services.NewCouponService(db).List(&storeID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch coupons"})
+		}
+
+		return c.JSON(coupons)
+	}
+}
+
+// CreateStoreCouponHandler creates a coupon for the store's products
+func CreateStoreCouponHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		storeID, ok, resp := couponStore(c, db)
+		if !ok {
+			return resp
+		}
+
+		var input services.CouponInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+
+		user := c.Locals("user").(models.User)
+		coupon, err := services.NewCouponService(db).Create(input, &storeID, user.ID)
+		if err != nil {
+			return couponError(c, err, "failed to create coupon")
+		}
+
+		return c.Status(201).JSON(coupon)
+	}
+}
+
+// UpdateStoreCouponHandler edits one of the store's coupons
+func UpdateStoreCouponHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		storeID, ok, resp := couponStore(c, db)
+		if !ok {
+			return resp
+		}
+		couponID, err := uuid.Parse(c.Params("couponId"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid coupon ID"})
+		}
+
+		var input services.CouponInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+
+		coupon, err := services.NewCouponService(db).Update(couponID, input, &storeID)
+		if err != nil {
+			return couponError(c, err, "failed to update coupon")
+		}
+
+		return c.JSON(coupon)
+	}
+}
+
+// DeleteStoreCouponHandler deletes one of the store's coupons
+func DeleteStoreCouponHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		storeID, ok, resp := couponStore(c, db)
+		if !ok {
+			return resp
+		}
+		couponID, err := uuid.Parse(c.Params("couponId"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid coupon ID"})
+		}
+
+		if err := services.NewCouponService(db).Delete(couponID, &storeID); err != nil {
+			return couponError(c, err, "failed to delete coupon")
+		}
+
+		return c.JSON(fiber.Map{"message": "coupon deleted"})
+	}
+}
+
+// Admin: List All Coupons, optionally for one store (?store_id=)
+func AdminListCouponsHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var storeID *uuid.UUID
+		if raw := c.Query("store_id"); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid store_id"})
+			}
+			storeID = &id
+		}
+
+		coupons, err := services.NewCouponService(db).List(storeID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch coupons"})
+		}
+
+		return c.JSON(coupons)
+	}
+}
+
+// Admin: Create Coupon; platform-wide unless store_id is given
+func AdminCreateCouponHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input services.CouponInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+
+		user := c.Locals("user").(models.User)
+		coupon, err := services.NewCouponService(db).Create(input, nil, user.ID)
+		if err != nil {
+			return couponError(c, err, "failed to create coupon")
+		}
+
+		return c.Status(201).JSON(coupon)
+	}
+}
+
+// Admin: Update Coupon
+func AdminUpdateCouponHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		couponID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid coupon ID"})
+		}
+
+		var input services.CouponInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+
+		coupon, err := services.NewCouponService(db).Update(couponID, input, nil)
+		if err != nil {
+			return couponError(c, err, "failed to update coupon")
+		}
+
+		return c.JSON(coupon)
+	}
+}
+
+// Admin: Delete Coupon
+func AdminDeleteCouponHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		couponID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid coupon ID"})
+		}
+
+		if err := services.NewCouponService(db).Delete(couponID, nil); err != nil {
+			return couponError(c, err, "failed to delete coupon")
+		}
+
+		return c.JSON(fiber.Map{"message": "coupon deleted"})
+	}
+}
+
+// couponStore reads the store from the route and checks the user may manage
+// its coupons
+func couponStore(c *fiber.Ctx, db *gorm.DB) (uuid.UUID, bool, error) {
+	storeID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return uuid.Nil, false, c.Status(400).JSON(fiber.Map{"error": "invalid store ID"})
+	}
+
+	user := c.Locals("user").(models.User)
+	if ok, resp := authorizeStore(c, db, user, authz.ActionStoreUpdate, storeID); !ok {
+		return uuid.Nil, false, resp
+	}
+	return storeID, true, nil
+}
+
+// couponError maps coupon errors to responses
+func couponError(c *fiber.Ctx, err error, fallback string) error {
+	var invalid services.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error(), "field": invalid.Field})
+	case errors.Is(err, services.ErrCouponNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrCouponCodeTaken),
+		errors.Is(err, services.ErrCouponUsageLimit), errors.Is(err, services.ErrCouponPerUserLimit):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrCouponInactive), errors.Is(err, services.ErrCouponNotStarted),
+		errors.Is(err, services.ErrCouponExpired), errors.Is(err, services.ErrCouponMinSpend),
+		errors.Is(err, services.ErrCouponNotApplicable):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": fallback})
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/services"
+)
+
+// Admin: List Delivery Holidays
+func ListDeliveryHolidaysHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		holidays, err := services.NewDeliveryCalendar(db).ListHolidays()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch holidays"})
+		}
+
+		return c.JSON(holidays)
+	}
+}
+
+// Admin: Add Delivery Holiday. Body: {"name", "date": "2026-12-12", "recurring"}
+func CreateDeliveryHolidayHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body struct {
+			Name      string `json:"name"`
+			Date      string `json:"date"`
+			Recurring bool   `json:"recurring"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		date, err := time.Parse("2006-01-02", body.Date)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "date must be YYYY-MM-DD"})
+		}
+
+		holiday, err := services.NewDeliveryCalendar(db).AddHoliday(body.Name, date, body.Recurring)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.Status(201).JSON(holiday)
+	}
+}
+
+// Admin: Delete Delivery Holiday
+func DeleteDeliveryHolidayHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		holidayID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid holiday id"})
+		}
+
+		if err := services.NewDeliveryCalendar(db).DeleteHoliday(holidayID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": "holiday not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "failed to delete holiday"})
+		}
+
+		return c.JSON(fiber.Map{"message": "holiday deleted"})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"gorm.io/gorm"
 
 	"trumall/internal/models"
+	"trumall/internal/services"
 )
 
 // AddToFavoritesHandler adds a product to user's favorites
@@ -101,7 +102,7 @@ func GetUserFavoritesHandler(db *gorm.DB) fiber.Handler {
 		}
 
 		var favorites []models.Favorite
-		if err := db.Preload("Product").Preload("Product.Images").Preload("Product.Store").
+		if err := db.Preload("Product").Preload("Product.Images").Preload("Product.Store").Preload("Product.Sales", services.ActiveSales).
 			Where("user_id = ?", user.ID).
 			Order("created_at DESC").
 			Find(&favorites).Error; err != nil {
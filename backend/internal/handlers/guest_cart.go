@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/services"
+)
+
+// Guests send their cart token back in this header or cookie
+const (
+	cartTokenHeader = "X-Cart-Token"
+	cartTokenCookie = "cart_token"
+)
+
+// cartOwner is whose cart a request works on: the signed-in buyer's, or a
+// guest cart named by its token
+type cartOwner struct {
+	userID    *uuid.UUID
+	guestCart *models.GuestCart
+}
+
+// scope narrows a cart_items query to the owner's items
+func (o cartOwner) scope(db *gorm.DB) *gorm.DB {
+	if o.userID != nil {
+		return db.Where("user_id = ?", *o.userID)
+	}
+	if o.guestCart != nil {
+		return db.Where("guest_cart_id = ?", o.guestCart.ID)
+	}
+	return db.Where("1 = 0")
+}
+
+// assign makes the owner the owner of a new cart item
+func (o cartOwner) assign(item *models.CartItem) {
+	if o.userID != nil {
+		item.UserID = o.userID
+	} else if o.guestCart != nil {
+		item.GuestCartID = &o.guestCart.ID
+	}
+}
+
+// resolveCartOwner works out whose cart the request is for. Signed-in buyers
+// always use their own cart; a guest without a valid cart token has no cart
+// until createGuestCart makes one.
+func resolveCartOwner(c *fiber.Ctx, db *gorm.DB) (cartOwner, error) {
+	if user, ok := c.Locals("user").(models.User); ok {
+		return cartOwner{userID: &user.ID}, nil
+	}
+
+	if token := requestCartToken(c); token != "" {
+		cart, err := services.NewGuestCartService(db).Resolve(token)
+		switch {
+		case err == nil:
+			return cartOwner{guestCart: cart}, nil
+		case !errors.Is(err, services.ErrGuestCartInvalid) && !errors.Is(err, services.ErrGuestCartExpired):
+			return cartOwner{}, err
+		}
+	}
+	return cartOwner{}, nil
+}
+
+// createGuestCart gives a guest with no cart a new one. It is only called once
+// an item is about to go in, so requests that add nothing leave no cart behind.
+func createGuestCart(c *fiber.Ctx, db *gorm.DB) (cartOwner, error) {
+	cart, token, err := services.NewGuestCartService(db).Create()
+	if err != nil {
+		return cartOwner{}, err
+	}
+	setCartToken(c, cart, token)
+	return cartOwner{guestCart: cart}, nil
+}
+
+// touchCart keeps a guest cart alive after a change and hands out its fresh
+// token
+func touchCart(c *fiber.Ctx, db *gorm.DB, owner cartOwner) {
+	if owner.guestCart == nil {
+		return
+	}
+	token, err := services.NewGuestCartService(db).Touch(owner.guestCart)
+	if err != nil {
+		log.Printf("Error extending guest cart %s: %v", owner.guestCart.ID, err)
+		return
+	}
+	setCartToken(c, owner.guestCart, token)
+}
+
+func requestCartToken(c *fiber.Ctx) string {
+	if token := c.Get(cartTokenHeader); token != "" {
+		return token
+	}
+	return c.Cookies(cartTokenCookie)
+}
+
+func setCartToken(c *fiber.Ctx, cart *models.GuestCart, token string) {
+	c.Set(cartTokenHeader, token)
+	c.Cookie(&fiber.Cookie{
+		Name:     cartTokenCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  cart.ExpiresAt,
+		HTTPOnly: true,
+		SameSite: "Lax",
+	})
+}
+
+// mergeGuestCart moves the request's guest cart, if any, into the user's cart
+// once they have signed in. A bad or expired token is ignored: it must not
+// stop the sign-in.
+func mergeGuestCart(c *fiber.Ctx, db *gorm.DB, userID uuid.UUID) *services.GuestCartMerge {
+	token := requestCartToken(c)
+	if token == "" {
+		return nil
+	}
+	c.ClearCookie(cartTokenCookie)
+
+	guestCarts := services.NewGuestCartService(db)
+	cart, err := guestCarts.Resolve(token)
+	if err != nil {
+		return nil
+	}
+	merged, err := guestCarts.Merge(cart, userID)
+	if err != nil {
+		log.Printf("Error merging guest cart %s into user %s: %v", cart.ID, userID, err)
+		return nil
+	}
+	return merged
+}
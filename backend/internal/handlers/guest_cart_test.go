@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"trumall/internal/models"
+	"trumall/internal/testdb"
+)
+
+func TestGuestCartOnlyCreatedWhenAnItemGoesIn(t *testing.T) {
+	t.Setenv("GUEST_CART_SECRET", "test-secret")
+	db := testdb.Open(t, &models.Product{}, &models.ProductSale{}, &models.Order{}, &models.OrderItem{},
+		&models.CartItem{}, &models.GuestCart{})
+	inStock := models.Product{StoreID: uuid.New(), Title: "Kettle", PriceCents: 3000, Stock: 5}
+	soldOut := models.Product{StoreID: uuid.New(), Title: "Earbuds", PriceCents: 5000}
+	db.Create(&inStock)
+	db.Create(&soldOut)
+
+	app := fiber.New()
+	app.Get("/api/cart", GetCartHandler(db))
+	app.Post("/api/cart/add", AddToCartHandler(db))
+
+	guestCarts := func() int64 {
+		var n int64
+		db.Model(&models.GuestCart{}).Count(&n)
+		return n
+	}
+	cases := []struct {
+		name   string
+		method string
+		token  string
+		body   string
+		status int
+		carts  int64
+	}{
+		{"reading the cart", "GET", "", "", 200, 0},
+		{"a bad token is ignored", "GET", "not-a-token", "", 200, 0},
+		{"adding nothing", "POST", "", `{"product_id":"` + inStock.ID.String() + `","quantity":0}`, 400, 0},
+		{"adding an unknown product", "POST", "", `{"product_id":"` + uuid.NewString() + `","quantity":1}`, 404, 0},
+		{"adding more than the stock", "POST", "", `{"product_id":"` + soldOut.ID.String() + `","quantity":1}`, 400, 0},
+		{"adding an item", "POST", "", `{"product_id":"` + inStock.ID.String() + `","quantity":1}`, 200, 1},
+	}
+	var token string
+	for _, tc := range cases {
+		path := "/api/cart"
+		if tc.method == "POST" {
+			path = "/api/cart/add"
+		}
+		req := httptest.NewRequest(tc.method, path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		if tc.token != "" {
+			req.Header.Set(cartTokenHeader, tc.token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: status %d; want %d", tc.name, resp.StatusCode, tc.status)
+		}
+		if n := guestCarts(); n != tc.carts {
+			t.Errorf("%s: %d guest carts; want %d", tc.name, n, tc.carts)
+		}
+		token = resp.Header.Get(cartTokenHeader)
+	}
+	if token == "" {
+		t.Fatal("the first add returned no cart token")
+	}
+
+	// The guest's next add goes into the same cart
+	req := httptest.NewRequest("POST", "/api/cart/add", strings.NewReader(`{"product_id":"`+inStock.ID.String()+`","quantity":1}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(cartTokenHeader, token)
+	if resp, err := app.Test(req); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("second add: %v, %v", resp, err)
+	}
+	if n := guestCarts(); n != 1 {
+		t.Errorf("after a second add: %d guest carts; want 1", n)
+	}
+	var quantity int
+	db.Model(&models.CartItem{}).Where("product_id = ?", inStock.ID).Pluck("quantity", &quantity)
+	if quantity != 2 {
+		t.Errorf("quantity %d; want 2", quantity)
+	}
+}
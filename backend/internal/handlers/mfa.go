@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/services"
+)
+
+// mfaErrorStatus maps MFA service errors to HTTP status codes
+func mfaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrMFAInvalidCode):
+		return fiber.StatusUnauthorized
+	case errors.Is(err, services.ErrMFANotEnrolled), errors.Is(err, services.ErrMFAAlreadyEnabled):
+		return fiber.StatusBadRequest
+	case errors.Is(err, services.ErrMFARequired), errors.Is(err, services.ErrMFAEnrollmentCode):
+		return fiber.StatusForbidden
+	case errors.Is(err, services.ErrMFALocked):
+		return fiber.StatusTooManyRequests
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+// GetMFAStatusHandler reports whether 2FA is enabled and how many recovery codes are left
+func GetMFAStatusHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		mfaService := services.NewMFAService(db)
+		required, err := mfaService.IsRequired(user.Roles)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load mfa policy"})
+		}
+		remaining, err := mfaService.RemainingRecoveryCodes(user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to load recovery codes"})
+		}
+
+		return c.JSON(fiber.Map{
+			"enabled":                  user.TOTPEnabled,
+			"enabled_at":               user.TOTPEnabledAt,
+			"required":                 required,
+			"recovery_codes_remaining": remaining,
+		})
+	}
+}
+
+// BeginMFAEnrollmentHandler generates a new TOTP secret and otpauth URI.
+// Mounted both behind RequireAuth and behind RequireMFAPending (forced enrolment
+// at login). At login a password alone doesn't prove who is enrolling, so the
+// user must also send the enrolment code an admin issued them.
+func BeginMFAEnrollmentHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		mfaService := services.NewMFAService(db)
+		if pending, _ := c.Locals("mfa_pending").(bool); pending {
+			var body struct {
+				EnrollmentCode string `json:"enrollment_code"`
+			}
+			if err := c.BodyParser(&body); err != nil || strings.TrimSpace(body.EnrollmentCode) == "" {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": services.ErrMFAEnrollmentCode.Error()})
+			}
+			if err := mfaService.UseEnrollmentCode(&user, body.EnrollmentCode); err != nil {
+				return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+
+		enrollment, err := mfaService.BeginEnrollment(&user)
+		if err != nil {
+			return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(enrollment)
+	}
+}
+
+// ConfirmMFAEnrollmentHandler enables 2FA once the user proves their app produces valid codes.
+// Recovery codes are only ever returned here and from RegenerateRecoveryCodesHandler.
+func ConfirmMFAEnrollmentHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		var body struct {
+			Code string `json:"code"`
+		}
+		if err := c.BodyParser(&body); err != nil || strings.TrimSpace(body.Code) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code required"})
+		}
+
+		codes, err := services.NewMFAService(db).ConfirmEnrollment(&user, body.Code)
+		if err != nil {
+			return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		data := fiber.Map{
+			"enabled":        true,
+			"recovery_codes": codes,
+		}
+
+		// Completing enrolment during login finishes the login as well
+		if pending, _ := c.Locals("mfa_pending").(bool); pending {
+			token, err := generateToken(user.ID.String(), user.Roles)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "token"})
+			}
+			data["token"] = token
+			if merged := mergeGuestCart(c, db, user.ID); merged != nil {
+				data["cart"] = merged
+			}
+		}
+
+		return c.JSON(fiber.Map{"data": data})
+	}
+}
+
+// VerifyMFAHandler is the second login step: exchanges an MFA pending token and a
+// TOTP or recovery code for a session token
+func VerifyMFAHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		var body struct {
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		mfaService := services.NewMFAService(db)
+		var err error
+		switch {
+		case body.Code != "":
+			err = mfaService.VerifyCode(&user, body.Code)
+		case body.RecoveryCode != "":
+			err = mfaService.UseRecoveryCode(&user, body.RecoveryCode)
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code or recovery_code required"})
+		}
+		if err != nil {
+			return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		token, err := generateToken(user.ID.String(), user.Roles)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "token"})
+		}
+
+		data := fiber.Map{"token": token}
+		if merged := mergeGuestCart(c, db, user.ID); merged != nil {
+			data["cart"] = merged
+		}
+		return c.JSON(fiber.Map{"data": data})
+	}
+}
+
+// RegenerateRecoveryCodesHandler replaces all recovery codes; requires a current TOTP code
+func RegenerateRecoveryCodesHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		var body struct {
+			Code string `json:"code"`
+		}
+		if err := c.BodyParser(&body); err != nil || strings.TrimSpace(body.Code) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code required"})
+		}
+
+		mfaService := services.NewMFAService(db)
+		if err := mfaService.VerifyCode(&user, body.Code); err != nil {
+			return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		codes, err := mfaService.RegenerateRecoveryCodes(&user)
+		if err != nil {
+			return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{"recovery_codes": codes})
+	}
+}
+
+// DisableMFAHandler turns 2FA off; requires a current TOTP code
+func DisableMFAHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		var body struct {
+			Code string `json:"code"`
+		}
+		if err := c.BodyParser(&body); err != nil || strings.TrimSpace(body.Code) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code required"})
+		}
+
+		mfaService := services.NewMFAService(db)
+		if err := mfaService.VerifyCode(&user, body.Code); err != nil {
+			return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := mfaService.Disable(&user); err != nil {
+			return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{"message": "two-factor authentication disabled"})
+	}
+}
+
+// Admin: Issue a one-time code for a user to set up 2FA at login
+func IssueMFAEnrollmentCodeHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid user id"})
+		}
+
+		var user models.User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": "user not found"})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+
+		code, expiresAt, err := services.NewMFAService(db).IssueEnrollmentCode(&user)
+		if err != nil {
+			return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{"enrollment_code": code, "expires_at": expiresAt})
+	}
+}
+
+// Admin: List 2FA policies per role
+func ListMFAPoliciesHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		policies, err := services.NewMFAService(db).ListPolicies()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch mfa policies"})
+		}
+
+		return c.JSON(policies)
+	}
+}
+
+// Admin: Set whether 2FA is mandatory for a role
+func UpdateMFAPolicyHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role := c.Params("role")
+		if role != "buyer" && role != "seller" && role != "admin" {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid role"})
+		}
+
+		var body struct {
+			Required *bool `json:"required"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.Required == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "required field must be true or false"})
+		}
+
+		policy, err := services.NewMFAService(db).SetPolicy(role, *body.Required)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to update mfa policy"})
+		}
+
+		return c.JSON(policy)
+	}
+}
@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 
+	"trumall/internal/authz"
 	"trumall/internal/models"
+	"trumall/internal/services"
 )
 
 // GetOrdersHandler retrieves all orders for the authenticated user.
@@ -17,8 +20,11 @@ func GetOrdersHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
 		}
 
+		// Include parcel tracking, latest event first
 		var orders []models.Order
-		if err := db.Preload("OrderItems.Product.Images").Where("buyer_id = ?", user.ID).Find(&orders).Error; err != nil {
+		if err := db.Preload("OrderItems.Product.Images").Preload("PickupPoint").Preload("Discounts").
+			Preload("Shipments.Events", func(tx *gorm.DB) *gorm.DB { return tx.Order("occurred_at DESC") }).
+			Where("buyer_id = ?", user.ID).Find(&orders).Error; err != nil {
 			log.Printf("Error fetching orders for user %s: %v", user.ID, err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch orders"})
 		}
@@ -35,19 +41,15 @@ func GetSellerOrdersHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
 		}
 
-		var stores []models.Store
-		if err := db.Where("owner_id = ?", user.ID).Find(&stores).Error; err != nil {
+		// Owned stores plus stores where the user is staff (narrowed to the API key's stores)
+		storeIDs, err := accessibleStoreIDs(c, db, user, authz.ActionOrdersRead)
+		if err != nil {
 			log.Printf("Error fetching stores for user %s: %v", user.ID, err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch stores"})
 		}
 
-		var storeIDs []string
-		for _, store := range stores {
-			storeIDs = append(storeIDs, store.ID.String())
-		}
-
 		var orders []models.Order
-		if err := db.Preload("OrderItems.Product.Images").Preload("Buyer").Where("store_id IN ?", storeIDs).Find(&orders).Error; err != nil {
+		if err := db.Preload("OrderItems.Product.Images").Preload("Buyer").Preload("PickupPoint").Preload("Shipments").Preload("Discounts").Where("store_id IN ?", storeIDs).Find(&orders).Error; err != nil {
 			log.Printf("Error fetching orders for seller %s: %v", user.ID, err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch orders"})
 		}
@@ -56,7 +58,8 @@ func GetSellerOrdersHandler(db *gorm.DB) fiber.Handler {
 	}
 }
 
-// UpdateOrderStatusHandler updates the status of an order.
+// UpdateOrderStatusHandler moves an order along its fulfilment, e.g. processing
+// to shipped; see services.StaffOrderStatuses for what is allowed when.
 func UpdateOrderStatusHandler(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		orderID := c.Params("id")
@@ -69,13 +72,32 @@ func UpdateOrderStatusHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
 		}
 
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		}
+
 		var order models.Order
 		if err := db.First(&order, "id = ?", orderID).Error; err != nil {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "order not found"})
 		}
 
-		order.Status = body.Status
-		if err := db.Save(&order).Error; err != nil {
+		// Only the store's owner or fulfilment staff may change its orders
+		if ok, resp := authorizeStore(c, db, user, authz.ActionOrdersUpdate, order.StoreID); !ok {
+			return resp
+		}
+
+		if err := services.SetOrderStatusByStaff(db, &order, body.Status); err != nil {
+			switch {
+			case errors.Is(err, services.ErrInvalidOrderStatus):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+			case errors.Is(err, services.ErrOrderStatusTransition):
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error":            err.Error(),
+					"allowed_statuses": services.StaffOrderStatuses(order),
+				})
+			}
+			log.Printf("Error updating status of order %s: %v", order.ID, err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update order status"})
 		}
 
@@ -33,13 +33,18 @@ func GetPaymentStatusHandler(db *gorm.DB) fiber.Handler {
 			query = query.Where("checkout_request_id = ?", checkoutRequestID)
 		}
 
-		if err := query.First(&payment).Error; err != nil {
+		// An order may have had several payment attempts; report the latest
+		if err := query.Order("created_at DESC").First(&payment).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
 				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "payment not found"})
 			}
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to retrieve payment status"})
 		}
 
-		return c.JSON(fiber.Map{"status": payment.Status})
+		return c.JSON(fiber.Map{
+			"status":              payment.Status,
+			"checkout_request_id": payment.CheckoutRequestID,
+			"failure_reason":      payment.FailureReason,
+		})
 	}
 }
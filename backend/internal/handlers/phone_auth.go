@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/services"
+	"trumall/internal/sms"
+	"trumall/internal/validation"
+)
+
+// otpErrorStatus maps phone OTP service errors to HTTP status codes
+func otpErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrOTPRateLimited):
+		return fiber.StatusTooManyRequests
+	case errors.Is(err, services.ErrOTPInvalid):
+		return fiber.StatusUnauthorized
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+// RequestPhoneOTPHandler sends a login/registration code to a phone number.
+// The response is the same whether or not an account exists for the number.
+func RequestPhoneOTPHandler(db *gorm.DB, sender sms.Sender) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body struct {
+			Phone string `json:"phone"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		phone, err := validation.NormalizePhone(body.Phone)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		if err := services.NewPhoneOTPService(db, sender).RequestCode(phone, services.OTPPurposeLogin); err != nil {
+			if otpErrorStatus(err) == fiber.StatusInternalServerError {
+				log.Printf("Error sending login code to %s: %v", phone, err)
+				return c.Status(500).JSON(fiber.Map{"error": "failed to send code"})
+			}
+			return c.Status(otpErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{"message": "code sent", "phone": phone})
+	}
+}
+
+// PhoneLoginHandler verifies an SMS code and signs the user in, creating a
+// buyer account on first use of the number
+func PhoneLoginHandler(db *gorm.DB, sender sms.Sender) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body struct {
+			Phone string `json:"phone"`
+			Code  string `json:"code"`
+			Name  string `json:"name"` // optional, used when registering
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		phone, err := validation.NormalizePhone(body.Phone)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if body.Code == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "code required"})
+		}
+
+		if err := services.NewPhoneOTPService(db, sender).VerifyCode(phone, services.OTPPurposeLogin, body.Code); err != nil {
+			return c.Status(otpErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		now := time.Now()
+		var user models.User
+		err = db.Where("phone = ?", phone).First(&user).Error
+		switch {
+		case err == nil:
+			if user.PhoneVerifiedAt == nil {
+				db.Model(&user).Update("phone_verified_at", now)
+				user.PhoneVerifiedAt = &now
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			user = models.User{
+				ID:              uuid.New(),
+				Phone:           &phone,
+				PhoneVerifiedAt: &now,
+				Name:            body.Name,
+				Roles:           pq.StringArray{"buyer"},
+			}
+			if err := db.Create(&user).Error; err != nil {
+				log.Printf("Error creating phone user %s: %v", phone, err)
+				return c.Status(500).JSON(fiber.Map{"error": "failed to create user"})
+			}
+		default:
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+
+		return loginResponse(c, db, user)
+	}
+}
+
+// RequestPhoneVerificationHandler sends a code to add or change the phone on the signed-in account
+func RequestPhoneVerificationHandler(db *gorm.DB, sender sms.Sender) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		var body struct {
+			Phone string `json:"phone"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		phone, err := validation.NormalizePhone(body.Phone)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		var count int64
+		if err := db.Model(&models.User{}).Where("phone = ? AND id <> ?", phone, user.ID).Count(&count).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "db error"})
+		}
+		if count > 0 {
+			return c.Status(409).JSON(fiber.Map{"error": "phone number is already linked to another account"})
+		}
+
+		if err := services.NewPhoneOTPService(db, sender).RequestCode(phone, services.OTPPurposeVerify); err != nil {
+			if otpErrorStatus(err) == fiber.StatusInternalServerError {
+				log.Printf("Error sending verification code to %s: %v", phone, err)
+				return c.Status(500).JSON(fiber.Map{"error": "failed to send code"})
+			}
+			return c.Status(otpErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{"message": "code sent", "phone": phone})
+	}
+}
+
+// VerifyPhoneHandler confirms the code and stores the verified phone on the account
+func VerifyPhoneHandler(db *gorm.DB, sender sms.Sender) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		var body struct {
+			Phone string `json:"phone"`
+			Code  string `json:"code"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		phone, err := validation.NormalizePhone(body.Phone)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		if err := services.NewPhoneOTPService(db, sender).VerifyCode(phone, services.OTPPurposeVerify, body.Code); err != nil {
+			return c.Status(otpErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		now := time.Now()
+		if err := db.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"phone":             phone,
+			"phone_verified_at": now,
+		}).Error; err != nil {
+			// Unique index violation if another account claimed the number meanwhile
+			return c.Status(409).JSON(fiber.Map{"error": "failed to save phone number"})
+		}
+
+		user.Phone = &phone
+		user.PhoneVerifiedAt = &now
+		return c.JSON(user)
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/services"
+)
+
+// NearestPickupPointsHandler lists active pickup points closest to a location.
+// Query: lat, lng, optional store_id, radius_km and limit.
+func NearestPickupPointsHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lat, errLat := strconv.ParseFloat(c.Query("lat"), 64)
+		lng, errLng := strconv.ParseFloat(c.Query("lng"), 64)
+		if errLat != nil || errLng != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+			return c.Status(400).JSON(fiber.Map{"error": "valid lat and lng query parameters required"})
+		}
+
+		var storeID *uuid.UUID
+		if raw := c.Query("store_id"); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid store_id"})
+			}
+			storeID = &id
+		}
+		radiusKm := c.QueryFloat("radius_km", services.DefaultPickupSearchRadiusKm)
+		limit := c.QueryInt("limit", services.DefaultPickupSearchLimit)
+
+		points, err := services.NewPickupPointService(db).Nearest(lat, lng, storeID, radiusKm, limit)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to search pickup points"})
+		}
+
+		return c.JSON(points)
+	}
+}
+
+// Admin: List Pickup Points (including inactive)
+func AdminListPickupPointsHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		points, err := services.NewPickupPointService(db).List()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch pickup points"})
+		}
+
+		return c.JSON(points)
+	}
+}
+
+// Admin: Create Pickup Point
+func CreatePickupPointHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input services.PickupPointInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if err := services.ValidatePickupPoint(input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		point, err := services.NewPickupPointService(db).Create(input)
+		if err != nil {
+			return pickupPointError(c, err, "failed to create pickup point")
+		}
+
+		return c.Status(201).JSON(point)
+	}
+}
+
+// Admin: Update Pickup Point
+func UpdatePickupPointHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		pointID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid pickup point id"})
+		}
+
+		var input services.PickupPointInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if err := services.ValidatePickupPoint(input); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		point, err := services.NewPickupPointService(db).Update(pointID, input)
+		if err != nil {
+			return pickupPointError(c, err, "failed to update pickup point")
+		}
+
+		return c.JSON(point)
+	}
+}
+
+// Admin: Delete Pickup Point
+func DeletePickupPointHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		pointID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid pickup point id"})
+		}
+
+		if err := services.NewPickupPointService(db).Delete(pointID); err != nil {
+			return pickupPointError(c, err, "failed to delete pickup point")
+		}
+
+		return c.JSON(fiber.Map{"message": "pickup point deleted"})
+	}
+}
+
+// pickupPointError maps pickup point service errors to responses
+func pickupPointError(c *fiber.Ctx, err error, fallback string) error {
+	switch {
+	case errors.Is(err, services.ErrPickupPointNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrPickupPointCodeTaken), errors.Is(err, services.ErrPickupPointInUse):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": fallback})
+}
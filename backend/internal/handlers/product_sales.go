@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/authz"
+	"trumall/internal/models"
+	"trumall/internal/services"
+)
+
+// ListActiveSalesHandler lists the products on sale right now, for the deals
+// page
+func ListActiveSalesHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		onSale := db.Model(&models.ProductSale{}).Scopes(services.ActiveSales).Select("product_id")
+
+		var products []models.Product
+		if err := db.Preload("Store").Preload("Images").Preload("Sales", services.ActiveSales).
+			Where("id IN (?)", onSale).Find(&products).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch sales"})
+		}
+
+		return c.JSON(products)
+	}
+}
+
+// ListProductSalesHandler lists a product's past, current and scheduled sales
+// with how many units each has sold
+func ListProductSalesHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		product, ok, resp := saleProduct(c, db, authz.ActionProductsRead)
+		if !ok {
+			return resp
+		}
+
+		sales, err := services.NewProductSaleService(db).List(product.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch sales"})
+		}
+
+		return c.JSON(sales)
+	}
+}
+
+// CreateProductSaleHandler schedules a sale price for a product
+func CreateProductSaleHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		product, ok, resp := saleProduct(c, db, authz.ActionProductsWrite)
+		if !ok {
+			return resp
+		}
+
+		var input services.ProductSaleInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+
+		user := c.Locals("user").(models.User)
+		sale, err := services.NewProductSaleService(db).Create(product, input, user.ID)
+		if err != nil {
+			return productSaleError(c, err, "failed to create sale")
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(sale)
+	}
+}
+
+// UpdateProductSaleHandler edits one of a product's sales
+func UpdateProductSaleHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		product, ok, resp := saleProduct(c, db, authz.ActionProductsWrite)
+		if !ok {
+			return resp
+		}
+		saleID, err := uuid.Parse(c.Params("saleId"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid sale ID"})
+		}
+
+		var input services.ProductSaleInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+
+		sale, err := services.NewProductSaleService(db).Update(product, saleID, input)
+		if err != nil {
+			return productSaleError(c, err, "failed to update sale")
+		}
+
+		return c.JSON(sale)
+	}
+}
+
+// DeleteProductSaleHandler cancels a scheduled sale, or ends a running one
+func DeleteProductSaleHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		product, ok, resp := saleProduct(c, db, authz.ActionProductsWrite)
+		if !ok {
+			return resp
+		}
+		saleID, err := uuid.Parse(c.Params("saleId"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid sale ID"})
+		}
+
+		if err := services.NewProductSaleService(db).Delete(product.ID, saleID); err != nil {
+			return productSaleError(c, err, "failed to delete sale")
+		}
+
+		return c.JSON(fiber.Map{"message": "sale ended"})
+	}
+}
+
+// saleProduct loads the product from the route and checks the user may act on
+// its store's products
+func saleProduct(c *fiber.Ctx, db *gorm.DB, action authz.Action) (models.Product, bool, error) {
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return models.Product{}, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid product ID"})
+	}
+
+	var product models.Product
+	if err := db.First(&product, "id = ?", productID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return product, false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "product not found"})
+		}
+		return product, false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+
+	user := c.Locals("user").(models.User)
+	if ok, resp := authorizeStore(c, db, user, action, product.StoreID); !ok {
+		return product, false, resp
+	}
+	return product, true, nil
+}
+
+// productSaleError maps sale errors to responses
+func productSaleError(c *fiber.Ctx, err error, fallback string) error {
+	var invalid services.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "field": invalid.Field})
+	case errors.Is(err, services.ErrSaleNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrSaleOverlaps):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fallback})
+}
@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -14,7 +17,10 @@ import (
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 
+	"trumall/internal/authz"
 	"trumall/internal/models"
+	"trumall/internal/services"
+	"trumall/internal/validation"
 )
 
 // CreateProductHandler requires auth and checks that the authenticated user is the store owner.
@@ -60,13 +66,9 @@ func CreateProductHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(401).JSON(fiber.Map{"error": "unauthenticated"})
 		}
 
-		// Verify store ownership
-		var store models.Store
-		if err := db.First(&store, "id = ? AND owner_id = ?", sid, user.ID).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				return c.Status(403).JSON(fiber.Map{"error": "you do not own this store"})
-			}
-			return c.Status(500).JSON(fiber.Map{"error": "database error"})
+		// Verify the user may add products to this store (owner or staff)
+		if ok, resp := authorizeStore(c, db, user, authz.ActionProductsWrite, sid); !ok {
+			return resp
 		}
 
 		// Prepare product
@@ -131,6 +133,21 @@ func CreateProductHandler(db *gorm.DB) fiber.Handler {
 			}
 		}
 
+		// Handle category
+		if err := applyCategory(form, &p); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		// Handle the per-order quantity limit
+		if err := applyMaxOrderQuantity(form, &p); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		// Handle shipping weight and dimensions
+		if err := applyShippingDimensions(form, &p); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
 		// Handle image uploads
 		files := form.File["images"]
 		var productImages []models.ProductImage
@@ -202,7 +219,7 @@ func ListProductsHandler(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var products []models.Product
 
-		if err := db.Preload("Store").Preload("Images").Find(&products).Error; err != nil {
+		if err := db.Preload("Store").Preload("Images").Preload("Sales", services.ActiveSales).Find(&products).Error; err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch products"})
 		}
 
@@ -226,7 +243,7 @@ func GetProductHandler(db *gorm.DB) fiber.Handler {
 		}
 
 		var product models.Product
-		if err := db.Preload("Store").Preload("Images").First(&product, "id = ?", productID).Error; err != nil {
+		if err := db.Preload("Store").Preload("Images").Preload("Sales", services.ActiveSales).First(&product, "id = ?", productID).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
 				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "product not found"})
 			}
@@ -250,7 +267,7 @@ func ListProductsByStoreHandler(db *gorm.DB) fiber.Handler {
 		}
 
 		var products []models.Product
-		if err := db.Preload("Store").Preload("Images").Where("store_id = ?", parsedStoreID).Find(&products).Error; err != nil {
+		if err := db.Preload("Store").Preload("Images").Preload("Sales", services.ActiveSales).Where("store_id = ?", parsedStoreID).Find(&products).Error; err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch products for store"})
 		}
 
@@ -288,9 +305,9 @@ func UpdateProductHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
 		}
 
-		// Verify store ownership
-		if product.Store.OwnerID != user.ID {
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "you do not own this product's store"})
+		// Verify the user may edit this store's products (owner or staff)
+		if ok, resp := authorizeStore(c, db, user, authz.ActionProductsWrite, product.StoreID); !ok {
+			return resp
 		}
 
 		// Parse multipart form
@@ -371,6 +388,21 @@ func UpdateProductHandler(db *gorm.DB) fiber.Handler {
 			}
 		}
 
+		// Handle category
+		if err := applyCategory(form, &product); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		// Handle the per-order quantity limit
+		if err := applyMaxOrderQuantity(form, &product); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		// Handle shipping weight and dimensions
+		if err := applyShippingDimensions(form, &product); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
 		if err := db.Save(&product).Error; err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update product"})
 		}
@@ -404,9 +436,9 @@ func DeleteProductHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
 		}
 
-		// Verify store ownership
-		if product.Store.OwnerID != user.ID {
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "you do not own this product's store"})
+		// Verify the user may delete this store's products (owner or manager)
+		if ok, resp := authorizeStore(c, db, user, authz.ActionProductsDelete, product.StoreID); !ok {
+			return resp
 		}
 
 		err = db.Transaction(func(tx *gorm.DB) error {
@@ -435,19 +467,15 @@ func GetSellerProductsHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
 		}
 
-		var stores []models.Store
-		if err := db.Where("owner_id = ?", user.ID).Find(&stores).Error; err != nil {
+		// Owned stores plus stores where the user is staff (narrowed to the API key's stores)
+		storeIDs, err := accessibleStoreIDs(c, db, user, authz.ActionProductsRead)
+		if err != nil {
 			log.Printf("Error fetching stores for user %s: %v", user.ID, err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch stores"})
 		}
 
-		var storeIDs []string
-		for _, store := range stores {
-			storeIDs = append(storeIDs, store.ID.String())
-		}
-
 		var products []models.Product
-		if err := db.Preload("Store").Preload("Images").Where("store_id IN ?", storeIDs).Find(&products).Error; err != nil {
+		if err := db.Preload("Store").Preload("Images").Preload("Sales", services.ActiveSales).Where("store_id IN ?", storeIDs).Find(&products).Error; err != nil {
 			log.Printf("Error fetching products for seller %s: %v", user.ID, err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch products"})
 		}
@@ -480,9 +508,9 @@ func DeleteSellerProductHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
 		}
 
-		// Verify store ownership
-		if product.Store.OwnerID != user.ID {
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "you do not own this product's store"})
+		// Verify the user may delete this store's products (owner or manager)
+		if ok, resp := authorizeStore(c, db, user, authz.ActionProductsDelete, product.StoreID); !ok {
+			return resp
 		}
 
 		// Delete product images from storage
@@ -540,6 +568,7 @@ func SearchProductsHandler(db *gorm.DB) fiber.Handler {
 		if err := db.Where("LOWER(title) LIKE LOWER(?) OR LOWER(description) LIKE LOWER(?)", searchPattern, searchPattern).
 			Preload("Store").
 			Preload("Images").
+			Preload("Sales", services.ActiveSales).
 			Find(&products).Error; err != nil {
 			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "failed to search products"})
 		}
@@ -555,3 +584,74 @@ func SearchProductsHandler(db *gorm.DB) fiber.Handler {
 	}
 }
 
+// applyCategory reads the category name from the form and stores its slug; an
+// empty value clears it
+func applyCategory(form *multipart.Form, p *models.Product) error {
+	values, ok := form.Value["category"]
+	if !ok || len(values) == 0 {
+		return nil
+	}
+	slug, err := validation.NormalizeCategory(values[0])
+	if err != nil {
+		return err
+	}
+	p.Category = nil
+	if slug != "" {
+		p.Category = &slug
+	}
+	return nil
+}
+
+// applyMaxOrderQuantity reads max_order_quantity from the form; an empty value
+// or 0 removes the limit
+func applyMaxOrderQuantity(form *multipart.Form, p *models.Product) error {
+	values, ok := form.Value["max_order_quantity"]
+	if !ok || len(values) == 0 {
+		return nil
+	}
+	p.MaxOrderQuantity = nil
+	if values[0] == "" {
+		return nil
+	}
+	limit, err := strconv.Atoi(values[0])
+	if err != nil || limit < 0 {
+		return errors.New("max_order_quantity must be a non-negative integer")
+	}
+	if limit > 0 {
+		p.MaxOrderQuantity = &limit
+	}
+	return nil
+}
+
+// applyShippingDimensions reads weight_grams, length_cm, width_cm and height_cm from
+// the form, leaving fields that are not present unchanged
+func applyShippingDimensions(form *multipart.Form, p *models.Product) error {
+	if weights, ok := form.Value["weight_grams"]; ok && len(weights) > 0 {
+		weight, err := strconv.Atoi(weights[0])
+		if err != nil || weight < 0 {
+			return errors.New("weight_grams must be a non-negative integer")
+		}
+		p.WeightGrams = weight
+	}
+
+	dimensions := []struct {
+		field string
+		dest  *float64
+	}{
+		{"length_cm", &p.LengthCm},
+		{"width_cm", &p.WidthCm},
+		{"height_cm", &p.HeightCm},
+	}
+	for _, d := range dimensions {
+		values, ok := form.Value[d.field]
+		if !ok || len(values) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%s must be a non-negative number", d.field)
+		}
+		*d.dest = v
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/authz"
+	"trumall/internal/courier"
+	"trumall/internal/models"
+	"trumall/internal/services"
+)
+
+// ListCouriersHandler lists the couriers sellers can ship with
+func ListCouriersHandler(couriers *courier.Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"couriers": couriers.Codes()})
+	}
+}
+
+// CreateShipmentHandler books a paid order's parcel with a courier
+func CreateShipmentHandler(db *gorm.DB, couriers *courier.Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		}
+
+		var order models.Order
+		if err := db.First(&order, "id = ?", c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "order not found"})
+		}
+		if ok, resp := authorizeStore(c, db, user, authz.ActionOrdersUpdate, order.StoreID); !ok {
+			return resp
+		}
+
+		var input services.CreateShipmentInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if input.Courier == "" {
+			input.Courier = courier.ManualCode
+		}
+
+		shipment, err := services.NewShipmentService(db, couriers).Create(c.Context(), order.ID, input)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrOrderNotShippable):
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+			case errors.Is(err, courier.ErrUnknownCourier), errors.Is(err, courier.ErrTrackingNumberNeeded):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+			}
+			log.Printf("Error creating shipment for order %s: %v", order.ID, err)
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "failed to create shipment with courier"})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(shipment)
+	}
+}
+
+// ListOrderShipmentsHandler returns an order's shipments and tracking events to
+// its buyer or the store's staff
+func ListOrderShipmentsHandler(db *gorm.DB, couriers *courier.Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		}
+
+		var order models.Order
+		if err := db.First(&order, "id = ?", c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "order not found"})
+		}
+		if order.BuyerID != user.ID {
+			if ok, resp := authorizeStore(c, db, user, authz.ActionOrdersRead, order.StoreID); !ok {
+				return resp
+			}
+		}
+
+		shipments, err := services.NewShipmentService(db, couriers).ListForOrder(order.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch shipments"})
+		}
+
+		return c.JSON(fiber.Map{"order_status": order.Status, "shipments": shipments})
+	}
+}
+
+// ShipmentLabelHandler returns a printable label from the shipment's courier
+func ShipmentLabelHandler(db *gorm.DB, couriers *courier.Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		shipment, ok, resp := storeShipment(c, db, couriers)
+		if !ok {
+			return resp
+		}
+
+		label, err := services.NewShipmentService(db, couriers).Label(c.Context(), *shipment)
+		if err != nil {
+			if errors.Is(err, courier.ErrLabelUnsupported) {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+			}
+			log.Printf("Error fetching label for shipment %s: %v", shipment.ID, err)
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "failed to fetch label from courier"})
+		}
+
+		c.Set(fiber.HeaderContentType, label.ContentType)
+		return c.Send(label.Data)
+	}
+}
+
+// AddShipmentEventHandler records a tracking update for a manually couriered shipment
+func AddShipmentEventHandler(db *gorm.DB, couriers *courier.Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		shipment, ok, resp := storeShipment(c, db, couriers)
+		if !ok {
+			return resp
+		}
+
+		var body struct {
+			Status      string `json:"status"`
+			Description string `json:"description"`
+			Location    string `json:"location"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+
+		service := services.NewShipmentService(db, couriers)
+		if err := service.AddManualEvent(shipment, body.Status, body.Description, body.Location); err != nil {
+			switch {
+			case errors.Is(err, services.ErrShipmentNotManual):
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+			case errors.Is(err, services.ErrInvalidShipStatus):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to record tracking event"})
+		}
+
+		updated, err := service.Get(shipment.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch shipment"})
+		}
+		return c.JSON(updated)
+	}
+}
+
+// CourierWebhookHandler receives tracking updates from a courier. The courier
+// authenticates the request (e.g. by signature) when parsing it.
+func CourierWebhookHandler(db *gorm.DB, couriers *courier.Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		headers := http.Header{}
+		c.Request().Header.VisitAll(func(key, value []byte) {
+			headers.Add(string(key), string(value))
+		})
+
+		recorded, err := services.NewShipmentService(db, couriers).HandleWebhook(c.Params("courier"), headers, c.Body())
+		if err != nil {
+			switch {
+			case errors.Is(err, courier.ErrUnknownCourier), errors.Is(err, courier.ErrWebhookUnsupported):
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+			case errors.Is(err, courier.ErrInvalidSignature):
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+			}
+			log.Printf("Courier %s webhook failed: %v", c.Params("courier"), err)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		return c.JSON(fiber.Map{"recorded": recorded})
+	}
+}
+
+// storeShipment loads the :id shipment and checks the user may update its store's orders
+func storeShipment(c *fiber.Ctx, db *gorm.DB, couriers *courier.Registry) (*models.Shipment, bool, error) {
+	user, ok := c.Locals("user").(models.User)
+	if !ok {
+		return nil, false, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	shipmentID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return nil, false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid shipment id"})
+	}
+
+	shipment, err := services.NewShipmentService(db, couriers).Get(shipmentID)
+	if err != nil {
+		if errors.Is(err, services.ErrShipmentNotFound) {
+			return nil, false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return nil, false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch shipment"})
+	}
+	if ok, resp := authorizeStore(c, db, user, authz.ActionOrdersUpdate, shipment.StoreID); !ok {
+		return nil, false, resp
+	}
+	return shipment, true, nil
+}
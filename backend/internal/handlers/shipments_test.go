@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/courier"
+	"trumall/internal/models"
+	"trumall/internal/services"
+	"trumall/internal/testdb"
+)
+
+const testCourierSecret = "test-secret"
+
+type shipmentFixture struct {
+	db       *gorm.DB
+	app      *fiber.App
+	fake     *courier.FakeCourier
+	order    models.Order
+	shipment *models.Shipment
+}
+
+// newShipmentFixture books a paid order's parcel with the fake courier and
+// mounts the webhook endpoint
+func newShipmentFixture(t *testing.T) *shipmentFixture {
+	t.Helper()
+	db := testdb.Open(t, &models.User{}, &models.Store{}, &models.Address{}, &models.PickupPoint{},
+		&models.Product{}, &models.Order{}, &models.OrderItem{}, &models.Shipment{}, &models.ShipmentEvent{})
+
+	buyer := models.User{Name: "Buyer"}
+	if err := db.Create(&buyer).Error; err != nil {
+		t.Fatal(err)
+	}
+	store := models.Store{OwnerID: uuid.New(), Name: "Store", WarehouseCity: "Nairobi"}
+	if err := db.Create(&store).Error; err != nil {
+		t.Fatal(err)
+	}
+	address := models.Address{UserID: buyer.ID, Street: "1 Moi Avenue", City: "Mombasa", Country: "KE"}
+	if err := db.Create(&address).Error; err != nil {
+		t.Fatal(err)
+	}
+	order := models.Order{BuyerID: buyer.ID, StoreID: store.ID, ShippingAddressID: &address.ID, TotalCents: 100000, Status: "paid"}
+	if err := db.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	fake := courier.NewFakeCourier(testCourierSecret)
+	couriers := courier.NewRegistry(courier.ManualCourier{}, fake)
+	shipment, err := services.NewShipmentService(db, couriers).Create(context.Background(), order.ID,
+		services.CreateShipmentInput{Courier: courier.FakeCode})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	app := fiber.New()
+	app.Post("/api/webhooks/couriers/:courier", CourierWebhookHandler(db, couriers))
+	return &shipmentFixture{db: db, app: app, fake: fake, order: order, shipment: shipment}
+}
+
+// post sends a fake courier webhook with the given events, signed with sign
+func (f *shipmentFixture) post(t *testing.T, sign func([]byte) string, events ...courier.TrackingEvent) (int, map[string]interface{}) {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{"events": events})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/api/webhooks/couriers/fake", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if sign != nil {
+		req.Header.Set(courier.FakeSignatureHeader, sign(body))
+	}
+	resp, err := f.app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	var out map[string]interface{}
+	json.Unmarshal(raw, &out)
+	return resp.StatusCode, out
+}
+
+func (f *shipmentFixture) event(status, eventID string, at time.Time) courier.TrackingEvent {
+	return courier.TrackingEvent{
+		TrackingNumber: f.shipment.TrackingNumber,
+		Status:         status,
+		Description:    status,
+		OccurredAt:     at,
+		EventID:        eventID,
+	}
+}
+
+func (f *shipmentFixture) reload(t *testing.T) (models.Shipment, models.Order, int64) {
+	t.Helper()
+	var shipment models.Shipment
+	if err := f.db.First(&shipment, "id = ?", f.shipment.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	var order models.Order
+	if err := f.db.First(&order, "id = ?", f.order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	var events int64
+	if err := f.db.Model(&models.ShipmentEvent{}).Where("shipment_id = ?", f.shipment.ID).Count(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	return shipment, order, events
+}
+
+func TestCreateShipmentWithFakeCourier(t *testing.T) {
+	f := newShipmentFixture(t)
+
+	if !strings.HasPrefix(f.shipment.TrackingNumber, "FAKE") {
+		t.Errorf("tracking number = %q; want a FAKE one", f.shipment.TrackingNumber)
+	}
+	if f.shipment.CourierCode != courier.FakeCode || f.shipment.CarrierName != "Fake Courier" {
+		t.Errorf("courier = %s/%s; want fake/Fake Courier", f.shipment.CourierCode, f.shipment.CarrierName)
+	}
+
+	shipment, order, events := f.reload(t)
+	if shipment.Status != courier.StatusLabelCreated || shipment.ShippedAt != nil {
+		t.Errorf("shipment status = %s, shipped_at = %v; want label_created, unset", shipment.Status, shipment.ShippedAt)
+	}
+	if order.Status != "paid" {
+		t.Errorf("order status = %s; want paid until the parcel moves", order.Status)
+	}
+	if events != 1 {
+		t.Errorf("events = %d; want the label_created event", events)
+	}
+
+	// Only paid orders can be shipped
+	f.db.Model(&models.Order{}).Where("id = ?", f.order.ID).Update("status", "pending")
+	couriers := courier.NewRegistry(f.fake)
+	if _, err := services.NewShipmentService(f.db, couriers).Create(context.Background(), f.order.ID,
+		services.CreateShipmentInput{Courier: courier.FakeCode}); err != services.ErrOrderNotShippable {
+		t.Errorf("Create for a pending order = %v; want ErrOrderNotShippable", err)
+	}
+}
+
+func TestCourierWebhookMovesShipmentAndOrder(t *testing.T) {
+	f := newShipmentFixture(t)
+	// Courier events follow the label_created event recorded at booking
+	start := time.Now().Add(time.Minute)
+
+	status, body := f.post(t, f.fake.Sign, f.event(courier.StatusPickedUp, "e1", start))
+	if status != fiber.StatusOK || body["recorded"] != float64(1) {
+		t.Fatalf("picked_up webhook = %d %v; want 200 with 1 recorded", status, body)
+	}
+	shipment, order, _ := f.reload(t)
+	if shipment.Status != courier.StatusPickedUp || shipment.ShippedAt == nil {
+		t.Errorf("shipment status = %s, shipped_at = %v; want picked_up, set", shipment.Status, shipment.ShippedAt)
+	}
+	if order.Status != services.OrderStatusShipped {
+		t.Errorf("order status = %s; want shipped", order.Status)
+	}
+
+	status, body = f.post(t, f.fake.Sign,
+		f.event(courier.StatusInTransit, "e2", start.Add(10*time.Minute)),
+		f.event(courier.StatusDelivered, "e3", start.Add(30*time.Minute)))
+	if status != fiber.StatusOK || body["recorded"] != float64(2) {
+		t.Fatalf("delivery webhook = %d %v; want 200 with 2 recorded", status, body)
+	}
+	shipment, order, _ = f.reload(t)
+	if shipment.Status != courier.StatusDelivered || shipment.DeliveredAt == nil {
+		t.Errorf("shipment status = %s, delivered_at = %v; want delivered, set", shipment.Status, shipment.DeliveredAt)
+	}
+	if order.Status != services.OrderStatusDelivered {
+		t.Errorf("order status = %s; want delivered", order.Status)
+	}
+
+	// A late, older event is recorded but does not move the shipment back
+	status, _ = f.post(t, f.fake.Sign, f.event(courier.StatusOutForDelivery, "e4", start.Add(20*time.Minute)))
+	if status != fiber.StatusOK {
+		t.Fatalf("late webhook = %d; want 200", status)
+	}
+	shipment, order, _ = f.reload(t)
+	if shipment.Status != courier.StatusDelivered || order.Status != services.OrderStatusDelivered {
+		t.Errorf("after a late event: shipment %s, order %s; want both delivered", shipment.Status, order.Status)
+	}
+}
+
+func TestCourierWebhookIgnoresRedeliveredEvents(t *testing.T) {
+	f := newShipmentFixture(t)
+	event := f.event(courier.StatusInTransit, "e1", time.Now().Add(time.Minute))
+
+	if status, body := f.post(t, f.fake.Sign, event); status != fiber.StatusOK || body["recorded"] != float64(1) {
+		t.Fatalf("first delivery = %d %v; want 200 with 1 recorded", status, body)
+	}
+	_, _, before := f.reload(t)
+
+	if status, body := f.post(t, f.fake.Sign, event); status != fiber.StatusOK || body["recorded"] != float64(0) {
+		t.Fatalf("redelivery = %d %v; want 200 with 0 recorded", status, body)
+	}
+	if _, _, after := f.reload(t); after != before {
+		t.Errorf("events = %d after redelivery; want %d", after, before)
+	}
+
+	// Events for unknown tracking numbers are skipped, not retried
+	unknown := event
+	unknown.TrackingNumber = "FAKE-UNKNOWN"
+	unknown.EventID = "e2"
+	if status, body := f.post(t, f.fake.Sign, unknown); status != fiber.StatusOK || body["recorded"] != float64(0) {
+		t.Fatalf("unknown tracking number = %d %v; want 200 with 0 recorded", status, body)
+	}
+}
+
+func TestCourierWebhookRejectsBadSignature(t *testing.T) {
+	f := newShipmentFixture(t)
+	event := f.event(courier.StatusDelivered, "e1", time.Now())
+
+	forged := courier.NewFakeCourier("wrong-secret")
+	for name, sign := range map[string]func([]byte) string{
+		"wrong secret": forged.Sign,
+		"unsigned":     nil,
+	} {
+		status, _ := f.post(t, sign, event)
+		if status != fiber.StatusUnauthorized {
+			t.Errorf("%s: status = %d; want 401", name, status)
+		}
+	}
+
+	shipment, order, events := f.reload(t)
+	if shipment.Status != courier.StatusLabelCreated || order.Status != "paid" || events != 1 {
+		t.Errorf("after rejected webhooks: shipment %s, order %s, %d events; want label_created, paid, 1",
+			shipment.Status, order.Status, events)
+	}
+}
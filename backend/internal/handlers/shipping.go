@@ -15,8 +15,9 @@ func CalculateShippingHandler(db *gorm.DB) fiber.Handler {
 		user := c.Locals("user").(models.User)
 
 		type request struct {
-			AddressID      uuid.UUID `json:"address_id"`
-			ShippingMethod string    `json:"shipping_method"`
+			AddressID      uuid.UUID  `json:"address_id"`
+			PickupPointID  *uuid.UUID `json:"pickup_point_id"` // instead of address_id for pickup methods
+			ShippingMethod string     `json:"shipping_method"`
 		}
 
 		var req request
@@ -25,14 +26,16 @@ func CalculateShippingHandler(db *gorm.DB) fiber.Handler {
 		}
 
 		// Verify address belongs to user
-		var address models.Address
-		if err := db.Where("id = ? AND user_id = ?", req.AddressID, user.ID).First(&address).Error; err != nil {
-			return c.Status(404).JSON(fiber.Map{"error": "address not found"})
+		if req.PickupPointID == nil {
+			var address models.Address
+			if err := db.Where("id = ? AND user_id = ?", req.AddressID, user.ID).First(&address).Error; err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": "address not found"})
+			}
 		}
 
 		// Calculate cart total and get store ID from cart
 		var cart []models.CartItem
-		if err := db.Preload("Product.Store").Where("user_id = ?", user.ID).Find(&cart).Error; err != nil {
+		if err := db.Preload("Product.Store").Preload("Product.Sales", services.ActiveSales).Where("user_id = ? AND saved_for_later = ?", user.ID, false).Find(&cart).Error; err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch cart"})
 		}
 
@@ -40,20 +43,11 @@ func CalculateShippingHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(400).JSON(fiber.Map{"error": "cart is empty"})
 		}
 
-		var cartTotalCents int64
-		var storeID uuid.UUID
-		for _, item := range cart {
-			cartTotalCents += int64(item.Quantity) * int64(item.Product.PriceCents)
-			// Use the first product's store as the shipping origin
-			// Note: This assumes single-store checkout. For multi-store, handle separately.
-			if storeID == uuid.Nil {
-				storeID = item.Product.StoreID
-			}
-		}
-
-		// Calculate shipping with seller origin
+		// Quote with the same engine checkout uses; the quote_id locks the price in
 		shippingService := services.NewShippingService(db)
-		calculation, err := shippingService.CalculateShippingWithOrigin(storeID, req.AddressID, req.ShippingMethod, cartTotalCents)
+		quoteReq := cartQuoteRequest(user.ID, req.AddressID, req.ShippingMethod, cart)
+		quoteReq.PickupPointID = req.PickupPointID
+		calculation, err := shippingService.Quote(quoteReq)
 		if err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
@@ -62,30 +56,40 @@ func CalculateShippingHandler(db *gorm.DB) fiber.Handler {
 	}
 }
 
-// GetAvailableShippingMethodsHandler returns all available shipping methods for user's cart
+// GetAvailableShippingMethodsHandler returns all available shipping methods for user's cart,
+// delivered to address_id or collected from pickup_point_id
 func GetAvailableShippingMethodsHandler(db *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		user := c.Locals("user").(models.User)
 
-		addressIDParam := c.Query("address_id")
-		if addressIDParam == "" {
-			return c.Status(400).JSON(fiber.Map{"error": "address_id query parameter required"})
-		}
-
-		addressID, err := uuid.Parse(addressIDParam)
-		if err != nil {
-			return c.Status(400).JSON(fiber.Map{"error": "invalid address_id"})
-		}
+		var addressID uuid.UUID
+		var pickupPointID *uuid.UUID
+		switch {
+		case c.Query("pickup_point_id") != "":
+			id, err := uuid.Parse(c.Query("pickup_point_id"))
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid pickup_point_id"})
+			}
+			pickupPointID = &id
+		case c.Query("address_id") != "":
+			id, err := uuid.Parse(c.Query("address_id"))
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid address_id"})
+			}
+			addressID = id
 
-		// Verify address belongs to user
-		var address models.Address
-		if err := db.Where("id = ? AND user_id = ?", addressID, user.ID).First(&address).Error; err != nil {
-			return c.Status(404).JSON(fiber.Map{"error": "address not found"})
+			// Verify address belongs to user
+			var address models.Address
+			if err := db.Where("id = ? AND user_id = ?", addressID, user.ID).First(&address).Error; err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": "address not found"})
+			}
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": "address_id or pickup_point_id query parameter required"})
 		}
 
 		// Calculate cart total and get store ID from cart
 		var cart []models.CartItem
-		if err := db.Preload("Product.Store").Where("user_id = ?", user.ID).Find(&cart).Error; err != nil {
+		if err := db.Preload("Product.Store").Preload("Product.Sales", services.ActiveSales).Where("user_id = ? AND saved_for_later = ?", user.ID, false).Find(&cart).Error; err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch cart"})
 		}
 
@@ -93,41 +97,23 @@ func GetAvailableShippingMethodsHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(400).JSON(fiber.Map{"error": "cart is empty"})
 		}
 
-		var cartTotalCents int64
-		var storeID uuid.UUID
-		for _, item := range cart {
-			cartTotalCents += int64(item.Quantity) * int64(item.Product.PriceCents)
-			// Use the first product's store as the shipping origin
-			if storeID == uuid.Nil {
-				storeID = item.Product.StoreID
-			}
-		}
-
-		// Get all active shipping methods
-		var methods []models.ShippingMethod
-		if err := db.Where("is_active = ?", true).Find(&methods).Error; err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch shipping methods"})
-		}
-
-		// Calculate shipping for each method with seller origin
+		// Quote every active method for the cart
+		quoteReq := cartQuoteRequest(user.ID, addressID, "", cart)
+		quoteReq.PickupPointID = pickupPointID
 		shippingService := services.NewShippingService(db)
-		var results []services.ShippingCalculation
-		for _, method := range methods {
-			calc, err := shippingService.CalculateShippingWithOrigin(storeID, addressID, method.Code, cartTotalCents)
-			if err != nil {
-				// Skip methods that aren't available for this address/cart
-				continue
-			}
-			results = append(results, *calc)
-		}
-
-		if len(results) == 0 {
-			return c.Status(400).JSON(fiber.Map{"error": "no shipping methods available for this address"})
+		results, unavailable, err := shippingService.QuoteAllMethods(quoteReq)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error":       err.Error(),
+				"unavailable": unavailable,
+			})
 		}
 
 		return c.JSON(fiber.Map{
-			"cart_total_cents": cartTotalCents,
+			"cart_total_cents": quoteReq.CartTotalCents,
+			"parcel":           quoteReq.Parcel,
 			"shipping_methods": results,
+			"unavailable":      unavailable,
 		})
 	}
 }
@@ -144,3 +130,21 @@ func ListShippingMethodsHandler(db *gorm.DB) fiber.Handler {
 		return c.JSON(methods)
 	}
 }
+
+// cartQuoteRequest builds a shipping quote request for the buyer's cart.
+// Checkout is single-store, so the first product's store is the shipping origin.
+func cartQuoteRequest(userID, addressID uuid.UUID, methodCode string, cart []models.CartItem) services.QuoteRequest {
+	req := services.QuoteRequest{
+		UserID:     userID,
+		AddressID:  addressID,
+		MethodCode: methodCode,
+		Parcel:     services.ParcelForCart(cart),
+	}
+	for _, item := range cart {
+		req.CartTotalCents += int64(item.Quantity) * services.CurrentPriceCents(item.Product)
+		if req.StoreID == uuid.Nil {
+			req.StoreID = item.Product.StoreID
+		}
+	}
+	return req
+}
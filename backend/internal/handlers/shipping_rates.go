@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"trumall/internal/services"
+)
+
+// Admin: Export the whole Shipping Rate Configuration.
+// Query: format=json (default) or csv.
+func ExportShippingRatesHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cfg, err := services.NewShippingService(db).ExportRates()
+		if err != nil {
+			if errors.Is(err, services.ErrDuplicateZoneNames) {
+				return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "failed to export shipping rates"})
+		}
+
+		c.Set("X-Rates-Version", cfg.Version)
+		filename := "shipping-rates-" + time.Now().Format("20060102")
+		if c.Query("format") == "csv" {
+			var buf bytes.Buffer
+			if err := services.EncodeRatesCSV(&buf, *cfg); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "failed to export shipping rates"})
+			}
+			c.Attachment(filename + ".csv")
+			c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+			return c.Send(buf.Bytes())
+		}
+
+		c.Attachment(filename + ".json")
+		return c.JSON(cfg)
+	}
+}
+
+// Admin: Preview a Shipping Rate Import without applying it
+func PreviewShippingRatesHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cfg, err := parseRateImport(c)
+		if err != nil {
+			return rateImportError(c, err, "invalid rate file")
+		}
+
+		diff, err := services.NewShippingService(db).PreviewRates(cfg)
+		if err != nil {
+			return rateImportError(c, err, "failed to preview shipping rates")
+		}
+
+		return c.JSON(diff)
+	}
+}
+
+// Admin: Import a Shipping Rate Configuration, replacing the current one.
+// Pass expected_version (from the export or preview) to refuse the import if
+// someone changed the rates in the meantime.
+func ImportShippingRatesHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cfg, err := parseRateImport(c)
+		if err != nil {
+			return rateImportError(c, err, "invalid rate file")
+		}
+
+		expected := c.Query("expected_version", cfg.Version)
+		diff, err := services.NewShippingService(db).ApplyRates(cfg, expected)
+		if err != nil {
+			return rateImportError(c, err, "failed to import shipping rates")
+		}
+
+		return c.JSON(diff)
+	}
+}
+
+// parseRateImport reads a rate document from the request body or a multipart
+// "file" field, as CSV if the format query, content type or file name say so
+// and as JSON otherwise
+func parseRateImport(c *fiber.Ctx) (services.RateConfig, error) {
+	body := c.Body()
+	isCSV := c.Query("format") == "csv" || strings.Contains(c.Get(fiber.HeaderContentType), "csv")
+
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return services.RateConfig{}, err
+		}
+		defer f.Close()
+		if body, err = io.ReadAll(f); err != nil {
+			return services.RateConfig{}, err
+		}
+		isCSV = isCSV || strings.HasSuffix(strings.ToLower(file.Filename), ".csv")
+	}
+
+	if isCSV {
+		return services.DecodeRatesCSV(bytes.NewReader(body))
+	}
+	var cfg services.RateConfig
+	if err := json.Unmarshal(body, &cfg); err != nil {
+		return cfg, services.ValidationError{Field: "json", Message: err.Error()}
+	}
+	return cfg, nil
+}
+
+// rateImportError maps rate import errors to responses; validation problems
+// are all listed so the file can be fixed in one go
+func rateImportError(c *fiber.Ctx, err error, fallback string) error {
+	var problems services.RateValidationErrors
+	var invalid services.ValidationError
+	switch {
+	case errors.As(err, &problems):
+		return c.Status(400).JSON(fiber.Map{"error": "rate file has problems", "problems": problems})
+	case errors.As(err, &invalid):
+		return c.Status(400).JSON(fiber.Map{"error": "rate file has problems", "problems": []services.ValidationError{invalid}})
+	case errors.Is(err, services.ErrRatesChanged), errors.Is(err, services.ErrDuplicateZoneNames):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": fallback})
+}
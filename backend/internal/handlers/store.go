@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"fmt"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 
+	"trumall/internal/authz"
 	"trumall/internal/models"
+	"trumall/internal/services"
 )
 
 func GetMyStoresHandler(db *gorm.DB) fiber.Handler {
@@ -57,10 +61,14 @@ func UpdateStoreHandler(db *gorm.DB) fiber.Handler {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
 		}
 
+		if ok, resp := authorizeStore(c, db, user, authz.ActionStoreUpdate, storeID); !ok {
+			return resp
+		}
+
 		var store models.Store
-		if err := db.First(&store, "id = ? AND owner_id = ?", storeID, user.ID).Error; err != nil {
+		if err := db.First(&store, "id = ?", storeID).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
-				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "you do not own this store"})
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "store not found"})
 			}
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
 		}
@@ -81,6 +89,32 @@ func UpdateStoreHandler(db *gorm.DB) fiber.Handler {
 		store.WarehouseLatitude = input.WarehouseLatitude
 		store.WarehouseLongitude = input.WarehouseLongitude
 
+		// Dispatch calendar settings are only changed when sent
+		var calendar struct {
+			HandlingDays  *int    `json:"handling_days"`
+			OrderCutoff   *string `json:"order_cutoff"`
+			ShipsSaturday *bool   `json:"ships_saturday"`
+		}
+		if err := c.BodyParser(&calendar); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if calendar.HandlingDays != nil {
+			if *calendar.HandlingDays < 0 || *calendar.HandlingDays > 30 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "handling_days must be between 0 and 30"})
+			}
+			store.HandlingDays = *calendar.HandlingDays
+		}
+		if calendar.OrderCutoff != nil {
+			hour, minute, err := services.ParseOrderCutoff(*calendar.OrderCutoff)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+			}
+			store.OrderCutoff = fmt.Sprintf("%02d:%02d", hour, minute)
+		}
+		if calendar.ShipsSaturday != nil {
+			store.ShipsSaturday = *calendar.ShipsSaturday
+		}
+
 		if err := db.Save(&store).Error; err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update store"})
 		}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/authz"
+	"trumall/internal/models"
+	"trumall/internal/services"
+)
+
+// GetStoreShippingProfileHandler returns a store's own shipping terms. They are
+// public, so storefronts can show free-shipping thresholds and delivery areas.
+func GetStoreShippingProfileHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		storeID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid store ID"})
+		}
+
+		profile, err := services.NewShippingService(db).GetStoreShippingProfile(storeID)
+		if err != nil {
+			return storeShippingError(c, err, "failed to fetch shipping profile")
+		}
+
+		return c.JSON(profile)
+	}
+}
+
+// UpdateStoreShippingProfileHandler replaces a store's shipping profile
+func UpdateStoreShippingProfileHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		storeID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid store ID"})
+		}
+
+		user := c.Locals("user").(models.User)
+		if ok, resp := authorizeStore(c, db, user, authz.ActionStoreUpdate, storeID); !ok {
+			return resp
+		}
+
+		var input services.StoreShippingProfileInput
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+
+		profile, err := services.NewShippingService(db).SaveStoreShippingProfile(storeID, input)
+		if err != nil {
+			return storeShippingError(c, err, "failed to save shipping profile")
+		}
+
+		return c.JSON(profile)
+	}
+}
+
+// DeleteStoreShippingProfileHandler returns a store to the platform rates
+func DeleteStoreShippingProfileHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		storeID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid store ID"})
+		}
+
+		user := c.Locals("user").(models.User)
+		if ok, resp := authorizeStore(c, db, user, authz.ActionStoreUpdate, storeID); !ok {
+			return resp
+		}
+
+		if err := services.NewShippingService(db).DeleteStoreShippingProfile(storeID); err != nil {
+			return storeShippingError(c, err, "failed to delete shipping profile")
+		}
+
+		return c.JSON(fiber.Map{"message": "shipping profile deleted"})
+	}
+}
+
+// ListActiveShippingZonesHandler lists the platform's active zones, which
+// sellers refer to in their shipping profiles
+func ListActiveShippingZonesHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var zones []models.ShippingZone
+		if err := db.Where("is_active = ?", true).Order("name ASC").Find(&zones).Error; err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch shipping zones"})
+		}
+
+		return c.JSON(zones)
+	}
+}
+
+// storeShippingError maps store shipping profile errors to responses
+func storeShippingError(c *fiber.Ctx, err error, fallback string) error {
+	var invalid services.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error(), "field": invalid.Field})
+	case errors.Is(err, services.ErrStoreShippingProfileNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": fallback})
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/authz"
+	"trumall/internal/middleware"
+	"trumall/internal/models"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+// authorizeStore checks the store policy (and API key binding, if any) for the action.
+// When access is denied it writes the error response and returns false; callers
+// should then return the second value.
+func authorizeStore(c *fiber.Ctx, db *gorm.DB, user models.User, action authz.Action, storeID uuid.UUID) (bool, error) {
+	allowed, err := authz.NewPolicy(db).Can(user, action, storeID)
+	if err != nil {
+		log.Printf("Error checking %s on store %s for user %s: %v", action, storeID, user.ID, err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "database error"})
+	}
+	if !allowed {
+		return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "you do not have permission for this store"})
+	}
+	if !middleware.StoreAllowed(c, storeID) {
+		return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "api key is not allowed for this store"})
+	}
+	return true, nil
+}
+
+// accessibleStoreIDs returns the stores where the user may perform the action,
+// narrowed to the API key's stores when the request uses one
+func accessibleStoreIDs(c *fiber.Ctx, db *gorm.DB, user models.User, action authz.Action) ([]string, error) {
+	ids, err := authz.NewPolicy(db).StoresFor(user, action)
+	if err != nil {
+		return nil, err
+	}
+
+	storeIDs := []string{}
+	for _, id := range ids {
+		if middleware.StoreAllowed(c, id) {
+			storeIDs = append(storeIDs, id.String())
+		}
+	}
+	return storeIDs, nil
+}
+
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateStoreInvitationHandler invites a user to a store's staff with a role.
+// The token is returned once so the owner can share the accept link.
+func CreateStoreInvitationHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		storeID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid store ID"})
+		}
+		if ok, resp := authorizeStore(c, db, user, authz.ActionStaffManage, storeID); !ok {
+			return resp
+		}
+
+		var body struct {
+			Email string `json:"email"`
+			Role  string `json:"role"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if !authz.ValidStaffRole(body.Role) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "role must be one of manager, inventory_clerk, fulfilment"})
+		}
+
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create invitation"})
+		}
+		token := hex.EncodeToString(buf)
+
+		invitation := models.StoreInvitation{
+			ID:        uuid.New(),
+			StoreID:   storeID,
+			Role:      body.Role,
+			TokenHash: hashInvitationToken(token),
+			InvitedBy: user.ID,
+			ExpiresAt: time.Now().Add(invitationTTL),
+		}
+		if email := strings.ToLower(strings.TrimSpace(body.Email)); email != "" {
+			invitation.Email = &email
+		}
+
+		if err := db.Create(&invitation).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create invitation"})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"invitation": invitation,
+			"token":      token,
+		})
+	}
+}
+
+// RevokeStoreInvitationHandler cancels a pending invitation
+func RevokeStoreInvitationHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		storeID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid store ID"})
+		}
+		invitationID, err := uuid.Parse(c.Params("invitationId"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid invitation ID"})
+		}
+		if ok, resp := authorizeStore(c, db, user, authz.ActionStaffManage, storeID); !ok {
+			return resp
+		}
+
+		result := db.Model(&models.StoreInvitation{}).
+			Where("id = ? AND store_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitationID, storeID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to revoke invitation"})
+		}
+		if result.RowsAffected == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "invitation not found"})
+		}
+
+		return c.JSON(fiber.Map{"message": "invitation revoked"})
+	}
+}
+
+// AcceptStoreInvitationHandler adds the signed-in user to the store's staff
+func AcceptStoreInvitationHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		var body struct {
+			Token string `json:"token"`
+		}
+		if err := c.BodyParser(&body); err != nil || body.Token == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token required"})
+		}
+
+		var staff models.StoreStaff
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var invitation models.StoreInvitation
+			if err := tx.Where("token_hash = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?",
+				hashInvitationToken(body.Token), time.Now()).
+				First(&invitation).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return fiber.NewError(fiber.StatusNotFound, "invitation not found or expired")
+				}
+				return err
+			}
+
+			if invitation.Email != nil && (user.Email == nil || !strings.EqualFold(*user.Email, *invitation.Email)) {
+				return fiber.NewError(fiber.StatusForbidden, "this invitation was sent to a different account")
+			}
+
+			var store models.Store
+			if err := tx.First(&store, "id = ?", invitation.StoreID).Error; err != nil {
+				return err
+			}
+			if store.OwnerID == user.ID {
+				return fiber.NewError(fiber.StatusBadRequest, "you already own this store")
+			}
+
+			// Re-accepting for an existing member just updates their role
+			err := tx.Where("store_id = ? AND user_id = ?", invitation.StoreID, user.ID).First(&staff).Error
+			switch {
+			case err == nil:
+				staff.Role = invitation.Role
+				if err := tx.Save(&staff).Error; err != nil {
+					return err
+				}
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				staff = models.StoreStaff{
+					ID:        uuid.New(),
+					StoreID:   invitation.StoreID,
+					UserID:    user.ID,
+					Role:      invitation.Role,
+					InvitedBy: invitation.InvitedBy,
+				}
+				if err := tx.Create(&staff).Error; err != nil {
+					return err
+				}
+			default:
+				return err
+			}
+
+			now := time.Now()
+			return tx.Model(&invitation).Updates(map[string]interface{}{
+				"accepted_at": now,
+				"accepted_by": user.ID,
+			}).Error
+		})
+		if err != nil {
+			var fe *fiber.Error
+			if errors.As(err, &fe) {
+				return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+			}
+			log.Printf("Error accepting invitation for user %s: %v", user.ID, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to accept invitation"})
+		}
+
+		return c.JSON(staff)
+	}
+}
+
+// ListStoreStaffHandler lists staff members and pending invitations for a store
+func ListStoreStaffHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		storeID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid store ID"})
+		}
+		if ok, resp := authorizeStore(c, db, user, authz.ActionStaffManage, storeID); !ok {
+			return resp
+		}
+
+		var staff []models.StoreStaff
+		if err := db.Preload("User").Where("store_id = ?", storeID).Order("created_at ASC").Find(&staff).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch staff"})
+		}
+
+		var invitations []models.StoreInvitation
+		if err := db.Where("store_id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", storeID, time.Now()).
+			Order("created_at DESC").
+			Find(&invitations).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch invitations"})
+		}
+
+		return c.JSON(fiber.Map{
+			"staff":       staff,
+			"invitations": invitations,
+		})
+	}
+}
+
+// UpdateStoreStaffHandler changes a staff member's role
+func UpdateStoreStaffHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		storeID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid store ID"})
+		}
+		staffUserID, err := uuid.Parse(c.Params("userId"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid user ID"})
+		}
+		if ok, resp := authorizeStore(c, db, user, authz.ActionStaffManage, storeID); !ok {
+			return resp
+		}
+
+		var body struct {
+			Role string `json:"role"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if !authz.ValidStaffRole(body.Role) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "role must be one of manager, inventory_clerk, fulfilment"})
+		}
+
+		var staff models.StoreStaff
+		if err := db.Where("store_id = ? AND user_id = ?", storeID, staffUserID).First(&staff).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "staff member not found"})
+		}
+
+		staff.Role = body.Role
+		if err := db.Save(&staff).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update staff member"})
+		}
+
+		return c.JSON(staff)
+	}
+}
+
+// RemoveStoreStaffHandler removes a staff member from a store
+func RemoveStoreStaffHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		storeID, err := uuid.Parse(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid store ID"})
+		}
+		staffUserID, err := uuid.Parse(c.Params("userId"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid user ID"})
+		}
+		if ok, resp := authorizeStore(c, db, user, authz.ActionStaffManage, storeID); !ok {
+			return resp
+		}
+
+		result := db.Where("store_id = ? AND user_id = ?", storeID, staffUserID).Delete(&models.StoreStaff{})
+		if result.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to remove staff member"})
+		}
+		if result.RowsAffected == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "staff member not found"})
+		}
+
+		return c.JSON(fiber.Map{"message": "staff member removed"})
+	}
+}
+
+// GetMyStaffMembershipsHandler lists the stores where the signed-in user is staff
+func GetMyStaffMembershipsHandler(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(models.User)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthenticated"})
+		}
+
+		var memberships []models.StoreStaff
+		if err := db.Preload("Store").Where("user_id = ?", user.ID).Find(&memberships).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch memberships"})
+		}
+
+		return c.JSON(memberships)
+	}
+}
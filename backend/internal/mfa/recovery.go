@@ -0,0 +1,38 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// RecoveryCodeCount is how many one-time recovery codes are issued per enrolment
+const RecoveryCodeCount = 10
+
+// recoveryAlphabet avoids characters that are easily confused (0/O, 1/I/L)
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// GenerateRecoveryCodes returns n random codes in the form xxxxx-xxxxx
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	buf := make([]byte, 10)
+	for i := 0; i < n; i++ {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		for j, b := range buf {
+			if j == 5 {
+				sb.WriteByte('-')
+			}
+			sb.WriteByte(recoveryAlphabet[int(b)%len(recoveryAlphabet)])
+		}
+		codes = append(codes, sb.String())
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode lowercases and strips whitespace and dashes so codes typed by hand still match
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ToLower(strings.Join(strings.Fields(code), ""))
+}
@@ -0,0 +1,101 @@
+package mfa
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test secret ("12345678901234567890"), SHA-1 codes
+// truncated to six digits
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeAtMatchesRFC6238(t *testing.T) {
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tc := range cases {
+		code, err := CodeAt(rfcSecret, Step(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tc.code {
+			t.Errorf("CodeAt(%d) = %s; want %s", tc.unix, code, tc.code)
+		}
+	}
+}
+
+func TestValidateSkewWindow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	current := Step(now)
+
+	cases := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"current period", 0, true},
+		{"one period behind", -1, true},
+		{"one period ahead", 1, true},
+		{"two periods behind", -2, false},
+		{"two periods ahead", 2, false},
+	}
+	for _, tc := range cases {
+		code, _ := CodeAt(rfcSecret, current+tc.offset)
+		step, ok := Validate(rfcSecret, code, now)
+		if ok != tc.ok {
+			t.Errorf("%s: ok = %v; want %v", tc.name, ok, tc.ok)
+		}
+		if ok && step != current+tc.offset {
+			t.Errorf("%s: step = %d; want %d", tc.name, step, current+tc.offset)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Now()
+	code, _ := CodeAt(rfcSecret, Step(now))
+
+	if _, ok := Validate(rfcSecret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("a code typed with a space was rejected")
+	}
+	for _, bad := range []string{"", code[:5], code + "0", "abcdef"} {
+		if _, ok := Validate(rfcSecret, bad, now); ok {
+			t.Errorf("Validate(%q) accepted", bad)
+		}
+	}
+	if _, ok := Validate("not base32!", code, now); ok {
+		t.Error("an invalid secret validated a code")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("code %q is not xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q issued twice", code)
+		}
+		seen[code] = true
+	}
+
+	if got := NormalizeRecoveryCode(" ABCDE-fghjk \n"); got != "abcdefghjk" {
+		t.Errorf("NormalizeRecoveryCode = %q; want abcdefghjk", got)
+	}
+	if strings.ContainsAny(strings.Join(codes, ""), "01ilo") {
+		t.Error("codes contain easily confused characters")
+	}
+}
@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
@@ -10,51 +12,68 @@ import (
 	"gorm.io/gorm"
 
 	"trumall/internal/models"
+	"trumall/internal/services"
 )
 
-// RequireAuth validates the Authorization header Bearer token and attaches user info to context
-func RequireAuth(db *gorm.DB) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		auth := c.Get("Authorization")
-		if auth == "" {
-			return c.Status(401).JSON(fiber.Map{"error": "missing auth"})
-		}
+// parseBearerToken extracts and validates the JWT from the Authorization header.
+// On failure it returns the HTTP status and error message to send.
+func parseBearerToken(c *fiber.Ctx) (jwt.MapClaims, uuid.UUID, int, string) {
+	auth := c.Get("Authorization")
+	if auth == "" {
+		return nil, uuid.Nil, 401, "missing auth"
+	}
 
-		// Expect: "Bearer <token>"
-		var tokenStr string
-		_, err := fmt.Sscanf(auth, "Bearer %s", &tokenStr)
-		if err != nil || tokenStr == "" {
-			return c.Status(401).JSON(fiber.Map{"error": "invalid auth header"})
-		}
+	// Expect: "Bearer <token>"
+	var tokenStr string
+	_, err := fmt.Sscanf(auth, "Bearer %s", &tokenStr)
+	if err != nil || tokenStr == "" {
+		return nil, uuid.Nil, 401, "invalid auth header"
+	}
 
-		secret := os.Getenv("JWT_SECRET")
-		if secret == "" {
-			return c.Status(500).JSON(fiber.Map{"error": "server misconfigured (no jwt secret)"})
-		}
-		// parse and validate token
-		tok, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			// ensure signing method
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fiber.ErrUnauthorized
-			}
-			return []byte(secret), nil
-		})
-		if err != nil || !tok.Valid {
-			return c.Status(401).JSON(fiber.Map{"error": "invalid token"})
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return nil, uuid.Nil, 500, "server misconfigured (no jwt secret)"
+	}
+	// parse and validate token
+	tok, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		// ensure signing method
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fiber.ErrUnauthorized
 		}
+		return []byte(secret), nil
+	})
+	if err != nil || !tok.Valid {
+		return nil, uuid.Nil, 401, "invalid token"
+	}
 
-		claims, ok := tok.Claims.(jwt.MapClaims)
-		if !ok {
-			return c.Status(401).JSON(fiber.Map{"error": "invalid token claims"})
-		}
+	claims, ok := tok.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, uuid.Nil, 401, "invalid token claims"
+	}
+
+	sub, ok := claims["sub"].(string)
+	if !ok || sub == "" {
+		return nil, uuid.Nil, 401, "invalid token subject"
+	}
+	uid, err := uuid.Parse(sub)
+	if err != nil {
+		return nil, uuid.Nil, 401, "invalid user id in token"
+	}
 
-		sub, ok := claims["sub"].(string)
-		if !ok || sub == "" {
-			return c.Status(401).JSON(fiber.Map{"error": "invalid token subject"})
+	return claims, uid, 0, ""
+}
+
+// RequireAuth validates the Authorization header Bearer token and attaches user info to context
+func RequireAuth(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, uid, status, msg := parseBearerToken(c)
+		if status != 0 {
+			return c.Status(status).JSON(fiber.Map{"error": msg})
 		}
-		uid, err := uuid.Parse(sub)
-		if err != nil {
-			return c.Status(401).JSON(fiber.Map{"error": "invalid user id in token"})
+
+		// MFA pending tokens only grant access to the second login step
+		if typ, _ := claims["typ"].(string); typ == TokenTypeMFAPending {
+			return c.Status(401).JSON(fiber.Map{"error": "two-factor verification required"})
 		}
 
 		var user models.User
@@ -98,6 +117,100 @@ func RequireAuth(db *gorm.DB) fiber.Handler {
 	}
 }
 
+// OptionalAuth authenticates requests that carry an Authorization header, as
+// RequireAuth does, and lets anonymous ones through without a user
+func OptionalAuth(db *gorm.DB) fiber.Handler {
+	requireAuth := RequireAuth(db)
+	return func(c *fiber.Ctx) error {
+		if c.Get("Authorization") == "" {
+			return c.Next()
+		}
+		return requireAuth(c)
+	}
+}
+
+// TokenTypeMFAPending marks the short-lived token issued after a correct password
+// when the account still has to pass (or set up) two-factor authentication
+const TokenTypeMFAPending = "mfa_pending"
+
+// RequireMFAPending accepts only MFA pending tokens, for the second step of login
+func RequireMFAPending(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, uid, status, msg := parseBearerToken(c)
+		if status != 0 {
+			return c.Status(status).JSON(fiber.Map{"error": msg})
+		}
+
+		if typ, _ := claims["typ"].(string); typ != TokenTypeMFAPending {
+			return c.Status(401).JSON(fiber.Map{"error": "mfa token required"})
+		}
+
+		var user models.User
+		if err := db.First(&user, "id = ?", uid).Error; err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": "user not found"})
+		}
+
+		c.Locals("user", user)
+		c.Locals("user_id", uid)
+		c.Locals("mfa_pending", true)
+
+		return c.Next()
+	}
+}
+
+// RequireAuthOrAPIKey accepts either a user JWT (delegating to RequireAuth) or a seller
+// API key carrying the given scope. API keys are read from the X-API-Key header or
+// from an Authorization bearer value starting with the API key prefix.
+func RequireAuthOrAPIKey(db *gorm.DB, scope string) fiber.Handler {
+	jwtAuth := RequireAuth(db)
+	return func(c *fiber.Ctx) error {
+		raw := c.Get("X-API-Key")
+		if raw == "" {
+			if auth := c.Get("Authorization"); strings.HasPrefix(auth, "Bearer "+services.APIKeyPrefix) {
+				raw = strings.TrimPrefix(auth, "Bearer ")
+			}
+		}
+		if raw == "" {
+			return jwtAuth(c)
+		}
+
+		key, user, err := services.NewAPIKeyService(db).Authenticate(raw)
+		if err != nil {
+			if errors.Is(err, services.ErrAPIKeyInvalid) || errors.Is(err, services.ErrAPIKeyExpired) {
+				return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": "failed to verify api key"})
+		}
+		if !services.HasScope(key, scope) {
+			return c.Status(403).JSON(fiber.Map{"error": "api key is missing scope " + scope})
+		}
+
+		c.Locals("user", *user)
+		c.Locals("user_id", user.ID)
+		c.Locals("user_roles", []string(user.Roles))
+		c.Locals("api_key", key)
+
+		return c.Next()
+	}
+}
+
+// APIKeyFromContext returns the API key used for the request, or nil for JWT sessions
+func APIKeyFromContext(c *fiber.Ctx) *models.APIKey {
+	key, _ := c.Locals("api_key").(*models.APIKey)
+	return key
+}
+
+// StoreAllowed reports whether the request's credential may act on the store.
+// JWT sessions are unrestricted here (ownership is checked by the handlers);
+// API keys are limited to the stores they were bound to.
+func StoreAllowed(c *fiber.Ctx, storeID uuid.UUID) bool {
+	key := APIKeyFromContext(c)
+	if key == nil {
+		return true
+	}
+	return services.KeyAllowsStore(key, storeID)
+}
+
 // RequireRole ensures the user has the given role (e.g. "seller")
 func RequireRole(role string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"trumall/internal/models"
+	"trumall/internal/services"
+	"trumall/internal/testdb"
+)
+
+func TestRequireAuthOrAPIKey(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	db := testdb.Open(t, &models.User{}, &models.Store{}, &models.APIKey{})
+	seller := models.User{Name: "Seller"}
+	db.Create(&seller)
+	store := models.Store{OwnerID: seller.ID, Name: "Store"}
+	other := models.Store{OwnerID: seller.ID, Name: "Other"}
+	db.Create(&store)
+	db.Create(&other)
+
+	keys := services.NewAPIKeyService(db)
+	_, readOnly, err := keys.Create(seller.ID, services.CreateAPIKeyInput{
+		Name: "Reader", Scopes: []string{services.ScopeProductsRead}, StoreIDs: []uuid.UUID{store.ID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, writer, _ := keys.Create(seller.ID, services.CreateAPIKeyInput{
+		Name: "Writer", Scopes: []string{services.ScopeInventoryWrite}, StoreIDs: []uuid.UUID{store.ID},
+	})
+	revoked, revokedRaw, _ := keys.Create(seller.ID, services.CreateAPIKeyInput{
+		Name: "Old", Scopes: []string{services.ScopeInventoryWrite}, StoreIDs: []uuid.UUID{store.ID},
+	})
+	db.Model(revoked).Update("revoked_at", time.Now())
+
+	app := fiber.New()
+	app.Post("/stores/:id/inventory", RequireAuthOrAPIKey(db, services.ScopeInventoryWrite), func(c *fiber.Ctx) error {
+		storeID, _ := uuid.Parse(c.Params("id"))
+		if !StoreAllowed(c, storeID) {
+			return c.Status(403).JSON(fiber.Map{"error": "api key is not allowed for this store"})
+		}
+		return c.SendStatus(204)
+	})
+
+	cases := []struct {
+		name   string
+		header string
+		value  string
+		store  uuid.UUID
+		status int
+	}{
+		{"key with the scope", "X-API-Key", writer, store.ID, 204},
+		{"key as a bearer token", "Authorization", "Bearer " + writer, store.ID, 204},
+		{"key without the scope", "X-API-Key", readOnly, store.ID, 403},
+		{"key for another store", "X-API-Key", writer, other.ID, 403},
+		{"revoked key", "X-API-Key", revokedRaw, store.ID, 401},
+		{"made-up key", "X-API-Key", services.APIKeyPrefix + "abcdefgh_NOTASECRET", store.ID, 401},
+		{"no credentials", "", "", store.ID, 401},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("POST", "/stores/"+tc.store.String()+"/inventory", nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: status %d; want %d", tc.name, resp.StatusCode, tc.status)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/services"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	idempotencyAnonymousScope = "anonymous"
+)
+
+// Idempotency makes a POST safe to retry. A request carrying an
+// Idempotency-Key header runs once per key and user; repeating it replays the
+// stored response (marked with Idempotent-Replayed: true), a repeat while the
+// first is still running gets 409 (for at most the lease, after which the key
+// is taken to be abandoned and claimed afresh), and reusing the key for a different
+// request gets 422. Server errors and conflicts are not stored, so those
+// requests can be retried with the same key. Requests without the header are
+// handled as usual. Mount it after the auth middleware so keys are kept per
+// user.
+func Idempotency(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyKeyHeader)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return c.Status(400).JSON(fiber.Map{"error": "Idempotency-Key must be at most 255 characters"})
+		}
+
+		scope := idempotencyAnonymousScope
+		if user, ok := c.Locals("user").(models.User); ok {
+			scope = user.ID.String()
+		}
+
+		keys := services.NewIdempotencyService(db)
+		hash := services.IdempotencyRequestHash(c.Method(), c.Path(), c.Body())
+		record, claimed, err := keys.Begin(scope, key, hash)
+		switch {
+		case errors.Is(err, services.ErrIdempotencyInFlight):
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, services.ErrIdempotencyMismatch):
+			return c.Status(422).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			log.Printf("Error claiming idempotency key: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to check Idempotency-Key"})
+		}
+
+		if !claimed {
+			c.Set(IdempotentReplayedHeader, "true")
+			if record.ContentType != "" {
+				c.Set(fiber.HeaderContentType, record.ContentType)
+			}
+			return c.Status(record.StatusCode).Send(record.ResponseBody)
+		}
+
+		if err := c.Next(); err != nil {
+			if releaseErr := keys.Release(record); releaseErr != nil {
+				log.Printf("Error releasing idempotency key %s: %v", record.ID, releaseErr)
+			}
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		if status >= 500 || status == fiber.StatusConflict {
+			if err := keys.Release(record); err != nil {
+				log.Printf("Error releasing idempotency key %s: %v", record.ID, err)
+			}
+			return nil
+		}
+		body := append([]byte(nil), c.Response().Body()...)
+		contentType := string(c.Response().Header.ContentType())
+		if err := keys.Complete(record, status, contentType, body); err != nil {
+			log.Printf("Error storing response for idempotency key %s: %v", record.ID, err)
+			keys.Release(record)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"trumall/internal/models"
+	"trumall/internal/testdb"
+)
+
+// idempotentApp mounts a handler that counts its calls behind Idempotency,
+// answering with status
+func idempotentApp(t *testing.T, status *int) (*fiber.App, *int) {
+	t.Helper()
+	db := testdb.Open(t, &models.IdempotencyKey{})
+	user := models.User{ID: uuid.New()}
+	calls := 0
+
+	app := fiber.New()
+	app.Post("/orders", func(c *fiber.Ctx) error {
+		c.Locals("user", user)
+		return c.Next()
+	}, Idempotency(db), func(c *fiber.Ctx) error {
+		calls++
+		return c.Status(*status).JSON(fiber.Map{"call": calls})
+	})
+	return app, &calls
+}
+
+func postWithKey(t *testing.T, app *fiber.App, key, body string) (int, string, string) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data), resp.Header.Get(IdempotentReplayedHeader)
+}
+
+func TestIdempotencyReplaysStoredResponse(t *testing.T) {
+	status := 201
+	app, calls := idempotentApp(t, &status)
+
+	code, body, replayed := postWithKey(t, app, "k1", `{"qty":1}`)
+	if code != 201 || replayed != "" {
+		t.Fatalf("first request = %d (replayed %q); want 201", code, replayed)
+	}
+	code, again, replayed := postWithKey(t, app, "k1", `{"qty":1}`)
+	if code != 201 || again != body || replayed != "true" {
+		t.Errorf("retry = %d %s (replayed %q); want the stored %s", code, again, replayed, body)
+	}
+	if *calls != 1 {
+		t.Errorf("handler ran %d times; want 1", *calls)
+	}
+
+	if code, _, _ := postWithKey(t, app, "k1", `{"qty":2}`); code != 422 {
+		t.Errorf("same key, different body = %d; want 422", code)
+	}
+	postWithKey(t, app, "", `{"qty":1}`)
+	if *calls != 2 {
+		t.Errorf("a request without a key: handler ran %d times in total; want 2", *calls)
+	}
+}
+
+func TestIdempotencyDoesNotStoreFailures(t *testing.T) {
+	cases := []int{500, 409}
+	for _, failure := range cases {
+		t.Run(fmt.Sprint(failure), func(t *testing.T) {
+			status := failure
+			app, calls := idempotentApp(t, &status)
+
+			if code, _, _ := postWithKey(t, app, "k1", `{}`); code != failure {
+				t.Fatalf("first request = %d; want %d", code, failure)
+			}
+			status = 201
+			if code, _, replayed := postWithKey(t, app, "k1", `{}`); code != 201 || replayed != "" {
+				t.Errorf("retry = %d (replayed %q); want a fresh 201", code, replayed)
+			}
+			if *calls != 2 {
+				t.Errorf("handler ran %d times; want 2", *calls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyRejectsLongKeys(t *testing.T) {
+	status := 201
+	app, calls := idempotentApp(t, &status)
+	if code, _, _ := postWithKey(t, app, strings.Repeat("k", maxIdempotencyKeyLength+1), `{}`); code != 400 {
+		t.Errorf("long key = %d; want 400", code)
+	}
+	if *calls != 0 {
+		t.Error("the handler ran for a rejected key")
+	}
+}
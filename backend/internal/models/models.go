@@ -9,29 +9,36 @@ import (
 )
 
 type OrderItem struct {
-	ID             uuid.UUID `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
-	OrderID        uuid.UUID `gorm:"type:uuid;index" json:"order_id"`
-	ProductID      uuid.UUID `gorm:"type:uuid;index" json:"product_id"`
-	UnitPriceCents int64     `json:"unit_price_cents"`
-	Quantity       int       `json:"quantity"`
-	Product        Product   `gorm:"foreignKey:ProductID" json:"product"`
+	ID             uuid.UUID  `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
+	OrderID        uuid.UUID  `gorm:"type:uuid;index" json:"order_id"`
+	ProductID      uuid.UUID  `gorm:"type:uuid;index" json:"product_id"`
+	UnitPriceCents int64      `json:"unit_price_cents"`
+	Quantity       int        `json:"quantity"`
+	SaleID         *uuid.UUID `gorm:"type:uuid;index" json:"sale_id,omitempty"` // the sale whose price was charged
+	Product        Product    `gorm:"foreignKey:ProductID" json:"product"`
 }
 type Order struct {
 	ID                uuid.UUID      `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
 	BuyerID           uuid.UUID      `gorm:"type:uuid;index" json:"buyer_id"`
 	StoreID           uuid.UUID      `gorm:"type:uuid;index" json:"store_id"`
-	ShippingAddressID uuid.UUID      `gorm:"type:uuid;index" json:"shipping_address_id"` // New field
+	ShippingAddressID *uuid.UUID     `gorm:"type:uuid;index" json:"shipping_address_id,omitempty"` // nil for pickup orders
+	PickupPointID     *uuid.UUID     `gorm:"type:uuid;index" json:"pickup_point_id,omitempty"`     // set for click-and-collect orders
 	TotalCents        int64          `json:"total_cents"`
 	ShippingCostCents int64          `gorm:"default:0" json:"shipping_cost_cents"` // New field
 	Currency          string         `gorm:"default:USD" json:"currency"`
 	Status            string         `gorm:"default:pending" json:"status"`
 	ShippingMethod    string         `gorm:"size:50" json:"shipping_method"` // New field
-	EstimatedDelivery time.Time      `json:"estimated_delivery"`             // New field
+	EstimatedDelivery time.Time      `json:"estimated_delivery"`             // New field; latest expected date
+	EstimatedDeliveryEarliest *time.Time `json:"estimated_delivery_earliest,omitempty"`
+	DiscountCents     int64          `gorm:"not null;default:0" json:"discount_cents"` // coupons, off items and shipping; already taken off TotalCents
 	CreatedAt         time.Time      `json:"created_at"`
 	UpdatedAt         time.Time      `json:"updated_at"`
 	OrderItems        []OrderItem    `gorm:"foreignKey:OrderID" json:"order_items"`
 	Buyer             User           `gorm:"foreignKey:BuyerID" json:"buyer"`
-	ShippingAddress   Address        `gorm:"foreignKey:ShippingAddressID" json:"shipping_address"` // New field
+	ShippingAddress   *Address       `gorm:"foreignKey:ShippingAddressID" json:"shipping_address,omitempty"` // New field
+	PickupPoint       *PickupPoint   `gorm:"foreignKey:PickupPointID" json:"pickup_point,omitempty"`
+	Shipments         []Shipment     `gorm:"foreignKey:OrderID" json:"shipments,omitempty"`
+	Discounts         []OrderDiscount `gorm:"foreignKey:OrderID" json:"discounts,omitempty"`
 }
 type Payment struct {
 	ID                uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
@@ -40,9 +47,10 @@ type Payment struct {
 	ProviderTxID      *string   // e.g. Stripe ID or generic provider ref
 	AmountCents       int64     `gorm:"not null"`
 	Currency          string    `gorm:"default:'KES'"`
-	Status            string    `gorm:"default:'initiated'"` // initiated | pending | paid | failed
+	Status            string    `gorm:"default:'initiated'"` // initiated (prompt queued) | pending (prompt sent) | paid | failed | superseded (replaced by a newer prompt) | refund_due (paid for an order already closed)
 	Phone             *string   // customer phone
 	CheckoutRequestID *string   // M-Pesa STK request ID
+	FailureReason     *string   // why the prompt failed, or why a refund is due
 	MpesaReceipt      *string   // M-Pesa receipt number from callback
 	SorobanTxID       *string   // Stellar Soroban tx id
 	CreatedAt         time.Time `gorm:"autoCreateTime"`
@@ -57,6 +65,7 @@ type Product struct {
 	Currency         string         `gorm:"default:KES" json:"currency"`
 	SKU              *string        `json:"sku,omitempty"`
 	Stock            int            `gorm:"default:0" json:"stock"`
+	MaxOrderQuantity *int           `json:"max_order_quantity,omitempty"` // most units one order may have; nil for no limit
 	AuthenticityHash *string        `json:"authenticity_hash,omitempty"`
 
 	// UX Enhancement Fields
@@ -72,11 +81,19 @@ type Product struct {
 	WarrantyInfo       *string        `json:"warranty_info,omitempty"`
 	OriginalPriceCents *int64         `json:"original_price_cents,omitempty"`
 	Discount           *int           `json:"discount,omitempty"`
+	Category           *string        `gorm:"size:50;index" json:"category,omitempty"` // slug, e.g. "electronics"
+
+	// Shipping weight and packed dimensions; zero means unknown
+	WeightGrams int     `gorm:"not null;default:0" json:"weight_grams"`
+	LengthCm    float64 `gorm:"not null;default:0" json:"length_cm"`
+	WidthCm     float64 `gorm:"not null;default:0" json:"width_cm"`
+	HeightCm    float64 `gorm:"not null;default:0" json:"height_cm"`
 
 	CreatedAt        time.Time      `json:"created_at"`
 	UpdatedAt        time.Time      `json:"updated_at"`
 	Images           []ProductImage `gorm:"foreignKey:ProductID" json:"images"`
 	Reviews          []Review       `gorm:"foreignKey:ProductID" json:"reviews,omitempty"`
+	Sales            []ProductSale  `gorm:"foreignKey:ProductID" json:"sales,omitempty"` // preloaded with services.ActiveSales: the sales on now
 	// Back-reference: Many products belong to one store
 	Store Store `gorm:"foreignKey:StoreID" json:"store"`
 }
@@ -92,7 +109,7 @@ type ProductImage struct {
 type Review struct {
 	ID               uuid.UUID      `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
 	ProductID        uuid.UUID      `gorm:"type:uuid;index" json:"product_id"`
-	UserID           uuid.UUID      `gorm:"type:uuid;index" json:"user_id"`
+	UserID           *uuid.UUID     `gorm:"type:uuid;index" json:"user_id"` // nil once the author deletes their account
 	Rating           int            `gorm:"not null" json:"rating"` // 1-5
 	Comment          *string        `json:"comment,omitempty"`
 	Images           pq.StringArray `gorm:"type:text[]" json:"images,omitempty"` // Array of image URLs
@@ -114,28 +131,189 @@ type Store struct {
 	WarehousePostalCode  string  `gorm:"size:20" json:"warehouse_postal_code,omitempty"`
 	WarehouseLatitude    float64 `json:"warehouse_latitude,omitempty"`
 	WarehouseLongitude   float64 `json:"warehouse_longitude,omitempty"`
+	// Dispatch calendar for delivery estimates
+	HandlingDays         int     `gorm:"not null;default:1" json:"handling_days"`           // working days to pack and hand over
+	OrderCutoff          string  `gorm:"size:5;not null;default:'14:00'" json:"order_cutoff"` // HH:MM; later orders start the next working day
+	ShipsSaturday        bool    `gorm:"not null;default:false" json:"ships_saturday"`
 	CreatedAt            time.Time `json:"created_at"`
 	Products             []Product `gorm:"foreignKey:StoreID" json:"products"`
 }
+// StoreStaff grants a user a role (manager, inventory_clerk, fulfilment) within a store
+type StoreStaff struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	StoreID   uuid.UUID `gorm:"type:uuid;not null;index" json:"store_id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	Role      string    `gorm:"size:30;not null" json:"role"`
+	InvitedBy uuid.UUID `gorm:"type:uuid" json:"invited_by"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+	User      User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Store     Store     `gorm:"foreignKey:StoreID" json:"store,omitempty"`
+}
+
+// StoreInvitation invites someone to join a store's staff; only a hash of the token is stored
+type StoreInvitation struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	StoreID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"store_id"`
+	Email      *string    `json:"email,omitempty"` // if set, only this account can accept
+	Role       string     `gorm:"size:30;not null" json:"role"`
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	InvitedBy  uuid.UUID  `gorm:"type:uuid;not null" json:"invited_by"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	AcceptedBy *uuid.UUID `gorm:"type:uuid" json:"accepted_by,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
 type User struct {
 	ID           uuid.UUID      `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id"`
-	Email        string         `gorm:"uniqueIndex;not null" json:"email"`
-	PasswordHash string         `gorm:"not null" json:"-"`
+	Email        *string        `gorm:"uniqueIndex" json:"email"`     // nil for phone-only accounts
+	PasswordHash string         `json:"-"`                             // empty for phone-only accounts
 	Name         string         `json:"name"`
 	Roles        pq.StringArray `gorm:"type:text[]" json:"roles"` // ["buyer","seller"]
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	Addresses    []Address      `json:"addresses" gorm:"foreignKey:UserID"`
+	// Verified mobile number (2547XXXXXXXX), used for OTP login and M-Pesa STK push
+	Phone           *string    `gorm:"uniqueIndex" json:"phone,omitempty"`
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at,omitempty"`
+	// Two-factor authentication (TOTP)
+	TOTPSecret    *string    `json:"-"`
+	TOTPEnabled   bool       `gorm:"not null;default:false" json:"totp_enabled"`
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at,omitempty"`
+	TOTPLastStep  int64      `gorm:"not null;default:0" json:"-"` // last accepted time step, prevents code replay
+	MFAFailedAttempts      int        `gorm:"not null;default:0" json:"-"` // wrong codes since the last success or lockout
+	MFALockedUntil         *time.Time `json:"-"`                           // second step refused until then
+	MFAEnrollmentCodeHash  *string    `json:"-"`                           // admin-issued, lets a required account enrol at login
+	MFAEnrollmentExpiresAt *time.Time `json:"-"`
+	// Reminders about carts left without checking out; buyers can turn them off
+	CartReminders bool      `gorm:"not null;default:true" json:"cart_reminders"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Addresses     []Address `json:"addresses" gorm:"foreignKey:UserID"`
+}
+
+// RecoveryCode is a one-time 2FA backup code; only the bcrypt hash is stored
+type RecoveryCode struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// PhoneOTP is a one-time SMS code for phone login or phone verification; only the hash is stored
+type PhoneOTP struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	Phone      string     `gorm:"size:20;not null;index" json:"phone"`
+	Purpose    string     `gorm:"size:20;not null" json:"purpose"` // login | verify
+	CodeHash   string     `gorm:"not null" json:"-"`
+	Attempts   int        `gorm:"not null;default:0" json:"attempts"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	ConsumedAt *time.Time `json:"consumed_at,omitempty"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// APIKey is a seller integration credential. Only a SHA-256 hash of the secret is stored;
+// Prefix is the public part used to look the key up and to identify it in listings.
+type APIKey struct {
+	ID         uuid.UUID      `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	UserID     uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name       string         `gorm:"size:100;not null" json:"name"`
+	Prefix     string         `gorm:"size:20;not null;uniqueIndex" json:"prefix"`
+	KeyHash    string         `gorm:"not null" json:"-"`
+	Scopes     pq.StringArray `gorm:"type:text[]" json:"scopes"`    // e.g. ["products:read","inventory:write"]
+	StoreIDs   pq.StringArray `gorm:"type:text[]" json:"store_ids"` // stores the key may act on
+	ExpiresAt  *time.Time     `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time     `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time     `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time      `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// DataRequest records a data-subject request (export or deletion) for compliance auditing
+type DataRequest struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	Type      string    `gorm:"size:20;not null" json:"type"` // export | deletion
+	Details   *string   `gorm:"type:jsonb" json:"details,omitempty"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// MFAPolicy controls whether two-factor authentication is mandatory for a role
+type MFAPolicy struct {
+	Role      string    `gorm:"primaryKey;size:50" json:"role"`
+	Required  bool      `gorm:"not null;default:false" json:"required"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 }
 type CartItem struct {
-	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
-	UserID    uuid.UUID `gorm:"type:uuid;not null"`
-	ProductID uuid.UUID `gorm:"type:uuid;not null"`
-	Quantity  int       `json:"quantity"`
-	Price     int64     `json:"price"`
-	Product   Product   `gorm:"foreignKey:ProductID"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID          uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID      *uuid.UUID `gorm:"type:uuid;index"`                            // set for signed-in buyers' carts
+	GuestCartID *uuid.UUID `gorm:"type:uuid;index" json:"guest_cart_id,omitempty"` // set for guests' carts
+	ProductID   uuid.UUID  `gorm:"type:uuid;not null"`
+	Quantity    int        `json:"quantity"`
+	Price       int64      `json:"price"`
+	// Saved for later: kept with the cart but left out of totals and checkout
+	SavedForLater bool    `gorm:"not null;default:false" json:"saved_for_later"`
+	Product       Product `gorm:"foreignKey:ProductID"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// GuestCart holds an anonymous visitor's cart items until they sign in, when
+// the items move to their account. The visitor holds a signed token for it.
+type GuestCart struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// AbandonedCart records a cart left idle past the abandonment threshold, one
+// row per store in the cart, for reminders and per-store recovery metrics
+type AbandonedCart struct {
+	ID             uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	UserID         *uuid.UUID `gorm:"type:uuid;index" json:"user_id,omitempty"`       // nil for guests and deleted accounts
+	GuestCartID    *uuid.UUID `gorm:"type:uuid;index" json:"guest_cart_id,omitempty"` // set for guest carts
+	StoreID        uuid.UUID  `gorm:"type:uuid;not null;index" json:"store_id"`
+	ItemCount      int        `gorm:"not null" json:"item_count"`
+	ValueCents     int64      `gorm:"not null" json:"value_cents"` // at the prices in the cart
+	LastActivityAt time.Time  `gorm:"not null" json:"last_activity_at"`
+	DetectedAt     time.Time  `gorm:"not null" json:"detected_at"`
+	RemindedAt     *time.Time `json:"reminded_at,omitempty"`
+	RecoveredAt    *time.Time `json:"recovered_at,omitempty"` // the buyer went on to order from the store
+	OrderID        *uuid.UUID `gorm:"type:uuid" json:"order_id,omitempty"`
+}
+
+// IdempotencyKey remembers the response to a request sent with an
+// Idempotency-Key header, so a retry gets the same answer instead of repeating
+// the request
+type IdempotencyKey struct {
+	ID           uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	Scope        string     `gorm:"size:64;not null;uniqueIndex:idx_idempotency_keys_scope_key" json:"scope"` // the user ID, or "anonymous"
+	Key          string     `gorm:"size:255;not null;uniqueIndex:idx_idempotency_keys_scope_key" json:"key"`
+	RequestHash  string     `gorm:"size:64;not null" json:"-"` // method, path and body, so a key cannot be reused for another request
+	StatusCode   int        `gorm:"not null;default:0" json:"status_code"`
+	ContentType  string     `gorm:"size:100" json:"content_type"`
+	ResponseBody []byte     `json:"-"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"` // nil while the request is in flight
+	LockedUntil  *time.Time `json:"locked_until,omitempty"` // an in-flight claim past this was abandoned and can be taken over
+	ExpiresAt    time.Time  `gorm:"not null;index" json:"expires_at"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// OutboxMessage is work queued in the same transaction as the records it is
+// about, such as sending the STK push for a new payment. It is carried out
+// once that transaction has committed and retried with backoff until it
+// succeeds or runs out of attempts.
+type OutboxMessage struct {
+	ID            uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	Topic         string     `gorm:"size:50;not null" json:"topic"`
+	Payload       string     `gorm:"type:jsonb;not null" json:"payload"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"not null" json:"next_attempt_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"` // a worker is delivering it until then
+	LastError     *string    `json:"last_error,omitempty"`
+	ProcessedAt   *time.Time `json:"processed_at,omitempty"`
+	FailedAt      *time.Time `json:"failed_at,omitempty"` // gave up after the last attempt
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
 }
 
 // STK Callback Wrapper matches the whole payload from Safaricom
@@ -198,11 +376,15 @@ type ShippingMethod struct {
 	ID              uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
 	Name            string    `gorm:"size:50;not null;uniqueIndex" json:"name"`
 	Code            string    `gorm:"size:20;not null;uniqueIndex" json:"code"`
+	Type            string    `gorm:"size:20;not null;default:delivery" json:"type"` // delivery | pickup
 	Description     *string   `json:"description,omitempty"`
 	BaseCostCents   int64     `gorm:"not null;default:0" json:"base_cost_cents"`
 	CostPerKgCents  int64     `gorm:"default:0" json:"cost_per_kg_cents"`
+	MaxWeightGrams  *int      `json:"max_weight_grams,omitempty"` // nil = no parcel weight limit
 	DeliveryDaysMin int       `gorm:"not null;default:1" json:"delivery_days_min"`
 	DeliveryDaysMax int       `gorm:"not null;default:3" json:"delivery_days_max"`
+	DeliversSaturday bool     `gorm:"not null;default:false" json:"delivers_saturday"`
+	DeliversSunday   bool     `gorm:"not null;default:false" json:"delivers_sunday"`
 	IsActive        bool      `gorm:"not null;default:true" json:"is_active"`
 	CreatedAt       time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt       time.Time `gorm:"autoUpdateTime" json:"updated_at"`
@@ -237,6 +419,171 @@ type ShippingRule struct {
 	ShippingZone               ShippingZone    `gorm:"foreignKey:ShippingZoneID" json:"shipping_zone"`
 }
 
+// ShippingDistanceBand prices a shipping method by great-circle distance between
+// the store warehouse and the delivery address
+type ShippingDistanceBand struct {
+	ID               uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	ShippingMethodID uuid.UUID `gorm:"type:uuid;not null;index" json:"shipping_method_id"`
+	MinKm            float64   `gorm:"not null;default:0" json:"min_km"`
+	MaxKm            *float64  `json:"max_km,omitempty"` // nil = no upper bound
+	CostCents        int64     `gorm:"not null;default:0" json:"cost_cents"`
+	CreatedAt        time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// ProductSale is a scheduled sale price for a product. A sale with a quantity
+// limit is a flash sale: the sale price only applies to the first units sold.
+type ProductSale struct {
+	ID             uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	ProductID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"product_id"`
+	Name           *string    `gorm:"size:100" json:"name,omitempty"` // e.g. "Black Friday"
+	SalePriceCents int64      `gorm:"not null" json:"sale_price_cents"`
+	StartsAt       time.Time  `gorm:"not null" json:"starts_at"`
+	EndsAt         time.Time  `gorm:"not null" json:"ends_at"`
+	QuantityLimit  *int       `json:"quantity_limit,omitempty"` // units at the sale price; nil = unlimited
+	CreatedBy      *uuid.UUID `gorm:"type:uuid" json:"created_by,omitempty"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Coupon is a promo code buyers enter at checkout. Store coupons are created by
+// the store's sellers and only discount that store's products; platform coupons
+// (no store) are created by admins.
+type Coupon struct {
+	ID               uuid.UUID      `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	Code             string         `gorm:"size:32;not null;uniqueIndex" json:"code"` // stored upper-case
+	Description      *string        `json:"description,omitempty"`
+	Type             string         `gorm:"size:20;not null" json:"type"`   // percentage | fixed | free_shipping
+	Value            int64          `gorm:"not null;default:0" json:"value"` // percent off, or cents off for fixed
+	MaxDiscountCents *int64         `json:"max_discount_cents,omitempty"`   // caps percentage coupons
+	MinSpendCents    int64          `gorm:"not null;default:0" json:"min_spend_cents"`
+	StoreID          *uuid.UUID     `gorm:"type:uuid;index" json:"store_id,omitempty"`
+	Category         *string        `gorm:"size:50" json:"category,omitempty"`
+	ProductIDs       pq.StringArray `gorm:"type:text[]" json:"product_ids"` // empty = any product in scope
+	StartsAt         *time.Time     `json:"starts_at,omitempty"`
+	EndsAt           *time.Time     `json:"ends_at,omitempty"`
+	UsageLimit       *int           `json:"usage_limit,omitempty"`    // redemptions in total; nil = unlimited
+	PerUserLimit     *int           `json:"per_user_limit,omitempty"` // redemptions per buyer; nil = unlimited
+	IsActive         bool           `gorm:"not null;default:true" json:"is_active"`
+	CreatedBy        *uuid.UUID     `gorm:"type:uuid" json:"created_by,omitempty"`
+	CreatedAt        time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// OrderDiscount is a discount taken off an order, e.g. a redeemed coupon
+type OrderDiscount struct {
+	ID                    uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	OrderID               uuid.UUID  `gorm:"type:uuid;not null;index" json:"order_id"`
+	CouponID              *uuid.UUID `gorm:"type:uuid;index" json:"coupon_id,omitempty"`
+	Code                  string     `gorm:"size:32" json:"code"`
+	Description           string     `json:"description"`
+	AmountCents           int64      `gorm:"not null;default:0" json:"amount_cents"`            // off the items
+	ShippingDiscountCents int64      `gorm:"not null;default:0" json:"shipping_discount_cents"` // off shipping
+	CreatedAt             time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// CouponRedemption ties a coupon's use on an order to the buyer, by keyed
+// hashes of their account, email and phone rather than the user ID, so
+// per-buyer limits survive the account being deleted and made again
+type CouponRedemption struct {
+	ID           uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	CouponID     uuid.UUID `gorm:"type:uuid;not null;index:idx_coupon_redemptions_coupon_redeemer" json:"coupon_id"`
+	OrderID      uuid.UUID `gorm:"type:uuid;not null;index" json:"order_id"`
+	RedeemerHash string    `gorm:"size:64;not null;index:idx_coupon_redemptions_coupon_redeemer" json:"-"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// StoreShippingProfile is a store's own shipping terms, applied on top of the
+// platform's methods, zones and rules
+type StoreShippingProfile struct {
+	ID                         uuid.UUID      `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	StoreID                    uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex" json:"store_id"`
+	IsActive                   bool           `gorm:"not null;default:true" json:"is_active"`
+	FreeShippingThresholdCents *int64         `json:"free_shipping_threshold_cents,omitempty"` // replaces the platform rule's threshold
+	MaxDeliveryRadiusKm        *float64       `json:"max_delivery_radius_km,omitempty"`        // nil = deliver anywhere
+	ExcludedZoneIDs            pq.StringArray `gorm:"type:text[]" json:"excluded_zone_ids"`    // zones the store won't ship to
+	CreatedAt                  time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt                  time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	Rates                      []StoreShippingRate `gorm:"foreignKey:StoreID;references:StoreID" json:"rates"`
+}
+
+// StoreShippingRate is a store's own price for a shipping method, optionally
+// limited to a zone or a distance from the warehouse
+type StoreShippingRate struct {
+	ID                         uuid.UUID      `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	StoreID                    uuid.UUID      `gorm:"type:uuid;not null;index" json:"store_id"`
+	ShippingMethodID           uuid.UUID      `gorm:"type:uuid;not null" json:"shipping_method_id"`
+	ShippingZoneID             *uuid.UUID     `gorm:"type:uuid" json:"shipping_zone_id,omitempty"` // nil = any zone
+	MaxDistanceKm              *float64       `json:"max_distance_km,omitempty"`                   // nil = any distance
+	CostCents                  int64          `gorm:"not null;default:0" json:"cost_cents"`
+	MinOrderValueCents         int64          `gorm:"not null;default:0" json:"min_order_value_cents"`
+	FreeShippingThresholdCents *int64         `json:"free_shipping_threshold_cents,omitempty"`
+	IsAvailable                bool           `gorm:"not null;default:true" json:"is_available"` // false = the store doesn't offer the method here
+	CreatedAt                  time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt                  time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+	ShippingMethod             ShippingMethod `gorm:"foreignKey:ShippingMethodID" json:"shipping_method,omitempty"`
+}
+
+// Shipment is an order's parcel handed to a courier
+type Shipment struct {
+	ID             uuid.UUID       `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	OrderID        uuid.UUID       `gorm:"type:uuid;not null;index" json:"order_id"`
+	StoreID        uuid.UUID       `gorm:"type:uuid;not null;index" json:"store_id"`
+	CourierCode    string          `gorm:"size:30;not null" json:"courier_code"`
+	CarrierName    string          `gorm:"size:100" json:"carrier_name"`
+	TrackingNumber string          `gorm:"size:100;not null;index" json:"tracking_number"`
+	ProviderRef    *string         `gorm:"size:100" json:"-"`
+	Status         string          `gorm:"size:30;not null;default:label_created" json:"status"`
+	ShippedAt      *time.Time      `json:"shipped_at,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+	Events         []ShipmentEvent `gorm:"foreignKey:ShipmentID" json:"events,omitempty"`
+}
+
+// ShipmentEvent is one tracking update, from a courier webhook or the seller
+type ShipmentEvent struct {
+	ID          uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	ShipmentID  uuid.UUID `gorm:"type:uuid;not null;index" json:"shipment_id"`
+	Status      string    `gorm:"size:30;not null" json:"status"`
+	Description string    `gorm:"size:255" json:"description"`
+	Location    string    `gorm:"size:100" json:"location,omitempty"`
+	EventID     *string   `gorm:"size:100" json:"-"` // courier's event ID, for deduplication
+	OccurredAt  time.Time `gorm:"not null" json:"occurred_at"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// DeliveryHoliday is a day nobody dispatches or delivers. Recurring holidays
+// repeat every year on the same month and day.
+type DeliveryHoliday struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	Name      string    `gorm:"size:100;not null" json:"name"`
+	Date      time.Time `gorm:"type:date;not null" json:"date"`
+	Recurring bool      `gorm:"not null;default:false" json:"recurring"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// PickupPoint is an agent or stage where buyers collect click-and-collect orders
+type PickupPoint struct {
+	ID           uuid.UUID      `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
+	Name         string         `gorm:"size:100;not null" json:"name"`
+	Code         string         `gorm:"size:30;not null;uniqueIndex" json:"code"`
+	Street       string         `gorm:"size:255;not null" json:"street"`
+	City         string         `gorm:"size:100;not null" json:"city"`
+	State        string         `gorm:"size:100" json:"state"`
+	Country      string         `gorm:"size:100;default:Kenya" json:"country"`
+	PostalCode   string         `gorm:"size:20" json:"postal_code"`
+	Latitude     float64        `json:"latitude"`
+	Longitude    float64        `json:"longitude"`
+	Phone        *string        `gorm:"size:20" json:"phone,omitempty"`
+	OpeningHours *string        `gorm:"type:jsonb" json:"opening_hours,omitempty"` // JSON like {"mon": "08:00-18:00", "sun": "closed"}
+	Capacity     int            `gorm:"not null;default:0" json:"capacity"`        // parcels held at once; 0 = unlimited
+	StoreIDs     pq.StringArray `gorm:"type:text[]" json:"store_ids"`              // stores that deliver here; empty = all
+	IsActive     bool           `gorm:"not null;default:true" json:"is_active"`
+	CreatedAt    time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
 type Favorite struct {
 	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey" json:"id"`
 	UserID    uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"trumall/internal/sms"
+)
+
+// ErrNoChannel means the recipient cannot be reached by the notifier
+var ErrNoChannel = errors.New("recipient has no contact for this notifier")
+
+// Kinds of notification
+const (
+	KindCartReminder = "cart_reminder"
+)
+
+// Recipient is who a notification is for
+type Recipient struct {
+	Name  string
+	Email *string
+	Phone *string // verified mobile number, 2547XXXXXXXX
+}
+
+// Notification is a message to a user; notifiers pick the channel
+type Notification struct {
+	Kind    string
+	To      Recipient
+	Subject string
+	Message string
+}
+
+// Notifier delivers notifications to users
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// ConsoleNotifier logs notifications instead of sending them. Used in
+// development until a delivery channel is configured.
+type ConsoleNotifier struct{}
+
+func (ConsoleNotifier) Notify(n Notification) error {
+	log.Printf("[notify] kind=%s to=%q subject=%q message=%q", n.Kind, n.To.Name, n.Subject, n.Message)
+	return nil
+}
+
+// SMSNotifier texts notifications to the recipient's verified phone
+type SMSNotifier struct {
+	Sender sms.Sender
+}
+
+func (s SMSNotifier) Notify(n Notification) error {
+	if n.To.Phone == nil || *n.To.Phone == "" {
+		return ErrNoChannel
+	}
+	return s.Sender.Send(*n.To.Phone, n.Message)
+}
+
+// NewNotifierFromEnv returns the notifier selected by NOTIFIER: "sms" sends
+// through the SMS gateway, anything else logs to the console
+func NewNotifierFromEnv(smsSender sms.Sender) Notifier {
+	switch os.Getenv("NOTIFIER") {
+	case "", "console":
+		return ConsoleNotifier{}
+	case "sms":
+		return SMSNotifier{Sender: smsSender}
+	default:
+		log.Printf("[notify] unknown NOTIFIER %q, falling back to console", os.Getenv("NOTIFIER"))
+		return ConsoleNotifier{}
+	}
+}
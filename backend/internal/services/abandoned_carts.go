@@ -0,0 +1,326 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/notify"
+)
+
+const (
+	// DefaultAbandonedCartAfter is how long a cart must sit untouched before it
+	// counts as abandoned, unless ABANDONED_CART_AFTER_HOURS says otherwise
+	DefaultAbandonedCartAfter = 24 * time.Hour
+
+	// cartReminderMaxAge stops reminders about carts that were already long
+	// forgotten when they were found (e.g. on the first sweep); they are still
+	// counted as abandoned
+	cartReminderMaxAge = 7 * 24 * time.Hour
+
+	// abandonedCartSweepLock keeps two servers from sweeping at once
+	abandonedCartSweepLock = 4_704_700
+)
+
+// abandonedCartRecoveredStatuses are the order statuses that make an abandoned
+// cart recovered: the buyer came back and paid
+var abandonedCartRecoveredStatuses = []string{"paid", "processing", "shipped", "ready_for_pickup", "delivered"}
+
+// AbandonedCartAfterFromEnv reads the abandonment threshold from
+// ABANDONED_CART_AFTER_HOURS
+func AbandonedCartAfterFromEnv() time.Duration {
+	raw := os.Getenv("ABANDONED_CART_AFTER_HOURS")
+	if raw == "" {
+		return DefaultAbandonedCartAfter
+	}
+	hours, err := strconv.Atoi(raw)
+	if err != nil || hours < 1 {
+		log.Printf("Invalid ABANDONED_CART_AFTER_HOURS %q, using %s", raw, DefaultAbandonedCartAfter)
+		return DefaultAbandonedCartAfter
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// AbandonedCartService finds carts left idle, reminds their buyers and reports
+// abandonment per store
+type AbandonedCartService struct {
+	db       *gorm.DB
+	notifier notify.Notifier
+	after    time.Duration
+}
+
+func NewAbandonedCartService(db *gorm.DB, notifier notify.Notifier, after time.Duration) *AbandonedCartService {
+	return &AbandonedCartService{db: db, notifier: notifier, after: after}
+}
+
+// AbandonedCartSweep reports what a sweep did
+type AbandonedCartSweep struct {
+	Detected  int   `json:"detected"`  // abandoned carts recorded, one per store in a cart
+	Reminded  int   `json:"reminded"`  // buyers reminded
+	Recovered int64 `json:"recovered"` // earlier abandoned carts whose buyer has since ordered
+}
+
+// abandonedCartRow is one store's share of an idle cart
+type abandonedCartRow struct {
+	UserID         *uuid.UUID
+	GuestCartID    *uuid.UUID
+	StoreID        uuid.UUID
+	LastActivityAt time.Time
+	ItemCount      int
+	ValueCents     int64
+}
+
+// idleCartsSQL finds carts whose items (saved-for-later ones aside) have not
+// changed since the cutoff and that have not been recorded since that last
+// change, split by store
+const idleCartsSQL = `WITH idle AS (
+	SELECT user_id, guest_cart_id, MAX(updated_at) AS last_activity_at
+	FROM cart_items
+	WHERE saved_for_later = FALSE
+	GROUP BY user_id, guest_cart_id
+	HAVING MAX(updated_at) < ?
+)
+SELECT idle.user_id, idle.guest_cart_id, products.store_id, idle.last_activity_at,
+	SUM(cart_items.quantity) AS item_count, SUM(cart_items.quantity * cart_items.price) AS value_cents
+FROM idle
+JOIN cart_items ON cart_items.saved_for_later = FALSE
+	AND cart_items.user_id IS NOT DISTINCT FROM idle.user_id
+	AND cart_items.guest_cart_id IS NOT DISTINCT FROM idle.guest_cart_id
+JOIN products ON products.id = cart_items.product_id
+WHERE NOT EXISTS (
+	SELECT 1 FROM abandoned_carts
+	WHERE abandoned_carts.user_id IS NOT DISTINCT FROM idle.user_id
+	AND abandoned_carts.guest_cart_id IS NOT DISTINCT FROM idle.guest_cart_id
+	AND abandoned_carts.last_activity_at >= idle.last_activity_at
+)
+GROUP BY idle.user_id, idle.guest_cart_id, products.store_id, idle.last_activity_at`
+
+// recoverCartsSQL marks abandoned carts whose buyer has since paid for an
+// order from the store, linking the first such order
+const recoverCartsSQL = `UPDATE abandoned_carts
+SET recovered_at = recovered.created_at, order_id = recovered.order_id
+FROM (
+	SELECT DISTINCT ON (abandoned_carts.id) abandoned_carts.id AS abandoned_cart_id, orders.id AS order_id, orders.created_at
+	FROM abandoned_carts
+	JOIN orders ON orders.buyer_id = abandoned_carts.user_id
+		AND orders.store_id = abandoned_carts.store_id
+		AND orders.created_at > abandoned_carts.last_activity_at
+		AND orders.status IN ?
+	WHERE abandoned_carts.recovered_at IS NULL
+	ORDER BY abandoned_carts.id, orders.created_at
+) AS recovered
+WHERE abandoned_carts.id = recovered.abandoned_cart_id`
+
+// Sweep records newly abandoned carts, reminds their buyers and marks
+// recoveries. A sweep already running elsewhere makes this one a no-op.
+func (s *AbandonedCartService) Sweep() (*AbandonedCartSweep, error) {
+	result := &AbandonedCartSweep{}
+	var detected []models.AbandonedCart
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", abandonedCartSweepLock).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		recovered := tx.Exec(recoverCartsSQL, abandonedCartRecoveredStatuses)
+		if recovered.Error != nil {
+			return recovered.Error
+		}
+		result.Recovered = recovered.RowsAffected
+
+		now := time.Now()
+		var rows []abandonedCartRow
+		if err := tx.Raw(idleCartsSQL, now.Add(-s.after)).Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			detected = append(detected, models.AbandonedCart{
+				ID:             uuid.New(),
+				UserID:         row.UserID,
+				GuestCartID:    row.GuestCartID,
+				StoreID:        row.StoreID,
+				ItemCount:      row.ItemCount,
+				ValueCents:     row.ValueCents,
+				LastActivityAt: row.LastActivityAt,
+				DetectedAt:     now,
+			})
+		}
+		if len(detected) == 0 {
+			return nil
+		}
+		return tx.Create(&detected).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Detected = len(detected)
+	result.Reminded = s.remind(detected)
+	return result, nil
+}
+
+// remind sends one reminder per buyer covering all stores in their cart.
+// Guests cannot be reached, and buyers who turned reminders off are skipped.
+// Failures are logged; the cart is not reminded about again.
+func (s *AbandonedCartService) remind(detected []models.AbandonedCart) int {
+	byUser := map[uuid.UUID][]models.AbandonedCart{}
+	var userIDs []uuid.UUID
+	storeIDs := map[uuid.UUID]bool{}
+	for _, cart := range detected {
+		if cart.UserID == nil || time.Since(cart.LastActivityAt) > cartReminderMaxAge {
+			continue
+		}
+		if _, seen := byUser[*cart.UserID]; !seen {
+			userIDs = append(userIDs, *cart.UserID)
+		}
+		byUser[*cart.UserID] = append(byUser[*cart.UserID], cart)
+		storeIDs[cart.StoreID] = true
+	}
+	if len(userIDs) == 0 {
+		return 0
+	}
+
+	var users []models.User
+	if err := s.db.Where("id IN ? AND cart_reminders = ?", userIDs, true).Find(&users).Error; err != nil {
+		log.Printf("Error loading users for cart reminders: %v", err)
+		return 0
+	}
+	ids := make([]uuid.UUID, 0, len(storeIDs))
+	for id := range storeIDs {
+		ids = append(ids, id)
+	}
+	var stores []models.Store
+	if err := s.db.Where("id IN ?", ids).Find(&stores).Error; err != nil {
+		log.Printf("Error loading stores for cart reminders: %v", err)
+		return 0
+	}
+	storeNames := make(map[uuid.UUID]string, len(stores))
+	for _, store := range stores {
+		storeNames[store.ID] = store.Name
+	}
+
+	reminded := 0
+	for _, user := range users {
+		carts := byUser[user.ID]
+		n := cartReminder(user, carts, storeNames)
+		if err := s.notifier.Notify(n); err != nil {
+			log.Printf("Error sending cart reminder to user %s: %v", user.ID, err)
+			continue
+		}
+		cartIDs := make([]uuid.UUID, 0, len(carts))
+		for _, cart := range carts {
+			cartIDs = append(cartIDs, cart.ID)
+		}
+		if err := s.db.Model(&models.AbandonedCart{}).Where("id IN ?", cartIDs).Update("reminded_at", time.Now()).Error; err != nil {
+			log.Printf("Error recording cart reminder for user %s: %v", user.ID, err)
+		}
+		reminded++
+	}
+	return reminded
+}
+
+func cartReminder(user models.User, carts []models.AbandonedCart, storeNames map[uuid.UUID]string) notify.Notification {
+	var items int
+	var value int64
+	names := make([]string, 0, len(carts))
+	for _, cart := range carts {
+		items += cart.ItemCount
+		value += cart.ValueCents
+		if name := storeNames[cart.StoreID]; name != "" {
+			names = append(names, name)
+		}
+	}
+
+	noun := "items"
+	if items == 1 {
+		noun = "item"
+	}
+	message := fmt.Sprintf("Hi %s, you left %d %s (KES %.2f) in your TrustMall cart", user.Name, items, noun, float64(value)/100)
+	if len(names) > 0 {
+		message += " from " + strings.Join(names, ", ")
+	}
+	message += ". Your cart is saved; come back to check out. You can turn these reminders off in your account settings."
+
+	var phone *string
+	if user.PhoneVerifiedAt != nil {
+		phone = user.Phone
+	}
+	return notify.Notification{
+		Kind:    notify.KindCartReminder,
+		To:      notify.Recipient{Name: user.Name, Email: user.Email, Phone: phone},
+		Subject: "You left something in your cart",
+		Message: message,
+	}
+}
+
+// StartSweeper sweeps for abandoned carts every interval until the process
+// exits
+func (s *AbandonedCartService) StartSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sweep, err := s.Sweep()
+			if err != nil {
+				log.Printf("Error sweeping abandoned carts: %v", err)
+				continue
+			}
+			if sweep.Detected > 0 || sweep.Recovered > 0 {
+				log.Printf("Abandoned carts: %d detected, %d buyers reminded, %d recovered", sweep.Detected, sweep.Reminded, sweep.Recovered)
+			}
+		}
+	}()
+}
+
+// StoreAbandonmentMetrics sums a store's abandoned carts over a period
+type StoreAbandonmentMetrics struct {
+	StoreID             uuid.UUID `json:"store_id"`
+	AbandonedCarts      int64     `json:"abandoned_carts"`
+	AbandonedValueCents int64     `json:"abandoned_value_cents"`
+	Reminded            int64     `json:"reminded"`
+	Recovered           int64     `json:"recovered"`
+	RecoveredValueCents int64     `json:"recovered_value_cents"` // cart value of the recovered carts
+	RecoveryRate        float64   `json:"recovery_rate"`         // recovered / abandoned
+}
+
+// Metrics sums abandoned carts detected between from and to, per store. With
+// storeID set only that store is reported, with zeros if it had none.
+func (s *AbandonedCartService) Metrics(storeID *uuid.UUID, from, to time.Time) ([]StoreAbandonmentMetrics, error) {
+	q := s.db.Model(&models.AbandonedCart{}).
+		Select(`store_id,
+			COUNT(*) AS abandoned_carts,
+			COALESCE(SUM(value_cents), 0) AS abandoned_value_cents,
+			COUNT(reminded_at) AS reminded,
+			COUNT(recovered_at) AS recovered,
+			COALESCE(SUM(value_cents) FILTER (WHERE recovered_at IS NOT NULL), 0) AS recovered_value_cents`).
+		Where("detected_at >= ? AND detected_at < ?", from, to).
+		Group("store_id").
+		Order("abandoned_carts DESC")
+	if storeID != nil {
+		q = q.Where("store_id = ?", *storeID)
+	}
+
+	var metrics []StoreAbandonmentMetrics
+	if err := q.Scan(&metrics).Error; err != nil {
+		return nil, err
+	}
+	if storeID != nil && len(metrics) == 0 {
+		metrics = append(metrics, StoreAbandonmentMetrics{StoreID: *storeID})
+	}
+	for i := range metrics {
+		if metrics[i].AbandonedCarts > 0 {
+			metrics[i].RecoveryRate = float64(metrics[i].Recovered) / float64(metrics[i].AbandonedCarts)
+		}
+	}
+	return metrics, nil
+}
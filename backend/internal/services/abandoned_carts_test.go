@@ -0,0 +1,188 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/notify"
+	"trumall/internal/testdb"
+)
+
+// recordingNotifier keeps what it was asked to send, failing for the
+// recipients named in fail
+type recordingNotifier struct {
+	sent []notify.Notification
+	fail map[string]bool
+}
+
+func (r *recordingNotifier) Notify(n notify.Notification) error {
+	r.sent = append(r.sent, n)
+	if r.fail[n.To.Name] {
+		return errors.New("gateway unavailable")
+	}
+	return nil
+}
+
+func TestAbandonedCartAfterFromEnv(t *testing.T) {
+	cases := []struct {
+		env  string
+		want time.Duration
+	}{
+		{"", DefaultAbandonedCartAfter},
+		{"48", 48 * time.Hour},
+		{"0", DefaultAbandonedCartAfter},
+		{"a day", DefaultAbandonedCartAfter},
+	}
+	for _, tc := range cases {
+		t.Setenv("ABANDONED_CART_AFTER_HOURS", tc.env)
+		if got := AbandonedCartAfterFromEnv(); got != tc.want {
+			t.Errorf("%q = %s; want %s", tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestCartReminder(t *testing.T) {
+	phone := "254712345678"
+	verified := time.Now()
+	duka, soko := uuid.New(), uuid.New()
+	names := map[uuid.UUID]string{duka: "Duka", soko: "Soko"}
+
+	cases := []struct {
+		name     string
+		user     models.User
+		carts    []models.AbandonedCart
+		contains string
+		sms      bool
+	}{
+		{"one item", models.User{Name: "Amina"}, []models.AbandonedCart{{StoreID: duka, ItemCount: 1, ValueCents: 4500}},
+			"Hi Amina, you left 1 item (KES 45.00) in your TrustMall cart from Duka.", false},
+		{"several stores", models.User{Name: "Amina", Phone: &phone, PhoneVerifiedAt: &verified}, []models.AbandonedCart{
+			{StoreID: duka, ItemCount: 2, ValueCents: 4500}, {StoreID: soko, ItemCount: 1, ValueCents: 1050},
+		}, "3 items (KES 55.50) in your TrustMall cart from Duka, Soko.", true},
+		{"unverified phone", models.User{Name: "Amina", Phone: &phone}, []models.AbandonedCart{{StoreID: uuid.New(), ItemCount: 2, ValueCents: 100}},
+			"2 items (KES 1.00) in your TrustMall cart.", false},
+	}
+	for _, tc := range cases {
+		n := cartReminder(tc.user, tc.carts, names)
+		if n.Kind != notify.KindCartReminder || !strings.Contains(n.Message, tc.contains) {
+			t.Errorf("%s: %s %q; want a cart reminder saying %q", tc.name, n.Kind, n.Message, tc.contains)
+		}
+		if (n.To.Phone != nil) != tc.sms {
+			t.Errorf("%s: phone %v; want one = %v", tc.name, n.To.Phone, tc.sms)
+		}
+	}
+}
+
+func openAbandonedCartDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t, &models.User{}, &models.Store{}, &models.AbandonedCart{})
+}
+
+// abandon records a cart of the user's, or a guest's when user is nil, left
+// idle for age
+func abandon(t *testing.T, db *gorm.DB, user *models.User, store models.Store, age time.Duration) models.AbandonedCart {
+	t.Helper()
+	cart := models.AbandonedCart{ID: uuid.New(), StoreID: store.ID, ItemCount: 1, ValueCents: 1000,
+		LastActivityAt: time.Now().Add(-age), DetectedAt: time.Now()}
+	if user != nil {
+		cart.UserID = &user.ID
+	} else {
+		guest := uuid.New()
+		cart.GuestCartID = &guest
+	}
+	mustCreate(t, db, &cart)
+	return cart
+}
+
+func TestRemindAbandonedCarts(t *testing.T) {
+	db := openAbandonedCartDB(t)
+	duka := models.Store{OwnerID: uuid.New(), Name: "Duka"}
+	soko := models.Store{OwnerID: uuid.New(), Name: "Soko"}
+	mustCreate(t, db, &duka)
+	mustCreate(t, db, &soko)
+	user := func(name string, reminders bool) *models.User {
+		u := models.User{Name: name}
+		mustCreate(t, db, &u)
+		if !reminders {
+			db.Model(&u).Update("cart_reminders", false)
+		}
+		return &u
+	}
+	keen, optedOut, unreachable, forgetful := user("Keen", true), user("Opted out", false), user("Unreachable", true), user("Forgetful", true)
+
+	detected := []models.AbandonedCart{
+		abandon(t, db, keen, duka, 25*time.Hour),
+		abandon(t, db, keen, soko, 25*time.Hour),
+		abandon(t, db, optedOut, duka, 25*time.Hour),
+		abandon(t, db, unreachable, duka, 25*time.Hour),
+		abandon(t, db, forgetful, duka, cartReminderMaxAge+time.Hour),
+		abandon(t, db, nil, duka, 25*time.Hour),
+	}
+	notifier := &recordingNotifier{fail: map[string]bool{"Unreachable": true}}
+	reminded := NewAbandonedCartService(db, notifier, DefaultAbandonedCartAfter).remind(detected)
+
+	if reminded != 1 || len(notifier.sent) != 2 {
+		t.Fatalf("%d reminded with %d sent; want Keen reminded once, and a failed send to Unreachable", reminded, len(notifier.sent))
+	}
+	for _, n := range notifier.sent {
+		if n.To.Name == "Keen" && !strings.Contains(n.Message, "from Duka, Soko") {
+			t.Errorf("Keen's reminder %q; want both stores in it", n.Message)
+		}
+	}
+	for i, cart := range detected {
+		db.First(&cart, "id = ?", cart.ID)
+		if want := i < 2; (cart.RemindedAt != nil) != want {
+			t.Errorf("cart %d: reminded at %v; want reminded = %v", i, cart.RemindedAt, want)
+		}
+	}
+}
+
+func TestAbandonmentMetrics(t *testing.T) {
+	db := openAbandonedCartDB(t)
+	service := NewAbandonedCartService(db, &recordingNotifier{}, DefaultAbandonedCartAfter)
+	duka, soko := uuid.New(), uuid.New()
+	now := time.Now()
+	record := func(store uuid.UUID, value int64, detected time.Time, reminded, recovered bool) {
+		cart := models.AbandonedCart{ID: uuid.New(), StoreID: store, ItemCount: 1, ValueCents: value,
+			LastActivityAt: detected.Add(-25 * time.Hour), DetectedAt: detected}
+		if reminded {
+			cart.RemindedAt = &detected
+		}
+		if recovered {
+			cart.RecoveredAt = &now
+		}
+		mustCreate(t, db, &cart)
+	}
+	record(duka, 1000, now.Add(-time.Hour), true, false)
+	record(duka, 2000, now.Add(-2*time.Hour), true, true)
+	record(duka, 3000, now.Add(-3*time.Hour), false, false)
+	record(duka, 9000, now.Add(-30*24*time.Hour), true, true)
+	record(soko, 500, now.Add(-time.Hour), false, false)
+
+	metrics, err := service.Metrics(nil, now.Add(-7*24*time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StoreAbandonmentMetrics{
+		{StoreID: duka, AbandonedCarts: 3, AbandonedValueCents: 6000, Reminded: 2, Recovered: 1, RecoveredValueCents: 2000, RecoveryRate: 1.0 / 3},
+		{StoreID: soko, AbandonedCarts: 1, AbandonedValueCents: 500},
+	}
+	if len(metrics) != len(want) || metrics[0] != want[0] || metrics[1] != want[1] {
+		t.Errorf("metrics = %+v; want %+v", metrics, want)
+	}
+
+	quiet := uuid.New()
+	metrics, err = service.Metrics(&quiet, now.Add(-7*24*time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0] != (StoreAbandonmentMetrics{StoreID: quiet}) {
+		t.Errorf("a store without abandoned carts = %+v; want one row of zeros", metrics)
+	}
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+var ErrAccountOwnsStores = errors.New("transfer or close your stores before deleting your account")
+
+type AccountDataService struct {
+	db *gorm.DB
+}
+
+func NewAccountDataService(db *gorm.DB) *AccountDataService {
+	return &AccountDataService{db: db}
+}
+
+// AccountExport is everything held about a user, as returned by GET /api/me/export
+type AccountExport struct {
+	GeneratedAt      time.Time              `json:"generated_at"`
+	Profile          models.User            `json:"profile"`
+	Addresses        []models.Address       `json:"addresses"`
+	CartItems        []models.CartItem      `json:"cart_items"`
+	AbandonedCarts   []models.AbandonedCart `json:"abandoned_carts"`
+	Favorites        []models.Favorite      `json:"favorites"`
+	Orders           []models.Order         `json:"orders"`
+	Payments         []models.Payment       `json:"payments"`
+	Reviews          []models.Review        `json:"reviews"`
+	StoresOwned      []models.Store         `json:"stores_owned"`
+	StaffMemberships []models.StoreStaff    `json:"staff_memberships"`
+	APIKeys          []models.APIKey        `json:"api_keys"`
+	StoredResponses  []StoredResponse       `json:"stored_responses"`
+}
+
+// StoredResponse is a response kept so a request retried with the same
+// Idempotency-Key can be replayed
+type StoredResponse struct {
+	Key         string     `json:"key"`
+	StatusCode  int        `json:"status_code"`
+	ContentType string     `json:"content_type"`
+	Body        string     `json:"body"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+}
+
+// Export collects all personal data held about the user
+func (s *AccountDataService) Export(userID uuid.UUID) (*AccountExport, error) {
+	export := &AccountExport{GeneratedAt: time.Now()}
+
+	if err := s.db.First(&export.Profile, "id = ?", userID).Error; err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+
+	// Soft-deleted addresses are still held, so they are part of the export
+	if err := s.db.Unscoped().Where("user_id = ?", userID).Find(&export.Addresses).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Preload("Product").Where("user_id = ?", userID).Find(&export.CartItems).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Order("detected_at ASC").Find(&export.AbandonedCarts).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Preload("Product").Where("user_id = ?", userID).Find(&export.Favorites).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Preload("OrderItems.Product").Preload("ShippingAddress", func(tx *gorm.DB) *gorm.DB {
+		return tx.Unscoped()
+	}).Where("buyer_id = ?", userID).Order("created_at ASC").Find(&export.Orders).Error; err != nil {
+		return nil, err
+	}
+
+	orderIDs := make([]uuid.UUID, 0, len(export.Orders))
+	for _, o := range export.Orders {
+		orderIDs = append(orderIDs, o.ID)
+	}
+	if len(orderIDs) > 0 {
+		if err := s.db.Where("order_id IN ?", orderIDs).Order("created_at ASC").Find(&export.Payments).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.db.Where("user_id = ?", userID).Find(&export.Reviews).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("owner_id = ?", userID).Find(&export.StoresOwned).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Find(&export.StaffMemberships).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("user_id = ?", userID).Find(&export.APIKeys).Error; err != nil {
+		return nil, err
+	}
+
+	// Replayable responses can repeat the order, payment and address details above
+	var keys []models.IdempotencyKey
+	if err := s.db.Where("scope = ?", userID.String()).Order("created_at ASC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	export.StoredResponses = make([]StoredResponse, 0, len(keys))
+	for _, k := range keys {
+		export.StoredResponses = append(export.StoredResponses, StoredResponse{
+			Key:         k.Key,
+			StatusCode:  k.StatusCode,
+			ContentType: k.ContentType,
+			Body:        string(k.ResponseBody),
+			CompletedAt: k.CompletedAt,
+			ExpiresAt:   k.ExpiresAt,
+		})
+	}
+
+	s.recordRequest(s.db, userID, "export", nil)
+	return export, nil
+}
+
+// WriteZip writes the export as a zip archive with one JSON file per category
+func (e *AccountExport) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", e.Profile},
+		{"addresses.json", e.Addresses},
+		{"cart_items.json", e.CartItems},
+		{"abandoned_carts.json", e.AbandonedCarts},
+		{"favorites.json", e.Favorites},
+		{"orders.json", e.Orders},
+		{"payments.json", e.Payments},
+		{"reviews.json", e.Reviews},
+		{"stores_owned.json", e.StoresOwned},
+		{"staff_memberships.json", e.StaffMemberships},
+		{"api_keys.json", e.APIKeys},
+		{"stored_responses.json", e.StoredResponses},
+	}
+
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: e.GeneratedAt,
+		})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// DeleteAccount erases a user's personal data. Orders and payments are kept for
+// financial records but detached from the user and stripped of contact details;
+// carts, favourites, addresses, credentials and stored Idempotency-Key
+// responses are purged.
+func (s *AccountDataService) DeleteAccount(user models.User) error {
+	var owned int64
+	if err := s.db.Model(&models.Store{}).Where("owner_id = ?", user.ID).Count(&owned).Error; err != nil {
+		return err
+	}
+	if owned > 0 {
+		return ErrAccountOwnsStores
+	}
+
+	return ShippingTransaction(s.db, func(tx *gorm.DB) error {
+		var orderIDs []uuid.UUID
+		if err := tx.Model(&models.Order{}).Where("buyer_id = ?", user.ID).Pluck("id", &orderIDs).Error; err != nil {
+			return err
+		}
+
+		// Anonymise payments: keep amounts, status and M-Pesa receipts, drop the phone number
+		if len(orderIDs) > 0 {
+			if err := tx.Model(&models.Payment{}).Where("order_id IN ?", orderIDs).
+				Update("phone", nil).Error; err != nil {
+				return err
+			}
+		}
+
+		// Anonymise orders: detach buyer and shipping address
+		if err := tx.Model(&models.Order{}).Where("buyer_id = ?", user.ID).Updates(map[string]interface{}{
+			"buyer_id":            nil,
+			"shipping_address_id": nil,
+		}).Error; err != nil {
+			return err
+		}
+
+		// Reviews stay on the product but lose their author
+		if err := tx.Model(&models.Review{}).Where("user_id = ?", user.ID).Updates(map[string]interface{}{
+			"user_id":   nil,
+			"user_name": "Deleted user",
+		}).Error; err != nil {
+			return err
+		}
+
+		purges := []interface{}{
+			&models.CartItem{},
+			&models.Favorite{},
+			&models.RecoveryCode{},
+			&models.APIKey{},
+			&models.StoreStaff{},
+		}
+		for _, model := range purges {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		// Addresses are soft-deletable, so purge them for real
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.Address{}).Error; err != nil {
+			return err
+		}
+		if user.Phone != nil {
+			if err := tx.Where("phone = ?", *user.Phone).Delete(&models.PhoneOTP{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("invited_by = ?", user.ID).Delete(&models.StoreInvitation{}).Error; err != nil {
+			return err
+		}
+		// Idempotency keys are scoped by user ID and hold whole response bodies
+		if err := tx.Where("scope = ?", user.ID.String()).Delete(&models.IdempotencyKey{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&models.User{}, "id = ?", user.ID).Error; err != nil {
+			return err
+		}
+
+		details := fmt.Sprintf(`{"orders_anonymised": %d}`, len(orderIDs))
+		return s.recordRequest(tx, user.ID, "deletion", &details)
+	})
+}
+
+func (s *AccountDataService) recordRequest(tx *gorm.DB, userID uuid.UUID, requestType string, details *string) error {
+	return tx.Create(&models.DataRequest{
+		ID:      uuid.New(),
+		UserID:  userID,
+		Type:    requestType,
+		Details: details,
+	}).Error
+}
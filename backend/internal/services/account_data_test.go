@@ -0,0 +1,179 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/testdb"
+)
+
+func openAccountDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t, append(orderTables, &models.Address{}, &models.AbandonedCart{}, &models.Favorite{},
+		&models.Review{}, &models.StoreStaff{}, &models.APIKey{}, &models.RecoveryCode{}, &models.PhoneOTP{},
+		&models.StoreInvitation{}, &models.IdempotencyKey{}, &models.DataRequest{})...)
+}
+
+// storedResponse completes an Idempotency-Key for user with body
+func storedResponse(t *testing.T, db *gorm.DB, userID uuid.UUID, key, body string) {
+	t.Helper()
+	now := time.Now()
+	mustCreate(t, db, &models.IdempotencyKey{
+		Scope:        userID.String(),
+		Key:          key,
+		RequestHash:  "hash",
+		StatusCode:   201,
+		ContentType:  "application/json",
+		ResponseBody: []byte(body),
+		CompletedAt:  &now,
+		ExpiresAt:    now.Add(IdempotencyKeyTTL),
+	})
+}
+
+func TestExportIncludesStoredResponses(t *testing.T) {
+	db := openAccountDB(t)
+	buyer := newBuyer(t, db)
+	stranger := newBuyer(t, db)
+	address := models.Address{UserID: buyer.ID, Street: "1 Moi Avenue", City: "Mombasa"}
+	mustCreate(t, db, &address)
+	db.Delete(&address)
+	newOrder(t, db, buyer.ID, "paid", 1, newProduct(t, db, 5, 1000))
+	storedResponse(t, db, buyer.ID, "checkout-1", `{"order_id":"1"}`)
+	storedResponse(t, db, stranger.ID, "checkout-1", `{"order_id":"2"}`)
+
+	export, err := NewAccountDataService(db).Export(buyer.ID)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if len(export.Addresses) != 1 {
+		t.Errorf("exported %d addresses; want the deleted one too", len(export.Addresses))
+	}
+	if len(export.Orders) != 1 {
+		t.Errorf("exported %d orders; want 1", len(export.Orders))
+	}
+	if len(export.StoredResponses) != 1 || export.StoredResponses[0].Body != `{"order_id":"1"}` {
+		t.Errorf("stored responses = %+v; want only the buyer's checkout", export.StoredResponses)
+	}
+
+	var requests int64
+	db.Model(&models.DataRequest{}).Where("user_id = ? AND type = ?", buyer.ID, "export").Count(&requests)
+	if requests != 1 {
+		t.Errorf("recorded %d export requests; want 1", requests)
+	}
+}
+
+func TestDeleteAccountPurgesPersonalData(t *testing.T) {
+	db := openAccountDB(t)
+	phone := "254700000001"
+	buyer := models.User{Name: "Buyer", Phone: &phone}
+	mustCreate(t, db, &buyer)
+	stranger := newBuyer(t, db)
+	product := newProduct(t, db, 5, 1000)
+
+	address := models.Address{UserID: buyer.ID, Street: "1 Moi Avenue", City: "Mombasa"}
+	mustCreate(t, db, &address)
+	order := newOrder(t, db, buyer.ID, "paid", 1, product)
+	db.Model(&order).Update("shipping_address_id", address.ID)
+	payment := newPayment(t, db, order.ID, "success", phone)
+	review := models.Review{ProductID: product.ID, UserID: &buyer.ID, Rating: 5, UserName: "Buyer"}
+	mustCreate(t, db, &review)
+	mustCreate(t, db, &models.Favorite{UserID: buyer.ID, ProductID: product.ID})
+	mustCreate(t, db, &models.PhoneOTP{Phone: phone, Purpose: "login", CodeHash: "x", ExpiresAt: time.Now()})
+	storedResponse(t, db, buyer.ID, "checkout-1", `{"shipping_address":"1 Moi Avenue"}`)
+	storedResponse(t, db, stranger.ID, "checkout-1", `{}`)
+
+	if err := NewAccountDataService(db).DeleteAccount(buyer); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+
+	remaining := []struct {
+		name  string
+		model any
+		where string
+		args  []any
+		want  int64
+	}{
+		{"users", &models.User{}, "id = ?", []any{buyer.ID}, 0},
+		{"addresses", &models.Address{}, "user_id = ?", []any{buyer.ID}, 0},
+		{"favourites", &models.Favorite{}, "user_id = ?", []any{buyer.ID}, 0},
+		{"phone codes", &models.PhoneOTP{}, "phone = ?", []any{phone}, 0},
+		{"stored responses", &models.IdempotencyKey{}, "scope = ?", []any{buyer.ID.String()}, 0},
+		{"other users' stored responses", &models.IdempotencyKey{}, "scope = ?", []any{stranger.ID.String()}, 1},
+		{"orders, detached", &models.Order{}, "id = ? AND buyer_id IS NULL AND shipping_address_id IS NULL", []any{order.ID}, 1},
+		{"payments, without phone", &models.Payment{}, "id = ? AND phone IS NULL", []any{payment.ID}, 1},
+		{"reviews, anonymised", &models.Review{}, "id = ? AND user_id IS NULL AND user_name = ?", []any{review.ID, "Deleted user"}, 1},
+	}
+	for _, r := range remaining {
+		var n int64
+		if err := db.Unscoped().Model(r.model).Where(r.where, r.args...).Count(&n).Error; err != nil {
+			t.Fatalf("%s: %v", r.name, err)
+		}
+		if n != r.want {
+			t.Errorf("%s: %d left; want %d", r.name, n, r.want)
+		}
+	}
+}
+
+func TestDeleteAccountRefusesStoreOwners(t *testing.T) {
+	db := openAccountDB(t)
+	seller := newBuyer(t, db)
+	mustCreate(t, db, &models.Store{OwnerID: seller.ID, Name: "Store"})
+
+	if err := NewAccountDataService(db).DeleteAccount(seller); !errors.Is(err, ErrAccountOwnsStores) {
+		t.Fatalf("DeleteAccount = %v; want ErrAccountOwnsStores", err)
+	}
+	var n int64
+	db.Model(&models.User{}).Where("id = ?", seller.ID).Count(&n)
+	if n != 1 {
+		t.Error("the store owner's account was deleted")
+	}
+}
+
+func TestExportWriteZip(t *testing.T) {
+	db := openAccountDB(t)
+	buyer := newBuyer(t, db)
+	storedResponse(t, db, buyer.ID, "checkout-1", `{"order_id":"1"}`)
+	export, err := NewAccountDataService(db).Export(buyer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := export.WriteZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	for _, name := range []string{"profile.json", "addresses.json", "orders.json", "payments.json", "api_keys.json", "stored_responses.json"} {
+		if files[name] == nil {
+			t.Errorf("archive has no %s", name)
+		}
+	}
+
+	rc, err := files["profile.json"].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var profile models.User
+	if err := json.NewDecoder(rc).Decode(&profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.ID != buyer.ID {
+		t.Errorf("profile.json is for %s; want %s", profile.ID, buyer.ID)
+	}
+}
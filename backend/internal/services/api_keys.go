@@ -0,0 +1,234 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+// API key scopes
+const (
+	ScopeProductsRead   = "products:read"
+	ScopeProductsWrite  = "products:write"
+	ScopeOrdersRead     = "orders:read"
+	ScopeInventoryWrite = "inventory:write"
+)
+
+// ValidScopes lists every scope a key can be granted
+var ValidScopes = map[string]bool{
+	ScopeProductsRead:   true,
+	ScopeProductsWrite:  true,
+	ScopeOrdersRead:     true,
+	ScopeInventoryWrite: true,
+}
+
+// APIKeyPrefix marks a bearer credential as an API key rather than a JWT
+const APIKeyPrefix = "tmk_"
+
+var (
+	ErrAPIKeyInvalid = errors.New("invalid api key")
+	ErrAPIKeyExpired = errors.New("api key expired or revoked")
+)
+
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type APIKeyService struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyService(db *gorm.DB) *APIKeyService {
+	return &APIKeyService{db: db}
+}
+
+// CreateAPIKeyInput describes a new key
+type CreateAPIKeyInput struct {
+	Name      string
+	Scopes    []string
+	StoreIDs  []uuid.UUID
+	ExpiresAt *time.Time
+}
+
+// Create issues a new key for the user and returns the record plus the plaintext
+// secret, which is never stored and cannot be shown again
+func (s *APIKeyService) Create(userID uuid.UUID, input CreateAPIKeyInput) (*models.APIKey, string, error) {
+	name := strings.TrimSpace(input.Name)
+	if name == "" || len(name) > 100 {
+		return nil, "", errors.New("name is required (max 100 characters)")
+	}
+	if len(input.Scopes) == 0 {
+		return nil, "", errors.New("at least one scope is required")
+	}
+	for _, scope := range input.Scopes {
+		if !ValidScopes[scope] {
+			return nil, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if len(input.StoreIDs) == 0 {
+		return nil, "", errors.New("at least one store is required")
+	}
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		return nil, "", errors.New("expires_at must be in the future")
+	}
+
+	// Keys can only be bound to stores the user owns
+	var owned int64
+	if err := s.db.Model(&models.Store{}).
+		Where("id IN ? AND owner_id = ?", input.StoreIDs, userID).
+		Count(&owned).Error; err != nil {
+		return nil, "", err
+	}
+	if int(owned) != len(uniqueUUIDs(input.StoreIDs)) {
+		return nil, "", errors.New("you do not own all of the given stores")
+	}
+
+	prefix, err := randomToken(5)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomToken(20)
+	if err != nil {
+		return nil, "", err
+	}
+	prefix = strings.ToLower(prefix)
+	raw := APIKeyPrefix + prefix + "_" + secret
+
+	storeIDs := make(pq.StringArray, 0, len(input.StoreIDs))
+	for _, id := range uniqueUUIDs(input.StoreIDs) {
+		storeIDs = append(storeIDs, id.String())
+	}
+
+	key := models.APIKey{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hashAPIKey(raw),
+		Scopes:    input.Scopes,
+		StoreIDs:  storeIDs,
+		ExpiresAt: input.ExpiresAt,
+	}
+	if err := s.db.Create(&key).Error; err != nil {
+		return nil, "", err
+	}
+	return &key, raw, nil
+}
+
+// List returns all keys belonging to the user, newest first
+func (s *APIKeyService) List(userID uuid.UUID) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	if err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke disables a key immediately
+func (s *APIKeyService) Revoke(userID, keyID uuid.UUID) error {
+	result := s.db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a raw key to its record and owning user, and records its use
+func (s *APIKeyService) Authenticate(raw string) (*models.APIKey, *models.User, error) {
+	if !strings.HasPrefix(raw, APIKeyPrefix) {
+		return nil, nil, ErrAPIKeyInvalid
+	}
+	parts := strings.SplitN(strings.TrimPrefix(raw, APIKeyPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, nil, ErrAPIKeyInvalid
+	}
+
+	var key models.APIKey
+	if err := s.db.Where("prefix = ?", parts[0]).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrAPIKeyInvalid
+		}
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashAPIKey(raw))) != 1 {
+		return nil, nil, ErrAPIKeyInvalid
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return nil, nil, ErrAPIKeyExpired
+	}
+
+	var user models.User
+	if err := s.db.First(&user, "id = ?", key.UserID).Error; err != nil {
+		return nil, nil, ErrAPIKeyInvalid
+	}
+
+	// Record usage, at most once a minute to keep scripts from writing on every call
+	s.db.Model(&models.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", key.ID, now.Add(-time.Minute)).
+		Update("last_used_at", now)
+	key.LastUsedAt = &now
+
+	return &key, &user, nil
+}
+
+// HasScope reports whether the key was granted the scope
+func HasScope(key *models.APIKey, scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyAllowsStore reports whether the key is bound to the store
+func KeyAllowsStore(key *models.APIKey, storeID uuid.UUID) bool {
+	for _, id := range key.StoreIDs {
+		if id == storeID.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func hashAPIKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return keyEncoding.EncodeToString(buf), nil
+}
+
+func uniqueUUIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	out := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
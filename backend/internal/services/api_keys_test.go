@@ -0,0 +1,146 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/testdb"
+)
+
+func openAPIKeyDB(t *testing.T) (*gorm.DB, models.User, models.Store) {
+	t.Helper()
+	db := testdb.Open(t, &models.User{}, &models.Store{}, &models.APIKey{})
+	seller := models.User{Name: "Seller"}
+	mustCreate(t, db, &seller)
+	store := models.Store{OwnerID: seller.ID, Name: "Store"}
+	mustCreate(t, db, &store)
+	return db, seller, store
+}
+
+func TestCreateAPIKeyValidation(t *testing.T) {
+	db, seller, store := openAPIKeyDB(t)
+	other := models.Store{OwnerID: uuid.New(), Name: "Other"}
+	mustCreate(t, db, &other)
+	past := time.Now().Add(-time.Hour)
+
+	cases := []struct {
+		name  string
+		input CreateAPIKeyInput
+		ok    bool
+	}{
+		{"valid", CreateAPIKeyInput{Name: "ERP", Scopes: []string{ScopeProductsRead}, StoreIDs: []uuid.UUID{store.ID}}, true},
+		{"same store twice", CreateAPIKeyInput{Name: "ERP", Scopes: []string{ScopeProductsRead}, StoreIDs: []uuid.UUID{store.ID, store.ID}}, true},
+		{"no name", CreateAPIKeyInput{Name: "  ", Scopes: []string{ScopeProductsRead}, StoreIDs: []uuid.UUID{store.ID}}, false},
+		{"name too long", CreateAPIKeyInput{Name: strings.Repeat("n", 101), Scopes: []string{ScopeProductsRead}, StoreIDs: []uuid.UUID{store.ID}}, false},
+		{"no scopes", CreateAPIKeyInput{Name: "ERP", StoreIDs: []uuid.UUID{store.ID}}, false},
+		{"unknown scope", CreateAPIKeyInput{Name: "ERP", Scopes: []string{"orders:write"}, StoreIDs: []uuid.UUID{store.ID}}, false},
+		{"no stores", CreateAPIKeyInput{Name: "ERP", Scopes: []string{ScopeProductsRead}}, false},
+		{"someone else's store", CreateAPIKeyInput{Name: "ERP", Scopes: []string{ScopeProductsRead}, StoreIDs: []uuid.UUID{store.ID, other.ID}}, false},
+		{"already expired", CreateAPIKeyInput{Name: "ERP", Scopes: []string{ScopeProductsRead}, StoreIDs: []uuid.UUID{store.ID}, ExpiresAt: &past}, false},
+	}
+	for _, tc := range cases {
+		_, _, err := NewAPIKeyService(db).Create(seller.ID, tc.input)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: err = %v; want ok = %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestAPIKeyStoredOnlyAsHash(t *testing.T) {
+	db, seller, store := openAPIKeyDB(t)
+	key, raw, err := NewAPIKeyService(db).Create(seller.ID, CreateAPIKeyInput{
+		Name: "ERP", Scopes: []string{ScopeProductsRead}, StoreIDs: []uuid.UUID{store.ID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw, APIKeyPrefix+key.Prefix+"_") {
+		t.Errorf("key %q does not start with its prefix %q", raw, key.Prefix)
+	}
+
+	var stored models.APIKey
+	db.First(&stored, "id = ?", key.ID)
+	if stored.KeyHash != hashAPIKey(raw) {
+		t.Error("stored hash is not the key's SHA-256")
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	db, seller, store := openAPIKeyDB(t)
+	keys := NewAPIKeyService(db)
+	create := func() (*models.APIKey, string) {
+		key, raw, err := keys.Create(seller.ID, CreateAPIKeyInput{
+			Name: "ERP", Scopes: []string{ScopeInventoryWrite}, StoreIDs: []uuid.UUID{store.ID},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key, raw
+	}
+	_, valid := create()
+	revoked, revokedRaw := create()
+	if err := keys.Revoke(seller.ID, revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	expired, expiredRaw := create()
+	db.Model(expired).Update("expires_at", time.Now().Add(-time.Minute))
+
+	// The right prefix with the wrong secret
+	tampered := valid[:len(valid)-1] + "A"
+	if tampered == valid {
+		tampered = valid[:len(valid)-1] + "B"
+	}
+
+	cases := []struct {
+		name string
+		raw  string
+		err  error
+	}{
+		{"valid", valid, nil},
+		{"wrong secret", tampered, ErrAPIKeyInvalid},
+		{"unknown prefix", APIKeyPrefix + "zzzzzzzz_SECRET", ErrAPIKeyInvalid},
+		{"not an api key", "eyJhbGciOi", ErrAPIKeyInvalid},
+		{"no secret", APIKeyPrefix + revoked.Prefix + "_", ErrAPIKeyInvalid},
+		{"revoked", revokedRaw, ErrAPIKeyExpired},
+		{"expired", expiredRaw, ErrAPIKeyExpired},
+	}
+	for _, tc := range cases {
+		key, user, err := keys.Authenticate(tc.raw)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+			continue
+		}
+		if err == nil && (user.ID != seller.ID || key.LastUsedAt == nil) {
+			t.Errorf("%s: authenticated as %s, last used %v; want the seller, now", tc.name, user.ID, key.LastUsedAt)
+		}
+	}
+}
+
+func TestAPIKeyScopesAndStores(t *testing.T) {
+	store := uuid.New()
+	key := &models.APIKey{Scopes: []string{ScopeProductsRead, ScopeInventoryWrite}, StoreIDs: []string{store.String()}}
+
+	cases := []struct {
+		scope string
+		want  bool
+	}{
+		{ScopeProductsRead, true},
+		{ScopeInventoryWrite, true},
+		{ScopeProductsWrite, false},
+		{ScopeOrdersRead, false},
+	}
+	for _, tc := range cases {
+		if got := HasScope(key, tc.scope); got != tc.want {
+			t.Errorf("HasScope(%s) = %v; want %v", tc.scope, got, tc.want)
+		}
+	}
+	if !KeyAllowsStore(key, store) || KeyAllowsStore(key, uuid.New()) {
+		t.Error("KeyAllowsStore allows only the key's own stores")
+	}
+}
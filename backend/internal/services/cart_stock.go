@@ -0,0 +1,217 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"trumall/internal/models"
+)
+
+// stockReservationWindow is how long an unpaid order holds its units. Stock is
+// only deducted once the order is paid, so until then other buyers must not be
+// offered the same units; abandoned checkouts give them back.
+const stockReservationWindow = 24 * time.Hour
+
+var (
+	ErrNotEnoughStock     = errors.New("not enough stock")
+	ErrOrderLimitExceeded = errors.New("more than the product's order limit")
+)
+
+// CartQuantityError is a cart quantity the product cannot supply, with the
+// most the buyer can have instead
+type CartQuantityError struct {
+	ProductID uuid.UUID
+	Title     string
+	Requested int
+	Max       int
+	Err       error // ErrNotEnoughStock or ErrOrderLimitExceeded
+}
+
+func (e *CartQuantityError) Error() string {
+	switch {
+	case errors.Is(e.Err, ErrOrderLimitExceeded):
+		return fmt.Sprintf("you can order at most %d of %s", e.Max, e.Title)
+	case e.Max <= 0:
+		return fmt.Sprintf("%s is out of stock", e.Title)
+	}
+	return fmt.Sprintf("only %d of %s available", e.Max, e.Title)
+}
+
+func (e *CartQuantityError) Unwrap() error { return e.Err }
+
+// StockLimit is how many of a product one buyer can have in their cart
+type StockLimit struct {
+	Available        int  // stock less the units held by other buyers' unpaid orders
+	MaxOrderQuantity *int // the seller's per-order limit, if any
+}
+
+// Max is the largest quantity the buyer can have
+func (l StockLimit) Max() int {
+	n := max(l.Available, 0)
+	if l.MaxOrderQuantity != nil {
+		n = min(n, *l.MaxOrderQuantity)
+	}
+	return n
+}
+
+// Check reports whether quantity of the product is within its limits
+func (l StockLimit) Check(p models.Product, quantity int) error {
+	if l.MaxOrderQuantity != nil && quantity > *l.MaxOrderQuantity {
+		return &CartQuantityError{ProductID: p.ID, Title: p.Title, Requested: quantity, Max: *l.MaxOrderQuantity, Err: ErrOrderLimitExceeded}
+	}
+	if quantity > l.Available {
+		return &CartQuantityError{ProductID: p.ID, Title: p.Title, Requested: quantity, Max: max(l.Available, 0), Err: ErrNotEnoughStock}
+	}
+	return nil
+}
+
+// StockLimits works out the limits of products for a buyer. Units in unpaid
+// orders are reserved for those orders; the buyer's own unpaid orders are not
+// counted against them, as checking out again cancels those. buyerID is nil
+// for guests, and counts every unpaid order.
+func StockLimits(db *gorm.DB, products []models.Product, buyerID *uuid.UUID) (map[uuid.UUID]StockLimit, error) {
+	limits := make(map[uuid.UUID]StockLimit, len(products))
+	if len(products) == 0 {
+		return limits, nil
+	}
+	ids := make([]uuid.UUID, 0, len(products))
+	for _, p := range products {
+		ids = append(ids, p.ID)
+	}
+	reserved, err := reservedStock(db, ids, buyerID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range products {
+		limits[p.ID] = StockLimit{Available: p.Stock - reserved[p.ID], MaxOrderQuantity: p.MaxOrderQuantity}
+	}
+	return limits, nil
+}
+
+// CheckCartQuantity reports whether a buyer can have quantity of a product in
+// their cart
+func CheckCartQuantity(db *gorm.DB, p models.Product, quantity int, buyerID *uuid.UUID) error {
+	limits, err := StockLimits(db, []models.Product{p}, buyerID)
+	if err != nil {
+		return err
+	}
+	return limits[p.ID].Check(p, quantity)
+}
+
+// ReplacePendingOrders cancels the buyer's unpaid orders that a checkout of
+// cart replaces, those holding any of its products, releasing what they held,
+// and supersedes their payments. The cart is only cleared once an order is
+// paid, so without this the same units could be checked out, and paid for,
+// more than once. Orders for other products, such as one from another store
+// awaiting payment, are left alone. While a replaced order's payment prompt may
+// still be open on the buyer's phone the checkout is refused with
+// ErrPaymentInProgress, as the prompt can't be withdrawn. The buyer's row stays
+// locked until the transaction ends, so their checkouts run one at a time.
+func ReplacePendingOrders(tx *gorm.DB, buyerID uuid.UUID, cart []models.CartItem) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+		First(&models.User{}, "id = ?", buyerID).Error; err != nil {
+		return err
+	}
+	productIDs := make([]uuid.UUID, 0, len(cart))
+	for _, item := range cart {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	var ids []uuid.UUID
+	if err := tx.Model(&models.Order{}).Distinct("orders.id").
+		Joins("JOIN order_items ON order_items.order_id = orders.id").
+		Where("orders.buyer_id = ? AND orders.status = ? AND order_items.product_id IN ?", buyerID, "pending", productIDs).
+		Pluck("orders.id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var open []models.Payment
+	if err := tx.Where("order_id IN ? AND status IN ?", ids, []string{"initiated", "pending"}).Find(&open).Error; err != nil {
+		return err
+	}
+	for _, p := range open {
+		if promptStillOpen(p) {
+			return ErrPaymentInProgress
+		}
+	}
+
+	if err := tx.Model(&models.Order{}).Where("id IN ?", ids).Update("status", "cancelled").Error; err != nil {
+		return err
+	}
+	// A prompt for a cancelled order that is still paid is recorded as due a refund
+	return tx.Model(&models.Payment{}).Where("order_id IN ? AND status IN ?", ids, []string{"initiated", "pending"}).
+		Update("status", "superseded").Error
+}
+
+// ReserveStock checks the cart against stock at checkout, counting every unpaid
+// order, the buyer's own included. The products are locked (in a fixed order)
+// until the transaction ends, so concurrent checkouts cannot promise the same
+// units twice.
+func ReserveStock(tx *gorm.DB, cart []models.CartItem) error {
+	wanted := map[uuid.UUID]int{}
+	titles := map[uuid.UUID]string{}
+	ids := make([]uuid.UUID, 0, len(cart))
+	for _, item := range cart {
+		if _, seen := wanted[item.ProductID]; !seen {
+			ids = append(ids, item.ProductID)
+		}
+		wanted[item.ProductID] += item.Quantity
+		titles[item.ProductID] = item.Product.Title
+	}
+
+	var products []models.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", ids).Order("id").Find(&products).Error; err != nil {
+		return err
+	}
+	limits, err := StockLimits(tx, products, nil)
+	if err != nil {
+		return err
+	}
+	found := make(map[uuid.UUID]models.Product, len(products))
+	for _, p := range products {
+		found[p.ID] = p
+	}
+	for _, id := range ids {
+		p, ok := found[id]
+		if !ok {
+			// The product has been taken down since it was added
+			return &CartQuantityError{ProductID: id, Title: titles[id], Requested: wanted[id], Err: ErrNotEnoughStock}
+		}
+		if err := limits[id].Check(p, wanted[id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reservedStock sums the units of each product held by unpaid orders
+func reservedStock(db *gorm.DB, productIDs []uuid.UUID, buyerID *uuid.UUID) (map[uuid.UUID]int, error) {
+	var rows []struct {
+		ProductID uuid.UUID
+		Reserved  int
+	}
+	q := db.Model(&models.OrderItem{}).
+		Select("order_items.product_id, COALESCE(SUM(order_items.quantity), 0) AS reserved").
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_items.product_id IN ?", productIDs).
+		Where("orders.status = ? AND orders.created_at > ?", "pending", time.Now().Add(-stockReservationWindow)).
+		Group("order_items.product_id")
+	if buyerID != nil {
+		q = q.Where("orders.buyer_id <> ?", *buyerID)
+	}
+	if err := q.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	reserved := make(map[uuid.UUID]int, len(rows))
+	for _, row := range rows {
+		reserved[row.ProductID] = row.Reserved
+	}
+	return reserved, nil
+}
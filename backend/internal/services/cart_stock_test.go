@@ -0,0 +1,176 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"trumall/internal/models"
+)
+
+func TestReplacePendingOrdersOnlyTouchesCheckedOutProducts(t *testing.T) {
+	db := openOrderDB(t)
+	buyer := newBuyer(t, db)
+	earbuds := newProduct(t, db, 10, 5000)
+	kettle := newProduct(t, db, 10, 3000)
+
+	// An earlier checkout of the same cart, whose prompt was declined
+	replaced := newOrder(t, db, buyer.ID, "pending", 1, earbuds)
+	declined := newPayment(t, db, replaced.ID, "failed", "254700000001")
+	// Another store's order, still waiting for a prompt that timed out
+	other := newOrder(t, db, buyer.ID, "pending", 1, kettle)
+	waiting := newPayment(t, db, other.ID, "pending", "254700000001")
+	db.Model(&waiting).UpdateColumn("updated_at", time.Now().Add(-time.Hour))
+	// Someone else's order for the same product
+	stranger := newBuyer(t, db)
+	theirs := newOrder(t, db, stranger.ID, "pending", 1, earbuds)
+
+	if err := ReplacePendingOrders(db, buyer.ID, cartOf(buyer.ID, 1, earbuds)); err != nil {
+		t.Fatalf("ReplacePendingOrders: %v", err)
+	}
+
+	if got := statusOf[models.Order](t, db, replaced.ID); got != "cancelled" {
+		t.Errorf("replaced order is %s; want cancelled", got)
+	}
+	if got := statusOf[models.Payment](t, db, declined.ID); got != "failed" {
+		t.Errorf("declined payment is %s; want failed", got)
+	}
+	if got := statusOf[models.Order](t, db, other.ID); got != "pending" {
+		t.Errorf("order for other products is %s; want pending", got)
+	}
+	if got := statusOf[models.Payment](t, db, waiting.ID); got != "pending" {
+		t.Errorf("payment for other products is %s; want pending", got)
+	}
+	if got := statusOf[models.Order](t, db, theirs.ID); got != "pending" {
+		t.Errorf("another buyer's order is %s; want pending", got)
+	}
+}
+
+func TestReplacePendingOrdersSupersedesStalePrompts(t *testing.T) {
+	db := openOrderDB(t)
+	buyer := newBuyer(t, db)
+	earbuds := newProduct(t, db, 10, 5000)
+
+	order := newOrder(t, db, buyer.ID, "pending", 1, earbuds)
+	stale := newPayment(t, db, order.ID, "pending", "254700000001")
+	db.Model(&stale).UpdateColumn("updated_at", time.Now().Add(-stkPromptReuseWindow-time.Minute))
+
+	if err := ReplacePendingOrders(db, buyer.ID, cartOf(buyer.ID, 2, earbuds)); err != nil {
+		t.Fatalf("ReplacePendingOrders: %v", err)
+	}
+	if got := statusOf[models.Order](t, db, order.ID); got != "cancelled" {
+		t.Errorf("order is %s; want cancelled", got)
+	}
+	if got := statusOf[models.Payment](t, db, stale.ID); got != "superseded" {
+		t.Errorf("stale payment is %s; want superseded", got)
+	}
+}
+
+func TestReplacePendingOrdersRefusesWhilePromptOpen(t *testing.T) {
+	db := openOrderDB(t)
+	buyer := newBuyer(t, db)
+	earbuds := newProduct(t, db, 10, 5000)
+
+	for _, status := range []string{"initiated", "pending"} {
+		order := newOrder(t, db, buyer.ID, "pending", 1, earbuds)
+		open := newPayment(t, db, order.ID, status, "254700000001")
+
+		err := ReplacePendingOrders(db, buyer.ID, cartOf(buyer.ID, 1, earbuds))
+		if !errors.Is(err, ErrPaymentInProgress) {
+			t.Fatalf("%s prompt: err = %v; want ErrPaymentInProgress", status, err)
+		}
+		if got := statusOf[models.Order](t, db, order.ID); got != "pending" {
+			t.Errorf("%s prompt: order is %s; want pending", status, got)
+		}
+		db.Model(&open).Update("status", "failed")
+	}
+}
+
+func TestCheckCartQuantity(t *testing.T) {
+	db := openOrderDB(t)
+	buyer := newBuyer(t, db)
+	other := newBuyer(t, db)
+	stale := func(order models.Order) {
+		db.Model(&order).UpdateColumn("created_at", time.Now().Add(-stockReservationWindow-time.Hour))
+	}
+
+	limited := newProduct(t, db, 10, 1000)
+	db.Model(&limited).Update("max_order_quantity", 4)
+	held := newProduct(t, db, 5, 1000)
+	newOrder(t, db, other.ID, "pending", 3, held)
+	ours := newProduct(t, db, 5, 1000)
+	newOrder(t, db, buyer.ID, "pending", 4, ours)
+	freed := newProduct(t, db, 5, 1000)
+	stale(newOrder(t, db, other.ID, "pending", 5, freed))
+	newOrder(t, db, other.ID, "paid", 5, freed)
+	newOrder(t, db, other.ID, "cancelled", 5, freed)
+	gone := newProduct(t, db, 2, 1000)
+	newOrder(t, db, other.ID, "pending", 2, gone)
+
+	cases := []struct {
+		name     string
+		product  models.Product
+		quantity int
+		buyer    *uuid.UUID
+		err      error
+		max      int
+	}{
+		{"within the limits", limited, 4, &buyer.ID, nil, 0},
+		{"over the order limit", limited, 5, &buyer.ID, ErrOrderLimitExceeded, 4},
+		{"units held by another buyer", held, 3, &buyer.ID, ErrNotEnoughStock, 2},
+		{"the buyer's own unpaid order", ours, 5, &buyer.ID, nil, 0},
+		{"guests count every unpaid order", ours, 2, nil, ErrNotEnoughStock, 1},
+		{"stale, paid and cancelled orders hold nothing", freed, 5, &buyer.ID, nil, 0},
+		{"all held", gone, 1, &buyer.ID, ErrNotEnoughStock, 0},
+	}
+	for _, tc := range cases {
+		var product models.Product
+		db.First(&product, "id = ?", tc.product.ID)
+		err := CheckCartQuantity(db, product, tc.quantity, tc.buyer)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+			continue
+		}
+		var qerr *CartQuantityError
+		if errors.As(err, &qerr) && qerr.Max != tc.max {
+			t.Errorf("%s: at most %d; want %d", tc.name, qerr.Max, tc.max)
+		}
+	}
+
+	var product models.Product
+	db.First(&product, "id = ?", gone.ID)
+	if err := CheckCartQuantity(db, product, 1, &buyer.ID); err == nil || err.Error() != "Product is out of stock" {
+		t.Errorf("err = %v; want the product out of stock", err)
+	}
+}
+
+func TestReserveStock(t *testing.T) {
+	db := openOrderDB(t)
+	buyer := newBuyer(t, db)
+	kettle := newProduct(t, db, 5, 3000)
+	limited := newProduct(t, db, 5, 3000)
+	db.Model(&limited).Update("max_order_quantity", 2)
+	held := newProduct(t, db, 5, 3000)
+	newOrder(t, db, buyer.ID, "pending", 3, held)
+	removed := newProduct(t, db, 5, 3000)
+	db.Delete(&removed)
+
+	cases := []struct {
+		name string
+		cart []models.CartItem
+		err  error
+	}{
+		{"enough stock", cartOf(buyer.ID, 2, kettle, limited), nil},
+		{"lines of one product add up", append(cartOf(buyer.ID, 3, kettle), cartOf(buyer.ID, 3, kettle)...), ErrNotEnoughStock},
+		{"the buyer's own unpaid orders count", cartOf(buyer.ID, 3, held), ErrNotEnoughStock},
+		{"over the order limit", cartOf(buyer.ID, 3, limited), ErrOrderLimitExceeded},
+		{"product taken down", cartOf(buyer.ID, 1, removed), ErrNotEnoughStock},
+	}
+	for _, tc := range cases {
+		if err := ReserveStock(db, tc.cart); !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+		}
+	}
+}
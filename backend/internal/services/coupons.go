@@ -0,0 +1,524 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"trumall/internal/models"
+	"trumall/internal/validation"
+)
+
+// Coupon types
+const (
+	CouponTypePercentage   = "percentage"
+	CouponTypeFixed        = "fixed"
+	CouponTypeFreeShipping = "free_shipping"
+)
+
+// couponUnpaidHoldWindow is how long an unpaid order keeps its coupon
+// redemption; abandoned checkouts must not use a coupon up
+const couponUnpaidHoldWindow = 24 * time.Hour
+
+// couponHeldStatuses are the order statuses whose coupon redemptions count
+// towards the coupon's limits
+var couponHeldStatuses = []string{"paid", "processing", "shipped", "ready_for_pickup", "delivered"}
+
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+var (
+	ErrCouponNotFound      = errors.New("coupon not found")
+	ErrCouponInactive      = errors.New("this coupon is no longer active")
+	ErrCouponNotStarted    = errors.New("this coupon is not valid yet")
+	ErrCouponExpired       = errors.New("this coupon has expired")
+	ErrCouponMinSpend      = errors.New("cart does not reach this coupon's minimum spend")
+	ErrCouponNotApplicable = errors.New("this coupon does not apply to any item in your cart")
+	ErrCouponUsageLimit    = errors.New("this coupon has reached its usage limit")
+	ErrCouponPerUserLimit  = errors.New("you have already used this coupon the maximum number of times")
+	ErrCouponCodeTaken     = errors.New("a coupon with this code already exists")
+)
+
+type CouponService struct {
+	db *gorm.DB
+}
+
+func NewCouponService(db *gorm.DB) *CouponService {
+	return &CouponService{db: db}
+}
+
+// CouponInput is the editable part of a coupon. Fields left out of an update
+// keep their current value; a zero limit or max discount removes it.
+type CouponInput struct {
+	Code             *string    `json:"code"`
+	Description      *string    `json:"description"`
+	Type             *string    `json:"type"`
+	Value            *int64     `json:"value"`
+	MaxDiscountCents *int64     `json:"max_discount_cents"`
+	MinSpendCents    *int64     `json:"min_spend_cents"`
+	StoreID          *uuid.UUID `json:"store_id"` // admins only; sellers' coupons always belong to their store
+	Category         *string    `json:"category"` // "" removes the category scope
+	ProductIDs       []string   `json:"product_ids"`
+	StartsAt         *time.Time `json:"starts_at"`
+	ClearStartsAt    bool       `json:"clear_starts_at"`
+	EndsAt           *time.Time `json:"ends_at"`
+	ClearEndsAt      bool       `json:"clear_ends_at"`
+	UsageLimit       *int       `json:"usage_limit"`
+	PerUserLimit     *int       `json:"per_user_limit"`
+	IsActive         *bool      `json:"is_active"`
+}
+
+// Apply copies the fields that were sent onto c
+func (in CouponInput) Apply(c *models.Coupon) {
+	if in.Code != nil {
+		c.Code = strings.ToUpper(strings.TrimSpace(*in.Code))
+	}
+	if in.Description != nil {
+		c.Description = optionalString(*in.Description)
+	}
+	setString(&c.Type, in.Type)
+	setValue(&c.Value, in.Value)
+	setOptional(&c.MaxDiscountCents, in.MaxDiscountCents, in.MaxDiscountCents != nil && *in.MaxDiscountCents == 0)
+	setValue(&c.MinSpendCents, in.MinSpendCents)
+	setOptional(&c.StoreID, in.StoreID, false)
+	if in.Category != nil {
+		c.Category = optionalString(*in.Category)
+	}
+	if in.ProductIDs != nil {
+		c.ProductIDs = pq.StringArray(in.ProductIDs)
+	}
+	setOptional(&c.StartsAt, in.StartsAt, in.ClearStartsAt)
+	setOptional(&c.EndsAt, in.EndsAt, in.ClearEndsAt)
+	setOptional(&c.UsageLimit, in.UsageLimit, in.UsageLimit != nil && *in.UsageLimit == 0)
+	setOptional(&c.PerUserLimit, in.PerUserLimit, in.PerUserLimit != nil && *in.PerUserLimit == 0)
+	setValue(&c.IsActive, in.IsActive)
+}
+
+// List returns the coupons of a store, or every coupon when storeID is nil
+func (s *CouponService) List(storeID *uuid.UUID) ([]models.Coupon, error) {
+	var coupons []models.Coupon
+	err := s.scope(storeID).Order("created_at DESC").Find(&coupons).Error
+	return coupons, err
+}
+
+// Get returns a coupon; with a storeID only that store's coupons are found
+func (s *CouponService) Get(id uuid.UUID, storeID *uuid.UUID) (*models.Coupon, error) {
+	var coupon models.Coupon
+	if err := s.scope(storeID).Where("id = ?", id).First(&coupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// Create adds a coupon. A storeID makes it a store coupon whatever the input
+// says; without one the input's store_id (if any) is used.
+func (s *CouponService) Create(in CouponInput, storeID *uuid.UUID, createdBy uuid.UUID) (*models.Coupon, error) {
+	coupon := models.Coupon{IsActive: true, CreatedBy: &createdBy}
+	in.Apply(&coupon)
+	if storeID != nil {
+		coupon.StoreID = storeID
+	}
+	if err := s.validate(&coupon); err != nil {
+		return nil, err
+	}
+
+	coupon.ID = uuid.New()
+	if err := s.db.Select("*").Create(&coupon).Error; err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// Update edits a coupon; with a storeID only that store's coupons can be
+// edited, and they stay with the store
+func (s *CouponService) Update(id uuid.UUID, in CouponInput, storeID *uuid.UUID) (*models.Coupon, error) {
+	coupon, err := s.Get(id, storeID)
+	if err != nil {
+		return nil, err
+	}
+	if storeID != nil {
+		in.StoreID = nil
+	}
+	in.Apply(coupon)
+	if err := s.validate(coupon); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Save(coupon).Error; err != nil {
+		return nil, err
+	}
+	return coupon, nil
+}
+
+// Delete removes a coupon. Orders that used it keep their recorded discount.
+func (s *CouponService) Delete(id uuid.UUID, storeID *uuid.UUID) error {
+	coupon, err := s.Get(id, storeID)
+	if err != nil {
+		return err
+	}
+	return s.db.Delete(coupon).Error
+}
+
+func (s *CouponService) scope(storeID *uuid.UUID) *gorm.DB {
+	if storeID == nil {
+		return s.db
+	}
+	return s.db.Where("store_id = ?", *storeID)
+}
+
+// validate checks a coupon before it is saved, normalising its code and
+// category
+func (s *CouponService) validate(c *models.Coupon) error {
+	if !couponCodePattern.MatchString(c.Code) {
+		return invalid("code", "must be 3-32 letters, digits, dashes or underscores")
+	}
+
+	switch c.Type {
+	case CouponTypePercentage:
+		if c.Value < 1 || c.Value > 100 {
+			return invalid("value", "must be a percentage between 1 and 100")
+		}
+	case CouponTypeFixed:
+		if c.Value <= 0 {
+			return invalid("value", "must be the amount off in cents, greater than 0")
+		}
+		c.MaxDiscountCents = nil
+	case CouponTypeFreeShipping:
+		c.Value = 0
+		c.MaxDiscountCents = nil
+	default:
+		return invalid("type", "must be %s, %s or %s", CouponTypePercentage, CouponTypeFixed, CouponTypeFreeShipping)
+	}
+	if c.MaxDiscountCents != nil && *c.MaxDiscountCents < 0 {
+		return invalid("max_discount_cents", "must not be negative")
+	}
+	if c.MinSpendCents < 0 {
+		return invalid("min_spend_cents", "must not be negative")
+	}
+	if c.StartsAt != nil && c.EndsAt != nil && !c.EndsAt.After(*c.StartsAt) {
+		return invalid("ends_at", "must be after starts_at")
+	}
+	if c.UsageLimit != nil && *c.UsageLimit < 0 {
+		return invalid("usage_limit", "must not be negative")
+	}
+	if c.PerUserLimit != nil && *c.PerUserLimit < 0 {
+		return invalid("per_user_limit", "must not be negative")
+	}
+
+	if c.Category != nil {
+		slug, err := validation.NormalizeCategory(*c.Category)
+		if err != nil {
+			return invalid("category", "%s", err.Error())
+		}
+		c.Category = optionalString(slug)
+	}
+
+	if c.StoreID != nil {
+		if found, err := s.exists(&models.Store{}, "id = ?", *c.StoreID); err != nil {
+			return err
+		} else if !found {
+			return invalid("store_id", "store not found")
+		}
+	}
+
+	if len(c.ProductIDs) > 0 {
+		ids := make([]uuid.UUID, 0, len(c.ProductIDs))
+		seen := map[uuid.UUID]bool{}
+		for i, raw := range c.ProductIDs {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				return invalid(fmt.Sprintf("product_ids[%d]", i), "is not a valid ID")
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		q := s.db.Model(&models.Product{}).Where("id IN ?", ids)
+		if c.StoreID != nil {
+			q = q.Where("store_id = ?", *c.StoreID)
+		}
+		var n int64
+		if err := q.Count(&n).Error; err != nil {
+			return err
+		}
+		if int(n) != len(ids) {
+			return invalid("product_ids", "every product must exist and belong to the coupon's store")
+		}
+		c.ProductIDs = c.ProductIDs[:0]
+		for _, id := range ids {
+			c.ProductIDs = append(c.ProductIDs, id.String())
+		}
+	}
+
+	q := s.db.Model(&models.Coupon{}).Where("code = ?", c.Code)
+	if c.ID != uuid.Nil {
+		q = q.Where("id <> ?", c.ID)
+	}
+	var n int64
+	if err := q.Count(&n).Error; err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrCouponCodeTaken
+	}
+	return nil
+}
+
+func (s *CouponService) exists(model any, query string, args ...any) (bool, error) {
+	var n int64
+	err := s.db.Model(model).Where(query, args...).Count(&n).Error
+	return n > 0, err
+}
+
+// CouponItem is a cart line as far as coupons are concerned
+type CouponItem struct {
+	ProductID      uuid.UUID
+	StoreID        uuid.UUID
+	Category       *string
+	Quantity       int
+	UnitPriceCents int64
+}
+
+// CouponItemsForCart turns cart items (with their Product and its active
+// Sales loaded) into coupon items
+func CouponItemsForCart(cart []models.CartItem) []CouponItem {
+	items := make([]CouponItem, 0, len(cart))
+	for _, item := range cart {
+		items = append(items, CouponItem{
+			ProductID:      item.ProductID,
+			StoreID:        item.Product.StoreID,
+			Category:       item.Product.Category,
+			Quantity:       item.Quantity,
+			UnitPriceCents: CurrentPriceCents(item.Product),
+		})
+	}
+	return items
+}
+
+// CouponQuote is what a coupon takes off a cart
+type CouponQuote struct {
+	CouponID              uuid.UUID `json:"coupon_id"`
+	Code                  string    `json:"code"`
+	Type                  string    `json:"type"`
+	Description           string    `json:"description"`
+	SubtotalCents         int64     `json:"subtotal_cents"`
+	EligibleSubtotalCents int64     `json:"eligible_subtotal_cents"` // the items in the coupon's scope
+	DiscountCents         int64     `json:"discount_cents"`          // off the items
+	ShippingDiscountCents int64     `json:"shipping_discount_cents"` // off shipping
+}
+
+// TotalDiscountCents is everything the coupon takes off the order
+func (q CouponQuote) TotalDiscountCents() int64 {
+	return q.DiscountCents + q.ShippingDiscountCents
+}
+
+// Preview works out what a coupon would take off a buyer's cart without
+// redeeming it
+func (s *CouponService) Preview(code string, buyer models.User, items []CouponItem, shippingCents int64) (*CouponQuote, error) {
+	return s.quote(s.db, code, buyer, items, shippingCents, false)
+}
+
+// Apply works out a coupon's discount inside a checkout transaction. The
+// coupon row stays locked until tx ends, so concurrent checkouts cannot
+// redeem it past its limits.
+func (s *CouponService) Apply(tx *gorm.DB, code string, buyer models.User, items []CouponItem, shippingCents int64) (*CouponQuote, error) {
+	return s.quote(tx, code, buyer, items, shippingCents, true)
+}
+
+// Record stores an applied coupon against the buyer's order, which counts as
+// a redemption
+func (s *CouponService) Record(tx *gorm.DB, orderID uuid.UUID, buyer models.User, q *CouponQuote) error {
+	couponID := q.CouponID
+	if err := tx.Create(&models.OrderDiscount{
+		OrderID:               orderID,
+		CouponID:              &couponID,
+		Code:                  q.Code,
+		Description:           q.Description,
+		AmountCents:           q.DiscountCents,
+		ShippingDiscountCents: q.ShippingDiscountCents,
+	}).Error; err != nil {
+		return err
+	}
+
+	hashes := couponRedeemerHashes(buyer)
+	redemptions := make([]models.CouponRedemption, 0, len(hashes))
+	for _, hash := range hashes {
+		redemptions = append(redemptions, models.CouponRedemption{
+			ID:           uuid.New(),
+			CouponID:     couponID,
+			OrderID:      orderID,
+			RedeemerHash: hash,
+		})
+	}
+	return tx.Create(&redemptions).Error
+}
+
+func (s *CouponService) quote(db *gorm.DB, code string, buyer models.User, items []CouponItem, shippingCents int64, lock bool) (*CouponQuote, error) {
+	q := db.Where("code = ?", strings.ToUpper(strings.TrimSpace(code)))
+	if lock {
+		q = q.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	var coupon models.Coupon
+	if err := q.First(&coupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case !coupon.IsActive:
+		return nil, ErrCouponInactive
+	case coupon.StartsAt != nil && now.Before(*coupon.StartsAt):
+		return nil, ErrCouponNotStarted
+	case coupon.EndsAt != nil && !now.Before(*coupon.EndsAt):
+		return nil, ErrCouponExpired
+	}
+
+	result := discountFor(coupon, items, shippingCents)
+	if result.EligibleSubtotalCents == 0 {
+		return nil, ErrCouponNotApplicable
+	}
+	if result.EligibleSubtotalCents < coupon.MinSpendCents {
+		return nil, fmt.Errorf("%w of KES %.2f", ErrCouponMinSpend, float64(coupon.MinSpendCents)/100)
+	}
+
+	if coupon.UsageLimit != nil {
+		used, err := couponRedemptions(db, coupon.ID)
+		if err != nil {
+			return nil, err
+		}
+		if used >= int64(*coupon.UsageLimit) {
+			return nil, ErrCouponUsageLimit
+		}
+	}
+	if coupon.PerUserLimit != nil {
+		used, err := couponBuyerRedemptions(db, coupon.ID, buyer)
+		if err != nil {
+			return nil, err
+		}
+		if used >= int64(*coupon.PerUserLimit) {
+			return nil, ErrCouponPerUserLimit
+		}
+	}
+
+	return result, nil
+}
+
+// discountFor works out a coupon's discount on the items in its scope. Percent
+// discounts round down to the cent.
+func discountFor(coupon models.Coupon, items []CouponItem, shippingCents int64) *CouponQuote {
+	result := &CouponQuote{
+		CouponID: coupon.ID,
+		Code:     coupon.Code,
+		Type:     coupon.Type,
+	}
+	if coupon.Description != nil {
+		result.Description = *coupon.Description
+	}
+
+	products := map[string]bool{}
+	for _, id := range coupon.ProductIDs {
+		products[id] = true
+	}
+	for _, item := range items {
+		line := int64(item.Quantity) * item.UnitPriceCents
+		result.SubtotalCents += line
+		switch {
+		case coupon.StoreID != nil && item.StoreID != *coupon.StoreID:
+		case coupon.Category != nil && (item.Category == nil || *item.Category != *coupon.Category):
+		case len(products) > 0 && !products[item.ProductID.String()]:
+		default:
+			result.EligibleSubtotalCents += line
+		}
+	}
+
+	switch coupon.Type {
+	case CouponTypePercentage:
+		result.DiscountCents = result.EligibleSubtotalCents * coupon.Value / 100
+		if coupon.MaxDiscountCents != nil && result.DiscountCents > *coupon.MaxDiscountCents {
+			result.DiscountCents = *coupon.MaxDiscountCents
+		}
+	case CouponTypeFixed:
+		result.DiscountCents = min(coupon.Value, result.EligibleSubtotalCents)
+	case CouponTypeFreeShipping:
+		result.ShippingDiscountCents = shippingCents
+	}
+	return result
+}
+
+// couponRedemptions counts a coupon's redemptions that hold
+func couponRedemptions(db *gorm.DB, couponID uuid.UUID) (int64, error) {
+	var n int64
+	err := couponHeldOrders(db.Model(&models.OrderDiscount{}).
+		Joins("JOIN orders ON orders.id = order_discounts.order_id").
+		Where("order_discounts.coupon_id = ?", couponID)).
+		Count(&n).Error
+	return n, err
+}
+
+// couponBuyerRedemptions counts a coupon's redemptions that hold by one buyer:
+// their orders, and orders by any earlier, deleted account with the same email
+// or phone
+func couponBuyerRedemptions(db *gorm.DB, couponID uuid.UUID, buyer models.User) (int64, error) {
+	redeemed := db.Model(&models.CouponRedemption{}).Select("order_id").
+		Where("coupon_id = ? AND redeemer_hash IN ?", couponID, couponRedeemerHashes(buyer))
+	var n int64
+	err := couponHeldOrders(db.Model(&models.OrderDiscount{}).
+		Joins("JOIN orders ON orders.id = order_discounts.order_id").
+		Where("order_discounts.coupon_id = ?", couponID).
+		Where("orders.buyer_id = ? OR orders.id IN (?)", buyer.ID, redeemed)).
+		Count(&n).Error
+	return n, err
+}
+
+// couponHeldOrders narrows a query joined to orders to those whose coupon
+// redemption holds
+func couponHeldOrders(q *gorm.DB) *gorm.DB {
+	return q.Where("orders.status IN ? OR (orders.status = ? AND orders.created_at > ?)",
+		couponHeldStatuses, "pending", time.Now().Add(-couponUnpaidHoldWindow))
+}
+
+// couponRedeemerSigningKey uses a dedicated secret when configured, else the
+// JWT secret. Changing it starts per-buyer limits afresh.
+func couponRedeemerSigningKey() []byte {
+	if secret := os.Getenv("COUPON_REDEEMER_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+// couponRedeemerHashes identify a buyer in coupon_redemptions: their account,
+// email and phone, each keyed so the table holds no contact details
+func couponRedeemerHashes(buyer models.User) []string {
+	ids := []string{"user:" + buyer.ID.String()}
+	if buyer.Email != nil && *buyer.Email != "" {
+		ids = append(ids, "email:"+strings.ToLower(strings.TrimSpace(*buyer.Email)))
+	}
+	if buyer.Phone != nil && *buyer.Phone != "" {
+		ids = append(ids, "phone:"+*buyer.Phone)
+	}
+
+	hashes := make([]string, 0, len(ids))
+	for _, id := range ids {
+		mac := hmac.New(sha256.New, couponRedeemerSigningKey())
+		mac.Write([]byte(id))
+		hashes = append(hashes, hex.EncodeToString(mac.Sum(nil)))
+	}
+	return hashes
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/testdb"
+)
+
+func TestDiscountFor(t *testing.T) {
+	store := uuid.New()
+	otherStore := uuid.New()
+	phones := "phones"
+	maxDiscount := int64(1500)
+	items := []CouponItem{
+		{ProductID: uuid.New(), StoreID: store, Category: &phones, Quantity: 2, UnitPriceCents: 5000},
+		{ProductID: uuid.New(), StoreID: otherStore, Quantity: 1, UnitPriceCents: 3000},
+	}
+
+	cases := []struct {
+		name        string
+		coupon      models.Coupon
+		eligible    int64
+		discount    int64
+		shippingOff int64
+	}{
+		{"percentage of the cart", models.Coupon{Type: CouponTypePercentage, Value: 10}, 13000, 1300, 0},
+		{"percentage capped", models.Coupon{Type: CouponTypePercentage, Value: 50, MaxDiscountCents: &maxDiscount}, 13000, 1500, 0},
+		{"percentage rounds down", models.Coupon{Type: CouponTypePercentage, Value: 33}, 13000, 4290, 0},
+		{"fixed", models.Coupon{Type: CouponTypeFixed, Value: 2000}, 13000, 2000, 0},
+		{"fixed never more than the items", models.Coupon{Type: CouponTypeFixed, Value: 5000, StoreID: &otherStore}, 3000, 3000, 0},
+		{"store scope", models.Coupon{Type: CouponTypePercentage, Value: 10, StoreID: &store}, 10000, 1000, 0},
+		{"category scope", models.Coupon{Type: CouponTypePercentage, Value: 10, Category: &phones}, 10000, 1000, 0},
+		{"product scope", models.Coupon{Type: CouponTypeFixed, Value: 500, ProductIDs: pq.StringArray{items[1].ProductID.String()}}, 3000, 500, 0},
+		{"free shipping", models.Coupon{Type: CouponTypeFreeShipping}, 13000, 0, 800},
+	}
+	for _, tc := range cases {
+		got := discountFor(tc.coupon, items, 800)
+		if got.SubtotalCents != 13000 || got.EligibleSubtotalCents != tc.eligible ||
+			got.DiscountCents != tc.discount || got.ShippingDiscountCents != tc.shippingOff {
+			t.Errorf("%s: subtotal %d, eligible %d, discount %d, shipping off %d; want 13000, %d, %d, %d",
+				tc.name, got.SubtotalCents, got.EligibleSubtotalCents, got.DiscountCents, got.ShippingDiscountCents,
+				tc.eligible, tc.discount, tc.shippingOff)
+		}
+	}
+}
+
+func openCouponDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t, append(orderTables, &models.Coupon{}, &models.OrderDiscount{}, &models.CouponRedemption{},
+		&models.Address{}, &models.Favorite{}, &models.Review{}, &models.StoreStaff{}, &models.APIKey{},
+		&models.RecoveryCode{}, &models.PhoneOTP{}, &models.StoreInvitation{}, &models.IdempotencyKey{},
+		&models.DataRequest{})...)
+}
+
+func newCoupon(t *testing.T, db *gorm.DB, code string, usageLimit, perUserLimit *int) models.Coupon {
+	t.Helper()
+	coupon := models.Coupon{Code: code, Type: CouponTypeFixed, Value: 500, UsageLimit: usageLimit, PerUserLimit: perUserLimit, IsActive: true}
+	mustCreate(t, db, &coupon)
+	return coupon
+}
+
+// redeem checks a coupon out for buyer like checkout does, leaving the order
+// in status
+func redeem(t *testing.T, db *gorm.DB, buyer models.User, code, status string) error {
+	t.Helper()
+	coupons := NewCouponService(db)
+	items := []CouponItem{{ProductID: uuid.New(), StoreID: uuid.New(), Quantity: 1, UnitPriceCents: 10000}}
+	return db.Transaction(func(tx *gorm.DB) error {
+		quote, err := coupons.Apply(tx, code, buyer, items, 0)
+		if err != nil {
+			return err
+		}
+		order := newOrder(t, tx, buyer.ID, status, 1)
+		return coupons.Record(tx, order.ID, buyer, quote)
+	})
+}
+
+func TestCouponQuoteChecks(t *testing.T) {
+	db := openCouponDB(t)
+	buyer := newBuyer(t, db)
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	coupons := []models.Coupon{
+		{Code: "OFF", Type: CouponTypeFixed, Value: 500},
+		{Code: "LATER", Type: CouponTypeFixed, Value: 500, IsActive: true, StartsAt: &future},
+		{Code: "OVER", Type: CouponTypeFixed, Value: 500, IsActive: true, EndsAt: &past},
+		{Code: "BIGSPEND", Type: CouponTypeFixed, Value: 500, IsActive: true, MinSpendCents: 20000},
+		{Code: "OTHERSTORE", Type: CouponTypeFixed, Value: 500, IsActive: true, StoreID: &buyer.ID},
+		{Code: "GOOD", Type: CouponTypeFixed, Value: 500, IsActive: true},
+	}
+	for i := range coupons {
+		mustCreate(t, db, &coupons[i])
+	}
+	// GORM skips zero values with defaults, so switch the first one off by hand
+	db.Model(&coupons[0]).Update("is_active", false)
+
+	cases := []struct {
+		code string
+		err  error
+	}{
+		{"NOPE", ErrCouponNotFound},
+		{"OFF", ErrCouponInactive},
+		{"LATER", ErrCouponNotStarted},
+		{"OVER", ErrCouponExpired},
+		{"BIGSPEND", ErrCouponMinSpend},
+		{"OTHERSTORE", ErrCouponNotApplicable},
+		{" good ", nil},
+	}
+	items := []CouponItem{{ProductID: uuid.New(), StoreID: uuid.New(), Quantity: 1, UnitPriceCents: 10000}}
+	for _, tc := range cases {
+		_, err := NewCouponService(db).Preview(tc.code, buyer, items, 0)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%q: err = %v; want %v", tc.code, err, tc.err)
+		}
+	}
+}
+
+func TestCouponUsageLimits(t *testing.T) {
+	db := openCouponDB(t)
+	one, two := 1, 2
+	newCoupon(t, db, "ONCE", nil, &one)
+	newCoupon(t, db, "TWICE", &two, nil)
+	alice := newBuyer(t, db)
+	bob := newBuyer(t, db)
+	carol := newBuyer(t, db)
+
+	if err := redeem(t, db, alice, "ONCE", "paid"); err != nil {
+		t.Fatalf("first redemption: %v", err)
+	}
+	if err := redeem(t, db, alice, "ONCE", "paid"); !errors.Is(err, ErrCouponPerUserLimit) {
+		t.Errorf("second redemption by the same buyer = %v; want ErrCouponPerUserLimit", err)
+	}
+	if err := redeem(t, db, bob, "ONCE", "paid"); err != nil {
+		t.Errorf("another buyer: %v", err)
+	}
+
+	if err := redeem(t, db, alice, "TWICE", "pending"); err != nil {
+		t.Fatal(err)
+	}
+	if err := redeem(t, db, bob, "TWICE", "delivered"); err != nil {
+		t.Fatal(err)
+	}
+	if err := redeem(t, db, carol, "TWICE", "paid"); !errors.Is(err, ErrCouponUsageLimit) {
+		t.Errorf("third redemption = %v; want ErrCouponUsageLimit", err)
+	}
+}
+
+func TestCouponRedemptionsReleasedByUnpaidOrders(t *testing.T) {
+	db := openCouponDB(t)
+	one := 1
+	newCoupon(t, db, "ONCE", &one, &one)
+	buyer := newBuyer(t, db)
+
+	for _, status := range []string{"cancelled", "failed"} {
+		if err := redeem(t, db, buyer, "ONCE", status); err != nil {
+			t.Fatalf("redeeming on a %s order: %v", status, err)
+		}
+	}
+
+	// An abandoned checkout stops holding the coupon after the hold window
+	if err := redeem(t, db, buyer, "ONCE", "pending"); err != nil {
+		t.Fatal(err)
+	}
+	if err := redeem(t, db, buyer, "ONCE", "pending"); !errors.Is(err, ErrCouponUsageLimit) {
+		t.Fatalf("while the pending order holds it = %v; want ErrCouponUsageLimit", err)
+	}
+	db.Model(&models.Order{}).Where("status = ?", "pending").
+		Update("created_at", time.Now().Add(-couponUnpaidHoldWindow-time.Minute))
+	if err := redeem(t, db, buyer, "ONCE", "pending"); err != nil {
+		t.Errorf("after the hold window: %v", err)
+	}
+}
+
+func TestCouponPerUserLimitSurvivesAccountDeletion(t *testing.T) {
+	db := openCouponDB(t)
+	one := 1
+
+	email := "buyer@example.com"
+	phone := "254700000001"
+	cases := []struct {
+		name  string
+		code  string
+		again models.User
+	}{
+		{"same email", "WELCOME", models.User{Name: "Again", Email: strPtr("Buyer@Example.com")}},
+		{"same phone", "HELLO", models.User{Name: "Again", Phone: &phone}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			newCoupon(t, db, tc.code, nil, &one)
+			first := models.User{Name: "Buyer", Email: &email, Phone: &phone}
+			mustCreate(t, db, &first)
+			if err := redeem(t, db, first, tc.code, "paid"); err != nil {
+				t.Fatal(err)
+			}
+			if err := NewAccountDataService(db).DeleteAccount(first); err != nil {
+				t.Fatal(err)
+			}
+
+			again := tc.again
+			mustCreate(t, db, &again)
+			if err := redeem(t, db, again, tc.code, "paid"); !errors.Is(err, ErrCouponPerUserLimit) {
+				t.Errorf("redeeming from a new account = %v; want ErrCouponPerUserLimit", err)
+			}
+			if err := redeem(t, db, newBuyer(t, db), tc.code, "paid"); err != nil {
+				t.Errorf("a different buyer: %v", err)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string { return &s }
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // containers often ship without a zoneinfo database
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+// DefaultOrderCutoff applies to stores without a valid cut-off time
+const DefaultOrderCutoff = "14:00"
+
+// maxCalendarDays bounds the search for working days, so a misconfigured
+// calendar (e.g. every day a holiday) cannot loop forever
+const maxCalendarDays = 366
+
+var ErrInvalidCutoff = errors.New("order cut-off must be a time like 14:00")
+
+// DeliveryWindow is when an order placed now should arrive
+type DeliveryWindow struct {
+	Earliest   time.Time `json:"earliest"`
+	Latest     time.Time `json:"latest"`
+	DispatchBy time.Time `json:"dispatch_by"`
+	// OrderBy is today's cut-off, when ordering now still counts as today
+	OrderBy *time.Time `json:"order_by,omitempty"`
+}
+
+// DeliveryCalendar turns handling and transit days into calendar dates, skipping
+// weekends and holidays
+type DeliveryCalendar struct {
+	db  *gorm.DB
+	loc *time.Location
+}
+
+func NewDeliveryCalendar(db *gorm.DB) *DeliveryCalendar {
+	return &DeliveryCalendar{db: db, loc: deliveryLocation()}
+}
+
+// deliveryLocation is the time zone cut-offs and dates are reckoned in
+func deliveryLocation() *time.Location {
+	name := os.Getenv("DELIVERY_TIMEZONE")
+	if name == "" {
+		name = "Africa/Nairobi"
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Delivery calendar: unknown time zone %q, using EAT: %v", name, err)
+		return time.FixedZone("EAT", 3*60*60)
+	}
+	return loc
+}
+
+// ParseOrderCutoff validates an HH:MM cut-off and returns hour and minute
+func ParseOrderCutoff(cutoff string) (int, int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(cutoff))
+	if err != nil {
+		return 0, 0, ErrInvalidCutoff
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// holidaySet answers whether a day is a holiday. A holiday on a Sunday is also
+// observed on the Monday after, as in Kenya.
+type holidaySet struct {
+	dates  map[string]bool // 2006-01-02
+	annual map[string]bool // 01-02
+}
+
+func (h holidaySet) on(day time.Time) bool {
+	return h.dates[day.Format("2006-01-02")] || h.annual[day.Format("01-02")]
+}
+
+func (h holidaySet) isHoliday(day time.Time) bool {
+	if h.on(day) {
+		return true
+	}
+	return day.Weekday() == time.Monday && h.on(day.AddDate(0, 0, -1))
+}
+
+func (c *DeliveryCalendar) holidays(from, to time.Time) (holidaySet, error) {
+	var rows []models.DeliveryHoliday
+	err := c.db.Where("recurring = ? OR date BETWEEN ? AND ?", true,
+		from.AddDate(0, 0, -1).Format("2006-01-02"), to.Format("2006-01-02")).
+		Find(&rows).Error
+	if err != nil {
+		return holidaySet{}, err
+	}
+	set := holidaySet{dates: map[string]bool{}, annual: map[string]bool{}}
+	for _, h := range rows {
+		if h.Recurring {
+			set.annual[h.Date.Format("01-02")] = true
+		} else {
+			set.dates[h.Date.Format("2006-01-02")] = true
+		}
+	}
+	return set, nil
+}
+
+// Estimate dates an order placed at orderedAt. The store needs HandlingDays of
+// its working days to dispatch, counting the order day only if the order beat
+// the cut-off; the courier then takes daysMin to daysMax of its delivery days.
+func (c *DeliveryCalendar) Estimate(store models.Store, method models.ShippingMethod, daysMin, daysMax int, orderedAt time.Time) (*DeliveryWindow, error) {
+	now := orderedAt.In(c.loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, c.loc)
+
+	holidays, err := c.holidays(today, today.AddDate(0, 0, store.HandlingDays+daysMax+30))
+	if err != nil {
+		return nil, err
+	}
+	storeWorks := func(day time.Time) bool {
+		switch day.Weekday() {
+		case time.Sunday:
+			return false
+		case time.Saturday:
+			if !store.ShipsSaturday {
+				return false
+			}
+		}
+		return !holidays.isHoliday(day)
+	}
+	courierWorks := func(day time.Time) bool {
+		switch day.Weekday() {
+		case time.Sunday:
+			if !method.DeliversSunday {
+				return false
+			}
+		case time.Saturday:
+			if !method.DeliversSaturday {
+				return false
+			}
+		}
+		return !holidays.isHoliday(day)
+	}
+
+	hour, minute, err := ParseOrderCutoff(store.OrderCutoff)
+	if err != nil {
+		hour, minute, _ = ParseOrderCutoff(DefaultOrderCutoff)
+	}
+	cutoff := time.Date(today.Year(), today.Month(), today.Day(), hour, minute, 0, 0, c.loc)
+
+	window := &DeliveryWindow{}
+	orderDay := today
+	if storeWorks(today) && now.Before(cutoff) {
+		window.OrderBy = &cutoff
+	} else if orderDay, err = nextWorkingDay(today, storeWorks); err != nil {
+		return nil, err
+	}
+
+	if window.DispatchBy, err = addWorkingDays(orderDay, store.HandlingDays, storeWorks); err != nil {
+		return nil, err
+	}
+	if window.Earliest, err = addWorkingDays(window.DispatchBy, daysMin, courierWorks); err != nil {
+		return nil, err
+	}
+	if window.Latest, err = addWorkingDays(window.DispatchBy, daysMax, courierWorks); err != nil {
+		return nil, err
+	}
+	return window, nil
+}
+
+// EstimateDelivery dates a quote for a store and shipping method placed now
+func (s *ShippingService) EstimateDelivery(storeID uuid.UUID, methodCode string, daysMin, daysMax int) (*DeliveryWindow, error) {
+	var store models.Store
+	if err := s.db.First(&store, "id = ?", storeID).Error; err != nil {
+		return nil, fmt.Errorf("store not found: %w", err)
+	}
+	var method models.ShippingMethod
+	if err := s.db.First(&method, "code = ?", methodCode).Error; err != nil {
+		return nil, fmt.Errorf("shipping method not found: %w", err)
+	}
+	return NewDeliveryCalendar(s.db).Estimate(store, method, daysMin, daysMax, time.Now())
+}
+
+func nextWorkingDay(day time.Time, works func(time.Time) bool) (time.Time, error) {
+	for i := 0; i < maxCalendarDays; i++ {
+		day = day.AddDate(0, 0, 1)
+		if works(day) {
+			return day, nil
+		}
+	}
+	return time.Time{}, errors.New("delivery calendar has no working days")
+}
+
+// addWorkingDays moves n working days past day. With n = 0 it returns day
+// itself if that is a working day, else the next one.
+func addWorkingDays(day time.Time, n int, works func(time.Time) bool) (time.Time, error) {
+	var err error
+	if n <= 0 {
+		if works(day) {
+			return day, nil
+		}
+		return nextWorkingDay(day, works)
+	}
+	for ; n > 0; n-- {
+		if day, err = nextWorkingDay(day, works); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return day, nil
+}
+
+// ListHolidays returns configured holidays, recurring ones first
+func (c *DeliveryCalendar) ListHolidays() ([]models.DeliveryHoliday, error) {
+	var holidays []models.DeliveryHoliday
+	if err := c.db.Order("recurring DESC, date ASC").Find(&holidays).Error; err != nil {
+		return nil, err
+	}
+	return holidays, nil
+}
+
+// AddHoliday adds a non-delivery day
+func (c *DeliveryCalendar) AddHoliday(name string, date time.Time, recurring bool) (*models.DeliveryHoliday, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("holiday name is required")
+	}
+	holiday := models.DeliveryHoliday{Name: name, Date: date, Recurring: recurring}
+	if err := c.db.Create(&holiday).Error; err != nil {
+		return nil, err
+	}
+	return &holiday, nil
+}
+
+// DeleteHoliday removes a holiday, reporting gorm.ErrRecordNotFound if absent
+func (c *DeliveryCalendar) DeleteHoliday(id uuid.UUID) error {
+	res := c.db.Delete(&models.DeliveryHoliday{}, "id = ?", id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+func TestParseOrderCutoff(t *testing.T) {
+	cases := []struct {
+		cutoff       string
+		hour, minute int
+		err          error
+	}{
+		{"14:00", 14, 0, nil},
+		{" 09:30 ", 9, 30, nil},
+		{"23:59", 23, 59, nil},
+		{"24:00", 0, 0, ErrInvalidCutoff},
+		{"14:60", 0, 0, ErrInvalidCutoff},
+		{"noon", 0, 0, ErrInvalidCutoff},
+		{"", 0, 0, ErrInvalidCutoff},
+	}
+	for _, tc := range cases {
+		hour, minute, err := ParseOrderCutoff(tc.cutoff)
+		if !errors.Is(err, tc.err) || hour != tc.hour || minute != tc.minute {
+			t.Errorf("%q = %d:%d, %v; want %d:%d, %v", tc.cutoff, hour, minute, err, tc.hour, tc.minute, tc.err)
+		}
+	}
+}
+
+// openCalendarDB has a one-off holiday on Tuesday 17 November 2026 and an
+// annual one on 22 November, a Sunday that year, so observed on Monday 23rd
+func openCalendarDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	t.Setenv("DELIVERY_TIMEZONE", "Africa/Nairobi")
+	db := openShippingDB(t)
+	calendar := NewDeliveryCalendar(db)
+	if _, err := calendar.AddHoliday("Founders' Day", time.Date(2026, 11, 17, 0, 0, 0, 0, time.UTC), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := calendar.AddHoliday("Harvest Day", time.Date(2020, 11, 22, 0, 0, 0, 0, time.UTC), true); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestDeliveryEstimate(t *testing.T) {
+	calendar := NewDeliveryCalendar(openCalendarDB(t))
+	nairobi, _ := time.LoadLocation("Africa/Nairobi")
+
+	cases := []struct {
+		name                       string
+		at                         string // in Nairobi; 2 November 2026 is a Monday
+		store                      models.Store
+		method                     models.ShippingMethod
+		dispatch, earliest, latest string
+		orderBy                    bool
+	}{
+		{"before the cut-off", "2026-11-02 10:00", models.Store{HandlingDays: 1, OrderCutoff: "14:00"}, models.ShippingMethod{},
+			"2026-11-03", "2026-11-04", "2026-11-06", true},
+		{"after the cut-off", "2026-11-02 15:00", models.Store{HandlingDays: 1, OrderCutoff: "14:00"}, models.ShippingMethod{},
+			"2026-11-04", "2026-11-05", "2026-11-09", false},
+		{"earlier cut-off", "2026-11-02 13:00", models.Store{HandlingDays: 1, OrderCutoff: "12:00"}, models.ShippingMethod{},
+			"2026-11-04", "2026-11-05", "2026-11-09", false},
+		{"invalid cut-off uses the default", "2026-11-02 13:00", models.Store{HandlingDays: 1, OrderCutoff: "noon"}, models.ShippingMethod{},
+			"2026-11-03", "2026-11-04", "2026-11-06", true},
+		{"same-day dispatch", "2026-11-02 10:00", models.Store{HandlingDays: 0, OrderCutoff: "14:00"}, models.ShippingMethod{},
+			"2026-11-02", "2026-11-03", "2026-11-05", true},
+		{"friday evening", "2026-11-06 15:00", models.Store{HandlingDays: 1, OrderCutoff: "14:00"}, models.ShippingMethod{},
+			"2026-11-10", "2026-11-11", "2026-11-13", false},
+		{"saturday", "2026-11-07 10:00", models.Store{HandlingDays: 1, OrderCutoff: "14:00"}, models.ShippingMethod{},
+			"2026-11-10", "2026-11-11", "2026-11-13", false},
+		{"store ships on saturday", "2026-11-07 10:00", models.Store{HandlingDays: 1, OrderCutoff: "14:00", ShipsSaturday: true}, models.ShippingMethod{},
+			"2026-11-09", "2026-11-10", "2026-11-12", true},
+		{"courier delivers on saturday", "2026-11-05 10:00", models.Store{HandlingDays: 1, OrderCutoff: "14:00"}, models.ShippingMethod{DeliversSaturday: true},
+			"2026-11-06", "2026-11-07", "2026-11-10", true},
+		{"courier delivers all week", "2026-11-05 10:00", models.Store{HandlingDays: 1, OrderCutoff: "14:00"}, models.ShippingMethod{DeliversSaturday: true, DeliversSunday: true},
+			"2026-11-06", "2026-11-07", "2026-11-09", true},
+		{"holiday", "2026-11-16 10:00", models.Store{HandlingDays: 1, OrderCutoff: "14:00"}, models.ShippingMethod{},
+			"2026-11-18", "2026-11-19", "2026-11-24", true},
+		{"sunday holiday observed on monday", "2026-11-20 15:00", models.Store{HandlingDays: 1, OrderCutoff: "14:00"}, models.ShippingMethod{},
+			"2026-11-25", "2026-11-26", "2026-11-30", false},
+	}
+	for _, tc := range cases {
+		at, err := time.ParseInLocation("2006-01-02 15:04", tc.at, nairobi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		window, err := calendar.Estimate(tc.store, tc.method, 1, 3, at)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		day := func(d time.Time) string { return d.Format("2006-01-02") }
+		if day(window.DispatchBy) != tc.dispatch || day(window.Earliest) != tc.earliest || day(window.Latest) != tc.latest {
+			t.Errorf("%s: dispatch %s, arrives %s to %s; want %s, %s to %s", tc.name,
+				day(window.DispatchBy), day(window.Earliest), day(window.Latest), tc.dispatch, tc.earliest, tc.latest)
+		}
+		if (window.OrderBy != nil) != tc.orderBy {
+			t.Errorf("%s: order by %v; want one = %v", tc.name, window.OrderBy, tc.orderBy)
+		}
+	}
+}
+
+func TestDeliveryHolidays(t *testing.T) {
+	calendar := NewDeliveryCalendar(openCalendarDB(t))
+
+	if _, err := calendar.AddHoliday("  ", time.Now(), false); err == nil {
+		t.Error("holiday without a name was added")
+	}
+	holidays, err := calendar.ListHolidays()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holidays) != 2 || !holidays[0].Recurring {
+		t.Fatalf("holidays = %+v; want two, the annual one first", holidays)
+	}
+	if err := calendar.DeleteHoliday(holidays[0].ID); err != nil {
+		t.Error(err)
+	}
+	if err := calendar.DeleteHoliday(uuid.New()); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("deleting a missing holiday: err = %v; want gorm.ErrRecordNotFound", err)
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/geo"
+	"trumall/internal/models"
+)
+
+// Pricing bases reported on a ShippingCalculation
+const (
+	PricingBasisZone     = "zone"
+	PricingBasisDistance = "distance"
+)
+
+// LocalDeliveryRadiusKm is how close a warehouse must be to get the method's fastest delivery
+const LocalDeliveryRadiusKm = 15.0
+
+var ErrOutOfDeliveryRange = errors.New("address is outside the delivery range for this shipping method")
+
+// findDistanceBand returns the method's rate band for the warehouse-to-address
+// distance. It returns no band (and no error) when either end lacks coordinates or
+// the method has no distance rate table, so callers fall back to zone pricing.
+func (s *ShippingService) findDistanceBand(method models.ShippingMethod, store models.Store, dest models.Address) (*models.ShippingDistanceBand, *float64, error) {
+	distanceKm := warehouseDistanceKm(store, dest)
+	if distanceKm == nil {
+		return nil, nil, nil
+	}
+	km := *distanceKm
+
+	bands, err := s.ListDistanceBands(method.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(bands) == 0 {
+		return nil, &km, nil
+	}
+
+	for i := range bands {
+		if km >= bands[i].MinKm && (bands[i].MaxKm == nil || km < *bands[i].MaxKm) {
+			return &bands[i], &km, nil
+		}
+	}
+	return nil, &km, fmt.Errorf("%w: %s delivers up to %.0f km, this address is %.1f km away",
+		ErrOutOfDeliveryRange, method.Name, *bands[len(bands)-1].MaxKm, km)
+}
+
+// warehouseDistanceKm is the straight-line distance from the store's warehouse to
+// an address, to 0.1 km, or nil when either end lacks coordinates
+func warehouseDistanceKm(store models.Store, dest models.Address) *float64 {
+	if !geo.HasCoordinates(store.WarehouseLatitude, store.WarehouseLongitude) ||
+		!geo.HasCoordinates(dest.Latitude, dest.Longitude) {
+		return nil
+	}
+	km := geo.DistanceKm(store.WarehouseLatitude, store.WarehouseLongitude, dest.Latitude, dest.Longitude)
+	km = math.Round(km*10) / 10
+	return &km
+}
+
+// ListDistanceBands returns a method's distance rate table, nearest band first
+func (s *ShippingService) ListDistanceBands(methodID uuid.UUID) ([]models.ShippingDistanceBand, error) {
+	var bands []models.ShippingDistanceBand
+	if err := s.db.Where("shipping_method_id = ?", methodID).Order("min_km ASC").Find(&bands).Error; err != nil {
+		return nil, err
+	}
+	return bands, nil
+}
+
+// ReplaceDistanceBands validates and atomically swaps a method's distance rate table.
+// An empty table turns distance pricing off for the method.
+func (s *ShippingService) ReplaceDistanceBands(methodID uuid.UUID, bands []models.ShippingDistanceBand) ([]models.ShippingDistanceBand, error) {
+	if err := ValidateDistanceBands(bands); err != nil {
+		return nil, err
+	}
+
+	err := ShippingTransaction(s.db, func(tx *gorm.DB) error {
+		var method models.ShippingMethod
+		if err := tx.First(&method, "id = ?", methodID).Error; err != nil {
+			return err
+		}
+		return replaceBands(tx, methodID, bands)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bands, nil
+}
+
+// ValidateDistanceBands checks that bands are ordered, start at 0 km and leave no
+// gaps or overlaps; only the last band may be open-ended
+func ValidateDistanceBands(bands []models.ShippingDistanceBand) error {
+	for i, band := range bands {
+		if band.CostCents < 0 {
+			return fmt.Errorf("band %d: cost_cents cannot be negative", i)
+		}
+		if band.MaxKm != nil && *band.MaxKm <= band.MinKm {
+			return fmt.Errorf("band %d: max_km must be greater than min_km", i)
+		}
+		if i == 0 {
+			if band.MinKm != 0 {
+				return errors.New("the first band must start at 0 km")
+			}
+			continue
+		}
+		prev := bands[i-1]
+		if prev.MaxKm == nil {
+			return fmt.Errorf("band %d: only the last band may omit max_km", i-1)
+		}
+		if band.MinKm != *prev.MaxKm {
+			return fmt.Errorf("band %d: min_km must equal the previous band's max_km (%.1f)", i, *prev.MaxKm)
+		}
+	}
+	return nil
+}
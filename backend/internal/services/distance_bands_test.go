@@ -0,0 +1,94 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+func km(v float64) *float64 { return &v }
+
+func TestValidateDistanceBands(t *testing.T) {
+	cases := []struct {
+		name  string
+		bands []models.ShippingDistanceBand
+		ok    bool
+	}{
+		{"none", nil, true},
+		{"open-ended", []models.ShippingDistanceBand{{MinKm: 0, CostCents: 300}}, true},
+		{"contiguous", []models.ShippingDistanceBand{{MinKm: 0, MaxKm: km(10), CostCents: 300}, {MinKm: 10, MaxKm: km(50), CostCents: 700}, {MinKm: 50, CostCents: 1500}}, true},
+		{"not from zero", []models.ShippingDistanceBand{{MinKm: 5, MaxKm: km(10)}}, false},
+		{"gap", []models.ShippingDistanceBand{{MinKm: 0, MaxKm: km(10)}, {MinKm: 12, MaxKm: km(50)}}, false},
+		{"overlap", []models.ShippingDistanceBand{{MinKm: 0, MaxKm: km(10)}, {MinKm: 8, MaxKm: km(50)}}, false},
+		{"open-ended in the middle", []models.ShippingDistanceBand{{MinKm: 0}, {MinKm: 10, MaxKm: km(50)}}, false},
+		{"empty band", []models.ShippingDistanceBand{{MinKm: 0, MaxKm: km(0)}}, false},
+		{"negative cost", []models.ShippingDistanceBand{{MinKm: 0, CostCents: -1}}, false},
+	}
+	for _, tc := range cases {
+		if err := ValidateDistanceBands(tc.bands); (err == nil) != tc.ok {
+			t.Errorf("%s: err = %v; want ok = %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+// Nairobi CBD; a tenth of a degree of latitude is about 11 km
+const warehouseLat, warehouseLng = -1.2864, 36.8172
+
+func addressAt(t *testing.T, db *gorm.DB, lat, lng float64) models.Address {
+	t.Helper()
+	address := models.Address{UserID: uuid.New(), Street: "1 Main Street", City: "Nairobi", Country: "Kenya", Latitude: lat, Longitude: lng}
+	mustCreate(t, db, &address)
+	return address
+}
+
+func TestQuoteByDistance(t *testing.T) {
+	db := openShippingDB(t)
+	store := newShippingStore(t, db)
+	db.Model(&store).Updates(map[string]any{"warehouse_city": "Thika", "warehouse_latitude": warehouseLat, "warehouse_longitude": warehouseLng})
+	newCountryZone(t, db, "Kenya", 200)
+	method := newMethod(t, db, "standard", 500)
+	if _, err := NewShippingService(db).ReplaceDistanceBands(method.ID, []models.ShippingDistanceBand{
+		{MinKm: 0, MaxKm: km(10), CostCents: 300},
+		{MinKm: 10, MaxKm: km(50), CostCents: 700},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		address models.Address
+		basis   string
+		cost    int64
+		daysMax int
+		err     error
+	}{
+		{"first band", addressAt(t, db, warehouseLat+0.05, warehouseLng), PricingBasisDistance, 300, 1, nil},
+		{"second band", addressAt(t, db, warehouseLat+0.2, warehouseLng), PricingBasisDistance, 700, 3, nil},
+		{"beyond the last band", addressAt(t, db, warehouseLat+1, warehouseLng), "", 0, 0, ErrOutOfDeliveryRange},
+		{"no coordinates", newAddress(t, db, "Nairobi", "Kenya"), PricingBasisZone, 700, 3, nil},
+	}
+	for _, tc := range cases {
+		calc, err := quoteTo(db, store, tc.address, "standard", 10000, Parcel{})
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if calc.PricingBasis != tc.basis || calc.ShippingCostCents != tc.cost || calc.DeliveryDaysMax != tc.daysMax {
+			t.Errorf("%s: basis %s, cost %d, up to %d days; want %s, %d, %d", tc.name,
+				calc.PricingBasis, calc.ShippingCostCents, calc.DeliveryDaysMax, tc.basis, tc.cost, tc.daysMax)
+		}
+		if tc.basis == PricingBasisDistance {
+			lines := quoteLines(calc)
+			if lines[QuoteStepBase] != 0 || lines[QuoteStepZone] != 0 || lines[QuoteStepDistance] != tc.cost {
+				t.Errorf("%s: counted lines %v; want only the distance band", tc.name, lines)
+			}
+		}
+	}
+}
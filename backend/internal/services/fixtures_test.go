@@ -0,0 +1,147 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/cache"
+	"trumall/internal/models"
+	"trumall/internal/testdb"
+)
+
+// orderTables are the tables checkout and payment tests touch
+var orderTables = []any{
+	&models.User{}, &models.Store{}, &models.Product{}, &models.ProductSale{}, &models.CartItem{},
+	&models.Order{}, &models.OrderItem{}, &models.Payment{}, &models.OutboxMessage{},
+}
+
+func openOrderDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t, orderTables...)
+}
+
+func mustCreate(t *testing.T, db *gorm.DB, value any) {
+	t.Helper()
+	if err := db.Create(value).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newBuyer(t *testing.T, db *gorm.DB) models.User {
+	t.Helper()
+	user := models.User{Name: "Buyer"}
+	mustCreate(t, db, &user)
+	return user
+}
+
+func newProduct(t *testing.T, db *gorm.DB, stock int, priceCents int64) models.Product {
+	t.Helper()
+	product := models.Product{StoreID: uuid.New(), Title: "Product", PriceCents: priceCents, Stock: stock}
+	mustCreate(t, db, &product)
+	return product
+}
+
+// newOrder creates an order for buyer holding quantity of each product
+func newOrder(t *testing.T, db *gorm.DB, buyerID uuid.UUID, status string, quantity int, products ...models.Product) models.Order {
+	t.Helper()
+	order := models.Order{BuyerID: buyerID, StoreID: uuid.New(), Status: status, TotalCents: 10000, Currency: "KES"}
+	mustCreate(t, db, &order)
+	for _, p := range products {
+		mustCreate(t, db, &models.OrderItem{OrderID: order.ID, ProductID: p.ID, Quantity: quantity, UnitPriceCents: p.PriceCents})
+	}
+	return order
+}
+
+func newPayment(t *testing.T, db *gorm.DB, orderID uuid.UUID, status, phone string) models.Payment {
+	t.Helper()
+	payment := models.Payment{OrderID: orderID, Provider: "M-Pesa", AmountCents: 10000, Currency: "KES", Status: status, Phone: &phone}
+	mustCreate(t, db, &payment)
+	return payment
+}
+
+func cartOf(userID uuid.UUID, quantity int, products ...models.Product) []models.CartItem {
+	cart := make([]models.CartItem, 0, len(products))
+	for _, p := range products {
+		cart = append(cart, models.CartItem{UserID: &userID, ProductID: p.ID, Quantity: quantity, Price: p.PriceCents, Product: p})
+	}
+	return cart
+}
+
+func statusOf[T any](t *testing.T, db *gorm.DB, id uuid.UUID) string {
+	t.Helper()
+	var status string
+	if err := db.Model(new(T)).Where("id = ?", id).Pluck("status", &status).Error; err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+// shippingTables are the tables quoting reads
+var shippingTables = []any{
+	&models.Store{}, &models.Address{}, &models.ShippingMethod{}, &models.ShippingZone{}, &models.ShippingRule{},
+	&models.ShippingDistanceBand{}, &models.StoreShippingProfile{}, &models.StoreShippingRate{},
+	&models.DeliveryHoliday{}, &models.PickupPoint{}, &models.Order{}, &models.OrderItem{},
+}
+
+// openShippingDB also gives the test an empty quote cache of its own
+func openShippingDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	t.Setenv("SHIPPING_QUOTE_SECRET", "test-secret")
+	shared := ShippingCache
+	ShippingCache = cache.NewInMemoryCache[ShippingCalculation]("shipping")
+	t.Cleanup(func() { ShippingCache = shared })
+	return testdb.Open(t, shippingTables...)
+}
+
+// newShippingStore creates a store with a warehouse in Nairobi
+func newShippingStore(t *testing.T, db *gorm.DB) models.Store {
+	t.Helper()
+	store := models.Store{OwnerID: uuid.New(), Name: "Store", WarehouseCity: "Nairobi", WarehouseCountry: "Kenya",
+		HandlingDays: 1, OrderCutoff: DefaultOrderCutoff}
+	mustCreate(t, db, &store)
+	return store
+}
+
+func newAddress(t *testing.T, db *gorm.DB, city, country string) models.Address {
+	t.Helper()
+	address := models.Address{UserID: uuid.New(), Street: "1 Main Street", City: city, Country: country}
+	mustCreate(t, db, &address)
+	return address
+}
+
+// newMethod creates an active delivery method with a flat cost
+func newMethod(t *testing.T, db *gorm.DB, code string, baseCents int64) models.ShippingMethod {
+	t.Helper()
+	method := models.ShippingMethod{Name: code, Code: code, Type: ShippingTypeDelivery, BaseCostCents: baseCents,
+		DeliveryDaysMin: 1, DeliveryDaysMax: 3, IsActive: true}
+	mustCreate(t, db, &method)
+	return method
+}
+
+// newCountryZone creates a country-wide zone with a surcharge
+func newCountryZone(t *testing.T, db *gorm.DB, country string, costCents int64) models.ShippingZone {
+	t.Helper()
+	zone := models.ShippingZone{Name: country, Country: country, AdditionalCostCents: costCents, IsActive: true}
+	mustCreate(t, db, &zone)
+	return zone
+}
+
+func quoteTo(db *gorm.DB, store models.Store, address models.Address, method string, cartTotalCents int64, parcel Parcel) (*ShippingCalculation, error) {
+	return NewShippingService(db).Quote(QuoteRequest{
+		UserID: address.UserID, StoreID: store.ID, AddressID: address.ID,
+		MethodCode: method, CartTotalCents: cartTotalCents, Parcel: parcel,
+	})
+}
+
+// quoteLines sums a quote's counted lines by step
+func quoteLines(calc *ShippingCalculation) map[string]int64 {
+	lines := map[string]int64{}
+	for _, line := range calc.Breakdown {
+		if !line.Superseded {
+			lines[line.Step] += line.AmountCents
+		}
+	}
+	return lines
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+// GuestCartTTL is how long a guest cart lives after it was last changed
+const GuestCartTTL = 30 * 24 * time.Hour
+
+var (
+	ErrGuestCartInvalid = errors.New("invalid cart token")
+	ErrGuestCartExpired = errors.New("guest cart has expired")
+)
+
+// guestCartClaims is the signed content of a guest cart token
+type guestCartClaims struct {
+	CartID    uuid.UUID `json:"cid"`
+	ExpiresAt int64     `json:"exp"`
+}
+
+// guestCartSigningKey uses a dedicated secret when configured, else the JWT secret
+func guestCartSigningKey() []byte {
+	if secret := os.Getenv("GUEST_CART_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+func signGuestCartPayload(payload string) string {
+	mac := hmac.New(sha256.New, guestCartSigningKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+type GuestCartService struct {
+	db *gorm.DB
+}
+
+func NewGuestCartService(db *gorm.DB) *GuestCartService {
+	return &GuestCartService{db: db}
+}
+
+// Create starts a guest cart and returns it with its token
+func (s *GuestCartService) Create() (*models.GuestCart, string, error) {
+	cart := models.GuestCart{ID: uuid.New(), ExpiresAt: time.Now().Add(GuestCartTTL)}
+	if err := s.db.Create(&cart).Error; err != nil {
+		return nil, "", err
+	}
+	token, err := guestCartToken(cart)
+	if err != nil {
+		return nil, "", err
+	}
+	return &cart, token, nil
+}
+
+// PurgeExpired deletes expired guest carts, and their items with them
+func (s *GuestCartService) PurgeExpired() (int64, error) {
+	result := s.db.Where("expires_at < ?", time.Now()).Delete(&models.GuestCart{})
+	return result.RowsAffected, result.Error
+}
+
+// StartPurger deletes expired guest carts every interval until the process
+// exits
+func (s *GuestCartService) StartPurger(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			purged, err := s.PurgeExpired()
+			if err != nil {
+				log.Printf("Error purging expired guest carts: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Guest carts: %d expired carts deleted", purged)
+			}
+		}
+	}()
+}
+
+// Resolve returns the guest cart a token names
+func (s *GuestCartService) Resolve(token string) (*models.GuestCart, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrGuestCartInvalid
+	}
+	if !hmac.Equal([]byte(signGuestCartPayload(parts[0])), []byte(parts[1])) {
+		return nil, ErrGuestCartInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrGuestCartInvalid
+	}
+	var claims guestCartClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, ErrGuestCartInvalid
+	}
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, ErrGuestCartExpired
+	}
+
+	var cart models.GuestCart
+	if err := s.db.First(&cart, "id = ?", claims.CartID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrGuestCartExpired
+		}
+		return nil, err
+	}
+	if time.Now().After(cart.ExpiresAt) {
+		return nil, ErrGuestCartExpired
+	}
+	return &cart, nil
+}
+
+// Touch pushes a guest cart's expiry back after a change and returns a token
+// carrying the new expiry
+func (s *GuestCartService) Touch(cart *models.GuestCart) (string, error) {
+	cart.ExpiresAt = time.Now().Add(GuestCartTTL)
+	if err := s.db.Model(cart).Update("expires_at", cart.ExpiresAt).Error; err != nil {
+		return "", err
+	}
+	return guestCartToken(*cart)
+}
+
+// GuestCartMerge reports what signing in did with a guest cart
+type GuestCartMerge struct {
+	MergedItems int         `json:"merged_items"`
+	Capped      []uuid.UUID `json:"capped_product_ids,omitempty"`  // quantities cut down to the stock left or order limit
+	Dropped     []uuid.UUID `json:"dropped_product_ids,omitempty"` // out of stock
+}
+
+// Merge moves a guest cart's items into a user's cart, adding quantities to
+// items the user already has and capping them at the stock left and the
+// product's order limit, then deletes the guest cart
+func (s *GuestCartService) Merge(cart *models.GuestCart, userID uuid.UUID) (*GuestCartMerge, error) {
+	result := &GuestCartMerge{}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var guestItems []models.CartItem
+		if err := tx.Preload("Product").Where("guest_cart_id = ?", cart.ID).Find(&guestItems).Error; err != nil {
+			return err
+		}
+
+		products := make([]models.Product, 0, len(guestItems))
+		for _, guest := range guestItems {
+			products = append(products, guest.Product)
+		}
+		limits, err := StockLimits(tx, products, &userID)
+		if err != nil {
+			return err
+		}
+
+		for _, guest := range guestItems {
+			var item models.CartItem
+			err := tx.Where("user_id = ? AND product_id = ?", userID, guest.ProductID).First(&item).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			exists := err == nil
+
+			quantity := item.Quantity + guest.Quantity
+			if most := limits[guest.ProductID].Max(); quantity > most {
+				quantity = most
+				if quantity > item.Quantity {
+					result.Capped = append(result.Capped, guest.ProductID)
+				} else {
+					result.Dropped = append(result.Dropped, guest.ProductID)
+					continue
+				}
+			}
+
+			if exists {
+				// Saved for later only if both carts had it saved
+				updates := map[string]interface{}{"quantity": quantity, "saved_for_later": item.SavedForLater && guest.SavedForLater}
+				if err := tx.Model(&item).Updates(updates).Error; err != nil {
+					return err
+				}
+			} else {
+				item = models.CartItem{
+					UserID:        &userID,
+					ProductID:     guest.ProductID,
+					Quantity:      quantity,
+					Price:         guest.Price,
+					SavedForLater: guest.SavedForLater,
+				}
+				if err := tx.Create(&item).Error; err != nil {
+					return err
+				}
+			}
+			result.MergedItems++
+		}
+
+		return tx.Delete(cart).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func guestCartToken(cart models.GuestCart) (string, error) {
+	raw, err := json.Marshal(guestCartClaims{CartID: cart.ID, ExpiresAt: cart.ExpiresAt.Unix()})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return payload + "." + signGuestCartPayload(payload), nil
+}
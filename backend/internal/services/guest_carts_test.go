@@ -0,0 +1,188 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/testdb"
+)
+
+func openGuestCartDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	t.Setenv("GUEST_CART_SECRET", "test-secret")
+	return testdb.Open(t, append(orderTables, &models.GuestCart{})...)
+}
+
+func TestGuestCartToken(t *testing.T) {
+	db := openGuestCartDB(t)
+	carts := NewGuestCartService(db)
+	cart, token, err := carts.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, signature, _ := strings.Cut(token, ".")
+	cases := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"valid", token, nil},
+		{"tampered signature", payload + "." + strings.Repeat("A", len(signature)), ErrGuestCartInvalid},
+		{"no signature", payload, ErrGuestCartInvalid},
+		{"garbage", "not-a-token", ErrGuestCartInvalid},
+	}
+	for _, tc := range cases {
+		got, err := carts.Resolve(tc.token)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+		}
+		if err == nil && got.ID != cart.ID {
+			t.Errorf("%s: resolved cart %s; want %s", tc.name, got.ID, cart.ID)
+		}
+	}
+
+	// Signed with another secret
+	t.Setenv("GUEST_CART_SECRET", "other-secret")
+	if _, err := carts.Resolve(token); !errors.Is(err, ErrGuestCartInvalid) {
+		t.Errorf("token signed with another secret: err = %v; want ErrGuestCartInvalid", err)
+	}
+}
+
+func TestGuestCartExpiry(t *testing.T) {
+	db := openGuestCartDB(t)
+	carts := NewGuestCartService(db)
+	cart, token, _ := carts.Create()
+
+	// The cart's own expiry is what counts once the token checks out
+	db.Model(cart).Update("expires_at", time.Now().Add(-time.Minute))
+	if _, err := carts.Resolve(token); !errors.Is(err, ErrGuestCartExpired) {
+		t.Errorf("expired cart: err = %v; want ErrGuestCartExpired", err)
+	}
+
+	// Touching it gives it, and a new token, another TTL
+	fresh, err := carts.Touch(cart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := carts.Resolve(fresh); err != nil {
+		t.Errorf("touched cart: %v", err)
+	}
+}
+
+func TestGuestCartPurgeExpired(t *testing.T) {
+	db := openGuestCartDB(t)
+	carts := NewGuestCartService(db)
+	live, _, _ := carts.Create()
+	expired, _, _ := carts.Create()
+	db.Model(expired).Update("expires_at", time.Now().Add(-time.Minute))
+
+	purged, err := carts.PurgeExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("purged %d carts; want 1", purged)
+	}
+	var ids []uuid.UUID
+	db.Model(&models.GuestCart{}).Pluck("id", &ids)
+	if len(ids) != 1 || ids[0] != live.ID {
+		t.Errorf("carts left = %v; want only %s", ids, live.ID)
+	}
+}
+
+func TestGuestCartMerge(t *testing.T) {
+	db := openGuestCartDB(t)
+	buyer := newBuyer(t, db)
+	carts := NewGuestCartService(db)
+	guest, _, _ := carts.Create()
+
+	limited := newProduct(t, db, 10, 1000)
+	db.Model(&limited).Update("max_order_quantity", 3)
+
+	cases := []struct {
+		name    string
+		product models.Product
+		inCart  int // the buyer's own quantity; 0 for none
+		guest   int
+		want    int // 0 when it is not in the buyer's cart afterwards
+		capped  bool
+		dropped bool
+	}{
+		{"new to the buyer", newProduct(t, db, 10, 1000), 0, 2, 2, false, false},
+		{"added together", newProduct(t, db, 10, 1000), 1, 2, 3, false, false},
+		{"capped at stock", newProduct(t, db, 4, 1000), 2, 3, 4, true, false},
+		{"capped at the order limit", limited, 0, 5, 3, true, false},
+		{"already at the stock left", newProduct(t, db, 2, 1000), 2, 1, 2, false, true},
+		{"sold out", newProduct(t, db, 0, 1000), 0, 1, 0, false, true},
+	}
+	for _, tc := range cases {
+		if tc.inCart > 0 {
+			mustCreate(t, db, &models.CartItem{UserID: &buyer.ID, ProductID: tc.product.ID, Quantity: tc.inCart, Price: 1000})
+		}
+		mustCreate(t, db, &models.CartItem{GuestCartID: &guest.ID, ProductID: tc.product.ID, Quantity: tc.guest, Price: 1000})
+	}
+
+	merged, err := carts.Merge(guest, buyer.ID)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if merged.MergedItems != 4 {
+		t.Errorf("merged %d items; want 4", merged.MergedItems)
+	}
+	has := func(ids []uuid.UUID, id uuid.UUID) bool {
+		for _, x := range ids {
+			if x == id {
+				return true
+			}
+		}
+		return false
+	}
+	for _, tc := range cases {
+		var quantity int
+		db.Model(&models.CartItem{}).Where("user_id = ? AND product_id = ?", buyer.ID, tc.product.ID).Pluck("quantity", &quantity)
+		if quantity != tc.want {
+			t.Errorf("%s: quantity %d; want %d", tc.name, quantity, tc.want)
+		}
+		if got := has(merged.Capped, tc.product.ID); got != tc.capped {
+			t.Errorf("%s: reported capped = %v; want %v", tc.name, got, tc.capped)
+		}
+		if got := has(merged.Dropped, tc.product.ID); got != tc.dropped {
+			t.Errorf("%s: reported dropped = %v; want %v", tc.name, got, tc.dropped)
+		}
+	}
+
+	var left int64
+	db.Model(&models.GuestCart{}).Where("id = ?", guest.ID).Count(&left)
+	if left != 0 {
+		t.Error("the guest cart was not deleted")
+	}
+}
+
+func TestGuestCartMergeCountsOtherBuyersReservations(t *testing.T) {
+	db := openGuestCartDB(t)
+	buyer := newBuyer(t, db)
+	carts := NewGuestCartService(db)
+	guest, _, _ := carts.Create()
+
+	product := newProduct(t, db, 5, 1000)
+	// Another buyer's unpaid order holds three of the five
+	newOrder(t, db, newBuyer(t, db).ID, "pending", 3, product)
+	mustCreate(t, db, &models.CartItem{GuestCartID: &guest.ID, ProductID: product.ID, Quantity: 4, Price: 1000})
+
+	merged, err := carts.Merge(guest, buyer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var quantity int
+	db.Model(&models.CartItem{}).Where("user_id = ? AND product_id = ?", buyer.ID, product.ID).Pluck("quantity", &quantity)
+	if quantity != 2 || len(merged.Capped) != 1 {
+		t.Errorf("quantity %d, capped %v; want 2, capped", quantity, merged.Capped)
+	}
+}
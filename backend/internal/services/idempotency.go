@@ -0,0 +1,126 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"trumall/internal/models"
+)
+
+// IdempotencyKeyTTL is how long a key's response is kept for replay
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// idempotencyLease is how long a request has its key to itself. A key still in
+// flight after that (its server crashed, say) can be claimed by a retry.
+const idempotencyLease = 2 * time.Minute
+
+var (
+	ErrIdempotencyInFlight = errors.New("a request with this Idempotency-Key is still being processed")
+	ErrIdempotencyMismatch = errors.New("this Idempotency-Key was already used for a different request")
+)
+
+type IdempotencyService struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyService(db *gorm.DB) *IdempotencyService {
+	return &IdempotencyService{db: db}
+}
+
+// IdempotencyRequestHash fingerprints a request so a key cannot be replayed
+// against a different one
+func IdempotencyRequestHash(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Begin claims a key for a request. It returns claimed = true when the caller
+// should go on and handle the request, then Complete or Release the key. A
+// key that already has a response is returned for replay with claimed =
+// false. A key whose request is still in flight gives ErrIdempotencyInFlight,
+// unless its lease has run out, in which case the key is claimed afresh. One
+// used for a different request gives ErrIdempotencyMismatch. Expired keys are
+// cleared out on the way.
+func (s *IdempotencyService) Begin(scope, key, requestHash string) (record *models.IdempotencyKey, claimed bool, err error) {
+	now := time.Now()
+	if err := s.db.Where("expires_at < ?", now).Delete(&models.IdempotencyKey{}).Error; err != nil {
+		return nil, false, err
+	}
+
+	// Postgres keeps microseconds; the lease is matched on Complete and Release
+	lockedUntil := now.Add(idempotencyLease).Truncate(time.Microsecond)
+	record = &models.IdempotencyKey{
+		ID:          uuid.New(),
+		Scope:       scope,
+		Key:         key,
+		RequestHash: requestHash,
+		LockedUntil: &lockedUntil,
+		ExpiresAt:   now.Add(IdempotencyKeyTTL),
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return record, true, nil
+	}
+
+	var existing models.IdempotencyKey
+	if err := s.db.Where("scope = ? AND key = ?", scope, key).First(&existing).Error; err != nil {
+		return nil, false, err
+	}
+	switch {
+	case existing.RequestHash != requestHash:
+		return nil, false, ErrIdempotencyMismatch
+	case existing.CompletedAt == nil:
+		if existing.LockedUntil != nil && existing.LockedUntil.After(now) {
+			return nil, false, ErrIdempotencyInFlight
+		}
+		// Abandoned mid-request: take the key over, unless another retry got there first
+		takeover := s.db.Model(&models.IdempotencyKey{}).
+			Where("id = ? AND completed_at IS NULL", existing.ID).
+			Where("locked_until IS NULL OR locked_until < ?", now).
+			Update("locked_until", lockedUntil)
+		if takeover.Error != nil {
+			return nil, false, takeover.Error
+		}
+		if takeover.RowsAffected == 0 {
+			return nil, false, ErrIdempotencyInFlight
+		}
+		existing.LockedUntil = &lockedUntil
+		return &existing, true, nil
+	}
+	return &existing, false, nil
+}
+
+// Complete stores the response to replay for the key
+func (s *IdempotencyService) Complete(record *models.IdempotencyKey, statusCode int, contentType string, body []byte) error {
+	now := time.Now()
+	return s.claimed(record).Updates(map[string]interface{}{
+		"status_code":   statusCode,
+		"content_type":  contentType,
+		"response_body": body,
+		"completed_at":  now,
+		"locked_until":  nil,
+	}).Error
+}
+
+// Release forgets a claimed key without a response, so the request can be
+// retried with it
+func (s *IdempotencyService) Release(record *models.IdempotencyKey) error {
+	return s.claimed(record).Delete(&models.IdempotencyKey{}).Error
+}
+
+// claimed scopes a write to the key while this claim on it holds; a request
+// that outran its lease must not overwrite or drop the retry that took over
+func (s *IdempotencyService) claimed(record *models.IdempotencyKey) *gorm.DB {
+	return s.db.Model(&models.IdempotencyKey{}).Where("id = ? AND locked_until = ?", record.ID, record.LockedUntil)
+}
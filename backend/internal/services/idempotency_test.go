@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"trumall/internal/models"
+	"trumall/internal/testdb"
+)
+
+func TestIdempotencyKeyLifecycle(t *testing.T) {
+	db := testdb.Open(t, &models.IdempotencyKey{})
+	keys := NewIdempotencyService(db)
+	hash := IdempotencyRequestHash("POST", "/api/cart/checkout", []byte(`{}`))
+
+	first, claimed, err := keys.Begin("user", "k1", hash)
+	if err != nil || !claimed {
+		t.Fatalf("first Begin = %v, claimed %v; want a claim", err, claimed)
+	}
+	if _, _, err := keys.Begin("user", "k1", hash); !errors.Is(err, ErrIdempotencyInFlight) {
+		t.Errorf("Begin while in flight = %v; want ErrIdempotencyInFlight", err)
+	}
+	other := IdempotencyRequestHash("POST", "/api/cart/checkout", []byte(`{"coupon":"X"}`))
+	if _, _, err := keys.Begin("user", "k1", other); !errors.Is(err, ErrIdempotencyMismatch) {
+		t.Errorf("Begin for another request = %v; want ErrIdempotencyMismatch", err)
+	}
+	// Keys are per scope
+	if _, claimed, err := keys.Begin("someone-else", "k1", hash); err != nil || !claimed {
+		t.Errorf("Begin in another scope = %v, claimed %v; want a claim", err, claimed)
+	}
+
+	if err := keys.Complete(first, 201, "application/json", []byte(`{"id":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	replay, claimed, err := keys.Begin("user", "k1", hash)
+	if err != nil || claimed {
+		t.Fatalf("Begin after Complete = %v, claimed %v; want a replay", err, claimed)
+	}
+	if replay.StatusCode != 201 || string(replay.ResponseBody) != `{"id":1}` {
+		t.Errorf("replay = %d %s; want the stored 201 response", replay.StatusCode, replay.ResponseBody)
+	}
+}
+
+func TestIdempotencyKeyRelease(t *testing.T) {
+	db := testdb.Open(t, &models.IdempotencyKey{})
+	keys := NewIdempotencyService(db)
+
+	record, _, _ := keys.Begin("user", "k1", "hash")
+	if err := keys.Release(record); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed, err := keys.Begin("user", "k1", "hash"); err != nil || !claimed {
+		t.Errorf("Begin after Release = %v, claimed %v; want a claim", err, claimed)
+	}
+}
+
+func TestIdempotencyKeyLeaseTakeover(t *testing.T) {
+	db := testdb.Open(t, &models.IdempotencyKey{})
+	keys := NewIdempotencyService(db)
+
+	abandoned, _, _ := keys.Begin("user", "k1", "hash")
+	// Its server went away mid-request
+	db.Model(&models.IdempotencyKey{}).Where("id = ?", abandoned.ID).
+		Update("locked_until", time.Now().Add(-time.Second))
+
+	retry, claimed, err := keys.Begin("user", "k1", "hash")
+	if err != nil || !claimed {
+		t.Fatalf("Begin after the lease ran out = %v, claimed %v; want a claim", err, claimed)
+	}
+
+	// The first request finishing late must not overwrite or drop the retry's claim
+	if err := keys.Complete(abandoned, 200, "application/json", []byte(`{"id":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Release(abandoned); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Complete(retry, 201, "application/json", []byte(`{"id":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	replay, claimed, _ := keys.Begin("user", "k1", "hash")
+	if claimed || string(replay.ResponseBody) != `{"id":2}` {
+		t.Errorf("replay = %s (claimed %v); want the retry's response", replay.ResponseBody, claimed)
+	}
+}
+
+func TestIdempotencyKeysExpire(t *testing.T) {
+	db := testdb.Open(t, &models.IdempotencyKey{})
+	keys := NewIdempotencyService(db)
+
+	record, _, _ := keys.Begin("user", "k1", "hash")
+	keys.Complete(record, 201, "application/json", []byte(`{"id":1}`))
+	db.Model(&models.IdempotencyKey{}).Where("id = ?", record.ID).Update("expires_at", time.Now().Add(-time.Second))
+
+	if _, claimed, err := keys.Begin("user", "k1", "hash"); err != nil || !claimed {
+		t.Errorf("Begin after expiry = %v, claimed %v; want a fresh claim", err, claimed)
+	}
+}
@@ -0,0 +1,417 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"trumall/internal/mfa"
+	"trumall/internal/models"
+)
+
+var (
+	ErrMFANotEnrolled    = errors.New("two-factor authentication is not set up")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFAInvalidCode    = errors.New("invalid verification code")
+	ErrMFARequired       = errors.New("two-factor authentication is mandatory for your role")
+	ErrMFALocked         = errors.New("too many invalid codes, please wait before trying again")
+	ErrMFAEnrollmentCode = errors.New("an enrolment code from an administrator is required to set up two-factor authentication")
+)
+
+const (
+	mfaMaxAttempts       = 5
+	mfaLockout           = 15 * time.Minute
+	mfaEnrollmentCodeTTL = 24 * time.Hour
+)
+
+type MFAService struct {
+	db *gorm.DB
+}
+
+func NewMFAService(db *gorm.DB) *MFAService {
+	return &MFAService{db: db}
+}
+
+// Enrollment is returned when a user starts TOTP setup
+type Enrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+// IsRequired reports whether any of the given roles has a mandatory 2FA policy
+func (s *MFAService) IsRequired(roles []string) (bool, error) {
+	if len(roles) == 0 {
+		return false, nil
+	}
+	var count int64
+	if err := s.db.Model(&models.MFAPolicy{}).
+		Where("role IN ? AND required = ?", roles, true).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// BeginEnrollment generates a fresh (unconfirmed) secret for the user
+func (s *MFAService) BeginEnrollment(user *models.User) (*Enrollment, error) {
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	if err := s.db.Model(&models.User{}).Where("id = ?", user.ID).
+		Update("totp_secret", secret).Error; err != nil {
+		return nil, fmt.Errorf("failed to save secret: %w", err)
+	}
+	user.TOTPSecret = &secret
+
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "TrustMall"
+	}
+
+	// Label the entry in the authenticator app with whatever the user signs in with
+	account := user.ID.String()
+	if user.Email != nil && *user.Email != "" {
+		account = *user.Email
+	} else if user.Phone != nil {
+		account = *user.Phone
+	}
+
+	return &Enrollment{
+		Secret:     secret,
+		OtpauthURI: mfa.ProvisioningURI(issuer, account, secret),
+	}, nil
+}
+
+// ConfirmEnrollment verifies the first code from the authenticator app, enables 2FA
+// and returns a fresh set of recovery codes (shown to the user exactly once)
+func (s *MFAService) ConfirmEnrollment(user *models.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == nil || *user.TOTPSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+
+	step, ok := mfa.Validate(*user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrMFAInvalidCode
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"totp_enabled":    true,
+			"totp_enabled_at": now,
+			"totp_last_step":  step,
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = s.replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	return codes, nil
+}
+
+// VerifyCode checks a TOTP code for a user with 2FA enabled and records the
+// accepted time step so the same code cannot be used twice
+func (s *MFAService) VerifyCode(user *models.User, code string) error {
+	if !user.TOTPEnabled || user.TOTPSecret == nil {
+		return ErrMFANotEnrolled
+	}
+	return s.attempt(user.ID, func() error { return s.verifyCode(user, code) })
+}
+
+func (s *MFAService) verifyCode(user *models.User, code string) error {
+	step, ok := mfa.Validate(*user.TOTPSecret, code, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return ErrMFAInvalidCode
+	}
+
+	// Conditional update guards against two concurrent requests using the same code
+	result := s.db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMFAInvalidCode
+	}
+
+	user.TOTPLastStep = step
+	return nil
+}
+
+// UseRecoveryCode consumes one unused recovery code
+func (s *MFAService) UseRecoveryCode(user *models.User, code string) error {
+	if !user.TOTPEnabled {
+		return ErrMFANotEnrolled
+	}
+	return s.attempt(user.ID, func() error { return s.useRecoveryCode(user, code) })
+}
+
+func (s *MFAService) useRecoveryCode(user *models.User, code string) error {
+	normalized := mfa.NormalizeRecoveryCode(code)
+	if normalized == "" {
+		return ErrMFAInvalidCode
+	}
+
+	var stored []models.RecoveryCode
+	if err := s.db.Where("user_id = ? AND used_at IS NULL", user.ID).Find(&stored).Error; err != nil {
+		return err
+	}
+
+	for _, rc := range stored {
+		if bcrypt.CompareHashAndPassword([]byte(rc.CodeHash), []byte(normalized)) != nil {
+			continue
+		}
+		result := s.db.Model(&models.RecoveryCode{}).
+			Where("id = ? AND used_at IS NULL", rc.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrMFAInvalidCode
+		}
+		return nil
+	}
+
+	return ErrMFAInvalidCode
+}
+
+// IssueEnrollmentCode gives an account that has not set up 2FA a one-time code
+// for enrolling at login, where a password alone is not enough to bind an
+// authenticator. An admin hands it to the user out of band; issuing a new code
+// replaces the previous one.
+func (s *MFAService) IssueEnrollmentCode(user *models.User) (string, time.Time, error) {
+	if user.TOTPEnabled {
+		return "", time.Time{}, ErrMFAAlreadyEnabled
+	}
+
+	codes, err := mfa.GenerateRecoveryCodes(1)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate code: %w", err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(mfa.NormalizeRecoveryCode(codes[0])), bcrypt.DefaultCost)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to hash code: %w", err)
+	}
+
+	expiresAt := time.Now().Add(mfaEnrollmentCodeTTL)
+	if err := s.db.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"mfa_enrollment_code_hash":  string(hash),
+		"mfa_enrollment_expires_at": expiresAt,
+	}).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	return codes[0], expiresAt, nil
+}
+
+// UseEnrollmentCode consumes the user's admin-issued enrolment code. Wrong
+// codes count towards the same lockout as wrong TOTP codes.
+func (s *MFAService) UseEnrollmentCode(user *models.User, code string) error {
+	if user.TOTPEnabled {
+		return ErrMFAAlreadyEnabled
+	}
+	return s.attempt(user.ID, func() error {
+		var current models.User
+		if err := s.db.Select("id", "mfa_enrollment_code_hash", "mfa_enrollment_expires_at").
+			First(&current, "id = ?", user.ID).Error; err != nil {
+			return err
+		}
+		if current.MFAEnrollmentCodeHash == nil || current.MFAEnrollmentExpiresAt == nil ||
+			time.Now().After(*current.MFAEnrollmentExpiresAt) {
+			return ErrMFAEnrollmentCode
+		}
+		normalized := mfa.NormalizeRecoveryCode(code)
+		if normalized == "" || bcrypt.CompareHashAndPassword([]byte(*current.MFAEnrollmentCodeHash), []byte(normalized)) != nil {
+			return ErrMFAInvalidCode
+		}
+
+		// Conditional update so the code enrols one authenticator only
+		result := s.db.Model(&models.User{}).
+			Where("id = ? AND mfa_enrollment_code_hash = ?", user.ID, *current.MFAEnrollmentCodeHash).
+			Updates(map[string]interface{}{"mfa_enrollment_code_hash": nil, "mfa_enrollment_expires_at": nil})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrMFAInvalidCode
+		}
+		return nil
+	})
+}
+
+// attempt counts one guess at a second-factor code before check runs. Guesses
+// are counted under a row lock, so parallel requests can't get more than
+// mfaMaxAttempts; the last one allowed locks the user out for mfaLockout unless
+// it succeeds, and a success clears the count.
+func (s *MFAService) attempt(userID uuid.UUID, check func() error) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "mfa_failed_attempts", "mfa_locked_until").
+			First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		attempts := user.MFAFailedAttempts
+		if user.MFALockedUntil != nil {
+			if now.Before(*user.MFALockedUntil) {
+				return ErrMFALocked
+			}
+			attempts = 0 // the lockout has passed
+		}
+		attempts++
+
+		var lockedUntil *time.Time
+		if attempts >= mfaMaxAttempts {
+			until := now.Add(mfaLockout)
+			lockedUntil = &until
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"mfa_failed_attempts": attempts,
+			"mfa_locked_until":    lockedUntil,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := check(); err != nil {
+		return err
+	}
+	return s.db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"mfa_failed_attempts": 0,
+		"mfa_locked_until":    nil,
+	}).Error
+}
+
+// RegenerateRecoveryCodes invalidates all existing recovery codes and issues new ones
+func (s *MFAService) RegenerateRecoveryCodes(user *models.User) ([]string, error) {
+	if !user.TOTPEnabled {
+		return nil, ErrMFANotEnrolled
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = s.replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// RemainingRecoveryCodes counts unused recovery codes
+func (s *MFAService) RemainingRecoveryCodes(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	return count, err
+}
+
+// Disable turns off 2FA, unless a policy makes it mandatory for one of the user's roles
+func (s *MFAService) Disable(user *models.User) error {
+	required, err := s.IsRequired(user.Roles)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequired
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"totp_secret":     nil,
+			"totp_enabled":    false,
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	user.TOTPSecret = nil
+	user.TOTPEnabled = false
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	return nil
+}
+
+// ListPolicies returns the 2FA policy for every configured role
+func (s *MFAService) ListPolicies() ([]models.MFAPolicy, error) {
+	var policies []models.MFAPolicy
+	if err := s.db.Order("role ASC").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// SetPolicy creates or updates the 2FA policy for a role
+func (s *MFAService) SetPolicy(role string, required bool) (*models.MFAPolicy, error) {
+	var policy models.MFAPolicy
+	if err := s.db.FirstOrCreate(&policy, models.MFAPolicy{Role: role}).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(&policy).Update("required", required).Error; err != nil {
+		return nil, err
+	}
+	policy.Required = required
+	return &policy, nil
+}
+
+func (s *MFAService) replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes, err := mfa.GenerateRecoveryCodes(mfa.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]models.RecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		hash, err := bcrypt.GenerateFromPassword([]byte(mfa.NormalizeRecoveryCode(code)), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, models.RecoveryCode{
+			ID:       uuid.New(),
+			UserID:   userID,
+			CodeHash: string(hash),
+		})
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
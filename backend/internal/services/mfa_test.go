@@ -0,0 +1,209 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"trumall/internal/mfa"
+	"trumall/internal/models"
+	"trumall/internal/testdb"
+)
+
+// enrolledUser returns a user with 2FA enabled, its recovery codes, and the
+// service it was enrolled with
+func enrolledUser(t *testing.T) (*MFAService, *models.User, []string) {
+	t.Helper()
+	db := testdb.Open(t, &models.User{}, &models.RecoveryCode{}, &models.MFAPolicy{})
+	user := &models.User{Name: "Seller"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewMFAService(db)
+	enrollment, err := svc.BeginEnrollment(user)
+	if err != nil {
+		t.Fatalf("BeginEnrollment: %v", err)
+	}
+	codes, err := svc.ConfirmEnrollment(user, totpCode(t, enrollment.Secret, 0))
+	if err != nil {
+		t.Fatalf("ConfirmEnrollment: %v", err)
+	}
+	return svc, user, codes
+}
+
+// totpCode is the code for secret offset periods from now
+func totpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := mfa.CodeAt(secret, mfa.Step(time.Now())+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func reloadUser(t *testing.T, db *gorm.DB, id interface{}) models.User {
+	t.Helper()
+	var user models.User
+	if err := db.First(&user, "id = ?", id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestMFAConfirmEnrollmentRejectsWrongCode(t *testing.T) {
+	db := testdb.Open(t, &models.User{}, &models.RecoveryCode{}, &models.MFAPolicy{})
+	user := &models.User{Name: "Seller"}
+	db.Create(user)
+	svc := NewMFAService(db)
+
+	enrollment, err := svc.BeginEnrollment(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ConfirmEnrollment(user, totpCode(t, enrollment.Secret, 3)); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Fatalf("ConfirmEnrollment with a code outside the skew window = %v; want ErrMFAInvalidCode", err)
+	}
+	if reloadUser(t, db, user.ID).TOTPEnabled {
+		t.Fatal("2FA was enabled by a wrong code")
+	}
+}
+
+func TestMFAVerifyCode(t *testing.T) {
+	svc, user, codes := enrolledUser(t)
+	if len(codes) != mfa.RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes; want %d", len(codes), mfa.RecoveryCodeCount)
+	}
+	secret := *reloadUser(t, svc.db, user.ID).TOTPSecret
+
+	// The enrolment code's time step is spent
+	if err := svc.VerifyCode(user, totpCode(t, secret, 0)); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Errorf("replayed enrolment code = %v; want ErrMFAInvalidCode", err)
+	}
+	if err := svc.VerifyCode(user, totpCode(t, secret, 2)); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Errorf("code outside the skew window = %v; want ErrMFAInvalidCode", err)
+	}
+	if err := svc.VerifyCode(user, totpCode(t, secret, 1)); err != nil {
+		t.Fatalf("next period's code = %v; want accepted", err)
+	}
+	if err := svc.VerifyCode(user, totpCode(t, secret, 1)); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Errorf("reused code = %v; want ErrMFAInvalidCode", err)
+	}
+}
+
+func TestMFARecoveryCodesAreSingleUse(t *testing.T) {
+	svc, user, codes := enrolledUser(t)
+
+	// Typed by hand: upper case, no dash
+	typed := codes[0][:5] + codes[0][6:]
+	if err := svc.UseRecoveryCode(user, " "+typed+" "); err != nil {
+		t.Fatalf("UseRecoveryCode = %v; want accepted", err)
+	}
+	if err := svc.UseRecoveryCode(user, codes[0]); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Errorf("reused recovery code = %v; want ErrMFAInvalidCode", err)
+	}
+	if err := svc.UseRecoveryCode(user, "aaaaa-bbbbb"); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Errorf("unknown recovery code = %v; want ErrMFAInvalidCode", err)
+	}
+	if n, _ := svc.RemainingRecoveryCodes(user.ID); n != int64(len(codes)-1) {
+		t.Errorf("remaining = %d; want %d", n, len(codes)-1)
+	}
+
+	fresh, err := svc.RegenerateRecoveryCodes(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.UseRecoveryCode(user, codes[1]); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Errorf("recovery code from before regeneration = %v; want ErrMFAInvalidCode", err)
+	}
+	if err := svc.UseRecoveryCode(user, fresh[0]); err != nil {
+		t.Errorf("regenerated recovery code = %v; want accepted", err)
+	}
+}
+
+func TestMFALockoutAfterFailedAttempts(t *testing.T) {
+	svc, user, codes := enrolledUser(t)
+	secret := *reloadUser(t, svc.db, user.ID).TOTPSecret
+
+	for i := 0; i < mfaMaxAttempts; i++ {
+		if err := svc.VerifyCode(user, "000000"); !errors.Is(err, ErrMFAInvalidCode) {
+			t.Fatalf("attempt %d = %v; want ErrMFAInvalidCode", i+1, err)
+		}
+	}
+
+	// Locked: even right codes, and recovery codes, are refused
+	if err := svc.VerifyCode(user, totpCode(t, secret, 1)); !errors.Is(err, ErrMFALocked) {
+		t.Fatalf("right code while locked = %v; want ErrMFALocked", err)
+	}
+	if err := svc.UseRecoveryCode(user, codes[0]); !errors.Is(err, ErrMFALocked) {
+		t.Fatalf("recovery code while locked = %v; want ErrMFALocked", err)
+	}
+
+	// Once the cooldown passes the count starts over
+	svc.db.Model(&models.User{}).Where("id = ?", user.ID).Update("mfa_locked_until", time.Now().Add(-time.Second))
+	if err := svc.VerifyCode(user, totpCode(t, secret, 1)); err != nil {
+		t.Fatalf("right code after the cooldown = %v; want accepted", err)
+	}
+	after := reloadUser(t, svc.db, user.ID)
+	if after.MFAFailedAttempts != 0 || after.MFALockedUntil != nil {
+		t.Errorf("after success: %d attempts, locked until %v; want cleared", after.MFAFailedAttempts, after.MFALockedUntil)
+	}
+}
+
+func TestMFASuccessResetsFailedAttempts(t *testing.T) {
+	svc, user, codes := enrolledUser(t)
+
+	for i := 0; i < mfaMaxAttempts-1; i++ {
+		svc.UseRecoveryCode(user, "aaaaa-bbbbb")
+	}
+	if err := svc.UseRecoveryCode(user, codes[0]); err != nil {
+		t.Fatalf("last allowed attempt with a right code = %v; want accepted", err)
+	}
+	for i := 0; i < mfaMaxAttempts-1; i++ {
+		if err := svc.UseRecoveryCode(user, "aaaaa-bbbbb"); !errors.Is(err, ErrMFAInvalidCode) {
+			t.Fatalf("attempt %d after a success = %v; want ErrMFAInvalidCode", i+1, err)
+		}
+	}
+}
+
+func TestMFAEnrollmentCode(t *testing.T) {
+	db := testdb.Open(t, &models.User{}, &models.RecoveryCode{}, &models.MFAPolicy{})
+	user := &models.User{Name: "Admin"}
+	db.Create(user)
+	svc := NewMFAService(db)
+
+	if err := svc.UseEnrollmentCode(user, "aaaaa-bbbbb"); !errors.Is(err, ErrMFAEnrollmentCode) {
+		t.Fatalf("UseEnrollmentCode before one is issued = %v; want ErrMFAEnrollmentCode", err)
+	}
+
+	code, expiresAt, err := svc.IssueEnrollmentCode(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(expiresAt) < 23*time.Hour {
+		t.Errorf("code expires at %v; want about a day from now", expiresAt)
+	}
+	if err := svc.UseEnrollmentCode(user, "aaaaa-bbbbb"); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Errorf("wrong enrolment code = %v; want ErrMFAInvalidCode", err)
+	}
+	if err := svc.UseEnrollmentCode(user, code); err != nil {
+		t.Fatalf("issued enrolment code = %v; want accepted", err)
+	}
+	if err := svc.UseEnrollmentCode(user, code); !errors.Is(err, ErrMFAEnrollmentCode) {
+		t.Errorf("reused enrolment code = %v; want ErrMFAEnrollmentCode", err)
+	}
+
+	// Expired codes are refused
+	code, _, _ = svc.IssueEnrollmentCode(user)
+	db.Model(&models.User{}).Where("id = ?", user.ID).Update("mfa_enrollment_expires_at", time.Now().Add(-time.Minute))
+	if err := svc.UseEnrollmentCode(user, code); !errors.Is(err, ErrMFAEnrollmentCode) {
+		t.Errorf("expired enrolment code = %v; want ErrMFAEnrollmentCode", err)
+	}
+
+	user.TOTPEnabled = true
+	if _, _, err := svc.IssueEnrollmentCode(user); !errors.Is(err, ErrMFAAlreadyEnabled) {
+		t.Errorf("IssueEnrollmentCode for an enrolled user = %v; want ErrMFAAlreadyEnabled", err)
+	}
+}
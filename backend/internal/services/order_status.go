@@ -0,0 +1,70 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+var (
+	ErrInvalidOrderStatus    = errors.New("invalid order status")
+	ErrOrderStatusTransition = errors.New("order cannot be moved to that status")
+)
+
+// staffOrderTransitions are the status changes a store's staff may make by
+// hand. Payment alone makes an order paid, so no transition leads to it;
+// pending orders can only be cancelled, which releases what they hold.
+var staffOrderTransitions = map[string][]string{
+	"pending":                 {"cancelled"},
+	"paid":                    {"processing", OrderStatusShipped, OrderStatusReadyForPickup},
+	"processing":              {OrderStatusShipped, OrderStatusReadyForPickup},
+	OrderStatusShipped:        {OrderStatusReadyForPickup, OrderStatusDelivered},
+	OrderStatusReadyForPickup: {OrderStatusDelivered},
+}
+
+// StaffOrderStatuses lists the statuses staff can move an order to from its
+// current one
+func StaffOrderStatuses(order models.Order) []string {
+	var next []string
+	for _, status := range staffOrderTransitions[order.Status] {
+		// Ready for pickup only applies to click-and-collect orders
+		if status == OrderStatusReadyForPickup && order.PickupPointID == nil {
+			continue
+		}
+		next = append(next, status)
+	}
+	return next
+}
+
+// SetOrderStatusByStaff moves an order to status on behalf of the store's
+// staff, if that is one of StaffOrderStatuses. The update is conditional on the
+// status the check saw, so a payment landing meanwhile is not overwritten.
+func SetOrderStatusByStaff(db *gorm.DB, order *models.Order, status string) error {
+	if _, known := orderStatusRank[status]; !known && status != "cancelled" {
+		return fmt.Errorf("%w: %q", ErrInvalidOrderStatus, status)
+	}
+	allowed := false
+	for _, next := range StaffOrderStatuses(*order) {
+		allowed = allowed || next == status
+	}
+	if !allowed {
+		return fmt.Errorf("%w: it is %s", ErrOrderStatusTransition, order.Status)
+	}
+
+	now := time.Now()
+	result := db.Model(&models.Order{}).Where("id = ? AND status = ?", order.ID, order.Status).
+		Updates(map[string]interface{}{"status": status, "updated_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: it has changed since it was loaded", ErrOrderStatusTransition)
+	}
+	order.Status = status
+	order.UpdatedAt = now
+	return nil
+}
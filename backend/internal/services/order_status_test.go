@@ -0,0 +1,82 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"trumall/internal/models"
+)
+
+func TestSetOrderStatusByStaff(t *testing.T) {
+	pickupPoint := uuid.New()
+	cases := []struct {
+		from   string
+		to     string
+		pickup bool
+		err    error
+	}{
+		{"paid", "processing", false, nil},
+		{"processing", OrderStatusShipped, false, nil},
+		{OrderStatusShipped, OrderStatusDelivered, false, nil},
+		{"paid", OrderStatusShipped, false, nil},
+		{"pending", "cancelled", false, nil},
+		{"processing", OrderStatusReadyForPickup, true, nil},
+		{OrderStatusReadyForPickup, OrderStatusDelivered, true, nil},
+
+		// Only a payment makes an order paid
+		{"pending", "paid", false, ErrOrderStatusTransition},
+		{"cancelled", "paid", false, ErrOrderStatusTransition},
+		{"pending", OrderStatusDelivered, false, ErrOrderStatusTransition},
+		{"pending", "processing", false, ErrOrderStatusTransition},
+		// No going back, or cancelling once paid
+		{OrderStatusDelivered, OrderStatusShipped, false, ErrOrderStatusTransition},
+		{OrderStatusShipped, "processing", false, ErrOrderStatusTransition},
+		{"paid", "cancelled", false, ErrOrderStatusTransition},
+		{"processing", "processing", false, ErrOrderStatusTransition},
+		// Ready for pickup is for click-and-collect orders only
+		{"processing", OrderStatusReadyForPickup, false, ErrOrderStatusTransition},
+		// Made-up statuses
+		{"paid", "refunded", false, ErrInvalidOrderStatus},
+		{"paid", "", false, ErrInvalidOrderStatus},
+	}
+
+	db := openOrderDB(t)
+	buyer := newBuyer(t, db)
+	for _, tc := range cases {
+		order := newOrder(t, db, buyer.ID, tc.from, 1)
+		if tc.pickup {
+			order.PickupPointID = &pickupPoint
+		}
+
+		err := SetOrderStatusByStaff(db, &order, tc.to)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s -> %q: err = %v; want %v", tc.from, tc.to, err, tc.err)
+			continue
+		}
+		want := tc.from
+		if tc.err == nil {
+			want = tc.to
+		}
+		if got := statusOf[models.Order](t, db, order.ID); got != want {
+			t.Errorf("%s -> %q: stored status %s; want %s", tc.from, tc.to, got, want)
+		}
+	}
+}
+
+func TestSetOrderStatusByStaffDoesNotOverwriteConcurrentChange(t *testing.T) {
+	db := openOrderDB(t)
+	buyer := newBuyer(t, db)
+	order := newOrder(t, db, buyer.ID, "pending", 1)
+
+	// The payment lands after the seller loaded the order
+	db.Model(&models.Order{}).Where("id = ?", order.ID).Update("status", "paid")
+
+	if err := SetOrderStatusByStaff(db, &order, "cancelled"); !errors.Is(err, ErrOrderStatusTransition) {
+		t.Fatalf("cancelling a stale pending order = %v; want ErrOrderStatusTransition", err)
+	}
+	if got := statusOf[models.Order](t, db, order.ID); got != "paid" {
+		t.Errorf("order is %s; want paid", got)
+	}
+}
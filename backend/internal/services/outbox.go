@@ -0,0 +1,165 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+// outboxRetryDelays is the wait before each retry of a failed delivery; a
+// message is given up once they run out
+var outboxRetryDelays = []time.Duration{5 * time.Second, 15 * time.Second, 45 * time.Second, 2 * time.Minute}
+
+// outboxLease is how long a worker has a message to itself while delivering it
+const outboxLease = time.Minute
+
+// ErrOutboxPermanent marks a delivery error that retrying will not fix
+var ErrOutboxPermanent = errors.New("permanent failure")
+
+// OutboxHandler carries out the messages of one topic
+type OutboxHandler interface {
+	// Handle delivers the message. Errors wrapping ErrOutboxPermanent are not
+	// retried.
+	Handle(db *gorm.DB, msg models.OutboxMessage) error
+	// GiveUp is called once the message will not be retried, with the last error
+	GiveUp(db *gorm.DB, msg models.OutboxMessage, err error) error
+}
+
+var outboxHandlers = map[string]OutboxHandler{
+	TopicSTKPush: stkPushHandler{},
+}
+
+// Enqueue queues a message in the caller's transaction, so it is only
+// delivered if that transaction commits
+func Enqueue(tx *gorm.DB, topic string, payload interface{}) (*models.OutboxMessage, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	msg := models.OutboxMessage{
+		ID:            uuid.New(),
+		Topic:         topic,
+		Payload:       string(raw),
+		NextAttemptAt: time.Now(),
+	}
+	if err := tx.Create(&msg).Error; err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+type Outbox struct {
+	db *gorm.DB
+}
+
+func NewOutbox(db *gorm.DB) *Outbox {
+	return &Outbox{db: db}
+}
+
+// Deliver makes one attempt at a message if it is due and no other worker has
+// it. It reports whether an attempt was made.
+func (o *Outbox) Deliver(id uuid.UUID) (bool, error) {
+	now := time.Now()
+	claim := o.db.Model(&models.OutboxMessage{}).
+		Where("id = ? AND processed_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?", id, now).
+		Where("locked_until IS NULL OR locked_until < ?", now).
+		Updates(map[string]interface{}{
+			"locked_until": now.Add(outboxLease),
+			"attempts":     gorm.Expr("attempts + 1"),
+		})
+	if claim.Error != nil {
+		return false, claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return false, nil
+	}
+
+	var msg models.OutboxMessage
+	if err := o.db.First(&msg, "id = ?", id).Error; err != nil {
+		return true, err
+	}
+	handler, ok := outboxHandlers[msg.Topic]
+	if !ok {
+		return true, o.fail(msg, nil, fmt.Errorf("%w: no handler for topic %q", ErrOutboxPermanent, msg.Topic))
+	}
+
+	err := handler.Handle(o.db, msg)
+	if err == nil {
+		return true, o.db.Model(&msg).Updates(map[string]interface{}{
+			"processed_at": time.Now(),
+			"locked_until": nil,
+		}).Error
+	}
+	if errors.Is(err, ErrOutboxPermanent) || msg.Attempts > len(outboxRetryDelays) {
+		return true, o.fail(msg, handler, err)
+	}
+
+	reason := err.Error()
+	log.Printf("Outbox %s message %s failed (attempt %d), retrying: %v", msg.Topic, msg.ID, msg.Attempts, err)
+	return true, o.db.Model(&msg).Updates(map[string]interface{}{
+		"next_attempt_at": time.Now().Add(outboxRetryDelays[msg.Attempts-1]),
+		"locked_until":    nil,
+		"last_error":      reason,
+	}).Error
+}
+
+// DeliverDue attempts every message that is due, oldest first
+func (o *Outbox) DeliverDue() (int, error) {
+	var ids []uuid.UUID
+	now := time.Now()
+	if err := o.db.Model(&models.OutboxMessage{}).
+		Where("processed_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?", now).
+		Where("locked_until IS NULL OR locked_until < ?", now).
+		Order("next_attempt_at").Limit(100).
+		Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	attempted := 0
+	for _, id := range ids {
+		ok, err := o.Deliver(id)
+		if err != nil {
+			log.Printf("Error delivering outbox message %s: %v", id, err)
+		}
+		if ok {
+			attempted++
+		}
+	}
+	return attempted, nil
+}
+
+// Start delivers due messages every interval until the process exits
+func (o *Outbox) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := o.DeliverDue(); err != nil {
+				log.Printf("Error polling the outbox: %v", err)
+			}
+		}
+	}()
+}
+
+// fail gives up on a message and lets its handler record the outcome
+func (o *Outbox) fail(msg models.OutboxMessage, handler OutboxHandler, cause error) error {
+	log.Printf("Outbox %s message %s failed for good after %d attempts: %v", msg.Topic, msg.ID, msg.Attempts, cause)
+	if handler != nil {
+		if err := handler.GiveUp(o.db, msg, cause); err != nil {
+			return err
+		}
+	}
+	return o.db.Model(&msg).Updates(map[string]interface{}{
+		"failed_at":    time.Now(),
+		"locked_until": nil,
+		"last_error":   cause.Error(),
+	}).Error
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+// scriptedHandler fails or succeeds on each attempt as its script says,
+// succeeding once the script runs out
+type scriptedHandler struct {
+	script []error
+	gaveUp error
+}
+
+func (h *scriptedHandler) Handle(db *gorm.DB, msg models.OutboxMessage) error {
+	if msg.Attempts > len(h.script) {
+		return nil
+	}
+	return h.script[msg.Attempts-1]
+}
+
+func (h *scriptedHandler) GiveUp(db *gorm.DB, msg models.OutboxMessage, err error) error {
+	h.gaveUp = err
+	return nil
+}
+
+// useOutboxHandler handles topic with h for the rest of the test
+func useOutboxHandler(t *testing.T, topic string, h OutboxHandler) {
+	t.Helper()
+	outboxHandlers[topic] = h
+	t.Cleanup(func() { delete(outboxHandlers, topic) })
+}
+
+// deliverUntilSettled attempts a message, making each retry due at once, until
+// no attempt is made
+func deliverUntilSettled(t *testing.T, db *gorm.DB, msg *models.OutboxMessage) {
+	t.Helper()
+	outbox := NewOutbox(db)
+	for i := 0; i < 10; i++ {
+		attempted, err := outbox.Deliver(msg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !attempted {
+			break
+		}
+		db.Model(msg).UpdateColumn("next_attempt_at", time.Now().Add(-time.Second))
+	}
+	db.First(msg, "id = ?", msg.ID)
+}
+
+func TestOutboxRetriesThenGivesUp(t *testing.T) {
+	db := openOrderDB(t)
+	unreachable := errors.New("connection refused")
+	refused := fmt.Errorf("%w: bad phone number", ErrOutboxPermanent)
+	retries := len(outboxRetryDelays)
+
+	cases := []struct {
+		name      string
+		script    []error
+		attempts  int
+		processed bool
+	}{
+		{"delivered first time", nil, 1, true},
+		{"delivered on a retry", []error{unreachable, unreachable}, 3, true},
+		{"permanent failure", []error{unreachable, refused}, 2, false},
+		{"retries run out", []error{unreachable, unreachable, unreachable, unreachable, unreachable, unreachable}, retries + 1, false},
+	}
+	for i, tc := range cases {
+		topic := fmt.Sprintf("test.%d", i)
+		handler := &scriptedHandler{script: tc.script}
+		useOutboxHandler(t, topic, handler)
+		msg, err := Enqueue(db, topic, map[string]string{"case": tc.name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deliverUntilSettled(t, db, msg)
+		if msg.Attempts != tc.attempts || (msg.ProcessedAt != nil) != tc.processed || (msg.FailedAt != nil) == tc.processed {
+			t.Errorf("%s: %d attempts, processed %v, failed %v; want %d attempts, processed = %v", tc.name,
+				msg.Attempts, msg.ProcessedAt, msg.FailedAt, tc.attempts, tc.processed)
+		}
+		if (handler.gaveUp != nil) == tc.processed {
+			t.Errorf("%s: gave up with %v; want giving up = %v", tc.name, handler.gaveUp, !tc.processed)
+		}
+		if !tc.processed && (msg.LastError == nil || *msg.LastError != tc.script[tc.attempts-1].Error()) {
+			t.Errorf("%s: last error %v; want %q", tc.name, msg.LastError, tc.script[tc.attempts-1])
+		}
+	}
+}
+
+func TestOutboxDeliver(t *testing.T) {
+	db := openOrderDB(t)
+	outbox := NewOutbox(db)
+	useOutboxHandler(t, "test.flaky", &scriptedHandler{script: []error{errors.New("timeout")}})
+
+	// A retry waits its turn
+	msg, _ := Enqueue(db, "test.flaky", nil)
+	if attempted, err := outbox.Deliver(msg.ID); !attempted || err != nil {
+		t.Fatalf("first attempt: %v, %v", attempted, err)
+	}
+	db.First(msg, "id = ?", msg.ID)
+	if wait := time.Until(msg.NextAttemptAt); wait < outboxRetryDelays[0]-time.Second || wait > outboxRetryDelays[0] {
+		t.Errorf("retry in %s; want %s", wait, outboxRetryDelays[0])
+	}
+	if attempted, _ := outbox.Deliver(msg.ID); attempted {
+		t.Error("a retry was attempted before it was due")
+	}
+
+	// Another worker's lease keeps the message to it
+	leased, _ := Enqueue(db, "test.flaky", nil)
+	db.Model(leased).UpdateColumn("locked_until", time.Now().Add(outboxLease))
+	if n, err := outbox.DeliverDue(); n != 0 || err != nil {
+		t.Errorf("delivered %d with another worker's lease (%v); want none", n, err)
+	}
+	db.Model(leased).UpdateColumn("locked_until", time.Now().Add(-time.Second))
+	if n, _ := outbox.DeliverDue(); n != 1 {
+		t.Errorf("delivered %d once the lease ran out; want 1", n)
+	}
+
+	// Nothing can deliver a topic without a handler
+	orphan, _ := Enqueue(db, "test.unknown", nil)
+	deliverUntilSettled(t, db, orphan)
+	if orphan.FailedAt == nil || orphan.Attempts != 1 {
+		t.Errorf("unknown topic: failed %v after %d attempts; want failed after 1", orphan.FailedAt, orphan.Attempts)
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"trumall/internal/models"
+)
+
+// VolumetricDivisor converts packed volume (cm³) to volumetric weight in kg,
+// the usual courier figure of 5000 cm³ per kg
+const VolumetricDivisor = 5000
+
+var ErrParcelTooHeavy = errors.New("parcel exceeds the weight limit for this shipping method")
+
+// Parcel is the shipping weight of a cart. Couriers charge whichever of the actual
+// and volumetric weights is larger.
+type Parcel struct {
+	ActualWeightGrams     int `json:"actual_weight_grams"`
+	VolumetricWeightGrams int `json:"volumetric_weight_grams"`
+}
+
+// ChargeableWeightGrams is the weight used for pricing
+func (p Parcel) ChargeableWeightGrams() int {
+	if p.VolumetricWeightGrams > p.ActualWeightGrams {
+		return p.VolumetricWeightGrams
+	}
+	return p.ActualWeightGrams
+}
+
+// BillableKg rounds the chargeable weight up to whole kilograms
+func (p Parcel) BillableKg() int64 {
+	grams := p.ChargeableWeightGrams()
+	if grams <= 0 {
+		return 0
+	}
+	return int64((grams + 999) / 1000)
+}
+
+// ParcelForCart totals actual and volumetric weight across cart items.
+// Items must have Product loaded; products without weight or size add nothing.
+func ParcelForCart(items []models.CartItem) Parcel {
+	var parcel Parcel
+	for _, item := range items {
+		parcel.add(item.Product, item.Quantity)
+	}
+	return parcel
+}
+
+// ParcelForOrder is ParcelForCart for an order's items (with Product loaded)
+func ParcelForOrder(items []models.OrderItem) Parcel {
+	var parcel Parcel
+	for _, item := range items {
+		parcel.add(item.Product, item.Quantity)
+	}
+	return parcel
+}
+
+func (p *Parcel) add(product models.Product, quantity int) {
+	p.ActualWeightGrams += quantity * product.WeightGrams
+
+	volumeCm3 := product.LengthCm * product.WidthCm * product.HeightCm
+	if volumeCm3 > 0 {
+		grams := int(math.Ceil(volumeCm3 * 1000 / VolumetricDivisor))
+		p.VolumetricWeightGrams += quantity * grams
+	}
+}
+
+// weightCharge returns the per-kg cost of the parcel, or ErrParcelTooHeavy if the
+// method cannot carry it
+func weightCharge(method models.ShippingMethod, parcel Parcel) (int64, error) {
+	if method.MaxWeightGrams != nil && parcel.ChargeableWeightGrams() > *method.MaxWeightGrams {
+		return 0, fmt.Errorf("%w: %s carries up to %.1f kg, this order weighs %.1f kg",
+			ErrParcelTooHeavy, method.Name,
+			float64(*method.MaxWeightGrams)/1000, float64(parcel.ChargeableWeightGrams())/1000)
+	}
+	return parcel.BillableKg() * method.CostPerKgCents, nil
+}
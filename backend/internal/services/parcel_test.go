@@ -0,0 +1,86 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"trumall/internal/models"
+)
+
+func TestParcelWeights(t *testing.T) {
+	cases := []struct {
+		name       string
+		product    models.Product
+		quantity   int
+		actual     int
+		volumetric int
+		billableKg int64
+	}{
+		{"no weight or size", models.Product{}, 3, 0, 0, 0},
+		{"actual weight", models.Product{WeightGrams: 1200}, 2, 2400, 0, 3},
+		{"exactly a kilo", models.Product{WeightGrams: 1000}, 1, 1000, 0, 1},
+		{"bulky but light", models.Product{WeightGrams: 2000, LengthCm: 50, WidthCm: 40, HeightCm: 30}, 1, 2000, 12000, 12},
+		{"small box rounds up", models.Product{LengthCm: 10, WidthCm: 10, HeightCm: 10}, 3, 0, 600, 1},
+		{"heavy but small", models.Product{WeightGrams: 5000, LengthCm: 10, WidthCm: 10, HeightCm: 10}, 1, 5000, 200, 5},
+	}
+	for _, tc := range cases {
+		parcel := ParcelForCart([]models.CartItem{{Product: tc.product, Quantity: tc.quantity}})
+		if parcel.ActualWeightGrams != tc.actual || parcel.VolumetricWeightGrams != tc.volumetric || parcel.BillableKg() != tc.billableKg {
+			t.Errorf("%s: actual %d g, volumetric %d g, billable %d kg; want %d, %d, %d", tc.name,
+				parcel.ActualWeightGrams, parcel.VolumetricWeightGrams, parcel.BillableKg(), tc.actual, tc.volumetric, tc.billableKg)
+		}
+	}
+}
+
+func TestWeightCharge(t *testing.T) {
+	limit := 5000
+	limited := models.ShippingMethod{Name: "Boda", CostPerKgCents: 100, MaxWeightGrams: &limit}
+	unlimited := models.ShippingMethod{Name: "Courier", CostPerKgCents: 100}
+
+	cases := []struct {
+		name   string
+		method models.ShippingMethod
+		parcel Parcel
+		charge int64
+		err    error
+	}{
+		{"under the limit", limited, Parcel{ActualWeightGrams: 4200}, 500, nil},
+		{"at the limit", limited, Parcel{ActualWeightGrams: 5000}, 500, nil},
+		{"over the limit", limited, Parcel{ActualWeightGrams: 5001}, 0, ErrParcelTooHeavy},
+		{"too bulky", limited, Parcel{ActualWeightGrams: 1000, VolumetricWeightGrams: 6000}, 0, ErrParcelTooHeavy},
+		{"no limit", unlimited, Parcel{ActualWeightGrams: 100000}, 10000, nil},
+		{"weightless", unlimited, Parcel{}, 0, nil},
+	}
+	for _, tc := range cases {
+		charge, err := weightCharge(tc.method, tc.parcel)
+		if !errors.Is(err, tc.err) || charge != tc.charge {
+			t.Errorf("%s: charge %d, err %v; want %d, %v", tc.name, charge, err, tc.charge, tc.err)
+		}
+	}
+}
+
+func TestQuoteChargesWeight(t *testing.T) {
+	db := openShippingDB(t)
+	store := newShippingStore(t, db)
+	address := newAddress(t, db, "Mombasa", "Kenya")
+	newCountryZone(t, db, "Kenya", 0)
+	method := newMethod(t, db, "standard", 500)
+	limit := 10000
+	db.Model(&method).Updates(map[string]any{"cost_per_kg_cents": 100, "max_weight_grams": limit})
+
+	calc, err := quoteTo(db, store, address, "standard", 10000, Parcel{ActualWeightGrams: 2500, VolumetricWeightGrams: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := quoteLines(calc); lines[QuoteStepWeight] != 300 {
+		t.Errorf("weight line = %d; want 300 for 3 kg", lines[QuoteStepWeight])
+	}
+	if calc.ShippingCostCents != 800 || calc.WeightCostCents != 300 || calc.ChargeableWeightGrams != 2500 {
+		t.Errorf("cost %d, weight cost %d, chargeable %d g; want 800, 300, 2500",
+			calc.ShippingCostCents, calc.WeightCostCents, calc.ChargeableWeightGrams)
+	}
+
+	if _, err := quoteTo(db, store, address, "standard", 10000, Parcel{ActualWeightGrams: limit + 1}); !errors.Is(err, ErrParcelTooHeavy) {
+		t.Errorf("parcel over the method's limit: err = %v; want ErrParcelTooHeavy", err)
+	}
+}
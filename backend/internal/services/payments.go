@@ -0,0 +1,131 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"trumall/internal/models"
+)
+
+// stkPromptReuseWindow is how long a sent payment prompt is taken to still be
+// open on the buyer's phone. Asking again for the same number within it
+// returns the same payment; asking for another number is refused, as the open
+// prompt can't be withdrawn and paying both would charge the buyer twice.
+const stkPromptReuseWindow = 2 * time.Minute
+
+var (
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrOrderNotPayable   = errors.New("order cannot be paid")
+	ErrPaymentInProgress = errors.New("a payment prompt for this order is still open; complete or dismiss it, or try again in a few minutes")
+)
+
+type PaymentService struct {
+	db *gorm.DB
+}
+
+func NewPaymentService(db *gorm.DB) *PaymentService {
+	return &PaymentService{db: db}
+}
+
+// StartMpesa asks the buyer to pay for their order by M-Pesa on phone (already
+// normalised). Only pending orders still holding their stock can be paid. A
+// prompt still open for the same phone is returned as it is, with reused set,
+// and one open for another phone is refused with ErrPaymentInProgress. Older
+// open payments are superseded by a new one, whose STK push is sent through
+// the outbox.
+func (s *PaymentService) StartMpesa(buyerID, orderID uuid.UUID, phone string) (payment *models.Payment, reused bool, err error) {
+	var push *models.OutboxMessage
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// The order lock serialises payment requests for it
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND buyer_id = ?", orderID, buyerID).First(&order).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrOrderNotFound
+			}
+			return err
+		}
+		if err := orderPayable(order); err != nil {
+			return err
+		}
+
+		var open []models.Payment
+		if err := tx.Where("order_id = ? AND status IN ?", order.ID, []string{"initiated", "pending"}).
+			Order("created_at DESC").Find(&open).Error; err != nil {
+			return err
+		}
+		for i := range open {
+			if !promptStillOpen(open[i]) {
+				continue
+			}
+			if open[i].Phone == nil || *open[i].Phone != phone {
+				return ErrPaymentInProgress
+			}
+			payment, reused = &open[i], true
+			return nil
+		}
+		if len(open) > 0 {
+			ids := make([]uuid.UUID, 0, len(open))
+			for _, p := range open {
+				ids = append(ids, p.ID)
+			}
+			if err := tx.Model(&models.Payment{}).Where("id IN ?", ids).Update("status", "superseded").Error; err != nil {
+				return err
+			}
+		}
+
+		payment = &models.Payment{
+			ID:          uuid.New(),
+			OrderID:     order.ID,
+			Provider:    "M-Pesa",
+			AmountCents: order.TotalCents,
+			Currency:    "KES",
+			Status:      "initiated",
+			Phone:       &phone,
+		}
+		if err := tx.Create(payment).Error; err != nil {
+			return err
+		}
+		push, err = EnqueueSTKPush(tx, payment.ID)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if push != nil {
+		payment, err = SendSTKPush(s.db, push, payment.ID)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return payment, reused, nil
+}
+
+// orderPayable reports why an order cannot be paid, if it can't
+func orderPayable(order models.Order) error {
+	if order.Status != "pending" {
+		return fmt.Errorf("%w: it is %s", ErrOrderNotPayable, order.Status)
+	}
+	// Past the hold window its stock, sale units and coupon are no longer held
+	if time.Since(order.CreatedAt) > stockReservationWindow {
+		return fmt.Errorf("%w: it has expired; check out again", ErrOrderNotPayable)
+	}
+	if order.TotalCents < 100 {
+		return fmt.Errorf("%w: M-Pesa minimum amount is 1 KES", ErrOrderNotPayable)
+	}
+	return nil
+}
+
+// promptStillOpen reports whether an open payment's prompt is still queued, or
+// was sent too recently to have timed out on the phone
+func promptStillOpen(p models.Payment) bool {
+	if p.Status == "initiated" {
+		return true
+	}
+	return time.Since(p.UpdatedAt) < stkPromptReuseWindow
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"trumall/internal/models"
+)
+
+func TestStartMpesa(t *testing.T) {
+	db := openOrderDB(t)
+	mpesa := newFakeMpesa(t)
+	payments := NewPaymentService(db)
+	buyer := newBuyer(t, db)
+	const phone, otherPhone = "254712345678", "254798765432"
+
+	// prompt gives the order an earlier payment, last touched age ago
+	prompt := func(order models.Order, status, phone string, age time.Duration) *models.Payment {
+		p := newPayment(t, db, order.ID, status, phone)
+		db.Model(&p).UpdateColumn("updated_at", time.Now().Add(-age))
+		return &p
+	}
+
+	cases := []struct {
+		name    string
+		setup   func(order models.Order) *models.Payment // the earlier payment, if any
+		rejects bool                                     // M-Pesa refuses the push
+		err     error
+		reused  bool
+		status  string // of the payment returned
+		earlier string // the earlier payment's status afterwards
+	}{
+		{"first prompt", func(models.Order) *models.Payment { return nil }, false, nil, false, "pending", ""},
+		{"someone else's order", func(o models.Order) *models.Payment {
+			db.Model(&o).Update("buyer_id", uuid.New())
+			return nil
+		}, false, ErrOrderNotFound, false, "", ""},
+		{"already paid", func(o models.Order) *models.Payment {
+			db.Model(&o).Update("status", "paid")
+			return nil
+		}, false, ErrOrderNotPayable, false, "", ""},
+		{"expired", func(o models.Order) *models.Payment {
+			db.Model(&o).UpdateColumn("created_at", time.Now().Add(-stockReservationWindow-time.Hour))
+			return nil
+		}, false, ErrOrderNotPayable, false, "", ""},
+		{"under the M-Pesa minimum", func(o models.Order) *models.Payment {
+			db.Model(&o).Update("total_cents", 50)
+			return nil
+		}, false, ErrOrderNotPayable, false, "", ""},
+		{"prompt open on the same phone", func(o models.Order) *models.Payment {
+			return prompt(o, "pending", phone, time.Minute)
+		}, false, nil, true, "pending", "pending"},
+		{"prompt still queued", func(o models.Order) *models.Payment {
+			return prompt(o, "initiated", phone, time.Hour)
+		}, false, nil, true, "initiated", "initiated"},
+		{"prompt open on another phone", func(o models.Order) *models.Payment {
+			return prompt(o, "pending", otherPhone, time.Minute)
+		}, false, ErrPaymentInProgress, false, "", "pending"},
+		{"prompt timed out", func(o models.Order) *models.Payment {
+			return prompt(o, "pending", otherPhone, stkPromptReuseWindow+time.Minute)
+		}, false, nil, false, "pending", "superseded"},
+		{"declined prompt", func(o models.Order) *models.Payment {
+			return prompt(o, "failed", phone, time.Minute)
+		}, false, nil, false, "pending", "failed"},
+		{"rejected by M-Pesa", func(models.Order) *models.Payment { return nil }, true, nil, false, "failed", ""},
+	}
+	for _, tc := range cases {
+		mpesa.pushes, mpesa.status, mpesa.body = 0, 200, `{"CheckoutRequestID":"ws_CO_1"}`
+		if tc.rejects {
+			mpesa.status, mpesa.body = 400, `{"errorMessage":"Invalid Amount"}`
+		}
+		order := newOrder(t, db, buyer.ID, "pending", 1)
+		earlier := tc.setup(order)
+
+		payment, reused, err := payments.StartMpesa(buyer.ID, order.ID, phone)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+			continue
+		}
+		if err == nil {
+			if payment.Status != tc.status || reused != tc.reused {
+				t.Errorf("%s: payment %s, reused %v; want %s, %v", tc.name, payment.Status, reused, tc.status, tc.reused)
+			}
+			if reused && (payment.ID != earlier.ID || mpesa.pushes != 0) {
+				t.Errorf("%s: payment %s after %d pushes; want the open one, %s, without a push", tc.name, payment.ID, mpesa.pushes, earlier.ID)
+			}
+			if !reused && (mpesa.pushes != 1 || *payment.Phone != phone || payment.AmountCents != order.TotalCents) {
+				t.Errorf("%s: %d pushes for %d to %s; want one for %d to %s", tc.name, mpesa.pushes, payment.AmountCents, *payment.Phone, order.TotalCents, phone)
+			}
+		} else if mpesa.pushes != 0 {
+			t.Errorf("%s: %d pushes; want none", tc.name, mpesa.pushes)
+		}
+		if earlier != nil {
+			if got := statusOf[models.Payment](t, db, earlier.ID); got != tc.earlier {
+				t.Errorf("%s: earlier payment %s; want %s", tc.name, got, tc.earlier)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/sms"
+)
+
+const (
+	OTPPurposeLogin  = "login"
+	OTPPurposeVerify = "verify"
+
+	otpLength      = 6
+	otpTTL         = 5 * time.Minute
+	otpMaxAttempts = 5
+	otpResendAfter = 60 * time.Second
+	otpHourlyLimit = 5
+)
+
+var (
+	ErrOTPRateLimited = errors.New("too many codes requested, please wait before trying again")
+	ErrOTPInvalid     = errors.New("invalid or expired code")
+)
+
+type PhoneOTPService struct {
+	db     *gorm.DB
+	sender sms.Sender
+}
+
+func NewPhoneOTPService(db *gorm.DB, sender sms.Sender) *PhoneOTPService {
+	return &PhoneOTPService{db: db, sender: sender}
+}
+
+// RequestCode generates a code for a normalised phone number and sends it by SMS
+func (s *PhoneOTPService) RequestCode(phone, purpose string) error {
+	now := time.Now()
+
+	// Rate limit per phone number: one code per minute, a handful per hour
+	var recent []models.PhoneOTP
+	if err := s.db.Where("phone = ? AND created_at > ?", phone, now.Add(-time.Hour)).
+		Order("created_at DESC").
+		Find(&recent).Error; err != nil {
+		return err
+	}
+	if len(recent) >= otpHourlyLimit {
+		return ErrOTPRateLimited
+	}
+	if len(recent) > 0 && now.Sub(recent[0].CreatedAt) < otpResendAfter {
+		return ErrOTPRateLimited
+	}
+
+	code, err := randomDigits(otpLength)
+	if err != nil {
+		return fmt.Errorf("failed to generate code: %w", err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash code: %w", err)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// A new code replaces any outstanding one for the same purpose
+		if err := tx.Model(&models.PhoneOTP{}).
+			Where("phone = ? AND purpose = ? AND consumed_at IS NULL", phone, purpose).
+			Update("consumed_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PhoneOTP{
+			Phone:     phone,
+			Purpose:   purpose,
+			CodeHash:  string(hash),
+			ExpiresAt: now.Add(otpTTL),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Your TrustMall code is %s. It expires in %d minutes. Do not share it.", code, int(otpTTL.Minutes()))
+	return s.sender.Send(phone, message)
+}
+
+// VerifyCode checks and consumes the latest outstanding code for the phone number
+func (s *PhoneOTPService) VerifyCode(phone, purpose, code string) error {
+	var otp models.PhoneOTP
+	err := s.db.Where("phone = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > ?", phone, purpose, time.Now()).
+		Order("created_at DESC").
+		First(&otp).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrOTPInvalid
+		}
+		return err
+	}
+
+	// Count the attempt before checking it, in one conditional update, so
+	// concurrent guesses cannot get past the limit between check and write
+	attempt := s.db.Model(&models.PhoneOTP{}).
+		Where("id = ? AND consumed_at IS NULL AND attempts < ?", otp.ID, otpMaxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if attempt.Error != nil {
+		return attempt.Error
+	}
+	if attempt.RowsAffected == 0 {
+		return ErrOTPInvalid
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(otp.CodeHash), []byte(code)) != nil {
+		return ErrOTPInvalid
+	}
+
+	// Conditional update so a code can only be consumed once
+	result := s.db.Model(&models.PhoneOTP{}).
+		Where("id = ? AND consumed_at IS NULL", otp.ID).
+		Update("consumed_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOTPInvalid
+	}
+	return nil
+}
+
+func randomDigits(n int) (string, error) {
+	max := big.NewInt(10)
+	buf := make([]byte, n)
+	for i := range buf {
+		d, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		buf[i] = byte('0' + d.Int64())
+	}
+	return string(buf), nil
+}
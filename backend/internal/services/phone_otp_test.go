@@ -0,0 +1,158 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/testdb"
+)
+
+// recordingSender keeps the codes it was asked to send
+type recordingSender struct {
+	codes []string
+}
+
+var otpCodePattern = regexp.MustCompile(`\d{6}`)
+
+func (r *recordingSender) Send(to, message string) error {
+	r.codes = append(r.codes, otpCodePattern.FindString(message))
+	return nil
+}
+
+func (r *recordingSender) last() string {
+	return r.codes[len(r.codes)-1]
+}
+
+func newOTPService(t *testing.T) (*PhoneOTPService, *recordingSender, *gorm.DB) {
+	t.Helper()
+	db := testdb.Open(t, &models.PhoneOTP{})
+	sender := &recordingSender{}
+	return NewPhoneOTPService(db, sender), sender, db
+}
+
+const otpTestPhone = "254700000001"
+
+func TestPhoneOTPVerifyOnce(t *testing.T) {
+	otps, sender, _ := newOTPService(t)
+	if err := otps.RequestCode(otpTestPhone, OTPPurposeLogin); err != nil {
+		t.Fatal(err)
+	}
+	code := sender.last()
+
+	cases := []struct {
+		name    string
+		phone   string
+		purpose string
+		err     error
+	}{
+		{"another phone", "254700000002", OTPPurposeLogin, ErrOTPInvalid},
+		{"another purpose", otpTestPhone, OTPPurposeVerify, ErrOTPInvalid},
+		{"right code", otpTestPhone, OTPPurposeLogin, nil},
+		{"used again", otpTestPhone, OTPPurposeLogin, ErrOTPInvalid},
+	}
+	for _, tc := range cases {
+		if err := otps.VerifyCode(tc.phone, tc.purpose, code); !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestPhoneOTPExpires(t *testing.T) {
+	otps, sender, db := newOTPService(t)
+	otps.RequestCode(otpTestPhone, OTPPurposeLogin)
+	db.Model(&models.PhoneOTP{}).Where("phone = ?", otpTestPhone).Update("expires_at", time.Now().Add(-time.Second))
+
+	if err := otps.VerifyCode(otpTestPhone, OTPPurposeLogin, sender.last()); !errors.Is(err, ErrOTPInvalid) {
+		t.Errorf("expired code: err = %v; want ErrOTPInvalid", err)
+	}
+}
+
+func TestPhoneOTPNewCodeReplacesOld(t *testing.T) {
+	otps, sender, db := newOTPService(t)
+	otps.RequestCode(otpTestPhone, OTPPurposeLogin)
+	first := sender.last()
+	// Get past the resend wait
+	db.Model(&models.PhoneOTP{}).Where("phone = ?", otpTestPhone).Update("created_at", time.Now().Add(-otpResendAfter))
+	if err := otps.RequestCode(otpTestPhone, OTPPurposeLogin); err != nil {
+		t.Fatal(err)
+	}
+	second := sender.last()
+
+	if first != second {
+		if err := otps.VerifyCode(otpTestPhone, OTPPurposeLogin, first); !errors.Is(err, ErrOTPInvalid) {
+			t.Errorf("replaced code: err = %v; want ErrOTPInvalid", err)
+		}
+	}
+	if err := otps.VerifyCode(otpTestPhone, OTPPurposeLogin, second); err != nil {
+		t.Errorf("new code: %v", err)
+	}
+}
+
+func TestPhoneOTPRateLimit(t *testing.T) {
+	otps, _, db := newOTPService(t)
+	if err := otps.RequestCode(otpTestPhone, OTPPurposeLogin); err != nil {
+		t.Fatal(err)
+	}
+	if err := otps.RequestCode(otpTestPhone, OTPPurposeLogin); !errors.Is(err, ErrOTPRateLimited) {
+		t.Errorf("second code within a minute: err = %v; want ErrOTPRateLimited", err)
+	}
+
+	for i := 1; i < otpHourlyLimit; i++ {
+		db.Create(&models.PhoneOTP{Phone: otpTestPhone, Purpose: OTPPurposeLogin, CodeHash: "x", ExpiresAt: time.Now()})
+	}
+	// All within the hour, none within the resend wait
+	db.Model(&models.PhoneOTP{}).Where("phone = ?", otpTestPhone).Update("created_at", time.Now().Add(-10*time.Minute))
+	if err := otps.RequestCode(otpTestPhone, OTPPurposeLogin); !errors.Is(err, ErrOTPRateLimited) {
+		t.Errorf("code over the hourly limit: err = %v; want ErrOTPRateLimited", err)
+	}
+}
+
+func TestPhoneOTPLocksAfterMaxAttempts(t *testing.T) {
+	otps, sender, _ := newOTPService(t)
+	otps.RequestCode(otpTestPhone, OTPPurposeLogin)
+	code := sender.last()
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	for i := 0; i < otpMaxAttempts; i++ {
+		if err := otps.VerifyCode(otpTestPhone, OTPPurposeLogin, wrong); !errors.Is(err, ErrOTPInvalid) {
+			t.Fatalf("wrong code %d: err = %v; want ErrOTPInvalid", i+1, err)
+		}
+	}
+	if err := otps.VerifyCode(otpTestPhone, OTPPurposeLogin, code); !errors.Is(err, ErrOTPInvalid) {
+		t.Errorf("right code after %d wrong ones: err = %v; want ErrOTPInvalid", otpMaxAttempts, err)
+	}
+}
+
+func TestPhoneOTPConcurrentGuessesStayWithinLimit(t *testing.T) {
+	otps, sender, db := newOTPService(t)
+	otps.RequestCode(otpTestPhone, OTPPurposeLogin)
+	wrong := "000000"
+	if sender.last() == wrong {
+		wrong = "111111"
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4*otpMaxAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			otps.VerifyCode(otpTestPhone, OTPPurposeLogin, wrong)
+		}()
+	}
+	wg.Wait()
+
+	var otp models.PhoneOTP
+	db.Where("phone = ?", otpTestPhone).First(&otp)
+	if otp.Attempts != otpMaxAttempts {
+		t.Errorf("attempts = %d; want %d", otp.Attempts, otpMaxAttempts)
+	}
+}
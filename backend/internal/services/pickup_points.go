@@ -0,0 +1,376 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"trumall/internal/geo"
+	"trumall/internal/models"
+)
+
+// Shipping method types
+const (
+	ShippingTypeDelivery = "delivery"
+	ShippingTypePickup   = "pickup"
+)
+
+// Nearest pickup point search limits
+const (
+	DefaultPickupSearchRadiusKm = 10.0
+	MaxPickupSearchRadiusKm     = 100.0
+	DefaultPickupSearchLimit    = 10
+	MaxPickupSearchLimit        = 50
+)
+
+// pickupUnpaidHoldWindow is how long an unpaid order keeps its slot at a pickup
+// point; abandoned checkouts must not fill a point up
+const pickupUnpaidHoldWindow = 24 * time.Hour
+
+// pickupHeldStatuses are the order statuses whose parcels occupy a pickup point
+var pickupHeldStatuses = []string{"paid", "processing", "shipped", "ready_for_pickup"}
+
+var (
+	ErrPickupPointNotFound    = errors.New("pickup point not found")
+	ErrPickupPointInactive    = errors.New("pickup point is not currently accepting orders")
+	ErrPickupPointUnsupported = errors.New("this store does not deliver to the selected pickup point")
+	ErrPickupPointFull        = errors.New("pickup point is at capacity, please choose another")
+	ErrPickupPointCodeTaken   = errors.New("a pickup point with this code already exists")
+	ErrPickupPointInUse       = errors.New("pickup point has orders and cannot be deleted; deactivate it instead")
+	ErrPickupPointRequired    = errors.New("pickup_point_id is required for pickup shipping methods")
+	ErrAddressRequired        = errors.New("address_id is required for delivery shipping methods")
+)
+
+var pickupDays = map[string]bool{"mon": true, "tue": true, "wed": true, "thu": true, "fri": true, "sat": true, "sun": true}
+
+var openingHoursPattern = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d-([01]\d|2[0-3]):[0-5]\d$`)
+
+type PickupPointService struct {
+	db *gorm.DB
+}
+
+func NewPickupPointService(db *gorm.DB) *PickupPointService {
+	return &PickupPointService{db: db}
+}
+
+// PickupPointInput is the admin-editable part of a pickup point
+type PickupPointInput struct {
+	Name         string            `json:"name"`
+	Code         string            `json:"code"`
+	Street       string            `json:"street"`
+	City         string            `json:"city"`
+	State        string            `json:"state"`
+	Country      string            `json:"country"`
+	PostalCode   string            `json:"postal_code"`
+	Latitude     float64           `json:"latitude"`
+	Longitude    float64           `json:"longitude"`
+	Phone        *string           `json:"phone"`
+	OpeningHours map[string]string `json:"opening_hours"` // day (mon..sun) -> "HH:MM-HH:MM" or "closed"
+	Capacity     int               `json:"capacity"`
+	StoreIDs     []uuid.UUID       `json:"store_ids"`
+	IsActive     *bool             `json:"is_active"`
+}
+
+// NearbyPickupPoint is a search result with its distance and free capacity
+type NearbyPickupPoint struct {
+	models.PickupPoint
+	DistanceKm float64 `json:"distance_km"`
+	// SlotsAvailable is nil when the point has no capacity limit
+	SlotsAvailable *int `json:"slots_available,omitempty"`
+}
+
+// ValidatePickupPoint checks an admin's pickup point before it is saved
+func ValidatePickupPoint(in PickupPointInput) error {
+	if strings.TrimSpace(in.Name) == "" || strings.TrimSpace(in.Code) == "" {
+		return errors.New("name and code are required")
+	}
+	if strings.TrimSpace(in.Street) == "" || strings.TrimSpace(in.City) == "" {
+		return errors.New("street and city are required")
+	}
+	// Pickup points are found by coordinates, so they must have real ones
+	if !geo.HasCoordinates(in.Latitude, in.Longitude) ||
+		in.Latitude < -90 || in.Latitude > 90 || in.Longitude < -180 || in.Longitude > 180 {
+		return errors.New("valid latitude and longitude are required")
+	}
+	if in.Capacity < 0 {
+		return errors.New("capacity cannot be negative")
+	}
+	for day, hours := range in.OpeningHours {
+		if !pickupDays[day] {
+			return fmt.Errorf("opening_hours: unknown day %q, use mon..sun", day)
+		}
+		if hours == "closed" {
+			continue
+		}
+		if !openingHoursPattern.MatchString(hours) || hours[:5] >= hours[6:] {
+			return fmt.Errorf("opening_hours.%s: expected HH:MM-HH:MM or closed", day)
+		}
+	}
+	return nil
+}
+
+// apply copies validated input onto a pickup point
+func (in PickupPointInput) apply(p *models.PickupPoint) error {
+	p.Name = strings.TrimSpace(in.Name)
+	p.Code = strings.ToUpper(strings.TrimSpace(in.Code))
+	p.Street = in.Street
+	p.City = in.City
+	p.State = in.State
+	p.Country = in.Country
+	if p.Country == "" {
+		p.Country = "Kenya"
+	}
+	p.PostalCode = in.PostalCode
+	p.Latitude = in.Latitude
+	p.Longitude = in.Longitude
+	p.Phone = in.Phone
+	p.Capacity = in.Capacity
+	if in.IsActive != nil {
+		p.IsActive = *in.IsActive
+	}
+
+	p.OpeningHours = nil
+	if len(in.OpeningHours) > 0 {
+		raw, err := json.Marshal(in.OpeningHours)
+		if err != nil {
+			return err
+		}
+		hours := string(raw)
+		p.OpeningHours = &hours
+	}
+
+	p.StoreIDs = pq.StringArray{}
+	for _, id := range in.StoreIDs {
+		p.StoreIDs = append(p.StoreIDs, id.String())
+	}
+	return nil
+}
+
+// Create adds a pickup point; new points are active unless stated otherwise
+func (s *PickupPointService) Create(in PickupPointInput) (*models.PickupPoint, error) {
+	if err := ValidatePickupPoint(in); err != nil {
+		return nil, err
+	}
+	point := models.PickupPoint{IsActive: true}
+	if err := in.apply(&point); err != nil {
+		return nil, err
+	}
+	if err := s.checkCodeFree(point.Code, uuid.Nil); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(&point).Error; err != nil {
+		return nil, err
+	}
+	return &point, nil
+}
+
+// Update replaces a pickup point's details
+func (s *PickupPointService) Update(id uuid.UUID, in PickupPointInput) (*models.PickupPoint, error) {
+	if err := ValidatePickupPoint(in); err != nil {
+		return nil, err
+	}
+	point, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := in.apply(point); err != nil {
+		return nil, err
+	}
+	if err := s.checkCodeFree(point.Code, point.ID); err != nil {
+		return nil, err
+	}
+	if err := s.db.Save(point).Error; err != nil {
+		return nil, err
+	}
+	return point, nil
+}
+
+func (s *PickupPointService) checkCodeFree(code string, exceptID uuid.UUID) error {
+	var n int64
+	if err := s.db.Model(&models.PickupPoint{}).Where("code = ? AND id <> ?", code, exceptID).Count(&n).Error; err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrPickupPointCodeTaken
+	}
+	return nil
+}
+
+// Delete removes a pickup point no order has been sent to
+func (s *PickupPointService) Delete(id uuid.UUID) error {
+	var orders int64
+	if err := s.db.Model(&models.Order{}).Where("pickup_point_id = ?", id).Count(&orders).Error; err != nil {
+		return err
+	}
+	if orders > 0 {
+		return ErrPickupPointInUse
+	}
+	res := s.db.Delete(&models.PickupPoint{}, "id = ?", id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrPickupPointNotFound
+	}
+	return nil
+}
+
+// Get returns a pickup point, active or not
+func (s *PickupPointService) Get(id uuid.UUID) (*models.PickupPoint, error) {
+	var point models.PickupPoint
+	if err := s.db.First(&point, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPickupPointNotFound
+		}
+		return nil, err
+	}
+	return &point, nil
+}
+
+// List returns all pickup points for the admin, by city then name
+func (s *PickupPointService) List() ([]models.PickupPoint, error) {
+	var points []models.PickupPoint
+	if err := s.db.Order("city ASC, name ASC").Find(&points).Error; err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// Nearest returns active pickup points within radiusKm of a location, closest
+// first. With a store ID, only points that store delivers to are returned.
+func (s *PickupPointService) Nearest(lat, lng float64, storeID *uuid.UUID, radiusKm float64, limit int) ([]NearbyPickupPoint, error) {
+	if radiusKm <= 0 {
+		radiusKm = DefaultPickupSearchRadiusKm
+	}
+	radiusKm = math.Min(radiusKm, MaxPickupSearchRadiusKm)
+	if limit <= 0 {
+		limit = DefaultPickupSearchLimit
+	}
+	if limit > MaxPickupSearchLimit {
+		limit = MaxPickupSearchLimit
+	}
+
+	// Narrow to a bounding box in SQL, then measure exactly
+	latDelta := radiusKm / 111.0
+	lngDelta := radiusKm / (111.0 * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+	query := s.db.Where("is_active = ?", true).
+		Where("latitude BETWEEN ? AND ?", lat-latDelta, lat+latDelta).
+		Where("longitude BETWEEN ? AND ?", lng-lngDelta, lng+lngDelta)
+	if storeID != nil {
+		query = query.Where("cardinality(store_ids) = 0 OR ? = ANY(store_ids)", storeID.String())
+	}
+	var points []models.PickupPoint
+	if err := query.Find(&points).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]NearbyPickupPoint, 0, len(points))
+	for _, p := range points {
+		km := geo.DistanceKm(lat, lng, p.Latitude, p.Longitude)
+		if km > radiusKm {
+			continue
+		}
+		results = append(results, NearbyPickupPoint{PickupPoint: p, DistanceKm: math.Round(km*10) / 10})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].DistanceKm < results[j].DistanceKm })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	for i := range results {
+		if results[i].Capacity == 0 {
+			continue
+		}
+		held, err := heldParcels(s.db, results[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		free := results[i].Capacity - int(held)
+		if free < 0 {
+			free = 0
+		}
+		results[i].SlotsAvailable = &free
+	}
+	return results, nil
+}
+
+// CheckPickupAvailability makes sure an order from storeID can be sent to the point.
+// With lock set (inside a transaction) the point row is locked, so concurrent
+// checkouts cannot both take its last slot.
+func CheckPickupAvailability(tx *gorm.DB, pointID, storeID uuid.UUID, lock bool) (*models.PickupPoint, error) {
+	query := tx
+	if lock {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	var point models.PickupPoint
+	if err := query.First(&point, "id = ?", pointID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPickupPointNotFound
+		}
+		return nil, err
+	}
+	if !point.IsActive {
+		return nil, ErrPickupPointInactive
+	}
+	if !pickupPointServesStore(point, storeID) {
+		return nil, ErrPickupPointUnsupported
+	}
+	if point.Capacity > 0 {
+		held, err := heldParcels(tx, point.ID)
+		if err != nil {
+			return nil, err
+		}
+		if held >= int64(point.Capacity) {
+			return nil, ErrPickupPointFull
+		}
+	}
+	return &point, nil
+}
+
+func pickupPointServesStore(point models.PickupPoint, storeID uuid.UUID) bool {
+	if len(point.StoreIDs) == 0 {
+		return true
+	}
+	for _, id := range point.StoreIDs {
+		if id == storeID.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// heldParcels counts orders waiting at or on their way to a pickup point
+func heldParcels(db *gorm.DB, pointID uuid.UUID) (int64, error) {
+	var n int64
+	err := db.Model(&models.Order{}).
+		Where("pickup_point_id = ?", pointID).
+		Where("status IN ? OR (status = ? AND created_at > ?)",
+			pickupHeldStatuses, "pending", time.Now().Add(-pickupUnpaidHoldWindow)).
+		Count(&n).Error
+	return n, err
+}
+
+// pickupDestination is the address a pickup order is shipped to
+func pickupDestination(p models.PickupPoint) models.Address {
+	return models.Address{
+		ID:         p.ID,
+		Label:      p.Name,
+		Street:     p.Street,
+		City:       p.City,
+		State:      p.State,
+		Country:    p.Country,
+		PostalCode: p.PostalCode,
+		Latitude:   p.Latitude,
+		Longitude:  p.Longitude,
+	}
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+func TestValidatePickupPoint(t *testing.T) {
+	valid := PickupPointInput{Name: "Agent", Code: "NBO-01", Street: "Moi Avenue", City: "Nairobi", Latitude: -1.28, Longitude: 36.82}
+	with := func(change func(*PickupPointInput)) PickupPointInput {
+		in := valid
+		change(&in)
+		return in
+	}
+
+	cases := []struct {
+		name string
+		in   PickupPointInput
+		ok   bool
+	}{
+		{"valid", valid, true},
+		{"opening hours", with(func(in *PickupPointInput) {
+			in.OpeningHours = map[string]string{"mon": "08:00-18:00", "sun": "closed"}
+		}), true},
+		{"no code", with(func(in *PickupPointInput) { in.Code = " " }), false},
+		{"no city", with(func(in *PickupPointInput) { in.City = "" }), false},
+		{"no coordinates", with(func(in *PickupPointInput) { in.Latitude, in.Longitude = 0, 0 }), false},
+		{"latitude out of range", with(func(in *PickupPointInput) { in.Latitude = 91 }), false},
+		{"negative capacity", with(func(in *PickupPointInput) { in.Capacity = -1 }), false},
+		{"unknown day", with(func(in *PickupPointInput) { in.OpeningHours = map[string]string{"monday": "08:00-18:00"} }), false},
+		{"closes before it opens", with(func(in *PickupPointInput) { in.OpeningHours = map[string]string{"mon": "18:00-08:00"} }), false},
+		{"not a time", with(func(in *PickupPointInput) { in.OpeningHours = map[string]string{"mon": "8am-6pm"} }), false},
+	}
+	for _, tc := range cases {
+		if err := ValidatePickupPoint(tc.in); (err == nil) != tc.ok {
+			t.Errorf("%s: err = %v; want ok = %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestPickupPointCodesAndDeletion(t *testing.T) {
+	db := openShippingDB(t)
+	points := NewPickupPointService(db)
+	in := PickupPointInput{Name: "Agent", Code: " nbo-01 ", Street: "Moi Avenue", City: "Nairobi", Latitude: -1.28, Longitude: 36.82}
+
+	point, err := points.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if point.Code != "NBO-01" || !point.IsActive || point.Country != "Kenya" {
+		t.Errorf("created %q, active %v, in %q; want NBO-01, active, in Kenya", point.Code, point.IsActive, point.Country)
+	}
+	in.Code = "NBO-01"
+	if _, err := points.Create(in); !errors.Is(err, ErrPickupPointCodeTaken) {
+		t.Errorf("second point with the code: err = %v; want ErrPickupPointCodeTaken", err)
+	}
+	if _, err := points.Update(point.ID, in); err != nil {
+		t.Errorf("updating a point keeps its own code: %v", err)
+	}
+
+	order := newOrder(t, db, uuid.New(), "delivered", 1)
+	db.Model(&order).Update("pickup_point_id", point.ID)
+	if err := points.Delete(point.ID); !errors.Is(err, ErrPickupPointInUse) {
+		t.Errorf("deleting a point with orders: err = %v; want ErrPickupPointInUse", err)
+	}
+	if err := points.Delete(uuid.New()); !errors.Is(err, ErrPickupPointNotFound) {
+		t.Errorf("deleting a missing point: err = %v; want ErrPickupPointNotFound", err)
+	}
+}
+
+// newPickupPoint creates an active pickup point in Nairobi
+func newPickupPoint(t *testing.T, db *gorm.DB, code string, capacity int, storeIDs ...string) models.PickupPoint {
+	t.Helper()
+	point := models.PickupPoint{Name: code, Code: code, Street: "Moi Avenue", City: "Nairobi", Country: "Kenya",
+		Latitude: -1.28, Longitude: 36.82, Capacity: capacity, StoreIDs: pq.StringArray(storeIDs), IsActive: true}
+	mustCreate(t, db, &point)
+	return point
+}
+
+// holdAt creates an order in status for the pickup point, placed age ago
+func holdAt(t *testing.T, db *gorm.DB, point models.PickupPoint, status string, age time.Duration) {
+	t.Helper()
+	order := newOrder(t, db, uuid.New(), status, 1)
+	db.Model(&order).Updates(map[string]any{"pickup_point_id": point.ID, "created_at": time.Now().Add(-age)})
+}
+
+func TestCheckPickupAvailability(t *testing.T) {
+	db := openShippingDB(t)
+	store := uuid.New()
+
+	closed := newPickupPoint(t, db, "CLOSED", 0)
+	db.Model(&closed).Update("is_active", false)
+	full := newPickupPoint(t, db, "FULL", 2)
+	holdAt(t, db, full, "paid", 48*time.Hour)
+	holdAt(t, db, full, "pending", time.Hour)
+	room := newPickupPoint(t, db, "ROOM", 2)
+	holdAt(t, db, room, "ready_for_pickup", 48*time.Hour)
+	holdAt(t, db, room, "pending", pickupUnpaidHoldWindow+time.Hour)
+	holdAt(t, db, room, "delivered", time.Hour)
+	holdAt(t, db, room, "cancelled", time.Hour)
+
+	cases := []struct {
+		name  string
+		point uuid.UUID
+		err   error
+	}{
+		{"open to all stores", newPickupPoint(t, db, "ALL", 0).ID, nil},
+		{"open to this store", newPickupPoint(t, db, "OURS", 0, uuid.NewString(), store.String()).ID, nil},
+		{"only other stores", newPickupPoint(t, db, "THEIRS", 0, uuid.NewString()).ID, ErrPickupPointUnsupported},
+		{"inactive", closed.ID, ErrPickupPointInactive},
+		{"missing", uuid.New(), ErrPickupPointNotFound},
+		{"at capacity", full.ID, ErrPickupPointFull},
+		{"collected and stale orders free their slots", room.ID, nil},
+	}
+	for _, tc := range cases {
+		if _, err := CheckPickupAvailability(db, tc.point, store, false); !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestQuoteToPickupPoint(t *testing.T) {
+	db := openShippingDB(t)
+	store := newShippingStore(t, db)
+	address := newAddress(t, db, "Mombasa", "Kenya")
+	newCountryZone(t, db, "Kenya", 200)
+	newMethod(t, db, "standard", 500)
+	pickup := newMethod(t, db, "pickup", 150)
+	db.Model(&pickup).Update("type", ShippingTypePickup)
+	point := newPickupPoint(t, db, "NBO-01", 1)
+	shipping := NewShippingService(db)
+
+	req := QuoteRequest{UserID: address.UserID, StoreID: store.ID, PickupPointID: &point.ID, MethodCode: "pickup", CartTotalCents: 4000}
+	calc, err := shipping.Quote(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Priced like an address: the point is in the warehouse's city, so 20% off
+	if calc.ShippingCostCents != 280 {
+		t.Errorf("pickup cost = %d; want 280", calc.ShippingCostCents)
+	}
+
+	cases := []struct {
+		name string
+		req  QuoteRequest
+		err  error
+	}{
+		{"pickup method without a point", QuoteRequest{UserID: address.UserID, StoreID: store.ID, AddressID: address.ID, MethodCode: "pickup"}, ErrPickupPointRequired},
+		{"delivery method to a point", QuoteRequest{UserID: address.UserID, StoreID: store.ID, PickupPointID: &point.ID, MethodCode: "standard"}, ErrAddressRequired},
+	}
+	for _, tc := range cases {
+		if _, err := shipping.Quote(tc.req); !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+		}
+	}
+
+	// Capacity is checked on every quote, cached or not
+	holdAt(t, db, point, "paid", time.Hour)
+	if _, err := shipping.Quote(req); !errors.Is(err, ErrPickupPointFull) {
+		t.Errorf("quote once the point filled up: err = %v; want ErrPickupPointFull", err)
+	}
+}
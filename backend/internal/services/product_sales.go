@@ -0,0 +1,295 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"trumall/internal/models"
+)
+
+// Sale statuses, as reported to sellers
+const (
+	SaleStatusScheduled = "scheduled"
+	SaleStatusActive    = "active"
+	SaleStatusSoldOut   = "sold_out"
+	SaleStatusEnded     = "ended"
+)
+
+// saleUnpaidHoldWindow is how long an unpaid order keeps its units of a flash
+// sale; abandoned checkouts must not sell a flash sale out
+const saleUnpaidHoldWindow = 24 * time.Hour
+
+// saleHeldStatuses are the order statuses whose units count as sold
+var saleHeldStatuses = []string{"paid", "processing", "shipped", "ready_for_pickup", "delivered"}
+
+// saleSoldSQL sums the units sold of the product_sales row in the outer query
+const saleSoldSQL = `SELECT COALESCE(SUM(order_items.quantity), 0) FROM order_items
+	JOIN orders ON orders.id = order_items.order_id
+	WHERE order_items.sale_id = product_sales.id
+	AND (orders.status IN ? OR (orders.status = ? AND orders.created_at > ?))`
+
+var (
+	ErrSaleNotFound = errors.New("sale not found")
+	ErrSaleOverlaps = errors.New("the product already has a sale during this period")
+	ErrSaleEnded    = errors.New("a sale in your cart has ended or changed; review your cart before checking out")
+	ErrSaleSoldOut  = errors.New("a flash sale in your cart has sold out")
+)
+
+type ProductSaleService struct {
+	db *gorm.DB
+}
+
+func NewProductSaleService(db *gorm.DB) *ProductSaleService {
+	return &ProductSaleService{db: db}
+}
+
+// ProductSaleInput is the seller-editable part of a sale. Fields left out of
+// an update keep their current value; a zero quantity_limit removes the limit.
+type ProductSaleInput struct {
+	Name           *string    `json:"name"`
+	SalePriceCents *int64     `json:"sale_price_cents"`
+	StartsAt       *time.Time `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at"`
+	QuantityLimit  *int       `json:"quantity_limit"`
+}
+
+// Apply copies the fields that were sent onto sale
+func (in ProductSaleInput) Apply(sale *models.ProductSale) {
+	if in.Name != nil {
+		sale.Name = optionalString(*in.Name)
+	}
+	setValue(&sale.SalePriceCents, in.SalePriceCents)
+	setValue(&sale.StartsAt, in.StartsAt)
+	setValue(&sale.EndsAt, in.EndsAt)
+	setOptional(&sale.QuantityLimit, in.QuantityLimit, in.QuantityLimit != nil && *in.QuantityLimit == 0)
+}
+
+// SaleSummary is a sale with how far it has got
+type SaleSummary struct {
+	models.ProductSale
+	Status       string `json:"status"`
+	SoldQuantity int64  `json:"sold_quantity"`
+}
+
+// ActiveSales narrows a product_sales query to the sales on now: started, not
+// ended and, for flash sales, not sold out. Use it to preload Product.Sales.
+func ActiveSales(db *gorm.DB) *gorm.DB {
+	now := time.Now()
+	return db.Where("product_sales.starts_at <= ? AND product_sales.ends_at > ?", now, now).
+		Where("product_sales.quantity_limit IS NULL OR product_sales.quantity_limit > ("+saleSoldSQL+")",
+			saleHeldStatuses, "pending", now.Add(-saleUnpaidHoldWindow))
+}
+
+// CurrentPrice is the price a product sells at, with the sale that sets it, if
+// any. It relies on the product's Sales having been preloaded with
+// ActiveSales, so one request prices the product the same way throughout;
+// without them the regular price is returned.
+func CurrentPrice(p models.Product) (int64, *models.ProductSale) {
+	price := p.PriceCents
+	var current *models.ProductSale
+	for i, sale := range p.Sales {
+		if sale.SalePriceCents < price {
+			price = sale.SalePriceCents
+			current = &p.Sales[i]
+		}
+	}
+	return price, current
+}
+
+// CurrentPriceCents is the price a product sells at; see CurrentPrice
+func CurrentPriceCents(p models.Product) int64 {
+	price, _ := CurrentPrice(p)
+	return price
+}
+
+// ReserveSales checks, inside a checkout transaction, that the sales pricing
+// the cart are still on, at the same price, and have enough units left. The
+// sale rows stay locked until tx ends, so concurrent checkouts cannot oversell
+// a flash sale.
+func ReserveSales(tx *gorm.DB, cart []models.CartItem) error {
+	wanted := map[uuid.UUID]int{}
+	prices := map[uuid.UUID]int64{}
+	for _, item := range cart {
+		if _, sale := CurrentPrice(item.Product); sale != nil {
+			wanted[sale.ID] += item.Quantity
+			prices[sale.ID] = sale.SalePriceCents
+		}
+	}
+
+	// Lock in a fixed order so two checkouts cannot deadlock
+	ids := make([]uuid.UUID, 0, len(wanted))
+	for id := range wanted {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	for _, id := range ids {
+		var sale models.ProductSale
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&sale).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrSaleEnded
+			}
+			return err
+		}
+		now := time.Now()
+		if now.Before(sale.StartsAt) || !now.Before(sale.EndsAt) || sale.SalePriceCents != prices[id] {
+			return ErrSaleEnded
+		}
+		if sale.QuantityLimit == nil {
+			continue
+		}
+		sold, err := saleSold(tx, sale.ID)
+		if err != nil {
+			return err
+		}
+		if left := int64(*sale.QuantityLimit) - sold; left < int64(wanted[id]) {
+			if left <= 0 {
+				return ErrSaleSoldOut
+			}
+			return fmt.Errorf("%w: only %d left at the sale price", ErrSaleSoldOut, left)
+		}
+	}
+	return nil
+}
+
+// List returns all of a product's sales, newest first
+func (s *ProductSaleService) List(productID uuid.UUID) ([]SaleSummary, error) {
+	var sales []models.ProductSale
+	if err := s.db.Where("product_id = ?", productID).Order("starts_at DESC").Find(&sales).Error; err != nil {
+		return nil, err
+	}
+
+	summaries := make([]SaleSummary, 0, len(sales))
+	now := time.Now()
+	for _, sale := range sales {
+		sold, err := saleSold(s.db, sale.ID)
+		if err != nil {
+			return nil, err
+		}
+		summary := SaleSummary{ProductSale: sale, SoldQuantity: sold, Status: SaleStatusActive}
+		switch {
+		case now.Before(sale.StartsAt):
+			summary.Status = SaleStatusScheduled
+		case !now.Before(sale.EndsAt):
+			summary.Status = SaleStatusEnded
+		case sale.QuantityLimit != nil && sold >= int64(*sale.QuantityLimit):
+			summary.Status = SaleStatusSoldOut
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// Create schedules a sale for a product
+func (s *ProductSaleService) Create(product models.Product, in ProductSaleInput, createdBy uuid.UUID) (*models.ProductSale, error) {
+	sale := models.ProductSale{ProductID: product.ID, CreatedBy: &createdBy}
+	in.Apply(&sale)
+	if !sale.EndsAt.After(time.Now()) {
+		return nil, invalid("ends_at", "must be in the future")
+	}
+	if err := s.validate(sale, product); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(&sale).Error; err != nil {
+		return nil, err
+	}
+	return &sale, nil
+}
+
+// Update edits one of a product's sales
+func (s *ProductSaleService) Update(product models.Product, saleID uuid.UUID, in ProductSaleInput) (*models.ProductSale, error) {
+	sale, err := s.get(product.ID, saleID)
+	if err != nil {
+		return nil, err
+	}
+	in.Apply(sale)
+	if err := s.validate(*sale, product); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Save(sale).Error; err != nil {
+		return nil, err
+	}
+	return sale, nil
+}
+
+// Delete removes a scheduled sale. A sale that has started is ended instead,
+// so orders placed during it keep their link to it.
+func (s *ProductSaleService) Delete(productID, saleID uuid.UUID) error {
+	sale, err := s.get(productID, saleID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if now.Before(sale.StartsAt) {
+		return s.db.Delete(sale).Error
+	}
+	if now.Before(sale.EndsAt) {
+		return s.db.Model(sale).Update("ends_at", now).Error
+	}
+	return nil
+}
+
+func (s *ProductSaleService) get(productID, saleID uuid.UUID) (*models.ProductSale, error) {
+	var sale models.ProductSale
+	if err := s.db.Where("id = ? AND product_id = ?", saleID, productID).First(&sale).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSaleNotFound
+		}
+		return nil, err
+	}
+	return &sale, nil
+}
+
+func (s *ProductSaleService) validate(sale models.ProductSale, product models.Product) error {
+	if sale.SalePriceCents <= 0 {
+		return invalid("sale_price_cents", "must be greater than 0")
+	}
+	if sale.SalePriceCents >= product.PriceCents {
+		return invalid("sale_price_cents", "must be below the regular price of %d", product.PriceCents)
+	}
+	if sale.StartsAt.IsZero() {
+		return invalid("starts_at", "is required")
+	}
+	if !sale.EndsAt.After(sale.StartsAt) {
+		return invalid("ends_at", "must be after starts_at")
+	}
+	if sale.QuantityLimit != nil && *sale.QuantityLimit < 0 {
+		return invalid("quantity_limit", "must not be negative")
+	}
+
+	q := s.db.Model(&models.ProductSale{}).
+		Where("product_id = ? AND starts_at < ? AND ends_at > ?", sale.ProductID, sale.EndsAt, sale.StartsAt)
+	if sale.ID != uuid.Nil {
+		q = q.Where("id <> ?", sale.ID)
+	}
+	var n int64
+	if err := q.Count(&n).Error; err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrSaleOverlaps
+	}
+	return nil
+}
+
+// saleSold counts the units of a sale that hold
+func saleSold(db *gorm.DB, saleID uuid.UUID) (int64, error) {
+	var sold int64
+	err := db.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_items.sale_id = ?", saleID).
+		Where("orders.status IN ? OR (orders.status = ? AND orders.created_at > ?)",
+			saleHeldStatuses, "pending", time.Now().Add(-saleUnpaidHoldWindow)).
+		Select("COALESCE(SUM(order_items.quantity), 0)").
+		Scan(&sold).Error
+	return sold, err
+}
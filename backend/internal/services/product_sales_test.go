@@ -0,0 +1,214 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+// newSale puts product on sale from start to end, both relative to now
+func newSale(t *testing.T, db *gorm.DB, product models.Product, priceCents int64, start, end time.Duration, limit *int) models.ProductSale {
+	t.Helper()
+	now := time.Now()
+	sale := models.ProductSale{ProductID: product.ID, SalePriceCents: priceCents,
+		StartsAt: now.Add(start), EndsAt: now.Add(end), QuantityLimit: limit}
+	mustCreate(t, db, &sale)
+	return sale
+}
+
+// sellAt creates an order in status, placed age ago, holding quantity units
+// bought at the sale price
+func sellAt(t *testing.T, db *gorm.DB, sale models.ProductSale, status string, quantity int, age time.Duration) {
+	t.Helper()
+	order := newOrder(t, db, uuid.New(), status, 0)
+	db.Model(&order).UpdateColumn("created_at", time.Now().Add(-age))
+	mustCreate(t, db, &models.OrderItem{OrderID: order.ID, ProductID: sale.ProductID, SaleID: &sale.ID,
+		Quantity: quantity, UnitPriceCents: sale.SalePriceCents})
+}
+
+// onSale loads a product the way the storefront prices it
+func onSale(t *testing.T, db *gorm.DB, id uuid.UUID) models.Product {
+	t.Helper()
+	var product models.Product
+	if err := db.Preload("Sales", ActiveSales).First(&product, "id = ?", id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return product
+}
+
+func intPtr(v int) *int { return &v }
+
+func TestCurrentPrice(t *testing.T) {
+	db := openOrderDB(t)
+	day := 24 * time.Hour
+
+	cases := []struct {
+		name  string
+		setup func(models.Product)
+		price int64
+	}{
+		{"no sale", func(models.Product) {}, 1000},
+		{"running sale", func(p models.Product) {
+			newSale(t, db, p, 700, -2*day, -day, nil)
+			newSale(t, db, p, 800, -time.Hour, day, nil)
+			newSale(t, db, p, 600, day, 2*day, nil)
+		}, 800},
+		{"flash sale with units left", func(p models.Product) {
+			sale := newSale(t, db, p, 500, -time.Hour, day, intPtr(2))
+			sellAt(t, db, sale, "paid", 1, time.Hour)
+			sellAt(t, db, sale, "pending", 5, saleUnpaidHoldWindow+time.Hour)
+			sellAt(t, db, sale, "cancelled", 5, time.Hour)
+		}, 500},
+		{"flash sale sold out", func(p models.Product) {
+			sale := newSale(t, db, p, 500, -time.Hour, day, intPtr(2))
+			sellAt(t, db, sale, "delivered", 1, 48*time.Hour)
+			sellAt(t, db, sale, "pending", 1, time.Hour)
+		}, 1000},
+	}
+	for _, tc := range cases {
+		product := newProduct(t, db, 10, 1000)
+		tc.setup(product)
+		price, sale := CurrentPrice(onSale(t, db, product.ID))
+		if price != tc.price || (sale != nil) != (tc.price != 1000) {
+			t.Errorf("%s: price = %d with sale %v; want %d", tc.name, price, sale, tc.price)
+		}
+	}
+}
+
+func TestReserveSales(t *testing.T) {
+	db := openOrderDB(t)
+	buyer := uuid.New()
+	flash := newProduct(t, db, 10, 1000)
+	sale := newSale(t, db, flash, 500, -time.Hour, 24*time.Hour, intPtr(5))
+	sellAt(t, db, sale, "paid", 2, time.Hour)
+	sellAt(t, db, sale, "pending", 1, time.Hour)
+	sellAt(t, db, sale, "pending", 4, saleUnpaidHoldWindow+time.Hour)
+	sellAt(t, db, sale, "cancelled", 4, time.Hour)
+	regular := newProduct(t, db, 10, 1000)
+
+	repriced := onSale(t, db, flash.ID)
+	repriced.Sales[0].SalePriceCents = 450
+	ending := newProduct(t, db, 10, 1000)
+	ended := newSale(t, db, ending, 500, -time.Hour, 24*time.Hour, nil)
+	endingCart := cartOf(buyer, 1, onSale(t, db, ending.ID))
+	db.Model(&ended).Update("ends_at", time.Now().Add(-time.Minute))
+	removing := newProduct(t, db, 10, 1000)
+	removed := newSale(t, db, removing, 500, -time.Hour, 24*time.Hour, nil)
+	removedCart := cartOf(buyer, 1, onSale(t, db, removing.ID))
+	db.Delete(&removed)
+
+	cases := []struct {
+		name string
+		cart []models.CartItem
+		err  error
+	}{
+		{"within the units left", cartOf(buyer, 2, onSale(t, db, flash.ID)), nil},
+		{"over the units left", cartOf(buyer, 3, onSale(t, db, flash.ID)), ErrSaleSoldOut},
+		{"lines of the same sale add up", append(cartOf(buyer, 1, onSale(t, db, flash.ID)), cartOf(buyer, 2, onSale(t, db, flash.ID))...), ErrSaleSoldOut},
+		{"regular price", cartOf(buyer, 50, onSale(t, db, regular.ID)), nil},
+		{"sale price changed", cartOf(buyer, 1, repriced), ErrSaleEnded},
+		{"sale ended since", endingCart, ErrSaleEnded},
+		{"sale removed since", removedCart, ErrSaleEnded},
+	}
+	for _, tc := range cases {
+		if err := ReserveSales(db, tc.cart); !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+		}
+	}
+	if err := ReserveSales(db, cartOf(buyer, 3, onSale(t, db, flash.ID))); err == nil || !strings.Contains(err.Error(), "only 2 left") {
+		t.Errorf("err = %v; want it to say only 2 are left", err)
+	}
+
+	// Once a checkout takes the last units, the next one is refused
+	cart := cartOf(buyer, 2, onSale(t, db, flash.ID))
+	if err := ReserveSales(db, cart); err != nil {
+		t.Fatal(err)
+	}
+	sellAt(t, db, sale, "pending", 2, 0)
+	if err := ReserveSales(db, cart); !errors.Is(err, ErrSaleSoldOut) {
+		t.Errorf("checkout after the sale sold out: err = %v; want ErrSaleSoldOut", err)
+	}
+}
+
+func TestProductSaleService(t *testing.T) {
+	db := openOrderDB(t)
+	sales := NewProductSaleService(db)
+	product := newProduct(t, db, 10, 1000)
+	day := 24 * time.Hour
+	now := time.Now()
+	at := func(d time.Duration) *time.Time { v := now.Add(d); return &v }
+	price := func(v int64) *int64 { return &v }
+
+	booked, err := sales.Create(product, ProductSaleInput{SalePriceCents: price(800), StartsAt: at(day), EndsAt: at(3 * day)}, uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		in    ProductSaleInput
+		field string // of the problem; empty for ErrSaleOverlaps
+	}{
+		{"no price", ProductSaleInput{StartsAt: at(4 * day), EndsAt: at(5 * day)}, "sale_price_cents"},
+		{"not below the regular price", ProductSaleInput{SalePriceCents: price(1000), StartsAt: at(4 * day), EndsAt: at(5 * day)}, "sale_price_cents"},
+		{"no start", ProductSaleInput{SalePriceCents: price(800), EndsAt: at(5 * day)}, "starts_at"},
+		{"ends before it starts", ProductSaleInput{SalePriceCents: price(800), StartsAt: at(5 * day), EndsAt: at(4 * day)}, "ends_at"},
+		{"already over", ProductSaleInput{SalePriceCents: price(800), StartsAt: at(-2 * day), EndsAt: at(-day)}, "ends_at"},
+		{"negative limit", ProductSaleInput{SalePriceCents: price(800), StartsAt: at(4 * day), EndsAt: at(5 * day), QuantityLimit: intPtr(-1)}, "quantity_limit"},
+		{"overlaps another sale", ProductSaleInput{SalePriceCents: price(800), StartsAt: at(2 * day), EndsAt: at(5 * day)}, ""},
+	}
+	for _, tc := range cases {
+		_, err := sales.Create(product, tc.in, uuid.New())
+		var ve ValidationError
+		switch {
+		case tc.field == "" && !errors.Is(err, ErrSaleOverlaps):
+			t.Errorf("%s: err = %v; want ErrSaleOverlaps", tc.name, err)
+		case tc.field != "" && (!errors.As(err, &ve) || ve.Field != tc.field):
+			t.Errorf("%s: err = %v; want a problem with %s", tc.name, err, tc.field)
+		}
+	}
+
+	// A sale can be moved over its own dates, and a zero limit removes the limit
+	moved, err := sales.Update(product, booked.ID, ProductSaleInput{EndsAt: at(4 * day), QuantityLimit: intPtr(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.QuantityLimit != nil || !moved.EndsAt.Equal(*at(4 * day)) {
+		t.Errorf("updated sale ends %v with limit %v; want %v without a limit", moved.EndsAt, moved.QuantityLimit, *at(4 * day))
+	}
+
+	running := newSale(t, db, product, 900, -day, 12*time.Hour, intPtr(3))
+	sellAt(t, db, running, "paid", 3, time.Hour)
+	newSale(t, db, product, 900, -3*day, -2*day, nil)
+	summaries, err := sales.List(product.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, s := range summaries {
+		statuses = append(statuses, s.Status)
+	}
+	if strings.Join(statuses, ",") != "scheduled,sold_out,ended" || summaries[1].SoldQuantity != 3 {
+		t.Errorf("statuses = %v, sold %d; want scheduled, sold_out, ended, with 3 sold", statuses, summaries[1].SoldQuantity)
+	}
+
+	// Deleting a scheduled sale removes it; deleting a running one ends it
+	if err := sales.Delete(product.ID, booked.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := sales.Delete(product.ID, running.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sales.get(product.ID, booked.ID); !errors.Is(err, ErrSaleNotFound) {
+		t.Errorf("scheduled sale after deleting it: err = %v; want ErrSaleNotFound", err)
+	}
+	if ended, err := sales.get(product.ID, running.ID); err != nil || ended.EndsAt.After(time.Now()) {
+		t.Errorf("running sale after deleting it = %v, %v; want it ended", ended, err)
+	}
+}
@@ -0,0 +1,352 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/courier"
+	"trumall/internal/models"
+)
+
+// Order statuses driven by shipments
+const (
+	OrderStatusShipped        = "shipped"
+	OrderStatusReadyForPickup = "ready_for_pickup"
+	OrderStatusDelivered      = "delivered"
+)
+
+// orderStatusRank orders the fulfilment statuses; tracking only ever moves an
+// order forward, and leaves statuses outside this list (e.g. cancelled) alone
+var orderStatusRank = map[string]int{
+	"pending":                 0,
+	"paid":                    1,
+	"processing":              2,
+	OrderStatusShipped:        3,
+	OrderStatusReadyForPickup: 4,
+	OrderStatusDelivered:      5,
+}
+
+// shippableOrderStatuses are the statuses in which a seller can add a shipment
+var shippableOrderStatuses = map[string]bool{"paid": true, "processing": true, OrderStatusShipped: true}
+
+var (
+	ErrShipmentNotFound  = errors.New("shipment not found")
+	ErrOrderNotShippable = errors.New("only paid orders can be shipped")
+	ErrShipmentNotManual = errors.New("tracking for this shipment comes from its courier")
+	ErrInvalidShipStatus = errors.New("invalid shipment status")
+)
+
+type ShipmentService struct {
+	db       *gorm.DB
+	couriers *courier.Registry
+}
+
+func NewShipmentService(db *gorm.DB, couriers *courier.Registry) *ShipmentService {
+	return &ShipmentService{db: db, couriers: couriers}
+}
+
+// CreateShipmentInput is a seller's request to ship an order
+type CreateShipmentInput struct {
+	Courier        string `json:"courier"`
+	CarrierName    string `json:"carrier_name"`    // manual courier: who is carrying it
+	TrackingNumber string `json:"tracking_number"` // manual courier: the carrier's tracking number
+}
+
+// Create books an order's parcel with a courier and records the shipment
+func (s *ShipmentService) Create(ctx context.Context, orderID uuid.UUID, in CreateShipmentInput) (*models.Shipment, error) {
+	var order models.Order
+	if err := s.db.Preload("OrderItems.Product").Preload("ShippingAddress").Preload("PickupPoint").Preload("Buyer").
+		First(&order, "id = ?", orderID).Error; err != nil {
+		return nil, err
+	}
+	if !shippableOrderStatuses[order.Status] {
+		return nil, ErrOrderNotShippable
+	}
+
+	c, err := s.couriers.Get(in.Courier)
+	if err != nil {
+		return nil, err
+	}
+
+	var store models.Store
+	if err := s.db.First(&store, "id = ?", order.StoreID).Error; err != nil {
+		return nil, err
+	}
+
+	req := courier.ShipmentRequest{
+		Reference: order.ID.String(),
+		Origin: courier.Address{
+			Name:       store.Name,
+			Street:     store.WarehouseStreet,
+			City:       store.WarehouseCity,
+			State:      store.WarehouseState,
+			Country:    store.WarehouseCountry,
+			PostalCode: store.WarehousePostalCode,
+		},
+		Destination:    shipmentDestination(order),
+		WeightGrams:    ParcelForOrder(order.OrderItems).ChargeableWeightGrams(),
+		TrackingNumber: in.TrackingNumber,
+		CarrierName:    in.CarrierName,
+	}
+	booking, err := c.CreateShipment(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	status := booking.Status
+	if status == "" {
+		status = courier.StatusLabelCreated
+	}
+	shipment := models.Shipment{
+		OrderID:        order.ID,
+		StoreID:        order.StoreID,
+		CourierCode:    c.Code(),
+		CarrierName:    booking.CarrierName,
+		TrackingNumber: booking.TrackingNumber,
+		Status:         status,
+	}
+	if booking.ProviderRef != "" {
+		shipment.ProviderRef = &booking.ProviderRef
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&shipment).Error; err != nil {
+			return err
+		}
+		_, err := s.recordEvents(tx, &shipment, []courier.TrackingEvent{{
+			TrackingNumber: shipment.TrackingNumber,
+			Status:         status,
+			Description:    "Shipment created with " + shipment.CarrierName,
+			OccurredAt:     time.Now(),
+		}})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &shipment, nil
+}
+
+// shipmentDestination is the buyer's address, or the pickup point they chose
+func shipmentDestination(order models.Order) courier.Address {
+	dest := courier.Address{Name: order.Buyer.Name}
+	if order.Buyer.Phone != nil {
+		dest.Phone = *order.Buyer.Phone
+	}
+	switch {
+	case order.PickupPoint != nil:
+		dest.Name = order.PickupPoint.Name + " (for " + order.Buyer.Name + ")"
+		dest.Street = order.PickupPoint.Street
+		dest.City = order.PickupPoint.City
+		dest.State = order.PickupPoint.State
+		dest.Country = order.PickupPoint.Country
+		dest.PostalCode = order.PickupPoint.PostalCode
+	case order.ShippingAddress != nil:
+		dest.Street = order.ShippingAddress.Street
+		dest.City = order.ShippingAddress.City
+		dest.State = order.ShippingAddress.State
+		dest.Country = order.ShippingAddress.Country
+		dest.PostalCode = order.ShippingAddress.PostalCode
+	}
+	return dest
+}
+
+// Get returns a shipment with its events, newest first
+func (s *ShipmentService) Get(id uuid.UUID) (*models.Shipment, error) {
+	var shipment models.Shipment
+	err := s.db.Preload("Events", func(tx *gorm.DB) *gorm.DB { return tx.Order("occurred_at DESC") }).
+		First(&shipment, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrShipmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &shipment, nil
+}
+
+// ListForOrder returns an order's shipments with their events, newest first
+func (s *ShipmentService) ListForOrder(orderID uuid.UUID) ([]models.Shipment, error) {
+	var shipments []models.Shipment
+	err := s.db.Preload("Events", func(tx *gorm.DB) *gorm.DB { return tx.Order("occurred_at DESC") }).
+		Where("order_id = ?", orderID).Order("created_at ASC").Find(&shipments).Error
+	return shipments, err
+}
+
+// Label fetches a printable label from the shipment's courier
+func (s *ShipmentService) Label(ctx context.Context, shipment models.Shipment) (*courier.Label, error) {
+	c, err := s.couriers.Get(shipment.CourierCode)
+	if err != nil {
+		return nil, err
+	}
+	return c.Label(ctx, shipment.TrackingNumber)
+}
+
+// AddManualEvent records a seller's status update for a manual shipment
+func (s *ShipmentService) AddManualEvent(shipment *models.Shipment, status, description, location string) error {
+	if shipment.CourierCode != courier.ManualCode {
+		return ErrShipmentNotManual
+	}
+	if !courier.ValidStatus(status) {
+		return ErrInvalidShipStatus
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		_, err := s.recordEvents(tx, shipment, []courier.TrackingEvent{{
+			TrackingNumber: shipment.TrackingNumber,
+			Status:         status,
+			Description:    strings.TrimSpace(description),
+			Location:       strings.TrimSpace(location),
+			OccurredAt:     time.Now(),
+		}})
+		return err
+	})
+}
+
+// HandleWebhook verifies a courier's tracking webhook and applies its events.
+// Events for tracking numbers we don't know are logged and skipped, so the
+// courier doesn't keep retrying them. It returns how many events were new.
+func (s *ShipmentService) HandleWebhook(courierCode string, headers http.Header, body []byte) (int, error) {
+	c, err := s.couriers.Get(courierCode)
+	if err != nil {
+		return 0, err
+	}
+	events, err := c.ParseWebhook(headers, body)
+	if err != nil {
+		return 0, err
+	}
+
+	byTracking := map[string][]courier.TrackingEvent{}
+	for _, e := range events {
+		byTracking[e.TrackingNumber] = append(byTracking[e.TrackingNumber], e)
+	}
+
+	recorded := 0
+	for tracking, events := range byTracking {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			var shipment models.Shipment
+			err := tx.Where("courier_code = ? AND tracking_number = ?", courierCode, tracking).First(&shipment).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("Courier %s webhook: unknown tracking number %s, skipping %d events", courierCode, tracking, len(events))
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			n, err := s.recordEvents(tx, &shipment, events)
+			recorded += n
+			return err
+		})
+		if err != nil {
+			return recorded, fmt.Errorf("tracking %s: %w", tracking, err)
+		}
+	}
+	return recorded, nil
+}
+
+// recordEvents stores new tracking events, sets the shipment's status from the
+// latest one and moves the order along. Events already seen (same courier event
+// ID) are ignored, so redelivered webhooks are harmless. It returns how many
+// events were new.
+func (s *ShipmentService) recordEvents(tx *gorm.DB, shipment *models.Shipment, events []courier.TrackingEvent) (int, error) {
+	recorded := 0
+	for _, e := range events {
+		event := models.ShipmentEvent{
+			ShipmentID:  shipment.ID,
+			Status:      e.Status,
+			Description: e.Description,
+			Location:    e.Location,
+			OccurredAt:  e.OccurredAt,
+		}
+		if e.EventID != "" {
+			var seen int64
+			if err := tx.Model(&models.ShipmentEvent{}).
+				Where("shipment_id = ? AND event_id = ?", shipment.ID, e.EventID).Count(&seen).Error; err != nil {
+				return recorded, err
+			}
+			if seen > 0 {
+				continue
+			}
+			eventID := e.EventID
+			event.EventID = &eventID
+		}
+		if err := tx.Create(&event).Error; err != nil {
+			return recorded, err
+		}
+		recorded++
+	}
+	if recorded == 0 {
+		return 0, nil
+	}
+
+	// Webhooks can arrive out of order; the latest event wins
+	var latest models.ShipmentEvent
+	if err := tx.Where("shipment_id = ?", shipment.ID).Order("occurred_at DESC, created_at DESC").First(&latest).Error; err != nil {
+		return recorded, err
+	}
+	shipment.Status = latest.Status
+	switch latest.Status {
+	case courier.StatusPickedUp, courier.StatusInTransit, courier.StatusOutForDelivery:
+		if shipment.ShippedAt == nil {
+			shipment.ShippedAt = &latest.OccurredAt
+		}
+	case courier.StatusDelivered:
+		if shipment.ShippedAt == nil {
+			shipment.ShippedAt = &latest.OccurredAt
+		}
+		shipment.DeliveredAt = &latest.OccurredAt
+	}
+	if err := tx.Save(shipment).Error; err != nil {
+		return recorded, err
+	}
+	return recorded, advanceOrder(tx, shipment.OrderID)
+}
+
+// advanceOrder moves an order to shipped once any parcel is on its way, and to
+// delivered (or ready_for_pickup, for click-and-collect) once all have arrived
+func advanceOrder(tx *gorm.DB, orderID uuid.UUID) error {
+	var order models.Order
+	if err := tx.First(&order, "id = ?", orderID).Error; err != nil {
+		return err
+	}
+	current, tracked := orderStatusRank[order.Status]
+	if !tracked {
+		return nil
+	}
+
+	var shipments []models.Shipment
+	if err := tx.Where("order_id = ?", orderID).Find(&shipments).Error; err != nil {
+		return err
+	}
+	moving, delivered := 0, 0
+	for _, sh := range shipments {
+		if sh.ShippedAt != nil {
+			moving++
+		}
+		if sh.Status == courier.StatusDelivered {
+			delivered++
+		}
+	}
+
+	target := ""
+	switch {
+	case len(shipments) > 0 && delivered == len(shipments):
+		target = OrderStatusDelivered
+		if order.PickupPointID != nil {
+			target = OrderStatusReadyForPickup
+		}
+	case moving > 0:
+		target = OrderStatusShipped
+	}
+	if target == "" || orderStatusRank[target] <= current {
+		return nil
+	}
+	return tx.Model(&order).Update("status", target).Error
+}
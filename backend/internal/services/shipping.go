@@ -5,10 +5,7 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/google/uuid"
 	"gorm.io/gorm"
-
-	"trumall/internal/cache"
 	"trumall/internal/models"
 )
 
@@ -24,166 +21,103 @@ type ShippingCalculation struct {
 	MethodCode        string    `json:"method_code"`
 	MethodName        string    `json:"method_name"`
 	ShippingCostCents int64     `json:"shipping_cost_cents"`
-	EstimatedDelivery time.Time `json:"estimated_delivery"`
+	EstimatedDelivery time.Time `json:"estimated_delivery"` // latest expected date
 	DeliveryDaysMin   int       `json:"delivery_days_min"`
 	DeliveryDaysMax   int       `json:"delivery_days_max"`
 	IsFreeShipping    bool      `json:"is_free_shipping"`
+	// Business-day delivery range for an order placed now
+	DeliveryWindow *DeliveryWindow `json:"delivery_window,omitempty"`
+	// Weight pricing
+	ChargeableWeightGrams int   `json:"chargeable_weight_grams"`
+	WeightCostCents       int64 `json:"weight_cost_cents"`
+	// Distance pricing
+	PricingBasis string   `json:"pricing_basis"`         // zone | distance
+	DistanceKm   *float64 `json:"distance_km,omitempty"` // set when both ends have coordinates
+	// Itemised pricing steps and the signed quote to present at checkout
+	Breakdown      []QuoteLine `json:"breakdown,omitempty"`
+	QuoteID        string      `json:"quote_id,omitempty"`
+	QuoteExpiresAt *time.Time  `json:"quote_expires_at,omitempty"`
+}
+
+// UnavailableMethod explains why a shipping method cannot be used for a cart
+type UnavailableMethod struct {
+	MethodCode string `json:"method_code"`
+	MethodName string `json:"method_name"`
+	Reason     string `json:"reason"`
 }
 
-// CalculateShipping calculates shipping cost for a given address and method
-// Now includes seller origin (store warehouse) in calculation
-func (s *ShippingService) CalculateShipping(addressID uuid.UUID, methodCode string, cartTotalCents int64) (*ShippingCalculation, error) {
+// Quote prices one shipping method for a cart and returns a signed quote that
+// checkout can lock in
+func (s *ShippingService) Quote(req QuoteRequest) (*ShippingCalculation, error) {
 	// Check cache first
-	cacheKey := fmt.Sprintf("shipping:%s:%s:%d", addressID, methodCode, cartTotalCents/1000) // Cache by thousand to reduce key variations
-	if cachedValue, found := cache.ShippingCache.Get(cacheKey); found {
-		if calc, ok := cachedValue.(*ShippingCalculation); ok {
-			return calc, nil
+	// Quotes are binding, so the key uses the exact cart total: rule thresholds and
+	// order value limits must see the same amount checkout will charge
+	destination, destinationTag := "a:"+req.AddressID.String(), shippingAddressTag(req.AddressID.String())
+	if req.PickupPointID != nil {
+		destination, destinationTag = "p:"+req.PickupPointID.String(), shippingPickupTag(req.PickupPointID.String())
+		// Capacity changes with every order, so it is checked before the cache
+		if _, err := CheckPickupAvailability(s.db, *req.PickupPointID, req.StoreID, false); err != nil {
+			return nil, err
 		}
 	}
-
-	// Fetch buyer's destination address
-	var address models.Address
-	if err := s.db.First(&address, "id = ?", addressID).Error; err != nil {
-		return nil, fmt.Errorf("address not found: %w", err)
-	}
-
-	// Fetch shipping method
-	var method models.ShippingMethod
-	if err := s.db.Where("code = ? AND is_active = ?", methodCode, true).First(&method).Error; err != nil {
-		return nil, fmt.Errorf("shipping method not found or inactive: %w", err)
+	cacheKey := fmt.Sprintf("shipping:%s:%s:%s:%d:%d", req.StoreID, destination, req.MethodCode,
+		req.CartTotalCents, req.Parcel.ChargeableWeightGrams())
+	result, found := ShippingCache.Get(cacheKey)
+	if !found {
+		calc, err := s.price(req)
+		if err != nil {
+			return nil, err
+		}
+		result = *calc
+		// Cache the result for 1 hour
+		ShippingCache.Set(cacheKey, result, 1*time.Hour,
+			shippingTagRates, shippingStoreTag(req.StoreID.String()), destinationTag)
 	}
 
-	// Find matching shipping zone (prioritized by specificity)
-	zone, err := s.findMatchingZone(address)
+	// The cached price is shared; the estimate and quote are per request
+	window, err := s.EstimateDelivery(req.StoreID, result.MethodCode, result.DeliveryDaysMin, result.DeliveryDaysMax)
 	if err != nil {
-		return nil, fmt.Errorf("no shipping zone found for address: %w", err)
-	}
-
-	// Calculate base cost
-	totalCostCents := method.BaseCostCents + zone.AdditionalCostCents
-
-	// Check for shipping rules (overrides, free shipping thresholds, etc.)
-	var rule models.ShippingRule
-	err = s.db.Where("shipping_method_id = ? AND shipping_zone_id = ? AND is_available = ?",
-		method.ID, zone.ID, true).
-		Preload("ShippingMethod").
-		Preload("ShippingZone").
-		First(&rule).Error
-
-	isFreeShipping := false
-	if err == nil {
-		// Rule exists - apply it
-		if rule.CostOverrideCents != nil {
-			totalCostCents = *rule.CostOverrideCents
-		}
-
-		// Check for free shipping threshold
-		if rule.FreeShippingThresholdCents != nil && cartTotalCents >= *rule.FreeShippingThresholdCents {
-			totalCostCents = 0
-			isFreeShipping = true
-		}
-
-		// Validate order value constraints
-		if cartTotalCents < rule.MinOrderValueCents {
-			return nil, fmt.Errorf("order value below minimum for this shipping method")
-		}
-		if rule.MaxOrderValueCents != nil && cartTotalCents > *rule.MaxOrderValueCents {
-			return nil, fmt.Errorf("order value exceeds maximum for this shipping method")
-		}
+		return nil, err
 	}
-
-	// Calculate estimated delivery
-	estimatedDelivery := time.Now().Add(time.Duration(method.DeliveryDaysMax) * 24 * time.Hour)
-
-	result := &ShippingCalculation{
-		MethodCode:        method.Code,
-		MethodName:        method.Name,
-		ShippingCostCents: totalCostCents,
-		EstimatedDelivery: estimatedDelivery,
-		DeliveryDaysMin:   method.DeliveryDaysMin,
-		DeliveryDaysMax:   method.DeliveryDaysMax,
-		IsFreeShipping:    isFreeShipping,
+	result.DeliveryWindow = window
+	result.EstimatedDelivery = window.Latest
+	if err := s.signQuote(req, &result); err != nil {
+		return nil, err
 	}
-
-	// Cache the result for 1 hour
-	cache.ShippingCache.Set(cacheKey, result, 1*time.Hour)
-
-	return result, nil
+	return &result, nil
 }
 
-// GetAvailableShippingMethods returns all available shipping methods for an address
-func (s *ShippingService) GetAvailableShippingMethods(addressID uuid.UUID, cartTotalCents int64) ([]ShippingCalculation, error) {
-	var address models.Address
-	if err := s.db.First(&address, "id = ?", addressID).Error; err != nil {
-		return nil, fmt.Errorf("address not found: %w", err)
-	}
-
-	// Get all active shipping methods
-	var methods []models.ShippingMethod
-	if err := s.db.Where("is_active = ?", true).Find(&methods).Error; err != nil {
-		return nil, fmt.Errorf("failed to fetch shipping methods: %w", err)
+// QuoteAllMethods quotes every active shipping method for a cart, reporting why
+// any method cannot be used
+func (s *ShippingService) QuoteAllMethods(req QuoteRequest) ([]ShippingCalculation, []UnavailableMethod, error) {
+	methods, err := s.ListAllShippingMethods()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch shipping methods: %w", err)
 	}
 
 	var results []ShippingCalculation
+	var unavailable []UnavailableMethod
 	for _, method := range methods {
-		calc, err := s.CalculateShipping(addressID, method.Code, cartTotalCents)
+		req.MethodCode = method.Code
+		calc, err := s.Quote(req)
 		if err != nil {
-			// Skip methods that aren't available for this address/cart
+			unavailable = append(unavailable, UnavailableMethod{
+				MethodCode: method.Code,
+				MethodName: method.Name,
+				Reason:     err.Error(),
+			})
 			continue
 		}
 		results = append(results, *calc)
 	}
 
 	if len(results) == 0 {
-		return nil, errors.New("no shipping methods available for this address")
-	}
-
-	return results, nil
-}
-
-// findMatchingZone finds the most specific matching zone for an address
-func (s *ShippingService) findMatchingZone(address models.Address) (*models.ShippingZone, error) {
-	var zones []models.ShippingZone
-
-	// Try to find zone by city first (most specific)
-	if err := s.db.Where("country = ? AND city = ? AND is_active = ?",
-		address.Country, address.City, true).
-		Order("priority ASC").
-		Find(&zones).Error; err != nil {
-		return nil, err
-	}
-
-	if len(zones) > 0 {
-		return &zones[0], nil
-	}
-
-	// Try state/region level
-	if address.State != "" {
-		if err := s.db.Where("country = ? AND state = ? AND city IS NULL AND is_active = ?",
-			address.Country, address.State, true).
-			Order("priority ASC").
-			Find(&zones).Error; err != nil {
-			return nil, err
+		if req.PickupPointID != nil {
+			return nil, unavailable, errors.New("no shipping methods available for this pickup point")
 		}
-
-		if len(zones) > 0 {
-			return &zones[0], nil
-		}
-	}
-
-	// Fall back to country-level zone (least specific)
-	if err := s.db.Where("country = ? AND state IS NULL AND city IS NULL AND is_active = ?",
-		address.Country, true).
-		Order("priority ASC").
-		Find(&zones).Error; err != nil {
-		return nil, err
+		return nil, unavailable, errors.New("no shipping methods available for this address")
 	}
-
-	if len(zones) > 0 {
-		return &zones[0], nil
-	}
-
-	return nil, errors.New("no matching shipping zone found")
+	return results, unavailable, nil
 }
 
 // GetShippingMethod retrieves a shipping method by code
@@ -203,100 +137,3 @@ func (s *ShippingService) ListAllShippingMethods() ([]models.ShippingMethod, err
 	}
 	return methods, nil
 }
-
-// CalculateShippingWithOrigin calculates shipping cost considering seller warehouse location
-// This provides more accurate shipping estimates based on origin-destination distance
-func (s *ShippingService) CalculateShippingWithOrigin(storeID uuid.UUID, addressID uuid.UUID, methodCode string, cartTotalCents int64) (*ShippingCalculation, error) {
-	// Fetch store with warehouse location
-	var store models.Store
-	if err := s.db.First(&store, "id = ?", storeID).Error; err != nil {
-		return nil, fmt.Errorf("store not found: %w", err)
-	}
-
-	// Fetch buyer destination address
-	var destAddress models.Address
-	if err := s.db.First(&destAddress, "id = ?", addressID).Error; err != nil {
-		return nil, fmt.Errorf("address not found: %w", err)
-	}
-
-	// Fetch shipping method
-	var method models.ShippingMethod
-	if err := s.db.Where("code = ? AND is_active = ?", methodCode, true).First(&method).Error; err != nil {
-		return nil, fmt.Errorf("shipping method not found or inactive: %w", err)
-	}
-
-	// Find matching shipping zone based on destination
-	zone, err := s.findMatchingZone(destAddress)
-	if err != nil {
-		return nil, fmt.Errorf("no shipping zone found for address: %w", err)
-	}
-
-	// Calculate base cost
-	totalCostCents := method.BaseCostCents + zone.AdditionalCostCents
-
-	// If warehouse location is set, calculate distance-based adjustment
-	if store.WarehouseCity != "" && store.WarehouseCountry != "" {
-		// Check if origin and destination are in same city - reduce cost
-		if store.WarehouseCity == destAddress.City && store.WarehouseCountry == destAddress.Country {
-			// Same city delivery - 20% discount
-			totalCostCents = int64(float64(totalCostCents) * 0.8)
-		} else if store.WarehouseCountry == destAddress.Country {
-			// Same country but different city - use zone cost as is
-			// No adjustment needed
-		} else {
-			// International shipping - add 50% surcharge
-			totalCostCents = int64(float64(totalCostCents) * 1.5)
-		}
-	}
-
-	// Check for shipping rules (overrides, free shipping thresholds, etc.)
-	var rule models.ShippingRule
-	err = s.db.Where("shipping_method_id = ? AND shipping_zone_id = ? AND is_available = ?",
-		method.ID, zone.ID, true).
-		Preload("ShippingMethod").
-		Preload("ShippingZone").
-		First(&rule).Error
-
-	isFreeShipping := false
-	if err == nil {
-		// Rule exists - apply it
-		if rule.CostOverrideCents != nil {
-			totalCostCents = *rule.CostOverrideCents
-		}
-
-		// Check for free shipping threshold
-		if rule.FreeShippingThresholdCents != nil && cartTotalCents >= *rule.FreeShippingThresholdCents {
-			totalCostCents = 0
-			isFreeShipping = true
-		}
-
-		// Validate order value constraints
-		if cartTotalCents < rule.MinOrderValueCents {
-			return nil, fmt.Errorf("order value below minimum for this shipping method")
-		}
-		if rule.MaxOrderValueCents != nil && cartTotalCents > *rule.MaxOrderValueCents {
-			return nil, fmt.Errorf("order value exceeds maximum for this shipping method")
-		}
-	}
-
-	// Calculate estimated delivery - adjust based on distance
-	deliveryDays := method.DeliveryDaysMax
-	if store.WarehouseCity == destAddress.City {
-		// Same city - faster delivery
-		deliveryDays = method.DeliveryDaysMin
-	}
-	estimatedDelivery := time.Now().Add(time.Duration(deliveryDays) * 24 * time.Hour)
-
-	result := &ShippingCalculation{
-		MethodCode:        method.Code,
-		MethodName:        method.Name,
-		ShippingCostCents: totalCostCents,
-		EstimatedDelivery: estimatedDelivery,
-		DeliveryDaysMin:   method.DeliveryDaysMin,
-		DeliveryDaysMax:   deliveryDays,
-		IsFreeShipping:    isFreeShipping,
-	}
-
-	return result, nil
-}
-
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/internal/validation"
+)
+
+var methodCodePattern = regexp.MustCompile(`^[a-z0-9_]{2,20}$`)
+
+var (
+	ErrShippingMethodCodeTaken = errors.New("a shipping method with this code already exists")
+	ErrShippingMethodNameTaken = errors.New("a shipping method with this name already exists")
+	ErrShippingZoneNameTaken   = errors.New("a shipping zone with this name already exists")
+	ErrShippingRuleExists      = errors.New("a rule for this method and zone already exists")
+	ErrShippingZoneInUse       = errors.New("stores still use this shipping zone; deactivate it instead")
+)
+
+// ValidationError is a rate configuration problem an admin can fix
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string { return e.Field + ": " + e.Message }
+
+func invalid(field, format string, args ...any) error {
+	return ValidationError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// ShippingMethodInput is the admin-editable part of a shipping method. Fields
+// left out of an update keep their current value.
+type ShippingMethodInput struct {
+	Name             *string `json:"name"`
+	Code             *string `json:"code"`
+	Type             *string `json:"type"`
+	Description      *string `json:"description"`
+	BaseCostCents    *int64  `json:"base_cost_cents"`
+	CostPerKgCents   *int64  `json:"cost_per_kg_cents"`
+	MaxWeightGrams   *int    `json:"max_weight_grams"`
+	DeliveryDaysMin  *int    `json:"delivery_days_min"`
+	DeliveryDaysMax  *int    `json:"delivery_days_max"`
+	DeliversSaturday *bool   `json:"delivers_saturday"`
+	DeliversSunday   *bool   `json:"delivers_sunday"`
+	IsActive         *bool   `json:"is_active"`
+}
+
+// Apply copies the fields that were sent onto m
+func (in ShippingMethodInput) Apply(m *models.ShippingMethod) {
+	setString(&m.Name, in.Name)
+	setString(&m.Code, in.Code)
+	setString(&m.Type, in.Type)
+	if in.Description != nil {
+		m.Description = optionalString(*in.Description)
+	}
+	setValue(&m.BaseCostCents, in.BaseCostCents)
+	setValue(&m.CostPerKgCents, in.CostPerKgCents)
+	if in.MaxWeightGrams != nil {
+		// 0 removes the limit
+		m.MaxWeightGrams = nil
+		if *in.MaxWeightGrams != 0 {
+			m.MaxWeightGrams = in.MaxWeightGrams
+		}
+	}
+	setValue(&m.DeliveryDaysMin, in.DeliveryDaysMin)
+	setValue(&m.DeliveryDaysMax, in.DeliveryDaysMax)
+	setValue(&m.DeliversSaturday, in.DeliversSaturday)
+	setValue(&m.DeliversSunday, in.DeliversSunday)
+	setValue(&m.IsActive, in.IsActive)
+}
+
+// ValidateShippingMethod checks a shipping method before it is saved
+func ValidateShippingMethod(m models.ShippingMethod) error {
+	if m.Name == "" || len(m.Name) > 50 {
+		return invalid("name", "is required and at most 50 characters")
+	}
+	if !methodCodePattern.MatchString(m.Code) {
+		return invalid("code", "must be 2-20 lowercase letters, digits or underscores")
+	}
+	if m.Type != ShippingTypeDelivery && m.Type != ShippingTypePickup {
+		return invalid("type", "must be %s or %s", ShippingTypeDelivery, ShippingTypePickup)
+	}
+	if m.BaseCostCents < 0 {
+		return invalid("base_cost_cents", "cannot be negative")
+	}
+	if m.CostPerKgCents < 0 {
+		return invalid("cost_per_kg_cents", "cannot be negative")
+	}
+	if m.MaxWeightGrams != nil && *m.MaxWeightGrams <= 0 {
+		return invalid("max_weight_grams", "must be positive")
+	}
+	if m.DeliveryDaysMin < 0 {
+		return invalid("delivery_days_min", "cannot be negative")
+	}
+	if m.DeliveryDaysMax < m.DeliveryDaysMin {
+		return invalid("delivery_days_max", "cannot be less than delivery_days_min")
+	}
+	return nil
+}
+
+// ShippingZoneInput is the admin-editable part of a shipping zone. Fields left
+// out of an update keep their current value; an empty state, city or postal
+// code pattern clears it.
+type ShippingZoneInput struct {
+	Name                *string `json:"name"`
+	Country             *string `json:"country"`
+	State               *string `json:"state"`
+	City                *string `json:"city"`
+	PostalCodePattern   *string `json:"postal_code_pattern"`
+	AdditionalCostCents *int64  `json:"additional_cost_cents"`
+	Priority            *int    `json:"priority"`
+	IsActive            *bool   `json:"is_active"`
+}
+
+// Apply copies the fields that were sent onto z
+func (in ShippingZoneInput) Apply(z *models.ShippingZone) {
+	setString(&z.Name, in.Name)
+	setString(&z.Country, in.Country)
+	if in.State != nil {
+		z.State = optionalString(*in.State)
+	}
+	if in.City != nil {
+		z.City = optionalString(*in.City)
+	}
+	if in.PostalCodePattern != nil {
+		z.PostalCodePattern = optionalString(*in.PostalCodePattern)
+	}
+	setValue(&z.AdditionalCostCents, in.AdditionalCostCents)
+	setValue(&z.Priority, in.Priority)
+	setValue(&z.IsActive, in.IsActive)
+}
+
+// ValidateShippingZone checks a shipping zone before it is saved
+func ValidateShippingZone(z models.ShippingZone) error {
+	if z.Name == "" || len(z.Name) > 100 {
+		return invalid("name", "is required and at most 100 characters")
+	}
+	if z.Country == "" {
+		return invalid("country", "is required")
+	}
+	if z.AdditionalCostCents < 0 {
+		return invalid("additional_cost_cents", "cannot be negative")
+	}
+	if z.Priority < 0 {
+		return invalid("priority", "cannot be negative")
+	}
+	if z.PostalCodePattern != nil {
+		if _, err := validation.ParsePostalCodePattern(*z.PostalCodePattern); err != nil {
+			return invalid("postal_code_pattern", "%v", err)
+		}
+	}
+	return nil
+}
+
+// ShippingRuleInput is the admin-editable part of a shipping rule. Fields left
+// out of an update keep their current value; clear_* flags remove optional limits.
+type ShippingRuleInput struct {
+	ShippingMethodID           *uuid.UUID `json:"shipping_method_id"`
+	ShippingZoneID             *uuid.UUID `json:"shipping_zone_id"`
+	CostOverrideCents          *int64     `json:"cost_override_cents"`
+	MinOrderValueCents         *int64     `json:"min_order_value_cents"`
+	MaxOrderValueCents         *int64     `json:"max_order_value_cents"`
+	FreeShippingThresholdCents *int64     `json:"free_shipping_threshold_cents"`
+	IsAvailable                *bool      `json:"is_available"`
+	ClearCostOverride          bool       `json:"clear_cost_override"`
+	ClearMaxOrderValue         bool       `json:"clear_max_order_value"`
+	ClearFreeShipping          bool       `json:"clear_free_shipping_threshold"`
+}
+
+// Apply copies the fields that were sent onto r
+func (in ShippingRuleInput) Apply(r *models.ShippingRule) {
+	setValue(&r.ShippingMethodID, in.ShippingMethodID)
+	setValue(&r.ShippingZoneID, in.ShippingZoneID)
+	setOptional(&r.CostOverrideCents, in.CostOverrideCents, in.ClearCostOverride)
+	setValue(&r.MinOrderValueCents, in.MinOrderValueCents)
+	setOptional(&r.MaxOrderValueCents, in.MaxOrderValueCents, in.ClearMaxOrderValue)
+	setOptional(&r.FreeShippingThresholdCents, in.FreeShippingThresholdCents, in.ClearFreeShipping)
+	setValue(&r.IsAvailable, in.IsAvailable)
+}
+
+// ValidateShippingRule checks a rule's amounts before it is saved
+func ValidateShippingRule(r models.ShippingRule) error {
+	if r.ShippingMethodID == uuid.Nil {
+		return invalid("shipping_method_id", "is required")
+	}
+	if r.ShippingZoneID == uuid.Nil {
+		return invalid("shipping_zone_id", "is required")
+	}
+	return validateRuleAmounts(r)
+}
+
+func validateRuleAmounts(r models.ShippingRule) error {
+	if r.CostOverrideCents != nil && *r.CostOverrideCents < 0 {
+		return invalid("cost_override_cents", "cannot be negative")
+	}
+	if r.MinOrderValueCents < 0 {
+		return invalid("min_order_value_cents", "cannot be negative")
+	}
+	if r.MaxOrderValueCents != nil && *r.MaxOrderValueCents < r.MinOrderValueCents {
+		return invalid("max_order_value_cents", "cannot be less than min_order_value_cents")
+	}
+	if r.FreeShippingThresholdCents != nil && *r.FreeShippingThresholdCents < 0 {
+		return invalid("free_shipping_threshold_cents", "cannot be negative")
+	}
+	return nil
+}
+
+// SaveShippingMethod validates and creates or updates a method, keeping codes unique
+func (s *ShippingService) SaveShippingMethod(m *models.ShippingMethod) error {
+	if err := ValidateShippingMethod(*m); err != nil {
+		return err
+	}
+	if taken, err := s.exists(&models.ShippingMethod{}, "code = ? AND id <> ?", m.Code, m.ID); err != nil {
+		return err
+	} else if taken {
+		return ErrShippingMethodCodeTaken
+	}
+	if taken, err := s.exists(&models.ShippingMethod{}, "name = ? AND id <> ?", m.Name, m.ID); err != nil {
+		return err
+	} else if taken {
+		return ErrShippingMethodNameTaken
+	}
+	return s.save(s.db, m, &m.ID)
+}
+
+// SaveShippingZone validates and creates or updates a zone, keeping names unique
+// so rate imports can refer to zones by name
+func (s *ShippingService) SaveShippingZone(z *models.ShippingZone) error {
+	if err := ValidateShippingZone(*z); err != nil {
+		return err
+	}
+	if taken, err := s.exists(&models.ShippingZone{}, "name = ? AND id <> ?", z.Name, z.ID); err != nil {
+		return err
+	} else if taken {
+		return ErrShippingZoneNameTaken
+	}
+	return s.save(s.db, z, &z.ID)
+}
+
+// DeleteShippingZone removes a zone, with its platform rules. A zone stores
+// have their own rates for, or exclude, is refused with ErrShippingZoneInUse.
+func (s *ShippingService) DeleteShippingZone(id uuid.UUID) error {
+	users, err := zoneStoreUsers(s.db, []uuid.UUID{id})
+	if err != nil {
+		return err
+	}
+	if stores := users[id]; len(stores) > 0 {
+		return fmt.Errorf("%w (used by %s)", ErrShippingZoneInUse, strings.Join(stores, ", "))
+	}
+	return s.db.Delete(&models.ShippingZone{}, "id = ?", id).Error
+}
+
+// zoneStoreUsersSQL finds the stores whose own rates or exclusions name a zone
+const zoneStoreUsersSQL = `
+SELECT r.shipping_zone_id::text AS zone_id, s.name AS store
+FROM store_shipping_rates r
+JOIN stores s ON s.id = r.store_id
+WHERE r.shipping_zone_id::text IN ?
+UNION
+SELECT x.zone_id, s.name
+FROM store_shipping_profiles p
+CROSS JOIN LATERAL unnest(p.excluded_zone_ids) AS x(zone_id)
+JOIN stores s ON s.id = p.store_id
+WHERE x.zone_id IN ?
+ORDER BY 2`
+
+// zoneStoreUsers returns, per zone, the names of the stores that refer to it
+func zoneStoreUsers(tx *gorm.DB, zoneIDs []uuid.UUID) (map[uuid.UUID][]string, error) {
+	users := map[uuid.UUID][]string{}
+	if len(zoneIDs) == 0 {
+		return users, nil
+	}
+	ids := make([]string, 0, len(zoneIDs))
+	for _, id := range zoneIDs {
+		ids = append(ids, id.String())
+	}
+	var rows []struct {
+		ZoneID string
+		Store  string
+	}
+	if err := tx.Raw(zoneStoreUsersSQL, ids, ids).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		id, err := uuid.Parse(row.ZoneID)
+		if err != nil {
+			continue
+		}
+		users[id] = append(users[id], row.Store)
+	}
+	return users, nil
+}
+
+// SaveShippingRule validates and creates or updates a rule. The method and zone
+// must exist and have no other rule.
+func (s *ShippingService) SaveShippingRule(r *models.ShippingRule) error {
+	if err := ValidateShippingRule(*r); err != nil {
+		return err
+	}
+	if ok, err := s.exists(&models.ShippingMethod{}, "id = ?", r.ShippingMethodID); err != nil {
+		return err
+	} else if !ok {
+		return invalid("shipping_method_id", "shipping method not found")
+	}
+	if ok, err := s.exists(&models.ShippingZone{}, "id = ?", r.ShippingZoneID); err != nil {
+		return err
+	} else if !ok {
+		return invalid("shipping_zone_id", "shipping zone not found")
+	}
+	if taken, err := s.exists(&models.ShippingRule{}, "shipping_method_id = ? AND shipping_zone_id = ? AND id <> ?",
+		r.ShippingMethodID, r.ShippingZoneID, r.ID); err != nil {
+		return err
+	} else if taken {
+		return ErrShippingRuleExists
+	}
+	// Don't write stale associations back
+	return s.save(s.db.Omit("ShippingMethod", "ShippingZone"), r, &r.ID)
+}
+
+// save creates the model if it has no ID yet, else updates it. Creates name
+// every column so false and zero values aren't replaced by column defaults.
+func (s *ShippingService) save(tx *gorm.DB, model any, id *uuid.UUID) error {
+	if *id == uuid.Nil {
+		*id = uuid.New()
+		return tx.Select("*").Create(model).Error
+	}
+	return tx.Save(model).Error
+}
+
+func (s *ShippingService) exists(model any, query string, args ...any) (bool, error) {
+	var n int64
+	err := s.db.Model(model).Where(query, args...).Count(&n).Error
+	return n > 0, err
+}
+
+func setString(dst *string, src *string) {
+	if src != nil {
+		*dst = strings.TrimSpace(*src)
+	}
+}
+
+func setValue[T any](dst *T, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setOptional[T any](dst **T, src *T, clear bool) {
+	if clear {
+		*dst = nil
+	} else if src != nil {
+		v := *src
+		*dst = &v
+	}
+}
+
+func optionalString(s string) *string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	return &s
+}
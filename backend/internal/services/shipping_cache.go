@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"trumall/internal/cache"
+)
+
+// Shipping cache tags. Every quote carries the rates tag plus tags for the store
+// and address or pickup point it was priced for.
+const (
+	shippingTagRates = "shipping:rates"
+)
+
+// ShippingCache holds priced quotes (without the per-request quote ID)
+var ShippingCache cache.Cache[ShippingCalculation] = cache.NewInMemoryCache[ShippingCalculation]("shipping")
+
+func shippingStoreTag(storeID string) string     { return "shipping:store:" + storeID }
+func shippingAddressTag(addressID string) string { return "shipping:address:" + addressID }
+func shippingPickupTag(pointID string) string    { return "shipping:pickup:" + pointID }
+
+// shippingRateTables are the tables whose changes can alter any quote
+var shippingRateTables = map[string]bool{
+	"shipping_methods":        true,
+	"shipping_zones":          true,
+	"shipping_rules":          true,
+	"shipping_distance_bands": true,
+}
+
+// RegisterShippingCacheInvalidation hooks GORM so that any write to shipping rate
+// tables, store shipping profiles, stores, addresses or pickup points evicts the affected cached quotes, whichever code
+// path makes the change. The hooks run once GORM's own transaction for the
+// statement has committed; inside a ShippingTransaction they wait for it to
+// commit instead.
+func RegisterShippingCacheInvalidation(db *gorm.DB) error {
+	const name = "shipping_cache:invalidate"
+	const after = "gorm:commit_or_rollback_transaction"
+	if err := db.Callback().Create().After(after).Register(name, invalidateShippingCache); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After(after).Register(name, invalidateShippingCache); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After(after).Register(name, invalidateShippingCache)
+}
+
+// shippingEvictions collects the tags a transaction's writes affect, to evict
+// once it commits. Evicting earlier lets a concurrent quote read the old rows
+// and cache them again for the full TTL.
+type shippingEvictions struct {
+	mu   sync.Mutex
+	tags map[string]bool
+}
+
+type shippingEvictionsKey struct{}
+
+// ShippingTransaction runs fn in a transaction and evicts the cached quotes its
+// writes affect after it commits. Use it instead of db.Transaction for
+// transactions that write any of the tables the cache hooks watch.
+func ShippingTransaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	pending := &shippingEvictions{tags: map[string]bool{}}
+	ctx := context.WithValue(db.Statement.Context, shippingEvictionsKey{}, pending)
+	if err := db.WithContext(ctx).Transaction(fn); err != nil {
+		return err
+	}
+	for tag := range pending.tags {
+		evictShippingTag(tag)
+	}
+	return nil
+}
+
+func invalidateShippingCache(tx *gorm.DB) {
+	if tx.Error != nil || tx.Statement.Schema == nil {
+		return
+	}
+	tag, ok := shippingCacheTag(tx)
+	if !ok {
+		return
+	}
+	if pending, ok := tx.Statement.Context.Value(shippingEvictionsKey{}).(*shippingEvictions); ok {
+		pending.mu.Lock()
+		pending.tags[tag] = true
+		pending.mu.Unlock()
+		return
+	}
+	evictShippingTag(tag)
+}
+
+func evictShippingTag(tag string) {
+	n := ShippingCache.InvalidateTag(tag)
+	if tag == shippingTagRates {
+		log.Printf("Shipping cache: rates changed, evicted %d quotes", n)
+	}
+}
+
+// shippingCacheTag is the tag of the quotes a write can change, if any
+func shippingCacheTag(tx *gorm.DB) (string, bool) {
+	table := tx.Statement.Schema.Table
+	switch {
+	case shippingRateTables[table]:
+		return shippingTagRates, true
+	case table == "store_shipping_profiles" || table == "store_shipping_rates":
+		id, ok := statementField(tx, "StoreID")
+		if !ok {
+			return shippingTagRates, true
+		}
+		return shippingStoreTag(id), true
+	case table == "stores" || table == "addresses" || table == "pickup_points":
+		// Only location changes matter, but the row is cheap to re-price. Fall back
+		// to a full flush when the statement doesn't identify a single row.
+		id, ok := statementPrimaryKey(tx)
+		if !ok {
+			return shippingTagRates, true
+		}
+		switch table {
+		case "stores":
+			return shippingStoreTag(id), true
+		case "addresses":
+			return shippingAddressTag(id), true
+		default:
+			return shippingPickupTag(id), true
+		}
+	}
+	return "", false
+}
+
+// statementPrimaryKey returns the primary key of the single row a statement was
+// run on, if it can tell
+func statementPrimaryKey(tx *gorm.DB) (string, bool) {
+	return statementValue(tx, tx.Statement.Schema.PrioritizedPrimaryField)
+}
+
+// statementField returns a field of the single model a statement was run on, if
+// it is set
+func statementField(tx *gorm.DB, name string) (string, bool) {
+	return statementValue(tx, tx.Statement.Schema.LookUpField(name))
+}
+
+func statementValue(tx *gorm.DB, field *schema.Field) (string, bool) {
+	rv := tx.Statement.ReflectValue
+	if field == nil || !rv.IsValid() || rv.Kind() != reflect.Struct {
+		return "", false
+	}
+	value, zero := field.ValueOf(tx.Statement.Context, rv)
+	if zero {
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+// openCachedShippingDB has the cache hooks registered, with a store, a method
+// and two addresses to quote
+func openCachedShippingDB(t *testing.T) (*gorm.DB, models.Store, models.ShippingMethod, [2]models.Address) {
+	t.Helper()
+	db := openShippingDB(t)
+	if err := RegisterShippingCacheInvalidation(db); err != nil {
+		t.Fatal(err)
+	}
+	store := newShippingStore(t, db)
+	newCountryZone(t, db, "Kenya", 200)
+	method := newMethod(t, db, "standard", 500)
+	addresses := [2]models.Address{newAddress(t, db, "Mombasa", "Kenya"), newAddress(t, db, "Kisumu", "Kenya")}
+	return db, store, method, addresses
+}
+
+// primeQuotes quotes both addresses and returns how many quotes are cached
+func primeQuotes(t *testing.T, db *gorm.DB, store models.Store, addresses [2]models.Address) int {
+	t.Helper()
+	for _, address := range addresses {
+		if _, err := quoteTo(db, store, address, "standard", 4000, Parcel{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ShippingCache.Stats().Entries
+}
+
+func TestShippingCacheInvalidation(t *testing.T) {
+	db, store, method, addresses := openCachedShippingDB(t)
+
+	cases := []struct {
+		name  string
+		write func()
+		left  int
+	}{
+		{"unrelated table", func() { newOrder(t, db, uuid.New(), "pending", 1) }, 2},
+		{"another store's profile", func() { mustCreate(t, db, &models.StoreShippingProfile{StoreID: uuid.New(), IsActive: true}) }, 2},
+		{"one address moved", func() { db.Model(&addresses[0]).Update("postal_code", "80100") }, 1},
+		{"addresses changed in bulk", func() { db.Model(&models.Address{}).Where("city = ?", "Kisumu").Update("state", "Kisumu") }, 0},
+		{"method price changed", func() { db.Model(&method).Update("base_cost_cents", 600) }, 0},
+		{"zone deleted", func() { db.Where("name = ?", "Nowhere").Delete(&models.ShippingZone{}) }, 0},
+		{"the store moved", func() { db.Model(&store).Update("warehouse_city", "Thika") }, 0},
+	}
+	for _, tc := range cases {
+		if cached := primeQuotes(t, db, store, addresses); cached != 2 {
+			t.Fatalf("%s: %d quotes cached; want 2", tc.name, cached)
+		}
+		tc.write()
+		if left := ShippingCache.Stats().Entries; left != tc.left {
+			t.Errorf("%s: %d quotes left; want %d", tc.name, left, tc.left)
+		}
+	}
+
+	// What was evicted is priced afresh
+	calc, err := quoteTo(db, store, addresses[0], "standard", 4000, Parcel{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calc.ShippingCostCents != 800 {
+		t.Errorf("cost after the price change = %d; want 800", calc.ShippingCostCents)
+	}
+}
+
+func TestShippingTransactionEvictsOnCommit(t *testing.T) {
+	db, store, method, addresses := openCachedShippingDB(t)
+	primeQuotes(t, db, store, addresses)
+
+	err := ShippingTransaction(db, func(tx *gorm.DB) error {
+		if err := tx.Model(&method).Update("base_cost_cents", 600).Error; err != nil {
+			return err
+		}
+		// A quote taken now would see the old rows, so eviction waits for the commit
+		if cached := ShippingCache.Stats().Entries; cached != 2 {
+			t.Errorf("%d quotes cached before the commit; want 2", cached)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := ShippingCache.Stats().Entries; left != 0 {
+		t.Errorf("%d quotes left after the commit; want 0", left)
+	}
+
+	primeQuotes(t, db, store, addresses)
+	rollback := errors.New("rollback")
+	err = ShippingTransaction(db, func(tx *gorm.DB) error {
+		tx.Model(&method).Update("base_cost_cents", 700)
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("err = %v; want the rollback", err)
+	}
+	if left := ShippingCache.Stats().Entries; left != 2 {
+		t.Errorf("%d quotes left after a rollback; want 2", left)
+	}
+}
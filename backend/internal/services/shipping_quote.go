@@ -0,0 +1,329 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+// Quote breakdown steps, in pipeline order
+const (
+	QuoteStepBase         = "base"
+	QuoteStepZone         = "zone"
+	QuoteStepOrigin       = "origin"
+	QuoteStepDistance     = "distance"
+	QuoteStepWeight       = "weight"
+	QuoteStepRuleOverride = "rule_override"
+	QuoteStepStoreRate    = "store_rate"
+	QuoteStepFreeShipping = "free_shipping"
+)
+
+// QuoteRequest is everything the quoting engine needs to price a cart
+type QuoteRequest struct {
+	UserID         uuid.UUID
+	StoreID        uuid.UUID
+	AddressID      uuid.UUID
+	PickupPointID  *uuid.UUID // set instead of AddressID for click-and-collect
+	MethodCode     string
+	CartTotalCents int64
+	Parcel         Parcel
+}
+
+// QuoteLine is one itemised step of a shipping price. Superseded lines were
+// replaced by a later step (e.g. a distance band replacing base + zone) and do not
+// count towards the total.
+type QuoteLine struct {
+	Step        string `json:"step"`
+	Description string `json:"description"`
+	AmountCents int64  `json:"amount_cents"`
+	Superseded  bool   `json:"superseded,omitempty"`
+}
+
+// quoteState is threaded through the pricing pipeline
+type quoteState struct {
+	req        QuoteRequest
+	store      models.Store
+	address    models.Address
+	method     models.ShippingMethod
+	zone       *ZoneMatch
+	distanceKm *float64
+	basis      string
+	rule       *models.ShippingRule
+	profile    *models.StoreShippingProfile // nil = platform rates only
+	storeRate  *models.StoreShippingRate
+	lines      []QuoteLine
+	isFree     bool
+}
+
+func (q *quoteState) add(step, description string, amountCents int64) {
+	q.lines = append(q.lines, QuoteLine{Step: step, Description: description, AmountCents: amountCents})
+}
+
+// supersede drops earlier lines of the given steps from the total
+func (q *quoteState) supersede(steps ...string) {
+	for i := range q.lines {
+		for _, step := range steps {
+			if q.lines[i].Step == step {
+				q.lines[i].Superseded = true
+			}
+		}
+	}
+}
+
+func (q *quoteState) total() int64 {
+	var total int64
+	for _, line := range q.lines {
+		if !line.Superseded {
+			total += line.AmountCents
+		}
+	}
+	if total < 0 {
+		return 0
+	}
+	return total
+}
+
+func (q *quoteState) amount(step string) int64 {
+	var total int64
+	for _, line := range q.lines {
+		if line.Step == step && !line.Superseded {
+			total += line.AmountCents
+		}
+	}
+	return total
+}
+
+// pricingStep is one stage of the quoting pipeline
+type pricingStep func(s *ShippingService, q *quoteState) error
+
+// quotePipeline prices a shipment; each step can add lines, supersede earlier
+// ones, or reject the method for this cart
+var quotePipeline = []pricingStep{
+	baseCostStep,
+	zoneStep,
+	distanceStep,
+	weightStep,
+	rulesStep,
+	storeProfileStep,
+	coverageStep,
+	freeShippingStep,
+}
+
+// price loads the quote inputs and runs them through the pipeline
+func (s *ShippingService) price(req QuoteRequest) (*ShippingCalculation, error) {
+	q := &quoteState{req: req, basis: PricingBasisZone}
+
+	if err := s.db.First(&q.store, "id = ?", req.StoreID).Error; err != nil {
+		return nil, fmt.Errorf("store not found: %w", err)
+	}
+	if err := s.db.Where("code = ? AND is_active = ?", req.MethodCode, true).First(&q.method).Error; err != nil {
+		return nil, fmt.Errorf("shipping method not found or inactive: %w", err)
+	}
+
+	address, err := s.quoteDestination(req, q.method)
+	if err != nil {
+		return nil, err
+	}
+	q.address = *address
+	if q.profile, err = s.activeStoreProfile(req.StoreID); err != nil {
+		return nil, err
+	}
+
+	for _, step := range quotePipeline {
+		if err := step(s, q); err != nil {
+			return nil, err
+		}
+	}
+
+	// Local deliveries get the method's fastest time
+	deliveryDays := q.method.DeliveryDaysMax
+	sameArea := q.store.WarehouseCity != "" && q.store.WarehouseCity == q.address.City
+	if q.distanceKm != nil {
+		sameArea = *q.distanceKm <= LocalDeliveryRadiusKm
+	}
+	if sameArea {
+		deliveryDays = q.method.DeliveryDaysMin
+	}
+
+	weightCostCents := q.amount(QuoteStepWeight)
+	if q.isFree {
+		weightCostCents = 0
+	}
+
+	return &ShippingCalculation{
+		MethodCode:        q.method.Code,
+		MethodName:        q.method.Name,
+		ShippingCostCents: q.total(),
+		DeliveryDaysMin:   q.method.DeliveryDaysMin,
+		DeliveryDaysMax:   deliveryDays,
+		IsFreeShipping:    q.isFree,
+
+		ChargeableWeightGrams: req.Parcel.ChargeableWeightGrams(),
+		WeightCostCents:       weightCostCents,
+		PricingBasis:          q.basis,
+		DistanceKm:            q.distanceKm,
+		Breakdown:             q.lines,
+	}, nil
+}
+
+// quoteDestination is where a method ships the parcel: the buyer's address, or
+// for pickup methods the pickup point, which is priced like an address
+func (s *ShippingService) quoteDestination(req QuoteRequest, method models.ShippingMethod) (*models.Address, error) {
+	if method.Type == ShippingTypePickup {
+		if req.PickupPointID == nil {
+			return nil, ErrPickupPointRequired
+		}
+		point, err := CheckPickupAvailability(s.db, *req.PickupPointID, req.StoreID, false)
+		if err != nil {
+			return nil, err
+		}
+		address := pickupDestination(*point)
+		return &address, nil
+	}
+	if req.PickupPointID != nil || req.AddressID == uuid.Nil {
+		return nil, ErrAddressRequired
+	}
+	var address models.Address
+	if err := s.db.First(&address, "id = ?", req.AddressID).Error; err != nil {
+		return nil, fmt.Errorf("address not found: %w", err)
+	}
+	return &address, nil
+}
+
+// baseCostStep starts from the method's flat cost
+func baseCostStep(s *ShippingService, q *quoteState) error {
+	q.add(QuoteStepBase, q.method.Name, q.method.BaseCostCents)
+	return nil
+}
+
+// zoneStep adds the destination zone surcharge and the warehouse city adjustment.
+// A missing zone is only fatal if distance pricing cannot take over.
+func zoneStep(s *ShippingService, q *quoteState) error {
+	match, err := s.ResolveZone(q.address)
+	if err != nil {
+		if errors.Is(err, ErrNoMatchingZone) {
+			return nil
+		}
+		return err
+	}
+	q.zone = match
+	q.add(QuoteStepZone, fmt.Sprintf("%s (%s)", match.Zone.Name, match.Reason), match.Zone.AdditionalCostCents)
+
+	if q.store.WarehouseCity == "" || q.store.WarehouseCountry == "" {
+		return nil
+	}
+	flat := q.amount(QuoteStepBase) + q.amount(QuoteStepZone)
+	if q.store.WarehouseCity == q.address.City && q.store.WarehouseCountry == q.address.Country {
+		// Same city delivery - 20% discount
+		q.add(QuoteStepOrigin, "Same-city delivery (-20%)", -flat/5)
+	} else if q.store.WarehouseCountry != q.address.Country {
+		// International shipping - 50% surcharge
+		q.add(QuoteStepOrigin, "International delivery (+50%)", flat/2)
+	}
+	return nil
+}
+
+// distanceStep replaces the flat and zone cost with the method's distance band
+// when both ends have coordinates
+func distanceStep(s *ShippingService, q *quoteState) error {
+	band, distanceKm, err := s.findDistanceBand(q.method, q.store, q.address)
+	if err != nil {
+		return err
+	}
+	q.distanceKm = distanceKm
+	if band == nil {
+		return nil
+	}
+
+	bandLabel := fmt.Sprintf("%.0f+ km", band.MinKm)
+	if band.MaxKm != nil {
+		bandLabel = fmt.Sprintf("%.0f-%.0f km", band.MinKm, *band.MaxKm)
+	}
+	q.supersede(QuoteStepBase, QuoteStepZone, QuoteStepOrigin)
+	q.add(QuoteStepDistance, fmt.Sprintf("%.1f km from warehouse (band %s)", *distanceKm, bandLabel), band.CostCents)
+	q.basis = PricingBasisDistance
+	return nil
+}
+
+// weightStep charges the method's per-kg rate and enforces its weight limit
+func weightStep(s *ShippingService, q *quoteState) error {
+	charge, err := weightCharge(q.method, q.req.Parcel)
+	if err != nil {
+		return err
+	}
+	if charge > 0 {
+		q.add(QuoteStepWeight, fmt.Sprintf("%d kg × %d", q.req.Parcel.BillableKg(), q.method.CostPerKgCents), charge)
+	}
+	return nil
+}
+
+// rulesStep applies the method/zone rule: order value limits and cost override.
+// Overrides replace the flat cost; heavier parcels still pay per kg.
+func rulesStep(s *ShippingService, q *quoteState) error {
+	if q.zone == nil {
+		return nil
+	}
+
+	var rule models.ShippingRule
+	err := s.db.Where("shipping_method_id = ? AND shipping_zone_id = ? AND is_available = ?",
+		q.method.ID, q.zone.Zone.ID, true).
+		First(&rule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	q.rule = &rule
+
+	// Validate order value constraints
+	if q.req.CartTotalCents < rule.MinOrderValueCents {
+		return fmt.Errorf("order value below minimum for this shipping method")
+	}
+	if rule.MaxOrderValueCents != nil && q.req.CartTotalCents > *rule.MaxOrderValueCents {
+		return fmt.Errorf("order value exceeds maximum for this shipping method")
+	}
+
+	if rule.CostOverrideCents != nil {
+		q.supersede(QuoteStepBase, QuoteStepZone, QuoteStepOrigin, QuoteStepDistance)
+		q.add(QuoteStepRuleOverride, "Fixed rate for "+q.zone.Zone.Name, *rule.CostOverrideCents)
+	}
+	return nil
+}
+
+// coverageStep rejects destinations nothing could price: no zone matched, the
+// method has no distance bands and the store has no rate for it
+func coverageStep(s *ShippingService, q *quoteState) error {
+	if q.zone == nil && q.basis != PricingBasisDistance && q.storeRate == nil {
+		return fmt.Errorf("no shipping zone found for address: %w", ErrNoMatchingZone)
+	}
+	return nil
+}
+
+// freeShippingStep waives the whole cost once the cart reaches the free shipping
+// threshold: the store rate's, else the store profile's, else the platform rule's
+func freeShippingStep(s *ShippingService, q *quoteState) error {
+	threshold := q.freeShippingThreshold()
+	if threshold == nil || q.req.CartTotalCents < *threshold {
+		return nil
+	}
+	q.add(QuoteStepFreeShipping, fmt.Sprintf("Free shipping on orders over %d", *threshold), -q.total())
+	q.isFree = true
+	return nil
+}
+
+func (q *quoteState) freeShippingThreshold() *int64 {
+	switch {
+	case q.storeRate != nil && q.storeRate.FreeShippingThresholdCents != nil:
+		return q.storeRate.FreeShippingThresholdCents
+	case q.profile != nil && q.profile.FreeShippingThresholdCents != nil:
+		return q.profile.FreeShippingThresholdCents
+	case q.rule != nil:
+		return q.rule.FreeShippingThresholdCents
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"trumall/internal/models"
+)
+
+func TestQuotePipeline(t *testing.T) {
+	db := openShippingDB(t)
+	store := newShippingStore(t, db)
+	kenya := newCountryZone(t, db, "Kenya", 200)
+	newCountryZone(t, db, "Uganda", 1000)
+	threshold, ceiling, override := int64(5000), int64(20000), int64(350)
+
+	cases := []struct {
+		name    string
+		city    string
+		country string
+		rule    *models.ShippingRule // for the case's own method in Kenya
+		cart    int64
+		cost    int64
+		free    bool
+		fails   bool
+	}{
+		{"base and zone", "Mombasa", "Kenya", nil, 4000, 700, false, false},
+		{"same city", "Nairobi", "Kenya", nil, 4000, 560, false, false},
+		{"international", "Kampala", "Uganda", nil, 4000, 2250, false, false},
+		{"no zone", "Arusha", "Tanzania", nil, 4000, 0, false, true},
+		{"rule override", "Mombasa", "Kenya", &models.ShippingRule{CostOverrideCents: &override}, 4000, 350, false, false},
+		{"under the free shipping threshold", "Mombasa", "Kenya", &models.ShippingRule{FreeShippingThresholdCents: &threshold}, 4000, 700, false, false},
+		{"free shipping", "Mombasa", "Kenya", &models.ShippingRule{FreeShippingThresholdCents: &threshold}, 5000, 0, true, false},
+		{"below the minimum order value", "Mombasa", "Kenya", &models.ShippingRule{MinOrderValueCents: 5000}, 4000, 0, false, true},
+		{"above the maximum order value", "Mombasa", "Kenya", &models.ShippingRule{MaxOrderValueCents: &ceiling}, 25000, 0, false, true},
+	}
+	for i, tc := range cases {
+		method := newMethod(t, db, "method"+string(rune('a'+i)), 500)
+		if tc.rule != nil {
+			rule := *tc.rule
+			rule.ShippingMethodID, rule.ShippingZoneID, rule.IsAvailable = method.ID, kenya.ID, true
+			mustCreate(t, db, &rule)
+		}
+		address := newAddress(t, db, tc.city, tc.country)
+
+		calc, err := quoteTo(db, store, address, method.Code, tc.cart, Parcel{})
+		if (err != nil) != tc.fails {
+			t.Errorf("%s: err = %v; want failure = %v", tc.name, err, tc.fails)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if calc.ShippingCostCents != tc.cost || calc.IsFreeShipping != tc.free {
+			t.Errorf("%s: cost %d, free %v; want %d, %v (breakdown %+v)", tc.name,
+				calc.ShippingCostCents, calc.IsFreeShipping, tc.cost, tc.free, calc.Breakdown)
+		}
+		var total int64
+		for _, amount := range quoteLines(calc) {
+			total += amount
+		}
+		if total != calc.ShippingCostCents {
+			t.Errorf("%s: counted lines add up to %d; want the cost %d", tc.name, total, calc.ShippingCostCents)
+		}
+	}
+}
+
+func TestRedeemQuote(t *testing.T) {
+	db := openShippingDB(t)
+	store := newShippingStore(t, db)
+	address := newAddress(t, db, "Mombasa", "Kenya")
+	newCountryZone(t, db, "Kenya", 200)
+	newMethod(t, db, "standard", 500)
+	shipping := NewShippingService(db)
+
+	req := QuoteRequest{UserID: address.UserID, StoreID: store.ID, AddressID: address.ID, MethodCode: "standard",
+		CartTotalCents: 4000, Parcel: Parcel{ActualWeightGrams: 1500}}
+	calc, err := shipping.Quote(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, signature, _ := strings.Cut(calc.QuoteID, ".")
+
+	// A quote signed with the right key that ran out a minute ago
+	var claims quoteClaims
+	raw, _ := base64.RawURLEncoding.DecodeString(payload)
+	json.Unmarshal(raw, &claims)
+	claims.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	raw, _ = json.Marshal(claims)
+	stale := base64.RawURLEncoding.EncodeToString(raw)
+	expired := stale + "." + signQuotePayload(stale)
+
+	// The same claims at a lower price, with the old signature
+	claims.ExpiresAt, claims.CostCents = time.Now().Add(time.Hour).Unix(), 1
+	raw, _ = json.Marshal(claims)
+	cheaper := base64.RawURLEncoding.EncodeToString(raw) + "." + signature
+
+	with := func(change func(*QuoteRequest)) QuoteRequest {
+		r := req
+		change(&r)
+		return r
+	}
+	cases := []struct {
+		name  string
+		quote string
+		req   QuoteRequest
+		err   error
+	}{
+		{"valid", calc.QuoteID, req, nil},
+		{"tampered price", cheaper, req, ErrQuoteInvalid},
+		{"tampered signature", payload + "." + strings.Repeat("A", len(signature)), req, ErrQuoteInvalid},
+		{"no signature", payload, req, ErrQuoteInvalid},
+		{"expired", expired, req, ErrQuoteExpired},
+		{"another buyer", calc.QuoteID, with(func(r *QuoteRequest) { r.UserID = uuid.New() }), ErrQuoteMismatch},
+		{"another address", calc.QuoteID, with(func(r *QuoteRequest) { r.AddressID = uuid.New() }), ErrQuoteMismatch},
+		{"cart total changed", calc.QuoteID, with(func(r *QuoteRequest) { r.CartTotalCents = 4100 }), ErrQuoteMismatch},
+		{"parcel got heavier", calc.QuoteID, with(func(r *QuoteRequest) { r.Parcel.ActualWeightGrams = 2500 }), ErrQuoteMismatch},
+	}
+	for _, tc := range cases {
+		redeemed, err := shipping.RedeemQuote(tc.quote, tc.req)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+			continue
+		}
+		if err == nil && redeemed.ShippingCostCents != calc.ShippingCostCents {
+			t.Errorf("%s: redeemed at %d; want the quoted %d", tc.name, redeemed.ShippingCostCents, calc.ShippingCostCents)
+		}
+	}
+
+	// The price holds even if rates change before checkout
+	db.Model(&models.ShippingMethod{}).Where("code = ?", "standard").Update("base_cost_cents", 900)
+	redeemed, err := shipping.RedeemQuote(calc.QuoteID, req)
+	if err != nil {
+		t.Fatalf("after a rate change: %v", err)
+	}
+	if redeemed.ShippingCostCents != calc.ShippingCostCents {
+		t.Errorf("after a rate change: redeemed at %d; want the quoted %d", redeemed.ShippingCostCents, calc.ShippingCostCents)
+	}
+
+	t.Setenv("SHIPPING_QUOTE_SECRET", "other-secret")
+	if _, err := shipping.RedeemQuote(calc.QuoteID, req); !errors.Is(err, ErrQuoteInvalid) {
+		t.Errorf("quote signed with another secret: err = %v; want ErrQuoteInvalid", err)
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShippingQuoteTTL is how long a buyer has to check out at a quoted price
+const ShippingQuoteTTL = 30 * time.Minute
+
+var (
+	ErrQuoteInvalid  = errors.New("invalid shipping quote")
+	ErrQuoteExpired  = errors.New("shipping quote has expired, please review shipping again")
+	ErrQuoteMismatch = errors.New("your cart or delivery details changed since shipping was quoted, please review shipping again")
+)
+
+// quoteClaims is the signed content of a quote ID: what was priced and the price
+type quoteClaims struct {
+	UserID          uuid.UUID  `json:"uid"`
+	StoreID         uuid.UUID  `json:"sid"`
+	AddressID       uuid.UUID  `json:"aid"`
+	PickupPointID   *uuid.UUID `json:"pid,omitempty"`
+	MethodCode      string     `json:"m"`
+	CartTotalCents  int64      `json:"ct"`
+	WeightGrams     int        `json:"w"`
+	CostCents       int64      `json:"c"`
+	WeightCostCents int64      `json:"wc"`
+	DaysMin         int        `json:"dmin"`
+	DaysMax         int        `json:"dmax"`
+	IsFree          bool       `json:"free,omitempty"`
+	Basis           string     `json:"b"`
+	ExpiresAt       int64      `json:"exp"`
+}
+
+// quoteSigningKey uses a dedicated secret when configured, else the JWT secret
+func quoteSigningKey() []byte {
+	if secret := os.Getenv("SHIPPING_QUOTE_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+func signQuotePayload(payload string) string {
+	mac := hmac.New(sha256.New, quoteSigningKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signQuote sets the calculation's quote ID and expiry
+func (s *ShippingService) signQuote(req QuoteRequest, calc *ShippingCalculation) error {
+	expiresAt := time.Now().Add(ShippingQuoteTTL)
+	raw, err := json.Marshal(quoteClaims{
+		UserID:          req.UserID,
+		StoreID:         req.StoreID,
+		AddressID:       req.AddressID,
+		PickupPointID:   req.PickupPointID,
+		MethodCode:      calc.MethodCode,
+		CartTotalCents:  req.CartTotalCents,
+		WeightGrams:     req.Parcel.ChargeableWeightGrams(),
+		CostCents:       calc.ShippingCostCents,
+		WeightCostCents: calc.WeightCostCents,
+		DaysMin:         calc.DeliveryDaysMin,
+		DaysMax:         calc.DeliveryDaysMax,
+		IsFree:          calc.IsFreeShipping,
+		Basis:           calc.PricingBasis,
+		ExpiresAt:       expiresAt.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	calc.QuoteID = payload + "." + signQuotePayload(payload)
+	calc.QuoteExpiresAt = &expiresAt
+	return nil
+}
+
+// RedeemQuote checks a quote ID issued by Quote against the cart being checked out
+// and returns the locked-in price. The cart, address, store and method must be
+// exactly what was quoted.
+func (s *ShippingService) RedeemQuote(quoteID string, req QuoteRequest) (*ShippingCalculation, error) {
+	parts := strings.Split(quoteID, ".")
+	if len(parts) != 2 {
+		return nil, ErrQuoteInvalid
+	}
+	if !hmac.Equal([]byte(signQuotePayload(parts[0])), []byte(parts[1])) {
+		return nil, ErrQuoteInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrQuoteInvalid
+	}
+	var claims quoteClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, ErrQuoteInvalid
+	}
+
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, ErrQuoteExpired
+	}
+	if claims.UserID != req.UserID || claims.StoreID != req.StoreID || claims.AddressID != req.AddressID ||
+		!sameUUID(claims.PickupPointID, req.PickupPointID) ||
+		claims.MethodCode != req.MethodCode || claims.CartTotalCents != req.CartTotalCents ||
+		claims.WeightGrams != req.Parcel.ChargeableWeightGrams() {
+		return nil, ErrQuoteMismatch
+	}
+
+	method, err := s.GetShippingMethod(claims.MethodCode)
+	if err != nil {
+		return nil, err
+	}
+	// The price is honoured, but not a destination the store has stopped serving
+	if err := s.CheckStoreCoverage(req, *method); err != nil {
+		return nil, err
+	}
+
+	// The price is locked in, but the dates are as of now
+	window, err := s.EstimateDelivery(claims.StoreID, claims.MethodCode, claims.DaysMin, claims.DaysMax)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	return &ShippingCalculation{
+		MethodCode:            method.Code,
+		MethodName:            method.Name,
+		ShippingCostCents:     claims.CostCents,
+		EstimatedDelivery:     window.Latest,
+		DeliveryWindow:        window,
+		DeliveryDaysMin:       claims.DaysMin,
+		DeliveryDaysMax:       claims.DaysMax,
+		IsFreeShipping:        claims.IsFree,
+		ChargeableWeightGrams: claims.WeightGrams,
+		WeightCostCents:       claims.WeightCostCents,
+		PricingBasis:          claims.Basis,
+		QuoteID:               quoteID,
+		QuoteExpiresAt:        &expiresAt,
+	}, nil
+}
+
+func sameUUID(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,765 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+// Kinds of record in a rate configuration
+const (
+	RateRecordMethod = "method"
+	RateRecordZone   = "zone"
+	RateRecordRule   = "rule"
+	RateRecordBand   = "band"
+)
+
+// Actions an import takes on a record
+const (
+	RateActionCreate     = "create"
+	RateActionUpdate     = "update"
+	RateActionDelete     = "delete"
+	RateActionDeactivate = "deactivate"
+)
+
+// rateImportLock serialises rate imports so a version check and the writes
+// that follow it can't interleave with another import
+const rateImportLock = 0x7261746573 // "rates"
+
+var (
+	ErrRatesChanged       = errors.New("shipping rates changed since this import was previewed")
+	ErrDuplicateZoneNames = errors.New("shipping zone names must be unique to export rates; rename the duplicates first")
+	ErrEmptyRateConfig    = errors.New("rate configuration has no shipping methods")
+)
+
+// maxRateValidationErrors caps how many problems one import reports
+const maxRateValidationErrors = 200
+
+// RateConfig is the whole shipping rate configuration as one document. Methods
+// are keyed by code, zones by name and rules by method code and zone name, so a
+// document can move between environments whose IDs differ.
+type RateConfig struct {
+	Version string       `json:"version,omitempty"`
+	Methods []RateMethod `json:"methods"`
+	Zones   []RateZone   `json:"zones"`
+	Rules   []RateRule   `json:"rules"`
+}
+
+type RateMethod struct {
+	Code             string     `json:"code"`
+	Name             string     `json:"name"`
+	Type             string     `json:"type"`
+	Description      string     `json:"description"`
+	BaseCostCents    int64      `json:"base_cost_cents"`
+	CostPerKgCents   int64      `json:"cost_per_kg_cents"`
+	MaxWeightGrams   *int       `json:"max_weight_grams"`
+	DeliveryDaysMin  int        `json:"delivery_days_min"`
+	DeliveryDaysMax  int        `json:"delivery_days_max"`
+	DeliversSaturday bool       `json:"delivers_saturday"`
+	DeliversSunday   bool       `json:"delivers_sunday"`
+	IsActive         *bool      `json:"is_active"` // nil = active
+	DistanceBands    []RateBand `json:"distance_bands"`
+}
+
+type RateBand struct {
+	MinKm     float64  `json:"min_km"`
+	MaxKm     *float64 `json:"max_km"`
+	CostCents int64    `json:"cost_cents"`
+}
+
+type RateZone struct {
+	Name                string `json:"name"`
+	Country             string `json:"country"`
+	State               string `json:"state"`
+	City                string `json:"city"`
+	PostalCodePattern   string `json:"postal_code_pattern"`
+	AdditionalCostCents int64  `json:"additional_cost_cents"`
+	Priority            int    `json:"priority"`
+	IsActive            *bool  `json:"is_active"` // nil = active
+}
+
+type RateRule struct {
+	MethodCode                 string `json:"method_code"`
+	ZoneName                   string `json:"zone_name"`
+	CostOverrideCents          *int64 `json:"cost_override_cents"`
+	MinOrderValueCents         int64  `json:"min_order_value_cents"`
+	MaxOrderValueCents         *int64 `json:"max_order_value_cents"`
+	FreeShippingThresholdCents *int64 `json:"free_shipping_threshold_cents"`
+	IsAvailable                *bool  `json:"is_available"` // nil = available
+}
+
+// RateValidationErrors lists every problem found in an imported document
+type RateValidationErrors []ValidationError
+
+func (e RateValidationErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more problems)", e[0].Error(), len(e)-1)
+}
+
+// RateChange is one record an import would create, change or remove
+type RateChange struct {
+	Record string   `json:"record"`
+	Key    string   `json:"key"`
+	Action string   `json:"action"`
+	Fields []string `json:"fields,omitempty"` // updates: what changed
+}
+
+// RateDiff is what applying a document does to the current configuration
+type RateDiff struct {
+	Version     string       `json:"version"` // of the configuration the diff was taken against
+	Changes     []RateChange `json:"changes"`
+	Created     int          `json:"created"`
+	Updated     int          `json:"updated"`
+	Deleted     int          `json:"deleted"`
+	Deactivated int          `json:"deactivated"`
+	Unchanged   int          `json:"unchanged"`
+}
+
+func (d *RateDiff) add(change RateChange) {
+	d.Changes = append(d.Changes, change)
+	switch change.Action {
+	case RateActionCreate:
+		d.Created++
+	case RateActionUpdate:
+		d.Updated++
+	case RateActionDelete:
+		d.Deleted++
+	case RateActionDeactivate:
+		d.Deactivated++
+	}
+}
+
+type ruleKey struct{ method, zone string }
+
+func (k ruleKey) String() string { return k.method + "/" + k.zone }
+
+// rateState is the configuration currently in the database, indexed by the keys
+// documents use
+type rateState struct {
+	methods map[string]models.ShippingMethod
+	zones   map[string]models.ShippingZone
+	rules   map[ruleKey]models.ShippingRule
+	bands   map[uuid.UUID][]models.ShippingDistanceBand
+	stores  map[string][]string // zone name -> stores whose own rates or exclusions use it
+}
+
+func loadRateState(tx *gorm.DB) (*rateState, error) {
+	var methods []models.ShippingMethod
+	var zones []models.ShippingZone
+	var rules []models.ShippingRule
+	var bands []models.ShippingDistanceBand
+	if err := tx.Find(&methods).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Find(&zones).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Order("min_km ASC").Find(&bands).Error; err != nil {
+		return nil, err
+	}
+
+	st := &rateState{
+		methods: map[string]models.ShippingMethod{},
+		zones:   map[string]models.ShippingZone{},
+		rules:   map[ruleKey]models.ShippingRule{},
+		bands:   map[uuid.UUID][]models.ShippingDistanceBand{},
+		stores:  map[string][]string{},
+	}
+	codes := map[uuid.UUID]string{}
+	for _, m := range methods {
+		st.methods[m.Code] = m
+		codes[m.ID] = m.Code
+	}
+	names := map[uuid.UUID]string{}
+	for _, z := range zones {
+		if _, dup := st.zones[z.Name]; dup {
+			return nil, fmt.Errorf("%w (%q)", ErrDuplicateZoneNames, z.Name)
+		}
+		st.zones[z.Name] = z
+		names[z.ID] = z.Name
+	}
+	for _, r := range rules {
+		st.rules[ruleKey{codes[r.ShippingMethodID], names[r.ShippingZoneID]}] = r
+	}
+	for _, b := range bands {
+		st.bands[b.ShippingMethodID] = append(st.bands[b.ShippingMethodID], b)
+	}
+
+	zoneIDs := make([]uuid.UUID, 0, len(zones))
+	for _, z := range zones {
+		zoneIDs = append(zoneIDs, z.ID)
+	}
+	users, err := zoneStoreUsers(tx, zoneIDs)
+	if err != nil {
+		return nil, err
+	}
+	for id, stores := range users {
+		st.stores[names[id]] = stores
+	}
+	return st, nil
+}
+
+// config renders the state as a document, stamped with its version
+func (st *rateState) config() RateConfig {
+	var cfg RateConfig
+	for _, m := range st.methods {
+		cfg.Methods = append(cfg.Methods, rateMethodFrom(m, st.bands[m.ID]))
+	}
+	for _, z := range st.zones {
+		cfg.Zones = append(cfg.Zones, rateZoneFrom(z))
+	}
+	for key, r := range st.rules {
+		cfg.Rules = append(cfg.Rules, rateRuleFrom(key, r))
+	}
+	cfg.normalize()
+	cfg.Version = cfg.version()
+	return cfg
+}
+
+func rateMethodFrom(m models.ShippingMethod, bands []models.ShippingDistanceBand) RateMethod {
+	out := RateMethod{
+		Code:             m.Code,
+		Name:             m.Name,
+		Type:             m.Type,
+		BaseCostCents:    m.BaseCostCents,
+		CostPerKgCents:   m.CostPerKgCents,
+		MaxWeightGrams:   m.MaxWeightGrams,
+		DeliveryDaysMin:  m.DeliveryDaysMin,
+		DeliveryDaysMax:  m.DeliveryDaysMax,
+		DeliversSaturday: m.DeliversSaturday,
+		DeliversSunday:   m.DeliversSunday,
+		IsActive:         &m.IsActive,
+		DistanceBands:    []RateBand{},
+	}
+	if m.Description != nil {
+		out.Description = *m.Description
+	}
+	for _, b := range bands {
+		out.DistanceBands = append(out.DistanceBands, RateBand{MinKm: b.MinKm, MaxKm: b.MaxKm, CostCents: b.CostCents})
+	}
+	return out
+}
+
+func rateZoneFrom(z models.ShippingZone) RateZone {
+	out := RateZone{
+		Name:                z.Name,
+		Country:             z.Country,
+		AdditionalCostCents: z.AdditionalCostCents,
+		Priority:            z.Priority,
+		IsActive:            &z.IsActive,
+	}
+	if z.State != nil {
+		out.State = *z.State
+	}
+	if z.City != nil {
+		out.City = *z.City
+	}
+	if z.PostalCodePattern != nil {
+		out.PostalCodePattern = *z.PostalCodePattern
+	}
+	return out
+}
+
+func rateRuleFrom(key ruleKey, r models.ShippingRule) RateRule {
+	return RateRule{
+		MethodCode:                 key.method,
+		ZoneName:                   key.zone,
+		CostOverrideCents:          r.CostOverrideCents,
+		MinOrderValueCents:         r.MinOrderValueCents,
+		MaxOrderValueCents:         r.MaxOrderValueCents,
+		FreeShippingThresholdCents: r.FreeShippingThresholdCents,
+		IsAvailable:                &r.IsAvailable,
+	}
+}
+
+// normalize trims keys, fills defaults and sorts records, so equal
+// configurations render (and hash) identically
+func (cfg *RateConfig) normalize() {
+	active := func(b **bool) {
+		if *b == nil {
+			t := true
+			*b = &t
+		}
+	}
+	for i := range cfg.Methods {
+		m := &cfg.Methods[i]
+		m.Code = strings.TrimSpace(m.Code)
+		m.Name = strings.TrimSpace(m.Name)
+		m.Type = strings.TrimSpace(m.Type)
+		if m.Type == "" {
+			m.Type = ShippingTypeDelivery
+		}
+		m.Description = strings.TrimSpace(m.Description)
+		if m.MaxWeightGrams != nil && *m.MaxWeightGrams == 0 {
+			m.MaxWeightGrams = nil
+		}
+		active(&m.IsActive)
+		if m.DistanceBands == nil {
+			m.DistanceBands = []RateBand{}
+		}
+		sort.SliceStable(m.DistanceBands, func(a, b int) bool { return m.DistanceBands[a].MinKm < m.DistanceBands[b].MinKm })
+	}
+	for i := range cfg.Zones {
+		z := &cfg.Zones[i]
+		z.Name = strings.TrimSpace(z.Name)
+		z.Country = strings.TrimSpace(z.Country)
+		z.State = strings.TrimSpace(z.State)
+		z.City = strings.TrimSpace(z.City)
+		z.PostalCodePattern = strings.TrimSpace(z.PostalCodePattern)
+		active(&z.IsActive)
+	}
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
+		r.MethodCode = strings.TrimSpace(r.MethodCode)
+		r.ZoneName = strings.TrimSpace(r.ZoneName)
+		active(&r.IsAvailable)
+	}
+	if cfg.Methods == nil {
+		cfg.Methods = []RateMethod{}
+	}
+	if cfg.Zones == nil {
+		cfg.Zones = []RateZone{}
+	}
+	if cfg.Rules == nil {
+		cfg.Rules = []RateRule{}
+	}
+	sort.SliceStable(cfg.Methods, func(a, b int) bool { return cfg.Methods[a].Code < cfg.Methods[b].Code })
+	sort.SliceStable(cfg.Zones, func(a, b int) bool { return cfg.Zones[a].Name < cfg.Zones[b].Name })
+	sort.SliceStable(cfg.Rules, func(a, b int) bool {
+		if cfg.Rules[a].MethodCode != cfg.Rules[b].MethodCode {
+			return cfg.Rules[a].MethodCode < cfg.Rules[b].MethodCode
+		}
+		return cfg.Rules[a].ZoneName < cfg.Rules[b].ZoneName
+	})
+}
+
+// version fingerprints a normalized configuration
+func (cfg RateConfig) version() string {
+	cfg.Version = ""
+	body, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (m RateMethod) apply(dst *models.ShippingMethod) {
+	dst.Code = m.Code
+	dst.Name = m.Name
+	dst.Type = m.Type
+	dst.Description = optionalString(m.Description)
+	dst.BaseCostCents = m.BaseCostCents
+	dst.CostPerKgCents = m.CostPerKgCents
+	dst.MaxWeightGrams = m.MaxWeightGrams
+	dst.DeliveryDaysMin = m.DeliveryDaysMin
+	dst.DeliveryDaysMax = m.DeliveryDaysMax
+	dst.DeliversSaturday = m.DeliversSaturday
+	dst.DeliversSunday = m.DeliversSunday
+	dst.IsActive = *m.IsActive
+}
+
+func (m RateMethod) bands() []models.ShippingDistanceBand {
+	bands := make([]models.ShippingDistanceBand, 0, len(m.DistanceBands))
+	for _, b := range m.DistanceBands {
+		bands = append(bands, models.ShippingDistanceBand{MinKm: b.MinKm, MaxKm: b.MaxKm, CostCents: b.CostCents})
+	}
+	return bands
+}
+
+func (z RateZone) apply(dst *models.ShippingZone) {
+	dst.Name = z.Name
+	dst.Country = z.Country
+	dst.State = optionalString(z.State)
+	dst.City = optionalString(z.City)
+	dst.PostalCodePattern = optionalString(z.PostalCodePattern)
+	dst.AdditionalCostCents = z.AdditionalCostCents
+	dst.Priority = z.Priority
+	dst.IsActive = *z.IsActive
+}
+
+func (r RateRule) apply(dst *models.ShippingRule) {
+	dst.CostOverrideCents = r.CostOverrideCents
+	dst.MinOrderValueCents = r.MinOrderValueCents
+	dst.MaxOrderValueCents = r.MaxOrderValueCents
+	dst.FreeShippingThresholdCents = r.FreeShippingThresholdCents
+	dst.IsAvailable = *r.IsAvailable
+}
+
+// ExportRates returns the current rate configuration as a document
+func (s *ShippingService) ExportRates() (*RateConfig, error) {
+	st, err := loadRateState(s.db)
+	if err != nil {
+		return nil, err
+	}
+	cfg := st.config()
+	return &cfg, nil
+}
+
+// PreviewRates validates a document and reports what importing it would change,
+// without changing anything
+func (s *ShippingService) PreviewRates(cfg RateConfig) (*RateDiff, error) {
+	st, err := loadRateState(s.db)
+	if err != nil {
+		return nil, err
+	}
+	cfg.normalize()
+	if err := validateRateConfig(cfg, st); err != nil {
+		return nil, err
+	}
+	return diffRates(cfg, st), nil
+}
+
+// ApplyRates replaces the rate configuration with a document in one
+// transaction: records in the document are created or updated, methods missing
+// from it are deactivated (orders refer to them), and missing zones, rules and
+// distance bands are deleted. Leaving out a zone that stores still use is a
+// validation error. With expectedVersion set, the import is refused
+// with ErrRatesChanged if the configuration changed since that version was
+// exported or previewed.
+func (s *ShippingService) ApplyRates(cfg RateConfig, expectedVersion string) (*RateDiff, error) {
+	cfg.normalize()
+	var diff *RateDiff
+	err := ShippingTransaction(s.db, func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", rateImportLock).Error; err != nil {
+			return err
+		}
+		st, err := loadRateState(tx)
+		if err != nil {
+			return err
+		}
+		if expectedVersion != "" && st.config().Version != expectedVersion {
+			return ErrRatesChanged
+		}
+		if err := validateRateConfig(cfg, st); err != nil {
+			return err
+		}
+		diff = diffRates(cfg, st)
+		return (&ShippingService{db: tx}).applyRates(cfg, st, diff)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+func validateRateConfig(cfg RateConfig, st *rateState) error {
+	var errs RateValidationErrors
+	report := func(prefix string, err error) {
+		if err == nil || len(errs) >= maxRateValidationErrors {
+			return
+		}
+		var ve ValidationError
+		if errors.As(err, &ve) {
+			errs = append(errs, ValidationError{Field: prefix + "." + ve.Field, Message: ve.Message})
+			return
+		}
+		errs = append(errs, ValidationError{Field: prefix, Message: err.Error()})
+	}
+
+	if len(cfg.Methods) == 0 {
+		report("methods", ErrEmptyRateConfig)
+	}
+
+	codes := map[string]bool{}
+	names := map[string]string{}
+	for i, m := range cfg.Methods {
+		prefix := rateField("methods", i, m.Code)
+		if codes[m.Code] {
+			report(prefix, invalid("code", "appears more than once"))
+			continue
+		}
+		codes[m.Code] = true
+		if other, dup := names[m.Name]; dup && m.Name != "" {
+			report(prefix, invalid("name", "is also used by method %s", other))
+		}
+		names[m.Name] = m.Code
+
+		var model models.ShippingMethod
+		m.apply(&model)
+		report(prefix, ValidateShippingMethod(model))
+		if err := ValidateDistanceBands(m.bands()); err != nil {
+			report(prefix, invalid("distance_bands", "%v", err))
+		}
+	}
+	// Methods left out are kept (deactivated), so their names stay taken
+	for code, m := range st.methods {
+		if other, dup := names[m.Name]; dup && !codes[code] {
+			report(rateField("methods", -1, other), invalid("name", "is used by method %s, which is not in the import", code))
+		}
+	}
+
+	zones := map[string]bool{}
+	for i, z := range cfg.Zones {
+		prefix := rateField("zones", i, z.Name)
+		if zones[z.Name] {
+			report(prefix, invalid("name", "appears more than once"))
+			continue
+		}
+		zones[z.Name] = true
+		var model models.ShippingZone
+		z.apply(&model)
+		report(prefix, ValidateShippingZone(model))
+	}
+	// Zones left out are deleted, which would pull them from under sellers
+	var inUse []string
+	for name := range st.stores {
+		if !zones[name] {
+			inUse = append(inUse, name)
+		}
+	}
+	sort.Strings(inUse)
+	for _, name := range inUse {
+		report(rateField("zones", -1, name), invalid("name", "is used by stores %s, so it can't be removed; keep it with is_active false instead",
+			strings.Join(st.stores[name], ", ")))
+	}
+
+	rules := map[ruleKey]bool{}
+	for i, r := range cfg.Rules {
+		key := ruleKey{r.MethodCode, r.ZoneName}
+		prefix := rateField("rules", i, key.String())
+		if !codes[r.MethodCode] {
+			report(prefix, invalid("method_code", "no method %q in the import", r.MethodCode))
+		}
+		if !zones[r.ZoneName] {
+			report(prefix, invalid("zone_name", "no zone %q in the import", r.ZoneName))
+		}
+		if rules[key] {
+			report(prefix, invalid("zone_name", "another rule covers this method and zone"))
+			continue
+		}
+		rules[key] = true
+		var model models.ShippingRule
+		r.apply(&model)
+		report(prefix, validateRuleAmounts(model))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// rateField names a record in error messages by its key, or by position when
+// the key is missing
+func rateField(list string, i int, key string) string {
+	if key == "" {
+		return fmt.Sprintf("%s[%d]", list, i)
+	}
+	return fmt.Sprintf("%s[%s]", list, key)
+}
+
+func diffRates(cfg RateConfig, st *rateState) *RateDiff {
+	current := st.config()
+	diff := &RateDiff{Version: current.Version, Changes: []RateChange{}}
+
+	methods := map[string]RateMethod{}
+	for _, m := range current.Methods {
+		methods[m.Code] = m
+	}
+	for _, m := range cfg.Methods {
+		diffRecord(diff, RateRecordMethod, m.Code, methods[m.Code], m, hasKey(methods, m.Code))
+		delete(methods, m.Code)
+	}
+	for _, m := range current.Methods {
+		if _, missing := methods[m.Code]; !missing {
+			continue
+		}
+		if *m.IsActive {
+			diff.add(RateChange{Record: RateRecordMethod, Key: m.Code, Action: RateActionDeactivate})
+		} else {
+			diff.Unchanged++
+		}
+	}
+
+	zones := map[string]RateZone{}
+	for _, z := range current.Zones {
+		zones[z.Name] = z
+	}
+	for _, z := range cfg.Zones {
+		diffRecord(diff, RateRecordZone, z.Name, zones[z.Name], z, hasKey(zones, z.Name))
+		delete(zones, z.Name)
+	}
+	for _, z := range current.Zones {
+		if _, missing := zones[z.Name]; missing {
+			diff.add(RateChange{Record: RateRecordZone, Key: z.Name, Action: RateActionDelete})
+		}
+	}
+
+	rules := map[ruleKey]RateRule{}
+	for _, r := range current.Rules {
+		rules[ruleKey{r.MethodCode, r.ZoneName}] = r
+	}
+	for _, r := range cfg.Rules {
+		key := ruleKey{r.MethodCode, r.ZoneName}
+		diffRecord(diff, RateRecordRule, key.String(), rules[key], r, hasKey(rules, key))
+		delete(rules, key)
+	}
+	for _, r := range current.Rules {
+		key := ruleKey{r.MethodCode, r.ZoneName}
+		if _, missing := rules[key]; missing {
+			diff.add(RateChange{Record: RateRecordRule, Key: key.String(), Action: RateActionDelete})
+		}
+	}
+	return diff
+}
+
+func hasKey[K comparable, V any](m map[K]V, key K) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// diffRecord compares a record's JSON fields with its current version
+func diffRecord(diff *RateDiff, record, key string, current, next any, exists bool) {
+	if !exists {
+		diff.add(RateChange{Record: record, Key: key, Action: RateActionCreate})
+		return
+	}
+	fields := changedFields(current, next)
+	if len(fields) == 0 {
+		diff.Unchanged++
+		return
+	}
+	diff.add(RateChange{Record: record, Key: key, Action: RateActionUpdate, Fields: fields})
+}
+
+func changedFields(a, b any) []string {
+	var left, right map[string]json.RawMessage
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	_ = json.Unmarshal(ja, &left)
+	_ = json.Unmarshal(jb, &right)
+	var fields []string
+	for name, value := range right {
+		if string(left[name]) != string(value) {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// applyRates writes a validated document's changes. Rules go last, once the
+// methods and zones they name exist.
+func (s *ShippingService) applyRates(cfg RateConfig, st *rateState, diff *RateDiff) error {
+	changed := map[string]RateChange{}
+	for _, c := range diff.Changes {
+		changed[c.Record+"\x00"+c.Key] = c
+	}
+	lookup := func(record, key string) (RateChange, bool) {
+		c, ok := changed[record+"\x00"+key]
+		return c, ok
+	}
+
+	methodIDs := map[string]uuid.UUID{}
+	for _, m := range cfg.Methods {
+		model := st.methods[m.Code]
+		methodIDs[m.Code] = model.ID
+		change, ok := lookup(RateRecordMethod, m.Code)
+		if !ok {
+			continue
+		}
+		m.apply(&model)
+		if err := s.save(s.db, &model, &model.ID); err != nil {
+			return fmt.Errorf("method %s: %w", m.Code, err)
+		}
+		methodIDs[m.Code] = model.ID
+		if change.Action == RateActionCreate || containsString(change.Fields, "distance_bands") {
+			if err := replaceBands(s.db, model.ID, m.bands()); err != nil {
+				return fmt.Errorf("method %s: %w", m.Code, err)
+			}
+		}
+	}
+	for _, c := range diff.Changes {
+		if c.Record == RateRecordMethod && c.Action == RateActionDeactivate {
+			model := st.methods[c.Key]
+			if err := s.db.Model(&model).Update("is_active", false).Error; err != nil {
+				return fmt.Errorf("method %s: %w", c.Key, err)
+			}
+		}
+	}
+
+	zoneIDs := map[string]uuid.UUID{}
+	for _, z := range cfg.Zones {
+		model := st.zones[z.Name]
+		zoneIDs[z.Name] = model.ID
+		if _, ok := lookup(RateRecordZone, z.Name); !ok {
+			continue
+		}
+		z.apply(&model)
+		if err := s.save(s.db, &model, &model.ID); err != nil {
+			return fmt.Errorf("zone %s: %w", z.Name, err)
+		}
+		zoneIDs[z.Name] = model.ID
+	}
+
+	// Replace semantics: zones and rules left out of the document go
+	docRules := map[ruleKey]bool{}
+	for _, r := range cfg.Rules {
+		docRules[ruleKey{r.MethodCode, r.ZoneName}] = true
+	}
+	for key, rule := range st.rules {
+		if !docRules[key] {
+			if err := s.db.Delete(&rule).Error; err != nil {
+				return fmt.Errorf("rule %s: %w", key, err)
+			}
+		}
+	}
+	for name, zone := range st.zones {
+		if _, kept := zoneIDs[name]; !kept {
+			if err := s.db.Delete(&zone).Error; err != nil {
+				return fmt.Errorf("zone %s: %w", name, err)
+			}
+		}
+	}
+
+	for _, r := range cfg.Rules {
+		key := ruleKey{r.MethodCode, r.ZoneName}
+		if _, ok := lookup(RateRecordRule, key.String()); !ok {
+			continue
+		}
+		model := st.rules[key]
+		model.ShippingMethodID = methodIDs[r.MethodCode]
+		model.ShippingZoneID = zoneIDs[r.ZoneName]
+		r.apply(&model)
+		if err := s.save(s.db.Omit("ShippingMethod", "ShippingZone"), &model, &model.ID); err != nil {
+			return fmt.Errorf("rule %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// replaceBands swaps a method's distance rate table inside the caller's transaction
+func replaceBands(tx *gorm.DB, methodID uuid.UUID, bands []models.ShippingDistanceBand) error {
+	if err := tx.Where("shipping_method_id = ?", methodID).Delete(&models.ShippingDistanceBand{}).Error; err != nil {
+		return err
+	}
+	for i := range bands {
+		bands[i].ID = uuid.New()
+		bands[i].ShippingMethodID = methodID
+		if err := tx.Create(&bands[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
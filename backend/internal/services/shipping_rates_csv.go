@@ -0,0 +1,335 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// rateCSVColumns is the CSV layout of a rate configuration: one row per
+// method, zone, rule or distance band, told apart by the record column. Each
+// kind of row fills only the columns that apply to it.
+var rateCSVColumns = []string{
+	"record", "method_code", "zone_name", "name", "type", "description",
+	"country", "state", "city", "postal_code_pattern",
+	"base_cost_cents", "cost_per_kg_cents", "max_weight_grams",
+	"delivery_days_min", "delivery_days_max", "delivers_saturday", "delivers_sunday",
+	"additional_cost_cents", "priority",
+	"cost_override_cents", "min_order_value_cents", "max_order_value_cents", "free_shipping_threshold_cents",
+	"min_km", "max_km", "cost_cents", "active",
+}
+
+// EncodeRatesCSV writes a rate configuration in the CSV layout
+func EncodeRatesCSV(w io.Writer, cfg RateConfig) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(rateCSVColumns); err != nil {
+		return err
+	}
+	row := func(values map[string]string) error {
+		record := make([]string, len(rateCSVColumns))
+		for i, col := range rateCSVColumns {
+			record[i] = values[col]
+		}
+		return out.Write(record)
+	}
+
+	for _, m := range cfg.Methods {
+		err := row(map[string]string{
+			"record":            RateRecordMethod,
+			"method_code":       m.Code,
+			"name":              m.Name,
+			"type":              m.Type,
+			"description":       m.Description,
+			"base_cost_cents":   formatInt(m.BaseCostCents),
+			"cost_per_kg_cents": formatInt(m.CostPerKgCents),
+			"max_weight_grams":  formatOptionalInt(m.MaxWeightGrams),
+			"delivery_days_min": strconv.Itoa(m.DeliveryDaysMin),
+			"delivery_days_max": strconv.Itoa(m.DeliveryDaysMax),
+			"delivers_saturday": strconv.FormatBool(m.DeliversSaturday),
+			"delivers_sunday":   strconv.FormatBool(m.DeliversSunday),
+			"active":            formatOptionalBool(m.IsActive),
+		})
+		if err != nil {
+			return err
+		}
+		for _, b := range m.DistanceBands {
+			err := row(map[string]string{
+				"record":      RateRecordBand,
+				"method_code": m.Code,
+				"min_km":      strconv.FormatFloat(b.MinKm, 'f', -1, 64),
+				"max_km":      formatOptionalFloat(b.MaxKm),
+				"cost_cents":  formatInt(b.CostCents),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, z := range cfg.Zones {
+		err := row(map[string]string{
+			"record":                RateRecordZone,
+			"zone_name":             z.Name,
+			"country":               z.Country,
+			"state":                 z.State,
+			"city":                  z.City,
+			"postal_code_pattern":   z.PostalCodePattern,
+			"additional_cost_cents": formatInt(z.AdditionalCostCents),
+			"priority":              strconv.Itoa(z.Priority),
+			"active":                formatOptionalBool(z.IsActive),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, r := range cfg.Rules {
+		err := row(map[string]string{
+			"record":                        RateRecordRule,
+			"method_code":                   r.MethodCode,
+			"zone_name":                     r.ZoneName,
+			"cost_override_cents":           formatOptionalInt(r.CostOverrideCents),
+			"min_order_value_cents":         formatInt(r.MinOrderValueCents),
+			"max_order_value_cents":         formatOptionalInt(r.MaxOrderValueCents),
+			"free_shipping_threshold_cents": formatOptionalInt(r.FreeShippingThresholdCents),
+			"active":                        formatOptionalBool(r.IsAvailable),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// DecodeRatesCSV reads a rate configuration in the CSV layout. Columns are
+// matched by header name, so they may come in any order and unused ones may be
+// left out. Every unreadable cell is reported, as RateValidationErrors.
+func DecodeRatesCSV(r io.Reader) (RateConfig, error) {
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = -1
+	in.TrimLeadingSpace = true
+
+	header, err := in.Read()
+	if errors.Is(err, io.EOF) {
+		return RateConfig{}, ValidationError{Field: "csv", Message: "file is empty"}
+	}
+	if err != nil {
+		return RateConfig{}, ValidationError{Field: "csv", Message: err.Error()}
+	}
+	index := map[string]int{}
+	for i, col := range header {
+		index[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(col, "\ufeff")))] = i
+	}
+	if _, ok := index["record"]; !ok {
+		return RateConfig{}, ValidationError{Field: "csv", Message: "header must include a record column"}
+	}
+
+	var cfg RateConfig
+	var errs RateValidationErrors
+	methods := map[string]int{}
+	var bands []csvRow
+
+	for line := 2; ; line++ {
+		record, err := in.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("row %d", line), Message: err.Error()})
+			break
+		}
+		row := csvRow{line: line, record: record, index: index}
+		switch kind := strings.ToLower(row.str("record")); kind {
+		case "":
+			continue // blank or spacer row
+		case RateRecordMethod:
+			m := RateMethod{
+				Code:             row.str("method_code"),
+				Name:             row.str("name"),
+				Type:             row.str("type"),
+				Description:      row.str("description"),
+				BaseCostCents:    row.int64("base_cost_cents"),
+				CostPerKgCents:   row.int64("cost_per_kg_cents"),
+				MaxWeightGrams:   row.optionalInt("max_weight_grams"),
+				DeliveryDaysMin:  int(row.int64("delivery_days_min")),
+				DeliveryDaysMax:  int(row.int64("delivery_days_max")),
+				DeliversSaturday: row.bool("delivers_saturday"),
+				DeliversSunday:   row.bool("delivers_sunday"),
+				IsActive:         row.optionalBool("active"),
+			}
+			if _, dup := methods[m.Code]; !dup {
+				methods[m.Code] = len(cfg.Methods)
+			}
+			cfg.Methods = append(cfg.Methods, m)
+		case RateRecordBand:
+			bands = append(bands, row)
+		case RateRecordZone:
+			cfg.Zones = append(cfg.Zones, RateZone{
+				Name:                row.str("zone_name"),
+				Country:             row.str("country"),
+				State:               row.str("state"),
+				City:                row.str("city"),
+				PostalCodePattern:   row.str("postal_code_pattern"),
+				AdditionalCostCents: row.int64("additional_cost_cents"),
+				Priority:            int(row.int64("priority")),
+				IsActive:            row.optionalBool("active"),
+			})
+		case RateRecordRule:
+			cfg.Rules = append(cfg.Rules, RateRule{
+				MethodCode:                 row.str("method_code"),
+				ZoneName:                   row.str("zone_name"),
+				CostOverrideCents:          row.optionalInt64("cost_override_cents"),
+				MinOrderValueCents:         row.int64("min_order_value_cents"),
+				MaxOrderValueCents:         row.optionalInt64("max_order_value_cents"),
+				FreeShippingThresholdCents: row.optionalInt64("free_shipping_threshold_cents"),
+				IsAvailable:                row.optionalBool("active"),
+			})
+		default:
+			row.fail("record", "must be method, zone, rule or band, not %q", kind)
+		}
+		errs = append(errs, row.errs...)
+	}
+
+	// Bands may come before or after their method's row
+	for _, row := range bands {
+		band := RateBand{
+			MinKm:     row.float("min_km"),
+			MaxKm:     row.optionalFloat("max_km"),
+			CostCents: row.int64("cost_cents"),
+		}
+		code := row.str("method_code")
+		if i, ok := methods[code]; ok {
+			cfg.Methods[i].DistanceBands = append(cfg.Methods[i].DistanceBands, band)
+		} else {
+			row.fail("method_code", "no method %q in the file", code)
+		}
+		errs = append(errs, row.errs...)
+	}
+
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	return cfg, nil
+}
+
+// csvRow reads typed cells from one CSV record, collecting parse errors
+type csvRow struct {
+	line   int
+	record []string
+	index  map[string]int
+	errs   RateValidationErrors
+}
+
+func (r *csvRow) str(col string) string {
+	i, ok := r.index[col]
+	if !ok || i >= len(r.record) {
+		return ""
+	}
+	return strings.TrimSpace(r.record[i])
+}
+
+func (r *csvRow) fail(col, format string, args ...any) {
+	r.errs = append(r.errs, ValidationError{
+		Field:   fmt.Sprintf("row %d.%s", r.line, col),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *csvRow) optionalInt64(col string) *int64 {
+	raw := r.str(col)
+	if raw == "" {
+		return nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		r.fail(col, "must be a whole number")
+		return nil
+	}
+	return &v
+}
+
+func (r *csvRow) int64(col string) int64 {
+	if v := r.optionalInt64(col); v != nil {
+		return *v
+	}
+	return 0
+}
+
+func (r *csvRow) optionalInt(col string) *int {
+	v := r.optionalInt64(col)
+	if v == nil {
+		return nil
+	}
+	n := int(*v)
+	return &n
+}
+
+func (r *csvRow) optionalFloat(col string) *float64 {
+	raw := r.str(col)
+	if raw == "" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		r.fail(col, "must be a number")
+		return nil
+	}
+	return &v
+}
+
+func (r *csvRow) float(col string) float64 {
+	if v := r.optionalFloat(col); v != nil {
+		return *v
+	}
+	return 0
+}
+
+func (r *csvRow) optionalBool(col string) *bool {
+	raw := r.str(col)
+	if raw == "" {
+		return nil
+	}
+	var v bool
+	switch strings.ToLower(raw) {
+	case "true", "yes", "y", "1":
+		v = true
+	case "false", "no", "n", "0":
+	default:
+		r.fail(col, "must be true or false")
+		return nil
+	}
+	return &v
+}
+
+func (r *csvRow) bool(col string) bool {
+	if v := r.optionalBool(col); v != nil {
+		return *v
+	}
+	return false
+}
+
+func formatInt(v int64) string { return strconv.FormatInt(v, 10) }
+
+func formatOptionalInt[T int | int64](v *T) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatInt(int64(*v), 10)
+}
+
+func formatOptionalFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+func formatOptionalBool(v *bool) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatBool(*v)
+}
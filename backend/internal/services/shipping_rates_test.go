@@ -0,0 +1,258 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+// seedRates creates a small rate configuration and returns it as the state
+// imports are checked against. loadRateState itself needs Postgres.
+func seedRates(t *testing.T, db *gorm.DB) *rateState {
+	t.Helper()
+	standard := newMethod(t, db, "standard", 500)
+	standard.Name = "Standard"
+	db.Model(&standard).Update("name", "Standard")
+	express := newMethod(t, db, "express", 900)
+	express.Name = "Express"
+	db.Model(&express).Update("name", "Express")
+	bands := []models.ShippingDistanceBand{{MinKm: 0, MaxKm: km(10), CostCents: 300}, {MinKm: 10, CostCents: 700}}
+	if err := replaceBands(db, standard.ID, bands); err != nil {
+		t.Fatal(err)
+	}
+	kenya := newCountryZone(t, db, "Kenya", 200)
+	coastline := newZone(t, db, models.ShippingZone{Name: "Coastline", City: strPtr("Mombasa")})
+	override := int64(400)
+	rule := models.ShippingRule{ShippingMethodID: standard.ID, ShippingZoneID: kenya.ID, CostOverrideCents: &override, IsAvailable: true}
+	mustCreate(t, db, &rule)
+
+	return &rateState{
+		methods: map[string]models.ShippingMethod{"standard": standard, "express": express},
+		zones:   map[string]models.ShippingZone{"Kenya": kenya, "Coastline": coastline},
+		rules:   map[ruleKey]models.ShippingRule{{"standard", "Kenya"}: rule},
+		bands:   map[uuid.UUID][]models.ShippingDistanceBand{standard.ID: bands},
+		stores:  map[string][]string{},
+	}
+}
+
+func cloneRates(t *testing.T, cfg RateConfig) RateConfig {
+	t.Helper()
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out RateConfig
+	if err := json.Unmarshal(raw, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// rateImport is the document TestDiffAndApplyRates imports over seedRates: it
+// raises the standard price, adds a pickup method, a Coast zone and a rule for
+// it, and leaves out express, Coastline and the standard/Kenya rule
+func rateImport(t *testing.T, st *rateState) RateConfig {
+	t.Helper()
+	cfg := cloneRates(t, st.config())
+	cfg.Version = ""
+	cfg.Methods[1].BaseCostCents = 600 // sorted by code: express, standard
+	cfg.Methods[0] = RateMethod{Code: "pickup", Name: "Pickup", Type: ShippingTypePickup, BaseCostCents: 150, DeliveryDaysMin: 1, DeliveryDaysMax: 2}
+	cfg.Zones[0] = RateZone{Name: "Coast", Country: "Kenya", PostalCodePattern: "80*", AdditionalCostCents: 300}
+	cfg.Rules = []RateRule{{MethodCode: "standard", ZoneName: "Coast", MinOrderValueCents: 1000}}
+	cfg.normalize()
+	return cfg
+}
+
+func TestRateConfigVersion(t *testing.T) {
+	st := seedRates(t, openShippingDB(t))
+	cfg := st.config()
+
+	reordered := cloneRates(t, cfg)
+	reordered.Methods[0], reordered.Methods[1] = reordered.Methods[1], reordered.Methods[0]
+	reordered.Zones[0].Name = "  " + reordered.Zones[0].Name + " "
+	reordered.normalize()
+	if reordered.version() != cfg.Version {
+		t.Error("the same configuration in another order has another version")
+	}
+
+	changed := cloneRates(t, cfg)
+	changed.Zones[0].AdditionalCostCents++
+	if changed.version() == cfg.Version {
+		t.Error("a changed configuration kept its version")
+	}
+}
+
+func TestValidateRateConfig(t *testing.T) {
+	st := seedRates(t, openShippingDB(t))
+	base := st.config()
+	with := func(change func(*RateConfig)) RateConfig {
+		cfg := cloneRates(t, base)
+		change(&cfg)
+		cfg.normalize()
+		return cfg
+	}
+
+	cases := []struct {
+		name   string
+		cfg    RateConfig
+		stores map[string][]string
+		field  string // of the first problem; empty when valid
+	}{
+		{"unchanged", base, nil, ""},
+		{"the import", rateImport(t, st), nil, ""},
+		{"no methods", with(func(c *RateConfig) { c.Methods = nil; c.Rules = nil }), nil, "methods"},
+		{"method code twice", with(func(c *RateConfig) { c.Methods[0].Code = "standard" }), nil, "methods[standard].code"},
+		{"method name twice", with(func(c *RateConfig) { c.Methods[0].Name = "Standard" }), nil, "methods[standard].name"},
+		{"name of a left-out method", with(func(c *RateConfig) {
+			c.Methods[0] = RateMethod{Code: "courier", Name: "Express", DeliveryDaysMax: 1}
+		}), nil, "methods[courier].name"},
+		{"bad method code", with(func(c *RateConfig) { c.Methods[0].Code = "Express!" }), nil, "methods[Express!].code"},
+		{"gap between bands", with(func(c *RateConfig) { c.Methods[1].DistanceBands[1].MinKm = 12 }), nil, "methods[standard].distance_bands"},
+		{"zone twice", with(func(c *RateConfig) { c.Zones[0].Name = "Kenya" }), nil, "zones[Kenya].name"},
+		{"bad postal pattern", with(func(c *RateConfig) { c.Zones[0].PostalCodePattern = "*" }), nil, "zones[Coastline].postal_code_pattern"},
+		{"zone in use left out", with(func(c *RateConfig) { c.Zones = c.Zones[1:] }), map[string][]string{"Coastline": {"Duka"}}, "zones[Coastline].name"},
+		{"zone in use kept", base, map[string][]string{"Coastline": {"Duka"}}, ""},
+		{"rule for a missing method", with(func(c *RateConfig) { c.Rules[0].MethodCode = "boda" }), nil, "rules[boda/Kenya].method_code"},
+		{"rule for a missing zone", with(func(c *RateConfig) { c.Rules[0].ZoneName = "Mars" }), nil, "rules[standard/Mars].zone_name"},
+		{"rule twice", with(func(c *RateConfig) { c.Rules = append(c.Rules, c.Rules[0]) }), nil, "rules[standard/Kenya].zone_name"},
+		{"negative override", with(func(c *RateConfig) { c.Rules[0].CostOverrideCents = int64Ptr(-1) }), nil, "rules[standard/Kenya].cost_override_cents"},
+	}
+	for _, tc := range cases {
+		st.stores = map[string][]string{}
+		if tc.stores != nil {
+			st.stores = tc.stores
+		}
+		err := validateRateConfig(tc.cfg, st)
+		var errs RateValidationErrors
+		switch {
+		case tc.field == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.field == "":
+		case !errors.As(err, &errs):
+			t.Errorf("%s: err = %v; want a problem with %s", tc.name, err, tc.field)
+		case errs[0].Field != tc.field:
+			t.Errorf("%s: first problem is %v; want one with %s", tc.name, errs[0], tc.field)
+		}
+	}
+}
+
+func TestDiffAndApplyRates(t *testing.T) {
+	db := openShippingDB(t)
+	st := seedRates(t, db)
+	cfg := rateImport(t, st)
+	if err := validateRateConfig(cfg, st); err != nil {
+		t.Fatal(err)
+	}
+
+	diff := diffRates(cfg, st)
+	if diff.Version != st.config().Version {
+		t.Errorf("diff taken against version %s; want %s", diff.Version, st.config().Version)
+	}
+	if diff.Created != 3 || diff.Updated != 1 || diff.Deleted != 2 || diff.Deactivated != 1 || diff.Unchanged != 1 {
+		t.Errorf("created %d, updated %d, deleted %d, deactivated %d, unchanged %d; want 3, 1, 2, 1, 1 (%+v)",
+			diff.Created, diff.Updated, diff.Deleted, diff.Deactivated, diff.Unchanged, diff.Changes)
+	}
+	for _, c := range diff.Changes {
+		if c.Record == RateRecordMethod && c.Key == "standard" && !reflect.DeepEqual(c.Fields, []string{"base_cost_cents"}) {
+			t.Errorf("standard changed %v; want only base_cost_cents", c.Fields)
+		}
+	}
+
+	if err := NewShippingService(db).applyRates(cfg, st, diff); err != nil {
+		t.Fatal(err)
+	}
+
+	var methods []models.ShippingMethod
+	db.Order("code").Find(&methods)
+	got := map[string]models.ShippingMethod{}
+	for _, m := range methods {
+		got[m.Code] = m
+	}
+	if len(methods) != 3 || got["express"].IsActive || got["standard"].BaseCostCents != 600 || got["pickup"].Type != ShippingTypePickup {
+		t.Errorf("methods after the import = %+v", methods)
+	}
+	var bands int64
+	db.Model(&models.ShippingDistanceBand{}).Where("shipping_method_id = ?", got["standard"].ID).Count(&bands)
+	if bands != 2 {
+		t.Errorf("standard has %d distance bands; want its 2 kept", bands)
+	}
+
+	var zones []string
+	db.Model(&models.ShippingZone{}).Order("name").Pluck("name", &zones)
+	if !reflect.DeepEqual(zones, []string{"Coast", "Kenya"}) {
+		t.Errorf("zones after the import = %v; want Coast, Kenya", zones)
+	}
+	var rules []models.ShippingRule
+	db.Preload("ShippingMethod").Preload("ShippingZone").Find(&rules)
+	if len(rules) != 1 || rules[0].ShippingMethod.Code != "standard" || rules[0].ShippingZone.Name != "Coast" || rules[0].MinOrderValueCents != 1000 {
+		t.Errorf("rules after the import = %+v; want only standard/Coast", rules)
+	}
+}
+
+func TestRatesCSVRoundTrip(t *testing.T) {
+	st := seedRates(t, openShippingDB(t))
+	cfg := rateImport(t, st)
+	limit := 20000
+	cfg.Methods[0].MaxWeightGrams = &limit
+	cfg.Methods[0].Description = "Collect from an agent, near you"
+	cfg.normalize()
+
+	var buf bytes.Buffer
+	if err := EncodeRatesCSV(&buf, cfg); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeRatesCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded.normalize()
+	if !reflect.DeepEqual(decoded, cfg) {
+		t.Errorf("decoded\n%+v\nwant\n%+v", decoded, cfg)
+	}
+}
+
+func TestDecodeRatesCSV(t *testing.T) {
+	cases := []struct {
+		name  string
+		csv   string
+		field string // of the first problem; empty when valid
+	}{
+		{"columns in any order", "\ufeffname,record,method_code,delivery_days_max\nStandard,method,standard,3\n,,,\nx,band,standard,\n", ""},
+		{"empty file", "", "csv"},
+		{"no record column", "method_code,name\nstandard,Standard\n", "csv"},
+		{"unknown record", "record\nwarehouse\n", "row 2.record"},
+		{"not a number", "record,method_code,base_cost_cents\nmethod,standard,5.00\n", "row 2.base_cost_cents"},
+		{"not a boolean", "record,method_code,active\nmethod,standard,maybe\n", "row 2.active"},
+		{"band for another method", "record,method_code,min_km\nmethod,standard,\nband,boda,0\n", "row 3.method_code"},
+	}
+	for _, tc := range cases {
+		_, err := DecodeRatesCSV(strings.NewReader(tc.csv))
+		if tc.field == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+			continue
+		}
+		var field string
+		var errs RateValidationErrors
+		var ve ValidationError
+		if errors.As(err, &errs) {
+			field = errs[0].Field
+		} else if errors.As(err, &ve) {
+			field = ve.Field
+		}
+		if field != tc.field {
+			t.Errorf("%s: err = %v; want a problem with %s", tc.name, err, tc.field)
+		}
+	}
+}
+
+func int64Ptr(v int64) *int64 { return &v }
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+	"trumall/mpesa"
+)
+
+// TopicSTKPush sends the M-Pesa payment prompt for a payment
+const TopicSTKPush = "mpesa.stk_push"
+
+type stkPushPayload struct {
+	PaymentID uuid.UUID `json:"payment_id"`
+}
+
+// EnqueueSTKPush queues the payment prompt for a payment created in the same
+// transaction
+func EnqueueSTKPush(tx *gorm.DB, paymentID uuid.UUID) (*models.OutboxMessage, error) {
+	return Enqueue(tx, TopicSTKPush, stkPushPayload{PaymentID: paymentID})
+}
+
+// SendSTKPush tries a push queued by EnqueueSTKPush straight away, once its
+// transaction has committed, and returns the payment as it then stands. If
+// M-Pesa can't be reached the outbox keeps retrying in the background.
+func SendSTKPush(db *gorm.DB, msg *models.OutboxMessage, paymentID uuid.UUID) (*models.Payment, error) {
+	if _, err := NewOutbox(db).Deliver(msg.ID); err != nil {
+		log.Printf("Error sending M-Pesa STK push for payment %s: %v", paymentID, err)
+	}
+	var payment models.Payment
+	if err := db.First(&payment, "id = ?", paymentID).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// stkPushHandler sends a queued payment prompt and records the outcome on the
+// payment: its checkout request ID, or why it could not be sent
+type stkPushHandler struct{}
+
+func (stkPushHandler) Handle(db *gorm.DB, msg models.OutboxMessage) error {
+	payment, order, err := stkPushTarget(db, msg)
+	if err != nil {
+		return err
+	}
+	// Paid, failed or superseded since it was queued: nothing to send
+	if payment.Status != "initiated" || order.Status != "pending" {
+		return nil
+	}
+	if payment.Phone == nil || *payment.Phone == "" {
+		return fmt.Errorf("%w: payment has no phone number", ErrOutboxPermanent)
+	}
+
+	checkoutRequestID, err := mpesa.InitiateSTK(*payment.Phone, int(payment.AmountCents/100), order.ID.String(), order.ID.String())
+	if err != nil {
+		if errors.Is(err, mpesa.ErrSTKRejected) {
+			return fmt.Errorf("%w: %v", ErrOutboxPermanent, err)
+		}
+		return err
+	}
+	// Record the request ID even if the payment was superseded while the push
+	// was in flight, so a payment to this prompt can still be matched
+	if err := db.Model(payment).Update("checkout_request_id", checkoutRequestID).Error; err != nil {
+		return err
+	}
+	return db.Model(&models.Payment{}).Where("id = ? AND status = ?", payment.ID, "initiated").
+		Updates(map[string]interface{}{"status": "pending", "failure_reason": nil}).Error
+}
+
+// GiveUp fails the payment. Its order stays pending, holding its stock, so the
+// buyer can be prompted again, e.g. on a corrected phone number.
+func (stkPushHandler) GiveUp(db *gorm.DB, msg models.OutboxMessage, cause error) error {
+	payment, _, err := stkPushTarget(db, msg)
+	if err != nil {
+		if errors.Is(err, ErrOutboxPermanent) {
+			return nil
+		}
+		return err
+	}
+	return db.Model(&models.Payment{}).Where("id = ? AND status = ?", payment.ID, "initiated").
+		Updates(map[string]interface{}{
+			"status":         "failed",
+			"failure_reason": stkFailureReason(cause),
+		}).Error
+}
+
+// stkFailureReason is the error as the buyer and support see it
+func stkFailureReason(cause error) string {
+	return strings.TrimPrefix(cause.Error(), ErrOutboxPermanent.Error()+": ")
+}
+
+func stkPushTarget(db *gorm.DB, msg models.OutboxMessage) (*models.Payment, *models.Order, error) {
+	var payload stkPushPayload
+	if err := json.Unmarshal([]byte(msg.Payload), &payload); err != nil {
+		return nil, nil, fmt.Errorf("%w: bad payload: %v", ErrOutboxPermanent, err)
+	}
+	var payment models.Payment
+	if err := db.First(&payment, "id = ?", payload.PaymentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, fmt.Errorf("%w: payment %s not found", ErrOutboxPermanent, payload.PaymentID)
+		}
+		return nil, nil, err
+	}
+	var order models.Order
+	if err := db.First(&order, "id = ?", payment.OrderID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, fmt.Errorf("%w: order %s not found", ErrOutboxPermanent, payment.OrderID)
+		}
+		return nil, nil, err
+	}
+	return &payment, &order, nil
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"trumall/internal/models"
+)
+
+// fakeMpesa stands in for the Daraja API. Each STK push is answered with the
+// status and body set on it; pushes counts them.
+type fakeMpesa struct {
+	status int
+	body   string
+	pushes int
+}
+
+func newFakeMpesa(t *testing.T) *fakeMpesa {
+	t.Helper()
+	f := &fakeMpesa{status: http.StatusOK, body: `{"CheckoutRequestID":"ws_CO_1"}`}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/oauth" {
+			w.Write([]byte(`{"access_token":"token","expires_in":"3599"}`))
+			return
+		}
+		f.pushes++
+		w.WriteHeader(f.status)
+		w.Write([]byte(f.body))
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("MPESA_OAUTH_URL", server.URL+"/oauth")
+	t.Setenv("MPESA_STK_URL", server.URL+"/stk")
+	return f
+}
+
+func TestSTKPush(t *testing.T) {
+	db := openOrderDB(t)
+	mpesa := newFakeMpesa(t)
+
+	cases := []struct {
+		name          string
+		status        int // of M-Pesa's answer
+		body          string
+		orderStatus   string
+		paymentStatus string
+		phone         string
+		pushed        bool
+		want          string // the payment's status afterwards
+		reason        string // part of its failure reason
+		retry         bool
+	}{
+		{"prompt sent", 200, `{"CheckoutRequestID":"ws_CO_1"}`, "pending", "initiated", "254712345678", true, "pending", "", false},
+		{"rejected", 400, `{"errorMessage":"Invalid PhoneNumber"}`, "pending", "initiated", "254712345678", true, "failed", "Invalid PhoneNumber", false},
+		{"m-pesa down", 503, `{"errorMessage":"Service unavailable"}`, "pending", "initiated", "254712345678", true, "initiated", "", true},
+		{"no checkout id", 200, `{}`, "pending", "initiated", "254712345678", true, "initiated", "", true},
+		{"no phone", 200, "", "pending", "initiated", "", false, "failed", "no phone number", false},
+		{"superseded since", 200, "", "pending", "superseded", "254712345678", false, "superseded", "", false},
+		{"order cancelled since", 200, "", "cancelled", "initiated", "254712345678", false, "initiated", "", false},
+	}
+	for _, tc := range cases {
+		mpesa.status, mpesa.body, mpesa.pushes = tc.status, tc.body, 0
+		order := newOrder(t, db, uuid.New(), tc.orderStatus, 1)
+		payment := newPayment(t, db, order.ID, tc.paymentStatus, tc.phone)
+		msg, err := EnqueueSTKPush(db, payment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := SendSTKPush(db, msg, payment.ID)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if (mpesa.pushes > 0) != tc.pushed {
+			t.Errorf("%s: %d pushes; want pushed = %v", tc.name, mpesa.pushes, tc.pushed)
+		}
+		if got.Status != tc.want {
+			t.Errorf("%s: payment %s; want %s", tc.name, got.Status, tc.want)
+		}
+		if tc.want == "pending" && (got.CheckoutRequestID == nil || *got.CheckoutRequestID != "ws_CO_1") {
+			t.Errorf("%s: checkout request %v; want ws_CO_1", tc.name, got.CheckoutRequestID)
+		}
+		if tc.reason != "" && (got.FailureReason == nil || !strings.Contains(*got.FailureReason, tc.reason) ||
+			strings.HasPrefix(*got.FailureReason, ErrOutboxPermanent.Error())) {
+			t.Errorf("%s: failure reason %v; want one saying %q", tc.name, got.FailureReason, tc.reason)
+		}
+		db.First(msg, "id = ?", msg.ID)
+		if retrying := msg.ProcessedAt == nil && msg.FailedAt == nil; retrying != tc.retry {
+			t.Errorf("%s: processed %v, failed %v; want retrying = %v", tc.name, msg.ProcessedAt, msg.FailedAt, tc.retry)
+		}
+		if got := statusOf[models.Order](t, db, order.ID); got != tc.orderStatus {
+			t.Errorf("%s: order %s; want it left %s", tc.name, got, tc.orderStatus)
+		}
+	}
+}
+
+func TestSTKPushGivesUpAfterRetries(t *testing.T) {
+	db := openOrderDB(t)
+	mpesa := newFakeMpesa(t)
+	mpesa.status, mpesa.body = 503, `{"errorMessage":"Service unavailable"}`
+	order := newOrder(t, db, uuid.New(), "pending", 1)
+	payment := newPayment(t, db, order.ID, "initiated", "254712345678")
+	msg, err := EnqueueSTKPush(db, payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deliverUntilSettled(t, db, msg)
+	if mpesa.pushes != len(outboxRetryDelays)+1 || msg.FailedAt == nil {
+		t.Errorf("%d pushes, failed %v; want %d and then giving up", mpesa.pushes, msg.FailedAt, len(outboxRetryDelays)+1)
+	}
+	var failed models.Payment
+	db.First(&failed, "id = ?", payment.ID)
+	if failed.Status != "failed" || failed.FailureReason == nil || !strings.Contains(*failed.FailureReason, "Service unavailable") {
+		t.Errorf("payment %s (%v); want failed with M-Pesa's error", failed.Status, failed.FailureReason)
+	}
+	if got := statusOf[models.Order](t, db, order.ID); got != "pending" {
+		t.Errorf("order %s; want it still pending, to be prompted again", got)
+	}
+}
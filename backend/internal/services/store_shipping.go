@@ -0,0 +1,342 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+var (
+	ErrStoreShippingProfileNotFound = errors.New("store has no shipping profile")
+	ErrStoreDoesNotShip             = errors.New("this store does not ship here")
+	ErrStoreMethodUnavailable       = errors.New("this store does not offer this shipping method here")
+)
+
+// StoreShippingProfileInput is a seller's whole shipping profile; saving it
+// replaces the previous one, rates included
+type StoreShippingProfileInput struct {
+	IsActive                   *bool                    `json:"is_active"` // nil = active
+	FreeShippingThresholdCents *int64                   `json:"free_shipping_threshold_cents"`
+	MaxDeliveryRadiusKm        *float64                 `json:"max_delivery_radius_km"`
+	ExcludedZoneIDs            []uuid.UUID              `json:"excluded_zone_ids"`
+	Rates                      []StoreShippingRateInput `json:"rates"`
+}
+
+// StoreShippingRateInput is one of a store's own rates. Methods are named by
+// code; leave zone_id out to apply in every zone.
+type StoreShippingRateInput struct {
+	MethodCode                 string     `json:"method_code"`
+	ZoneID                     *uuid.UUID `json:"zone_id"`
+	MaxDistanceKm              *float64   `json:"max_distance_km"`
+	CostCents                  int64      `json:"cost_cents"`
+	MinOrderValueCents         int64      `json:"min_order_value_cents"`
+	FreeShippingThresholdCents *int64     `json:"free_shipping_threshold_cents"`
+	IsAvailable                *bool      `json:"is_available"` // nil = available
+}
+
+// GetStoreShippingProfile returns a store's profile, active or not, with its rates
+func (s *ShippingService) GetStoreShippingProfile(storeID uuid.UUID) (*models.StoreShippingProfile, error) {
+	var profile models.StoreShippingProfile
+	err := s.db.Preload("Rates", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at ASC") }).
+		Preload("Rates.ShippingMethod").
+		First(&profile, "store_id = ?", storeID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrStoreShippingProfileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// activeStoreProfile returns the profile quoting should apply, or nil if the
+// store uses the platform rates as they are
+func (s *ShippingService) activeStoreProfile(storeID uuid.UUID) (*models.StoreShippingProfile, error) {
+	var profile models.StoreShippingProfile
+	err := s.db.Preload("Rates").Where("store_id = ? AND is_active = ?", storeID, true).First(&profile).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load store shipping profile: %w", err)
+	}
+	return &profile, nil
+}
+
+// SaveStoreShippingProfile validates a profile and replaces the store's current
+// one in a single transaction
+func (s *ShippingService) SaveStoreShippingProfile(storeID uuid.UUID, in StoreShippingProfileInput) (*models.StoreShippingProfile, error) {
+	rates, err := s.validateStoreShippingProfile(in)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ShippingTransaction(s.db, func(tx *gorm.DB) error {
+		var profile models.StoreShippingProfile
+		err := tx.Where("store_id = ?", storeID).First(&profile).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		profile.StoreID = storeID
+		profile.IsActive = in.IsActive == nil || *in.IsActive
+		profile.FreeShippingThresholdCents = in.FreeShippingThresholdCents
+		profile.MaxDeliveryRadiusKm = in.MaxDeliveryRadiusKm
+		profile.ExcludedZoneIDs = make([]string, 0, len(in.ExcludedZoneIDs))
+		for _, id := range in.ExcludedZoneIDs {
+			profile.ExcludedZoneIDs = append(profile.ExcludedZoneIDs, id.String())
+		}
+		active := profile.IsActive
+		if err := s.save(tx.Omit("Rates"), &profile, &profile.ID); err != nil {
+			return err
+		}
+		// An insert swaps a false flag for the column's default, so it's written separately
+		if !active {
+			if err := tx.Model(&profile).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+
+		// The store ID on the model lets the cache hook evict just this store's quotes
+		if err := tx.Where("store_id = ?", storeID).Delete(&models.StoreShippingRate{StoreID: storeID}).Error; err != nil {
+			return err
+		}
+		for i := range rates {
+			rates[i].StoreID = storeID
+			available := rates[i].IsAvailable
+			if err := s.save(tx.Omit("ShippingMethod"), &rates[i], &rates[i].ID); err != nil {
+				return err
+			}
+			if !available {
+				if err := tx.Model(&rates[i]).Update("is_available", false).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetStoreShippingProfile(storeID)
+}
+
+// DeleteStoreShippingProfile returns a store to the platform rates
+func (s *ShippingService) DeleteStoreShippingProfile(storeID uuid.UUID) error {
+	res := s.db.Where("store_id = ?", storeID).Delete(&models.StoreShippingProfile{StoreID: storeID})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrStoreShippingProfileNotFound
+	}
+	return nil
+}
+
+// validateStoreShippingProfile checks a profile and resolves its rates' method
+// codes and zones
+func (s *ShippingService) validateStoreShippingProfile(in StoreShippingProfileInput) ([]models.StoreShippingRate, error) {
+	if in.FreeShippingThresholdCents != nil && *in.FreeShippingThresholdCents < 0 {
+		return nil, invalid("free_shipping_threshold_cents", "cannot be negative")
+	}
+	if in.MaxDeliveryRadiusKm != nil && *in.MaxDeliveryRadiusKm <= 0 {
+		return nil, invalid("max_delivery_radius_km", "must be positive")
+	}
+	for i, id := range in.ExcludedZoneIDs {
+		if ok, err := s.exists(&models.ShippingZone{}, "id = ?", id); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, invalid(fmt.Sprintf("excluded_zone_ids[%d]", i), "shipping zone not found")
+		}
+	}
+
+	type rateKey struct {
+		method   uuid.UUID
+		zone     uuid.UUID
+		distance float64
+	}
+	seen := map[rateKey]bool{}
+	rates := make([]models.StoreShippingRate, 0, len(in.Rates))
+	for i, r := range in.Rates {
+		field := func(name string) string { return fmt.Sprintf("rates[%d].%s", i, name) }
+
+		var method models.ShippingMethod
+		if err := s.db.First(&method, "code = ?", r.MethodCode).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, invalid(field("method_code"), "shipping method %q not found", r.MethodCode)
+		} else if err != nil {
+			return nil, err
+		}
+		if r.ZoneID != nil {
+			if ok, err := s.exists(&models.ShippingZone{}, "id = ?", *r.ZoneID); err != nil {
+				return nil, err
+			} else if !ok {
+				return nil, invalid(field("zone_id"), "shipping zone not found")
+			}
+		}
+		if r.MaxDistanceKm != nil && *r.MaxDistanceKm <= 0 {
+			return nil, invalid(field("max_distance_km"), "must be positive")
+		}
+		if r.CostCents < 0 {
+			return nil, invalid(field("cost_cents"), "cannot be negative")
+		}
+		if r.MinOrderValueCents < 0 {
+			return nil, invalid(field("min_order_value_cents"), "cannot be negative")
+		}
+		if r.FreeShippingThresholdCents != nil && *r.FreeShippingThresholdCents < 0 {
+			return nil, invalid(field("free_shipping_threshold_cents"), "cannot be negative")
+		}
+
+		key := rateKey{method: method.ID}
+		if r.ZoneID != nil {
+			key.zone = *r.ZoneID
+		}
+		if r.MaxDistanceKm != nil {
+			key.distance = *r.MaxDistanceKm
+		}
+		if seen[key] {
+			return nil, invalid(field("method_code"), "another rate covers the same method, zone and distance")
+		}
+		seen[key] = true
+
+		rates = append(rates, models.StoreShippingRate{
+			ShippingMethodID:           method.ID,
+			ShippingZoneID:             r.ZoneID,
+			MaxDistanceKm:              r.MaxDistanceKm,
+			CostCents:                  r.CostCents,
+			MinOrderValueCents:         r.MinOrderValueCents,
+			FreeShippingThresholdCents: r.FreeShippingThresholdCents,
+			IsAvailable:                r.IsAvailable == nil || *r.IsAvailable,
+		})
+	}
+	return rates, nil
+}
+
+// storeProfileStep applies the store's own terms: it refuses destinations the
+// store has opted out of, then replaces the platform price with the store's
+// most specific matching rate. Heavier parcels still pay per kg.
+func storeProfileStep(s *ShippingService, q *quoteState) error {
+	if q.profile == nil {
+		return nil
+	}
+	if err := checkStoreRestrictions(q.profile, q.store, q.method, q.zone, q.address, q.distanceKm); err != nil {
+		return err
+	}
+
+	rate := matchStoreRate(q.profile.Rates, q.method.ID, q.zone, q.distanceKm)
+	if rate == nil {
+		return nil
+	}
+	if !rate.IsAvailable {
+		return ErrStoreMethodUnavailable
+	}
+	if q.req.CartTotalCents < rate.MinOrderValueCents {
+		return fmt.Errorf("order value below this store's minimum for %s", q.method.Name)
+	}
+	q.storeRate = rate
+
+	label := q.store.Name + " rate"
+	switch {
+	case rate.ShippingZoneID != nil && q.zone != nil:
+		label += " for " + q.zone.Zone.Name
+	case rate.MaxDistanceKm != nil:
+		label += fmt.Sprintf(" within %.0f km", *rate.MaxDistanceKm)
+	}
+	q.supersede(QuoteStepBase, QuoteStepZone, QuoteStepOrigin, QuoteStepDistance, QuoteStepRuleOverride)
+	q.add(QuoteStepStoreRate, label, rate.CostCents)
+	return nil
+}
+
+// matchStoreRate picks the store's rate for a method at a destination. A rate
+// for the destination's zone beats one for any zone, and a distance-limited rate
+// beats an unlimited one; among equals the cheaper wins.
+func matchStoreRate(rates []models.StoreShippingRate, methodID uuid.UUID, zone *ZoneMatch, distanceKm *float64) *models.StoreShippingRate {
+	var best *models.StoreShippingRate
+	bestScore := -1
+	for i := range rates {
+		r := &rates[i]
+		if r.ShippingMethodID != methodID {
+			continue
+		}
+		score := 0
+		if r.ShippingZoneID != nil {
+			if zone == nil || *r.ShippingZoneID != zone.Zone.ID {
+				continue
+			}
+			score += 2
+		}
+		if r.MaxDistanceKm != nil {
+			if distanceKm == nil || *distanceKm > *r.MaxDistanceKm {
+				continue
+			}
+			score++
+		}
+		if score > bestScore || (score == bestScore && r.CostCents < best.CostCents) {
+			best, bestScore = r, score
+		}
+	}
+	return best
+}
+
+// checkStoreRestrictions refuses zones the store excludes and, for deliveries,
+// addresses beyond its delivery radius. Pickup points are served by the pickup
+// network, so the radius doesn't apply to them. Without coordinates the
+// distance is unknown, and only the warehouse's own city counts as in range.
+func checkStoreRestrictions(profile *models.StoreShippingProfile, store models.Store, method models.ShippingMethod,
+	zone *ZoneMatch, address models.Address, distanceKm *float64) error {
+	if zone != nil {
+		for _, id := range profile.ExcludedZoneIDs {
+			if id == zone.Zone.ID.String() {
+				return fmt.Errorf("%w: %s does not ship to %s", ErrStoreDoesNotShip, store.Name, zone.Zone.Name)
+			}
+		}
+	}
+
+	if profile.MaxDeliveryRadiusKm == nil || method.Type == ShippingTypePickup {
+		return nil
+	}
+	radius := *profile.MaxDeliveryRadiusKm
+	if distanceKm == nil {
+		if store.WarehouseCity == "" || store.WarehouseCity != address.City {
+			return fmt.Errorf("%w: %s only delivers within %.0f km of its warehouse in %s",
+				ErrStoreDoesNotShip, store.Name, radius, store.WarehouseCity)
+		}
+		return nil
+	}
+	if *distanceKm > radius {
+		return fmt.Errorf("%w: %s only delivers within %.0f km, this address is %.1f km away",
+			ErrStoreDoesNotShip, store.Name, radius, *distanceKm)
+	}
+	return nil
+}
+
+// CheckStoreCoverage re-checks a store's restrictions for a quote being
+// redeemed, so checkout refuses destinations the store has since opted out of
+func (s *ShippingService) CheckStoreCoverage(req QuoteRequest, method models.ShippingMethod) error {
+	profile, err := s.activeStoreProfile(req.StoreID)
+	if err != nil || profile == nil {
+		return err
+	}
+	var store models.Store
+	if err := s.db.First(&store, "id = ?", req.StoreID).Error; err != nil {
+		return fmt.Errorf("store not found: %w", err)
+	}
+	address, err := s.quoteDestination(req, method)
+	if err != nil {
+		return err
+	}
+	zone, err := s.ResolveZone(*address)
+	if err != nil && !errors.Is(err, ErrNoMatchingZone) {
+		return err
+	}
+	distanceKm := warehouseDistanceKm(store, *address)
+	if err := checkStoreRestrictions(profile, store, method, zone, *address, distanceKm); err != nil {
+		return err
+	}
+	if rate := matchStoreRate(profile.Rates, method.ID, zone, distanceKm); rate != nil && !rate.IsAvailable {
+		return ErrStoreMethodUnavailable
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"trumall/internal/models"
+)
+
+func TestMatchStoreRate(t *testing.T) {
+	method, other := uuid.New(), uuid.New()
+	kenya := models.ShippingZone{ID: uuid.New(), Name: "Kenya"}
+	inKenya := &ZoneMatch{Zone: kenya}
+	elsewhere := &ZoneMatch{Zone: models.ShippingZone{ID: uuid.New(), Name: "Uganda"}}
+	rates := []models.StoreShippingRate{
+		{ShippingMethodID: method, CostCents: 500},
+		{ShippingMethodID: method, CostCents: 300},
+		{ShippingMethodID: method, ShippingZoneID: &kenya.ID, CostCents: 400},
+		{ShippingMethodID: method, MaxDistanceKm: km(10), CostCents: 450},
+		{ShippingMethodID: method, ShippingZoneID: &kenya.ID, MaxDistanceKm: km(10), CostCents: 600},
+		{ShippingMethodID: other, CostCents: 100},
+	}
+
+	cases := []struct {
+		name     string
+		method   uuid.UUID
+		zone     *ZoneMatch
+		distance *float64
+		want     int64 // cost of the matched rate; -1 for none
+	}{
+		{"zone and distance", method, inKenya, km(5), 600},
+		{"zone beats distance", method, inKenya, km(20), 400},
+		{"distance beats any zone", method, elsewhere, km(5), 450},
+		{"cheaper of the catch-alls", method, elsewhere, km(20), 300},
+		{"nothing known", method, nil, nil, 300},
+		{"only its own method", other, inKenya, km(5), 100},
+		{"no rate", uuid.New(), inKenya, km(5), -1},
+	}
+	for _, tc := range cases {
+		got := int64(-1)
+		if rate := matchStoreRate(rates, tc.method, tc.zone, tc.distance); rate != nil {
+			got = rate.CostCents
+		}
+		if got != tc.want {
+			t.Errorf("%s: matched the %d rate; want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCheckStoreRestrictions(t *testing.T) {
+	store := models.Store{Name: "Duka", WarehouseCity: "Nairobi"}
+	excluded := models.ShippingZone{ID: uuid.New(), Name: "Coast"}
+	profile := &models.StoreShippingProfile{MaxDeliveryRadiusKm: km(25), ExcludedZoneIDs: []string{excluded.ID.String()}}
+	delivery := models.ShippingMethod{Type: ShippingTypeDelivery}
+	pickup := models.ShippingMethod{Type: ShippingTypePickup}
+	nairobi := models.Address{City: "Nairobi"}
+	thika := models.Address{City: "Thika"}
+
+	cases := []struct {
+		name     string
+		method   models.ShippingMethod
+		zone     *ZoneMatch
+		address  models.Address
+		distance *float64
+		err      error
+	}{
+		{"within the radius", delivery, nil, thika, km(20), nil},
+		{"beyond the radius", delivery, nil, thika, km(30), ErrStoreDoesNotShip},
+		{"excluded zone", delivery, &ZoneMatch{Zone: excluded}, nairobi, km(5), ErrStoreDoesNotShip},
+		{"other zone", delivery, &ZoneMatch{Zone: models.ShippingZone{ID: uuid.New()}}, nairobi, km(5), nil},
+		{"no coordinates, warehouse city", delivery, nil, nairobi, nil, nil},
+		{"no coordinates, another city", delivery, nil, thika, nil, ErrStoreDoesNotShip},
+		{"pickup ignores the radius", pickup, nil, thika, km(30), nil},
+		{"pickup in an excluded zone", pickup, &ZoneMatch{Zone: excluded}, nairobi, nil, ErrStoreDoesNotShip},
+	}
+	for _, tc := range cases {
+		if err := checkStoreRestrictions(profile, store, tc.method, tc.zone, tc.address, tc.distance); !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestSaveStoreShippingProfile(t *testing.T) {
+	db := openShippingDB(t)
+	store := newShippingStore(t, db)
+	newMethod(t, db, "standard", 500)
+	kenya := newCountryZone(t, db, "Kenya", 200)
+	shipping := NewShippingService(db)
+	negative := int64(-1)
+	missing := uuid.New()
+
+	cases := []struct {
+		name  string
+		in    StoreShippingProfileInput
+		field string
+	}{
+		{"negative free shipping threshold", StoreShippingProfileInput{FreeShippingThresholdCents: &negative}, "free_shipping_threshold_cents"},
+		{"zero radius", StoreShippingProfileInput{MaxDeliveryRadiusKm: km(0)}, "max_delivery_radius_km"},
+		{"unknown excluded zone", StoreShippingProfileInput{ExcludedZoneIDs: []uuid.UUID{missing}}, "excluded_zone_ids[0]"},
+		{"unknown method", StoreShippingProfileInput{Rates: []StoreShippingRateInput{{MethodCode: "boda"}}}, "rates[0].method_code"},
+		{"unknown zone", StoreShippingProfileInput{Rates: []StoreShippingRateInput{{MethodCode: "standard", ZoneID: &missing}}}, "rates[0].zone_id"},
+		{"negative cost", StoreShippingProfileInput{Rates: []StoreShippingRateInput{{MethodCode: "standard", CostCents: -1}}}, "rates[0].cost_cents"},
+		{"same rate twice", StoreShippingProfileInput{Rates: []StoreShippingRateInput{
+			{MethodCode: "standard", ZoneID: &kenya.ID, CostCents: 300},
+			{MethodCode: "standard", ZoneID: &kenya.ID, CostCents: 400},
+		}}, "rates[1].method_code"},
+	}
+	for _, tc := range cases {
+		_, err := shipping.SaveStoreShippingProfile(store.ID, tc.in)
+		var ve ValidationError
+		if !errors.As(err, &ve) || ve.Field != tc.field {
+			t.Errorf("%s: err = %v; want a problem with %s", tc.name, err, tc.field)
+		}
+	}
+
+	// Saving replaces the whole profile, rates included
+	profile, err := shipping.SaveStoreShippingProfile(store.ID, StoreShippingProfileInput{Rates: []StoreShippingRateInput{
+		{MethodCode: "standard", CostCents: 300},
+		{MethodCode: "standard", ZoneID: &kenya.ID, CostCents: 250},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !profile.IsActive || len(profile.Rates) != 2 {
+		t.Fatalf("active %v with %d rates; want active with 2", profile.IsActive, len(profile.Rates))
+	}
+	profile, err = shipping.SaveStoreShippingProfile(store.ID, StoreShippingProfileInput{Rates: []StoreShippingRateInput{
+		{MethodCode: "standard", CostCents: 350},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.Rates) != 1 || profile.Rates[0].CostCents != 350 || profile.Rates[0].ShippingMethod.Code != "standard" {
+		t.Errorf("rates after saving again = %+v; want only the 350 one", profile.Rates)
+	}
+
+	if err := shipping.DeleteStoreShippingProfile(store.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := shipping.DeleteStoreShippingProfile(store.ID); !errors.Is(err, ErrStoreShippingProfileNotFound) {
+		t.Errorf("deleting it again: err = %v; want ErrStoreShippingProfileNotFound", err)
+	}
+}
+
+func TestQuoteWithStoreProfile(t *testing.T) {
+	db := openShippingDB(t)
+	address := newAddress(t, db, "Mombasa", "Kenya")
+	kenya := newCountryZone(t, db, "Kenya", 200)
+	standard := newMethod(t, db, "standard", 500)
+	db.Model(&standard).Update("cost_per_kg_cents", 100)
+	newMethod(t, db, "express", 900)
+	shipping := NewShippingService(db)
+	off, threshold, higher := false, int64(3000), int64(10000)
+	parcel := Parcel{ActualWeightGrams: 1500}
+
+	// Without a profile: 500 base + 200 zone + 200 for 2 kg
+	cases := []struct {
+		name string
+		in   StoreShippingProfileInput
+		cost int64
+		free bool
+		err  error
+	}{
+		{"store rate", StoreShippingProfileInput{Rates: []StoreShippingRateInput{{MethodCode: "standard", ZoneID: &kenya.ID, CostCents: 250}}}, 450, false, nil},
+		{"rate for another method", StoreShippingProfileInput{Rates: []StoreShippingRateInput{{MethodCode: "express", CostCents: 250}}}, 900, false, nil},
+		{"inactive profile", StoreShippingProfileInput{IsActive: &off, Rates: []StoreShippingRateInput{{MethodCode: "standard", CostCents: 250}}}, 900, false, nil},
+		{"store free shipping", StoreShippingProfileInput{FreeShippingThresholdCents: &threshold}, 0, true, nil},
+		{"rate threshold beats the store's", StoreShippingProfileInput{FreeShippingThresholdCents: &threshold,
+			Rates: []StoreShippingRateInput{{MethodCode: "standard", CostCents: 250, FreeShippingThresholdCents: &higher}}}, 450, false, nil},
+		{"excluded zone", StoreShippingProfileInput{ExcludedZoneIDs: []uuid.UUID{kenya.ID}}, 0, false, ErrStoreDoesNotShip},
+		{"beyond the delivery radius", StoreShippingProfileInput{MaxDeliveryRadiusKm: km(10)}, 0, false, ErrStoreDoesNotShip},
+		{"method not offered", StoreShippingProfileInput{Rates: []StoreShippingRateInput{{MethodCode: "standard", IsAvailable: &off}}}, 0, false, ErrStoreMethodUnavailable},
+	}
+	for _, tc := range cases {
+		store := newShippingStore(t, db)
+		if _, err := shipping.SaveStoreShippingProfile(store.ID, tc.in); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		calc, err := quoteTo(db, store, address, "standard", 4000, parcel)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v; want %v", tc.name, err, tc.err)
+			continue
+		}
+		if err == nil && (calc.ShippingCostCents != tc.cost || calc.IsFreeShipping != tc.free) {
+			t.Errorf("%s: cost %d, free %v; want %d, %v", tc.name, calc.ShippingCostCents, calc.IsFreeShipping, tc.cost, tc.free)
+		}
+	}
+
+	// A quote taken before the store stopped shipping there can't be redeemed
+	store := newShippingStore(t, db)
+	req := QuoteRequest{UserID: address.UserID, StoreID: store.ID, AddressID: address.ID, MethodCode: "standard", CartTotalCents: 4000, Parcel: parcel}
+	calc, err := shipping.Quote(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shipping.SaveStoreShippingProfile(store.ID, StoreShippingProfileInput{ExcludedZoneIDs: []uuid.UUID{kenya.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shipping.RedeemQuote(calc.QuoteID, req); !errors.Is(err, ErrStoreDoesNotShip) {
+		t.Errorf("redeeming after the store excluded the zone: err = %v; want ErrStoreDoesNotShip", err)
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"trumall/internal/models"
+	"trumall/internal/validation"
+)
+
+// Zone match levels, most specific first
+const (
+	ZoneLevelPostalCode = "postal_code"
+	ZoneLevelCity       = "city"
+	ZoneLevelState      = "state"
+	ZoneLevelCountry    = "country"
+)
+
+var ErrNoMatchingZone = errors.New("no matching shipping zone found")
+
+// ZoneMatch is the zone chosen for an address and why
+type ZoneMatch struct {
+	Zone   models.ShippingZone `json:"zone"`
+	Level  string              `json:"level"`
+	Reason string              `json:"reason"`
+}
+
+// ZoneCandidate records how a zone was evaluated while resolving an address
+type ZoneCandidate struct {
+	ZoneID   string `json:"zone_id"`
+	ZoneName string `json:"zone_name"`
+	Level    string `json:"level"`
+	Priority int    `json:"priority"`
+	Matched  bool   `json:"matched"`
+	Reason   string `json:"reason"`
+}
+
+// ResolveZone finds the most specific active zone for an address: postal code
+// pattern, then city, then state, then country. Ties are broken by priority.
+func (s *ShippingService) ResolveZone(address models.Address) (*ZoneMatch, error) {
+	match, _, err := s.resolveZone(address, false)
+	return match, err
+}
+
+// ExplainZone resolves an address and also returns every candidate zone that was
+// considered, for the admin address tester
+func (s *ShippingService) ExplainZone(address models.Address) (*ZoneMatch, []ZoneCandidate, error) {
+	return s.resolveZone(address, true)
+}
+
+func (s *ShippingService) resolveZone(address models.Address, explain bool) (*ZoneMatch, []ZoneCandidate, error) {
+	var candidates []ZoneCandidate
+	var match *ZoneMatch
+	record := func(zone models.ShippingZone, level string, matched bool, reason string) {
+		if matched && match == nil {
+			match = &ZoneMatch{Zone: zone, Level: level, Reason: reason}
+		}
+		if explain {
+			candidates = append(candidates, ZoneCandidate{
+				ZoneID:   zone.ID.String(),
+				ZoneName: zone.Name,
+				Level:    level,
+				Priority: zone.Priority,
+				Matched:  matched,
+				Reason:   reason,
+			})
+		}
+	}
+
+	// Postal code patterns are the most specific level
+	if address.PostalCode != "" {
+		var zones []models.ShippingZone
+		if err := s.db.Where("country = ? AND postal_code_pattern IS NOT NULL AND postal_code_pattern <> '' AND is_active = ?",
+			address.Country, true).
+			Order("priority ASC").
+			Find(&zones).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, zone := range zones {
+			pattern, err := validation.ParsePostalCodePattern(*zone.PostalCodePattern)
+			if err != nil {
+				// Patterns are validated on save; skip any that predate validation
+				log.Printf("Skipping shipping zone %s with invalid postal code pattern: %v", zone.ID, err)
+				record(zone, ZoneLevelPostalCode, false, "invalid pattern: "+err.Error())
+				continue
+			}
+			ok, reason := pattern.Match(address.PostalCode)
+			if !ok {
+				reason = fmt.Sprintf("postal code %s does not match %q", address.PostalCode, *zone.PostalCodePattern)
+			} else if match != nil {
+				ok = false
+				reason += " (shadowed by a higher priority zone)"
+			}
+			record(zone, ZoneLevelPostalCode, ok, reason)
+			if match != nil && !explain {
+				return match, nil, nil
+			}
+		}
+	}
+
+	levels := []struct {
+		level  string
+		skip   bool
+		query  string
+		args   []interface{}
+		reason string
+	}{
+		{ZoneLevelCity, address.City == "",
+			"country = ? AND city = ? AND is_active = ?", []interface{}{address.Country, address.City, true},
+			fmt.Sprintf("city is %s, %s", address.City, address.Country)},
+		{ZoneLevelState, address.State == "",
+			"country = ? AND state = ? AND city IS NULL AND is_active = ?", []interface{}{address.Country, address.State, true},
+			fmt.Sprintf("state is %s, %s", address.State, address.Country)},
+		{ZoneLevelCountry, false,
+			"country = ? AND state IS NULL AND city IS NULL AND is_active = ?", []interface{}{address.Country, true},
+			fmt.Sprintf("country-wide zone for %s", address.Country)},
+	}
+	for _, l := range levels {
+		if l.skip || (match != nil && !explain) {
+			continue
+		}
+		// A postal pattern zone has no city or state, but only covers the codes
+		// it matches; it must not act as a country-wide fallback
+		var zones []models.ShippingZone
+		if err := s.db.Where(l.query, l.args...).
+			Where("postal_code_pattern IS NULL OR postal_code_pattern = ''").
+			Order("priority ASC").Find(&zones).Error; err != nil {
+			return nil, nil, err
+		}
+		for i, zone := range zones {
+			reason := l.reason
+			if match != nil || i > 0 {
+				reason += " (shadowed by a more specific or higher priority zone)"
+			}
+			record(zone, l.level, match == nil, reason)
+		}
+	}
+
+	if match == nil {
+		return nil, candidates, ErrNoMatchingZone
+	}
+	return match, candidates, nil
+}
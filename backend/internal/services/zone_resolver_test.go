@@ -0,0 +1,85 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"trumall/internal/models"
+)
+
+func newZone(t *testing.T, db *gorm.DB, zone models.ShippingZone) models.ShippingZone {
+	t.Helper()
+	zone.Country, zone.IsActive = "Kenya", true
+	mustCreate(t, db, &zone)
+	return zone
+}
+
+func TestResolveZone(t *testing.T) {
+	db := openShippingDB(t)
+	cbd := newZone(t, db, models.ShippingZone{Name: "CBD", PostalCodePattern: strPtr("001*")})
+	newZone(t, db, models.ShippingZone{Name: "CBD exact", PostalCodePattern: strPtr("00100"), Priority: 5})
+	newZone(t, db, models.ShippingZone{Name: "Coast", PostalCodePattern: strPtr("80*")})
+	nairobi := newZone(t, db, models.ShippingZone{Name: "Nairobi", City: strPtr("Nairobi")})
+	central := newZone(t, db, models.ShippingZone{Name: "Central", State: strPtr("Central")})
+	kenya := newZone(t, db, models.ShippingZone{Name: "Kenya"})
+	closed := newZone(t, db, models.ShippingZone{Name: "Kisumu", City: strPtr("Kisumu")})
+	db.Model(&closed).Update("is_active", false)
+
+	cases := []struct {
+		name    string
+		address models.Address
+		zone    models.ShippingZone
+		level   string
+	}{
+		{"postal prefix", models.Address{City: "Nairobi", Country: "Kenya", PostalCode: "00105"}, cbd, ZoneLevelPostalCode},
+		{"higher priority pattern wins", models.Address{City: "Nairobi", Country: "Kenya", PostalCode: "00100"}, cbd, ZoneLevelPostalCode},
+		{"city when no pattern matches", models.Address{City: "Nairobi", Country: "Kenya", PostalCode: "00500"}, nairobi, ZoneLevelCity},
+		{"state", models.Address{City: "Thika", State: "Central", Country: "Kenya"}, central, ZoneLevelState},
+		{"country", models.Address{City: "Eldoret", Country: "Kenya"}, kenya, ZoneLevelCountry},
+		{"pattern zones are not a fallback", models.Address{City: "Eldoret", Country: "Kenya", PostalCode: "30100"}, kenya, ZoneLevelCountry},
+		{"inactive zones are skipped", models.Address{City: "Kisumu", Country: "Kenya"}, kenya, ZoneLevelCountry},
+	}
+	shipping := NewShippingService(db)
+	for _, tc := range cases {
+		match, err := shipping.ResolveZone(tc.address)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if match.Zone.ID != tc.zone.ID || match.Level != tc.level {
+			t.Errorf("%s: matched %s at %s; want %s at %s", tc.name, match.Zone.Name, match.Level, tc.zone.Name, tc.level)
+		}
+	}
+
+	if _, err := shipping.ResolveZone(models.Address{City: "Kampala", Country: "Uganda"}); !errors.Is(err, ErrNoMatchingZone) {
+		t.Errorf("address in another country: err = %v; want ErrNoMatchingZone", err)
+	}
+}
+
+func TestExplainZone(t *testing.T) {
+	db := openShippingDB(t)
+	cbd := newZone(t, db, models.ShippingZone{Name: "CBD", PostalCodePattern: strPtr("001*")})
+	newZone(t, db, models.ShippingZone{Name: "CBD exact", PostalCodePattern: strPtr("00100"), Priority: 5})
+	newZone(t, db, models.ShippingZone{Name: "Coast", PostalCodePattern: strPtr("80*")})
+	newZone(t, db, models.ShippingZone{Name: "Nairobi", City: strPtr("Nairobi")})
+	newZone(t, db, models.ShippingZone{Name: "Kenya"})
+
+	match, candidates, err := NewShippingService(db).ExplainZone(models.Address{City: "Nairobi", Country: "Kenya", PostalCode: "00100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match.Zone.ID != cbd.ID {
+		t.Errorf("matched %s; want CBD", match.Zone.Name)
+	}
+	// Every zone is listed, but only the chosen one as matched
+	if len(candidates) != 5 {
+		t.Errorf("%d candidates; want 5", len(candidates))
+	}
+	for _, c := range candidates {
+		if c.Matched != (c.ZoneID == cbd.ID.String()) {
+			t.Errorf("%s: matched = %v (%s)", c.ZoneName, c.Matched, c.Reason)
+		}
+	}
+}
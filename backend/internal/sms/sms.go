@@ -0,0 +1,32 @@
+package sms
+
+import (
+	"log"
+	"os"
+)
+
+// Sender delivers a text message to a phone number in 2547XXXXXXXX format
+type Sender interface {
+	Send(to, message string) error
+}
+
+// ConsoleSender logs messages instead of sending them. Used in development
+// until an SMS gateway (e.g. Africa's Talking) is configured.
+type ConsoleSender struct{}
+
+func (ConsoleSender) Send(to, message string) error {
+	log.Printf("[sms] to=%s message=%q", to, message)
+	return nil
+}
+
+// NewSenderFromEnv returns the sender selected by SMS_PROVIDER.
+// Only the console stand-in is built in for now.
+func NewSenderFromEnv() Sender {
+	switch os.Getenv("SMS_PROVIDER") {
+	case "", "console":
+		return ConsoleSender{}
+	default:
+		log.Printf("[sms] unknown SMS_PROVIDER %q, falling back to console", os.Getenv("SMS_PROVIDER"))
+		return ConsoleSender{}
+	}
+}
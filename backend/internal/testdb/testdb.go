@@ -0,0 +1,56 @@
+// Package testdb opens throwaway in-memory databases for tests. SQLite stands
+// in for Postgres: it ignores row locks and lacks Postgres-only SQL (advisory
+// locks, arrays, LATERAL), so code built on those is tested against Postgres
+// only.
+package testdb
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const driverName = "sqlite3_trumall"
+
+func init() {
+	// SQLite stand-in for Postgres' uuid_generate_v4(), which the models use as
+	// their primary key default
+	sql.Register(driverName, &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+		return conn.RegisterFunc("uuid_generate_v4", func() string { return uuid.NewString() }, false)
+	}})
+}
+
+// Open returns a private in-memory database with tables for the given models,
+// closed when the test ends
+func Open(t testing.TB, models ...any) *gorm.DB {
+	t.Helper()
+	dsn := "file:" + strings.NewReplacer("/", "_", " ", "_").Replace(t.Name()) + "?mode=memory&cache=shared&_busy_timeout=5000"
+	db, err := gorm.Open(sqlite.New(sqlite.Config{DriverName: driverName, DSN: dsn}), &gorm.Config{
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SQLite only takes a function as a column default in parentheses
+	db.Callback().Raw().Before("gorm:raw").Register("testdb:sqlite_defaults", func(tx *gorm.DB) {
+		q := tx.Statement.SQL.String()
+		tx.Statement.SQL.Reset()
+		tx.Statement.SQL.WriteString(strings.ReplaceAll(q, "DEFAULT uuid_generate_v4()", "DEFAULT (uuid_generate_v4())"))
+	})
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
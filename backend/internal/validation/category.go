@@ -0,0 +1,26 @@
+package validation
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// categorySlug matches product category slugs such as "electronics" or
+// "home-office"; the storefront links to /category/<slug>
+var categorySlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// NormalizeCategory turns a category name ("Home & Office") into its slug
+// ("home-office"). An empty name returns an empty slug.
+func NormalizeCategory(category string) (string, error) {
+	c := strings.ToLower(strings.TrimSpace(category))
+	if c == "" {
+		return "", nil
+	}
+	c = strings.NewReplacer("&", " ", "_", " ", "/", " ").Replace(c)
+	c = strings.Join(strings.Fields(c), "-")
+	if len(c) > 50 || !categorySlug.MatchString(c) {
+		return "", errors.New("category must be at most 50 letters, digits and dashes")
+	}
+	return c, nil
+}
@@ -0,0 +1,31 @@
+package validation
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// kenyanMSISDN matches Safaricom/Airtel style mobile numbers in the format
+// M-Pesa expects: 2547XXXXXXXX or 2541XXXXXXXX
+var kenyanMSISDN = regexp.MustCompile(`^254[17]\d{8}$`)
+
+// NormalizePhone converts common Kenyan mobile formats (07..., 01..., +2547..., 2547...)
+// to 2547XXXXXXXX / 2541XXXXXXXX and validates the result
+func NormalizePhone(phone string) (string, error) {
+	p := strings.TrimSpace(phone)
+	p = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(p)
+	p = strings.TrimPrefix(p, "+")
+
+	switch {
+	case strings.HasPrefix(p, "0") && len(p) == 10:
+		p = "254" + p[1:]
+	case (strings.HasPrefix(p, "7") || strings.HasPrefix(p, "1")) && len(p) == 9:
+		p = "254" + p
+	}
+
+	if !kenyanMSISDN.MatchString(p) {
+		return "", errors.New("invalid phone number, expected format 2547XXXXXXXX or 2541XXXXXXXX")
+	}
+	return p, nil
+}
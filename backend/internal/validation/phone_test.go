@@ -0,0 +1,30 @@
+package validation
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	cases := []struct {
+		phone string
+		want  string // empty when invalid
+	}{
+		{"0712345678", "254712345678"},
+		{"0112345678", "254112345678"},
+		{"+254 712 345 678", "254712345678"},
+		{"254-712-345-678", "254712345678"},
+		{"(0712) 345678", "254712345678"},
+		{"712345678", "254712345678"},
+		{"254112345678", "254112345678"},
+		{"0212345678", ""},
+		{"071234567", ""},
+		{"2547123456789", ""},
+		{"255712345678", ""},
+		{"07123456ab", ""},
+		{"", ""},
+	}
+	for _, tc := range cases {
+		got, err := NormalizePhone(tc.phone)
+		if got != tc.want || (err == nil) != (tc.want != "") {
+			t.Errorf("%q = %q, %v; want %q", tc.phone, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxPostalCodePatternLength matches the shipping_zones.postal_code_pattern column
+const MaxPostalCodePatternLength = 50
+
+// PostalCodePattern is a parsed ShippingZone.PostalCodePattern. A pattern is a
+// comma-separated list of terms, each one of:
+//
+//	00100          exact code
+//	001*           prefix
+//	00100-00199    inclusive range (both ends the same length)
+//	re:^80[0-9]{3}$  regular expression
+//
+// Codes are upper-cased with spaces removed before matching.
+type PostalCodePattern struct {
+	terms []postalTerm
+}
+
+type postalTerm struct {
+	raw    string
+	kind   string // exact | prefix | range | regex
+	value  string
+	upper  string
+	regexp *regexp.Regexp
+}
+
+// ParsePostalCodePattern validates and compiles a zone postal code pattern
+func ParsePostalCodePattern(pattern string) (*PostalCodePattern, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil, errors.New("postal code pattern is empty")
+	}
+	if len(pattern) > MaxPostalCodePatternLength {
+		return nil, fmt.Errorf("postal code pattern too long (max %d characters)", MaxPostalCodePatternLength)
+	}
+
+	// A regex may itself contain commas, so it must be the only term
+	if strings.HasPrefix(pattern, "re:") {
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, "re:"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid postal code regex: %v", err)
+		}
+		return &PostalCodePattern{terms: []postalTerm{{raw: pattern, kind: "regex", regexp: re}}}, nil
+	}
+
+	var parsed PostalCodePattern
+	for _, raw := range strings.Split(pattern, ",") {
+		term := strings.ToUpper(strings.TrimSpace(raw))
+		if term == "" {
+			return nil, errors.New("postal code pattern has an empty term")
+		}
+
+		switch {
+		case strings.HasSuffix(term, "*"):
+			prefix := strings.TrimSuffix(term, "*")
+			if prefix == "" || strings.Contains(prefix, "*") {
+				return nil, fmt.Errorf("invalid prefix %q", raw)
+			}
+			parsed.terms = append(parsed.terms, postalTerm{raw: term, kind: "prefix", value: prefix})
+		case strings.Contains(term, "-"):
+			bounds := strings.SplitN(term, "-", 2)
+			lower, upper := strings.TrimSpace(bounds[0]), strings.TrimSpace(bounds[1])
+			if lower == "" || len(lower) != len(upper) || !isDigits(lower) || !isDigits(upper) {
+				return nil, fmt.Errorf("invalid range %q: both ends must be numeric and the same length", raw)
+			}
+			if lower > upper {
+				return nil, fmt.Errorf("invalid range %q: start is after end", raw)
+			}
+			parsed.terms = append(parsed.terms, postalTerm{raw: term, kind: "range", value: lower, upper: upper})
+		default:
+			parsed.terms = append(parsed.terms, postalTerm{raw: term, kind: "exact", value: term})
+		}
+	}
+	return &parsed, nil
+}
+
+// Match reports whether the postal code matches, and if so a short explanation of
+// which term matched
+func (p *PostalCodePattern) Match(postalCode string) (bool, string) {
+	code := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(postalCode), " ", ""))
+	if code == "" {
+		return false, ""
+	}
+
+	for _, t := range p.terms {
+		switch t.kind {
+		case "exact":
+			if code == strings.ReplaceAll(t.value, " ", "") {
+				return true, fmt.Sprintf("postal code %s equals %s", code, t.value)
+			}
+		case "prefix":
+			if strings.HasPrefix(code, strings.ReplaceAll(t.value, " ", "")) {
+				return true, fmt.Sprintf("postal code %s starts with %s", code, t.value)
+			}
+		case "range":
+			if len(code) == len(t.value) && isDigits(code) && code >= t.value && code <= t.upper {
+				return true, fmt.Sprintf("postal code %s is within %s-%s", code, t.value, t.upper)
+			}
+		case "regex":
+			if t.regexp.MatchString(code) {
+				return true, fmt.Sprintf("postal code %s matches /%s/", code, t.regexp.String())
+			}
+		}
+	}
+	return false, ""
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,66 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePostalCodePattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		ok      bool
+	}{
+		{"00100", true},
+		{"001*", true},
+		{"00100-00199", true},
+		{"00100, 002*, 80100-80199", true},
+		{"re:^80[0-9]{3}$", true},
+		{"", false},
+		{"  ", false},
+		{"*", false},
+		{"0*1*", false},
+		{"00100,,00200", false},
+		{"00100-0199", false},
+		{"00199-00100", false},
+		{"AB-CD", false},
+		{"re:[", false},
+		{strings.Repeat("1", MaxPostalCodePatternLength+1), false},
+	}
+	for _, tc := range cases {
+		if _, err := ParsePostalCodePattern(tc.pattern); (err == nil) != tc.ok {
+			t.Errorf("%q: err = %v; want ok = %v", tc.pattern, err, tc.ok)
+		}
+	}
+}
+
+func TestPostalCodePatternMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		code    string
+		want    bool
+	}{
+		{"00100", "00100", true},
+		{"00100", "00101", false},
+		{"sw1a 1aa", "SW1A1AA", true},
+		{"001*", "00105", true},
+		{"001*", "00200", false},
+		{"00100-00199", "00150", true},
+		{"00100-00199", "00199", true},
+		{"00100-00199", "00200", false},
+		{"00100-00199", "0015", false},
+		{"00100-00199", "0015A", false},
+		{"00100, 80100-80199", "80110", true},
+		{"re:^80[0-9]{3}$", "80110", true},
+		{"re:^80[0-9]{3}$", "90110", false},
+		{"001*", "", false},
+	}
+	for _, tc := range cases {
+		pattern, err := ParsePostalCodePattern(tc.pattern)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.pattern, err)
+		}
+		if got, _ := pattern.Match(tc.code); got != tc.want {
+			t.Errorf("%q matching %q = %v; want %v", tc.pattern, tc.code, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+-- TOTP two-factor authentication
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- One-time recovery codes (bcrypt hashed)
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash TEXT NOT NULL,
+  used_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id);
+
+-- Per-role 2FA policy
+CREATE TABLE IF NOT EXISTS mfa_policies (
+  role VARCHAR(50) PRIMARY KEY,
+  required BOOLEAN NOT NULL DEFAULT FALSE,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+-- Admin accounts must use 2FA
+INSERT INTO mfa_policies (role, required) VALUES
+('admin', true),
+('seller', false)
+ON CONFLICT (role) DO NOTHING;
@@ -0,0 +1,16 @@
+DROP INDEX IF EXISTS idx_phone_otps_phone;
+DROP TABLE IF EXISTS phone_otps;
+
+-- Phone-only accounts get a placeholder email so the NOT NULL constraints can be restored
+UPDATE users SET email = phone || '@phone.invalid' WHERE email IS NULL;
+UPDATE users SET password_hash = '' WHERE password_hash IS NULL;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS chk_users_email_or_phone;
+DROP INDEX IF EXISTS idx_users_phone;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS phone_verified_at,
+DROP COLUMN IF EXISTS phone;
+
+ALTER TABLE users ALTER COLUMN password_hash SET NOT NULL;
+ALTER TABLE users ALTER COLUMN email SET NOT NULL;
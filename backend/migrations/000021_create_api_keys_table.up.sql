@@ -0,0 +1,16 @@
+-- Scoped API keys for seller integrations
+CREATE TABLE IF NOT EXISTS api_keys (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name VARCHAR(100) NOT NULL,
+  prefix VARCHAR(20) NOT NULL UNIQUE,
+  key_hash TEXT NOT NULL,
+  scopes TEXT[] NOT NULL DEFAULT ARRAY[]::TEXT[],
+  store_ids TEXT[] NOT NULL DEFAULT ARRAY[]::TEXT[],
+  expires_at TIMESTAMP WITH TIME ZONE,
+  revoked_at TIMESTAMP WITH TIME ZONE,
+  last_used_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_store_invitations_store_id;
+DROP TABLE IF EXISTS store_invitations;
+DROP INDEX IF EXISTS idx_store_staffs_user_id;
+DROP TABLE IF EXISTS store_staffs;
@@ -0,0 +1,30 @@
+-- Store staff with per-store roles
+CREATE TABLE IF NOT EXISTS store_staffs (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  store_id UUID NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  role VARCHAR(30) NOT NULL,
+  invited_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+  UNIQUE(store_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_store_staffs_user_id ON store_staffs(user_id);
+
+-- Pending invitations to join a store's staff
+CREATE TABLE IF NOT EXISTS store_invitations (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  store_id UUID NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+  email TEXT,
+  role VARCHAR(30) NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  invited_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  accepted_at TIMESTAMP WITH TIME ZONE,
+  accepted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  revoked_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_store_invitations_store_id ON store_invitations(store_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_data_requests_user_id;
+DROP TABLE IF EXISTS data_requests;
+
+DELETE FROM reviews WHERE user_id IS NULL;
+ALTER TABLE reviews ALTER COLUMN user_id SET NOT NULL;
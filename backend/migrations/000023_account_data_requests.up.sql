@@ -0,0 +1,14 @@
+-- Reviews must survive account deletion (the FK already says ON DELETE SET NULL)
+ALTER TABLE reviews ALTER COLUMN user_id DROP NOT NULL;
+
+-- Audit trail of data-subject requests (Kenya Data Protection Act).
+-- user_id deliberately has no FK so the record outlives the account.
+CREATE TABLE IF NOT EXISTS data_requests (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  user_id UUID NOT NULL,
+  type VARCHAR(20) NOT NULL, -- export | deletion
+  details JSONB,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_requests_user_id ON data_requests(user_id);
@@ -0,0 +1,7 @@
+ALTER TABLE shipping_methods DROP COLUMN IF EXISTS max_weight_grams;
+
+ALTER TABLE products
+DROP COLUMN IF EXISTS weight_grams,
+DROP COLUMN IF EXISTS length_cm,
+DROP COLUMN IF EXISTS width_cm,
+DROP COLUMN IF EXISTS height_cm;
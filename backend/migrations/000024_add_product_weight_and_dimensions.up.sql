@@ -0,0 +1,10 @@
+-- Product shipping weight and packed dimensions (0 = unknown)
+ALTER TABLE products
+ADD COLUMN IF NOT EXISTS weight_grams INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN IF NOT EXISTS length_cm NUMERIC(8,2) NOT NULL DEFAULT 0,
+ADD COLUMN IF NOT EXISTS width_cm NUMERIC(8,2) NOT NULL DEFAULT 0,
+ADD COLUMN IF NOT EXISTS height_cm NUMERIC(8,2) NOT NULL DEFAULT 0;
+
+-- Maximum chargeable parcel weight a shipping method accepts (NULL = no limit)
+ALTER TABLE shipping_methods
+ADD COLUMN IF NOT EXISTS max_weight_grams INTEGER;
@@ -0,0 +1,11 @@
+CREATE TABLE shipping_distance_bands (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    shipping_method_id UUID NOT NULL REFERENCES shipping_methods(id) ON DELETE CASCADE,
+    min_km NUMERIC(10,3) NOT NULL DEFAULT 0 CHECK (min_km >= 0),
+    max_km NUMERIC(10,3) CHECK (max_km IS NULL OR max_km > min_km),
+    cost_cents BIGINT NOT NULL DEFAULT 0 CHECK (cost_cents >= 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_shipping_distance_bands_method ON shipping_distance_bands(shipping_method_id, min_km);
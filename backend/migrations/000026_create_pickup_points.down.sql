@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_orders_pickup_point_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS pickup_point_id;
+ALTER TABLE shipping_methods DROP COLUMN IF EXISTS type;
+DROP TABLE IF EXISTS pickup_points;
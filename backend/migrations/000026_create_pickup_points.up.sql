@@ -0,0 +1,31 @@
+CREATE TABLE pickup_points (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(100) NOT NULL,
+    code VARCHAR(30) NOT NULL UNIQUE,
+    street VARCHAR(255) NOT NULL,
+    city VARCHAR(100) NOT NULL,
+    state VARCHAR(100),
+    country VARCHAR(100) DEFAULT 'Kenya',
+    postal_code VARCHAR(20),
+    latitude DOUBLE PRECISION NOT NULL DEFAULT 0,
+    longitude DOUBLE PRECISION NOT NULL DEFAULT 0,
+    phone VARCHAR(20),
+    opening_hours JSONB,
+    capacity INTEGER NOT NULL DEFAULT 0 CHECK (capacity >= 0),
+    store_ids TEXT[] NOT NULL DEFAULT ARRAY[]::TEXT[],
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_pickup_points_location ON pickup_points(latitude, longitude) WHERE is_active;
+
+ALTER TABLE shipping_methods
+ADD COLUMN type VARCHAR(20) NOT NULL DEFAULT 'delivery' CHECK (type IN ('delivery', 'pickup'));
+
+UPDATE shipping_methods SET type = 'pickup' WHERE code = 'pickup';
+
+ALTER TABLE orders
+ADD COLUMN pickup_point_id UUID REFERENCES pickup_points(id) ON DELETE RESTRICT;
+
+CREATE INDEX idx_orders_pickup_point_id ON orders(pickup_point_id);
@@ -0,0 +1,9 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS estimated_delivery_earliest;
+ALTER TABLE shipping_methods
+DROP COLUMN IF EXISTS delivers_saturday,
+DROP COLUMN IF EXISTS delivers_sunday;
+ALTER TABLE stores
+DROP COLUMN IF EXISTS handling_days,
+DROP COLUMN IF EXISTS order_cutoff,
+DROP COLUMN IF EXISTS ships_saturday;
+DROP TABLE IF EXISTS delivery_holidays;
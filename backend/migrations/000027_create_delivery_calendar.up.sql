@@ -0,0 +1,39 @@
+CREATE TABLE delivery_holidays (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(100) NOT NULL,
+    date DATE NOT NULL,
+    recurring BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_delivery_holidays_date ON delivery_holidays(date);
+
+-- Kenyan public holidays. Fixed-date ones recur (only month and day are used);
+-- Easter and Eid dates are gazetted each year and added by admins.
+INSERT INTO delivery_holidays (name, date, recurring) VALUES
+('New Year''s Day', '2000-01-01', TRUE),
+('Labour Day', '2000-05-01', TRUE),
+('Madaraka Day', '2000-06-01', TRUE),
+('Mazingira Day', '2000-10-10', TRUE),
+('Mashujaa Day', '2000-10-20', TRUE),
+('Jamhuri Day', '2000-12-12', TRUE),
+('Christmas Day', '2000-12-25', TRUE),
+('Boxing Day', '2000-12-26', TRUE),
+('Good Friday', '2026-04-03', FALSE),
+('Easter Monday', '2026-04-06', FALSE),
+('Good Friday', '2027-03-26', FALSE),
+('Easter Monday', '2027-03-29', FALSE);
+
+ALTER TABLE stores
+ADD COLUMN handling_days INTEGER NOT NULL DEFAULT 1 CHECK (handling_days >= 0),
+ADD COLUMN order_cutoff VARCHAR(5) NOT NULL DEFAULT '14:00',
+ADD COLUMN ships_saturday BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE shipping_methods
+ADD COLUMN delivers_saturday BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN delivers_sunday BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE shipping_methods SET delivers_saturday = TRUE WHERE code = 'express';
+
+ALTER TABLE orders
+ADD COLUMN estimated_delivery_earliest TIMESTAMP WITH TIME ZONE;
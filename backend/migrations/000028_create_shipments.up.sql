@@ -0,0 +1,33 @@
+CREATE TABLE shipments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    store_id UUID NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    courier_code VARCHAR(30) NOT NULL,
+    carrier_name VARCHAR(100),
+    tracking_number VARCHAR(100) NOT NULL,
+    provider_ref VARCHAR(100),
+    status VARCHAR(30) NOT NULL DEFAULT 'label_created',
+    shipped_at TIMESTAMP WITH TIME ZONE,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (courier_code, tracking_number)
+);
+
+CREATE INDEX idx_shipments_order_id ON shipments(order_id);
+CREATE INDEX idx_shipments_store_id ON shipments(store_id);
+CREATE INDEX idx_shipments_tracking_number ON shipments(tracking_number);
+
+CREATE TABLE shipment_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    shipment_id UUID NOT NULL REFERENCES shipments(id) ON DELETE CASCADE,
+    status VARCHAR(30) NOT NULL,
+    description VARCHAR(255),
+    location VARCHAR(100),
+    event_id VARCHAR(100),
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_shipment_events_shipment ON shipment_events(shipment_id, occurred_at);
+CREATE UNIQUE INDEX idx_shipment_events_event_id ON shipment_events(shipment_id, event_id) WHERE event_id IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS store_shipping_rates;
+DROP TABLE IF EXISTS store_shipping_profiles;
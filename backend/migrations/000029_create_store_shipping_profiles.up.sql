@@ -0,0 +1,26 @@
+CREATE TABLE store_shipping_profiles (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    store_id UUID NOT NULL UNIQUE REFERENCES stores(id) ON DELETE CASCADE,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    free_shipping_threshold_cents BIGINT CHECK (free_shipping_threshold_cents IS NULL OR free_shipping_threshold_cents >= 0),
+    max_delivery_radius_km NUMERIC(10,3) CHECK (max_delivery_radius_km IS NULL OR max_delivery_radius_km > 0),
+    excluded_zone_ids TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE store_shipping_rates (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    store_id UUID NOT NULL REFERENCES store_shipping_profiles(store_id) ON DELETE CASCADE,
+    shipping_method_id UUID NOT NULL REFERENCES shipping_methods(id) ON DELETE CASCADE,
+    shipping_zone_id UUID REFERENCES shipping_zones(id) ON DELETE CASCADE,
+    max_distance_km NUMERIC(10,3) CHECK (max_distance_km IS NULL OR max_distance_km > 0),
+    cost_cents BIGINT NOT NULL DEFAULT 0 CHECK (cost_cents >= 0),
+    min_order_value_cents BIGINT NOT NULL DEFAULT 0 CHECK (min_order_value_cents >= 0),
+    free_shipping_threshold_cents BIGINT CHECK (free_shipping_threshold_cents IS NULL OR free_shipping_threshold_cents >= 0),
+    is_available BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_store_shipping_rates_store ON store_shipping_rates(store_id, shipping_method_id);
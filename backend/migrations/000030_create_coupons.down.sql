@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS order_discounts;
+ALTER TABLE orders DROP COLUMN IF EXISTS discount_cents;
+DROP TABLE IF EXISTS coupons;
+DROP INDEX IF EXISTS idx_products_category;
+ALTER TABLE products DROP COLUMN IF EXISTS category;
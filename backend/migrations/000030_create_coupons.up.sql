@@ -0,0 +1,42 @@
+ALTER TABLE products ADD COLUMN category VARCHAR(50);
+CREATE INDEX idx_products_category ON products(category);
+
+CREATE TABLE coupons (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    code VARCHAR(32) NOT NULL UNIQUE,
+    description TEXT,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('percentage', 'fixed', 'free_shipping')),
+    value BIGINT NOT NULL DEFAULT 0 CHECK (value >= 0),
+    max_discount_cents BIGINT CHECK (max_discount_cents IS NULL OR max_discount_cents > 0),
+    min_spend_cents BIGINT NOT NULL DEFAULT 0 CHECK (min_spend_cents >= 0),
+    store_id UUID REFERENCES stores(id) ON DELETE CASCADE,
+    category VARCHAR(50),
+    product_ids TEXT[] NOT NULL DEFAULT '{}',
+    starts_at TIMESTAMP WITH TIME ZONE,
+    ends_at TIMESTAMP WITH TIME ZONE,
+    usage_limit INTEGER CHECK (usage_limit IS NULL OR usage_limit > 0),
+    per_user_limit INTEGER CHECK (per_user_limit IS NULL OR per_user_limit > 0),
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (ends_at IS NULL OR starts_at IS NULL OR ends_at > starts_at)
+);
+
+CREATE INDEX idx_coupons_store ON coupons(store_id);
+
+ALTER TABLE orders ADD COLUMN discount_cents BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE order_discounts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    coupon_id UUID REFERENCES coupons(id) ON DELETE SET NULL,
+    code VARCHAR(32),
+    description TEXT NOT NULL DEFAULT '',
+    amount_cents BIGINT NOT NULL DEFAULT 0 CHECK (amount_cents >= 0),
+    shipping_discount_cents BIGINT NOT NULL DEFAULT 0 CHECK (shipping_discount_cents >= 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_order_discounts_order ON order_discounts(order_id);
+CREATE INDEX idx_order_discounts_coupon ON order_discounts(coupon_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_order_items_sale;
+ALTER TABLE order_items DROP COLUMN IF EXISTS sale_id;
+DROP TABLE IF EXISTS product_sales;
@@ -0,0 +1,18 @@
+CREATE TABLE product_sales (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    name VARCHAR(100),
+    sale_price_cents BIGINT NOT NULL CHECK (sale_price_cents > 0),
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    quantity_limit INTEGER CHECK (quantity_limit IS NULL OR quantity_limit > 0),
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX idx_product_sales_product_window ON product_sales(product_id, starts_at, ends_at);
+
+ALTER TABLE order_items ADD COLUMN sale_id UUID REFERENCES product_sales(id) ON DELETE SET NULL;
+CREATE INDEX idx_order_items_sale ON order_items(sale_id);
@@ -0,0 +1,6 @@
+DELETE FROM cart_items WHERE user_id IS NULL;
+DROP INDEX IF EXISTS idx_cart_items_guest_cart_id;
+ALTER TABLE cart_items DROP CONSTRAINT IF EXISTS cart_items_one_owner;
+ALTER TABLE cart_items DROP COLUMN IF EXISTS guest_cart_id;
+ALTER TABLE cart_items ALTER COLUMN user_id SET NOT NULL;
+DROP TABLE IF EXISTS guest_carts;
@@ -0,0 +1,13 @@
+CREATE TABLE guest_carts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_guest_carts_expires_at ON guest_carts(expires_at);
+
+ALTER TABLE cart_items ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE cart_items ADD COLUMN guest_cart_id UUID REFERENCES guest_carts(id) ON DELETE CASCADE;
+ALTER TABLE cart_items ADD CONSTRAINT cart_items_one_owner CHECK ((user_id IS NULL) <> (guest_cart_id IS NULL));
+CREATE INDEX idx_cart_items_guest_cart_id ON cart_items(guest_cart_id);
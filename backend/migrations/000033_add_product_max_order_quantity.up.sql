@@ -0,0 +1,3 @@
+-- Sellers can cap how many units of a product one order may contain
+ALTER TABLE products ADD COLUMN IF NOT EXISTS max_order_quantity INTEGER
+    CONSTRAINT products_max_order_quantity_positive CHECK (max_order_quantity > 0);
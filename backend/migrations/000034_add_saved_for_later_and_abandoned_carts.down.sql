@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS abandoned_carts;
+ALTER TABLE users DROP COLUMN IF EXISTS cart_reminders;
+ALTER TABLE cart_items DROP COLUMN IF EXISTS saved_for_later;
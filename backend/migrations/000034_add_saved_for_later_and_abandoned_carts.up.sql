@@ -0,0 +1,20 @@
+ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS saved_for_later BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS cart_reminders BOOLEAN NOT NULL DEFAULT TRUE;
+
+CREATE TABLE abandoned_carts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    guest_cart_id UUID REFERENCES guest_carts(id) ON DELETE SET NULL,
+    store_id UUID NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    item_count INTEGER NOT NULL,
+    value_cents BIGINT NOT NULL,
+    last_activity_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    detected_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    reminded_at TIMESTAMP WITH TIME ZONE,
+    recovered_at TIMESTAMP WITH TIME ZONE,
+    order_id UUID REFERENCES orders(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_abandoned_carts_user_id ON abandoned_carts(user_id);
+CREATE INDEX idx_abandoned_carts_guest_cart_id ON abandoned_carts(guest_cart_id);
+CREATE INDEX idx_abandoned_carts_store_detected ON abandoned_carts(store_id, detected_at);
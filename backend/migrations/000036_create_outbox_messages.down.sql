@@ -0,0 +1,2 @@
+ALTER TABLE payments DROP COLUMN IF EXISTS failure_reason;
+DROP TABLE IF EXISTS outbox_messages;
@@ -0,0 +1,18 @@
+CREATE TABLE outbox_messages (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    topic VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    processed_at TIMESTAMP WITH TIME ZONE,
+    failed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The dispatcher only looks at messages still waiting to be delivered
+CREATE INDEX idx_outbox_messages_due ON outbox_messages(next_attempt_at)
+    WHERE processed_at IS NULL AND failed_at IS NULL;
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS failure_reason TEXT;
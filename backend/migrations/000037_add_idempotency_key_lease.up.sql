@@ -0,0 +1,4 @@
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+
+-- Claims left in flight before the lease existed can be taken over
+UPDATE idempotency_keys SET locked_until = created_at WHERE completed_at IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE store_shipping_rates DROP CONSTRAINT IF EXISTS store_shipping_rates_shipping_zone_id_fkey;
+ALTER TABLE store_shipping_rates ADD CONSTRAINT store_shipping_rates_shipping_zone_id_fkey
+    FOREIGN KEY (shipping_zone_id) REFERENCES shipping_zones(id) ON DELETE CASCADE;
@@ -0,0 +1,4 @@
+-- Zones sellers price for can't be removed from under them
+ALTER TABLE store_shipping_rates DROP CONSTRAINT IF EXISTS store_shipping_rates_shipping_zone_id_fkey;
+ALTER TABLE store_shipping_rates ADD CONSTRAINT store_shipping_rates_shipping_zone_id_fkey
+    FOREIGN KEY (shipping_zone_id) REFERENCES shipping_zones(id) ON DELETE RESTRICT;
@@ -0,0 +1,5 @@
+ALTER TABLE users
+DROP COLUMN IF EXISTS mfa_enrollment_expires_at,
+DROP COLUMN IF EXISTS mfa_enrollment_code_hash,
+DROP COLUMN IF EXISTS mfa_locked_until,
+DROP COLUMN IF EXISTS mfa_failed_attempts;
@@ -0,0 +1,7 @@
+-- Wrong second-factor codes lock the account's second step for a while
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS mfa_failed_attempts INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN IF NOT EXISTS mfa_locked_until TIMESTAMP WITH TIME ZONE,
+-- One-time code an admin issues so a required-but-unenrolled account can set up 2FA at login
+ADD COLUMN IF NOT EXISTS mfa_enrollment_code_hash TEXT,
+ADD COLUMN IF NOT EXISTS mfa_enrollment_expires_at TIMESTAMP WITH TIME ZONE;
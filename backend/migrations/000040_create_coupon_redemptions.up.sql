@@ -0,0 +1,14 @@
+-- Who redeemed a coupon, as keyed hashes of the buyer's account, email and
+-- phone. Orders lose their buyer_id when an account is deleted; these rows
+-- keep per-buyer coupon limits counting. Orders from before this migration
+-- are still counted by buyer_id while the account exists.
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    coupon_id UUID NOT NULL REFERENCES coupons(id) ON DELETE CASCADE,
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    redeemer_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_coupon_redeemer ON coupon_redemptions(coupon_id, redeemer_hash);
+CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_order_id ON coupon_redemptions(order_id);
@@ -9,6 +9,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"trumall/internal/models"
 )
@@ -66,48 +67,108 @@ func StkCallbackHandler(dbConn *gorm.DB) fiber.Handler {
 				tx.Rollback()
 				return c.Status(fiber.StatusNotFound).SendString("payment not found")
 			}
-
-			// Update Payment status
-			if err := tx.Model(&payment).
-				Updates(map[string]interface{}{
-					"status":        "paid",
-					"mpesa_receipt": receipt,
-					"phone":         phone,
-					"amount_cents":  amount * 100,
-					"updated_at":    time.Now(),
-				}).Error; err != nil {
-				log.Println("db update payment err:", err)
+			// A repeated callback for a payment already recorded
+			if payment.Status == "paid" || payment.Status == "refund_due" {
 				tx.Rollback()
-				return c.Status(fiber.StatusInternalServerError).SendString("internal server error")
+				return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
 			}
 
+			// The order lock keeps two prompts paid at once from both settling it
 			var order models.Order
-			if err := tx.Where("id = ?", payment.OrderID).First(&order).Error; err != nil {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", payment.OrderID).First(&order).Error; err != nil {
 				log.Println("order not found for payment_id:", payment.ID, err)
 				tx.Rollback()
 				return c.Status(fiber.StatusNotFound).SendString("order not found")
 			}
 
-			var orderItems []models.OrderItem
-			if err := tx.Where("order_id = ?", order.ID).Find(&orderItems).Error; err != nil {
-				log.Println("failed to fetch order items for order:", order.ID, err)
-				tx.Rollback()
-				return c.Status(fiber.StatusInternalServerError).SendString("internal server error")
+			// The buyer paid an older prompt as well, or paid after the order was
+			// closed: keep the money on record to refund, and leave the order and
+			// its stock alone
+			refundReason := ""
+			if order.Status != "pending" {
+				refundReason = "order was already " + order.Status
+			} else {
+				var orderItems []models.OrderItem
+				if err := tx.Where("order_id = ?", order.ID).Find(&orderItems).Error; err != nil {
+					log.Println("failed to fetch order items for order:", order.ID, err)
+					tx.Rollback()
+					return c.Status(fiber.StatusInternalServerError).SendString("internal server error")
+				}
+
+				// Deduct stock, never below zero. Checkout holds the units, but a
+				// seller can still cut the stock below what was sold; the order is
+				// then cancelled and the payment refunded.
+				if err := tx.SavePoint("stock").Error; err != nil {
+					log.Println("failed to create savepoint for order:", order.ID, err)
+					tx.Rollback()
+					return c.Status(fiber.StatusInternalServerError).SendString("internal server error")
+				}
+				for _, orderItem := range orderItems {
+					res := tx.Model(&models.Product{}).
+						Where("id = ? AND stock >= ?", orderItem.ProductID, orderItem.Quantity).
+						Update("stock", gorm.Expr("stock - ?", orderItem.Quantity))
+					if res.Error != nil {
+						log.Println("failed to deduct stock for product:", orderItem.ProductID, res.Error)
+						tx.Rollback()
+						return c.Status(fiber.StatusInternalServerError).SendString("internal server error")
+					}
+					if res.RowsAffected == 0 {
+						refundReason = "out of stock"
+						break
+					}
+				}
+				if refundReason != "" {
+					if err := tx.RollbackTo("stock").Error; err != nil {
+						log.Println("failed to roll back stock deduction for order:", order.ID, err)
+						tx.Rollback()
+						return c.Status(fiber.StatusInternalServerError).SendString("internal server error")
+					}
+					if err := tx.Model(&order).Updates(models.Order{Status: "cancelled", UpdatedAt: time.Now()}).Error; err != nil {
+						log.Println("db update order status err:", err)
+						tx.Rollback()
+						return c.Status(fiber.StatusInternalServerError).SendString("internal server error")
+					}
+				}
 			}
 
-			// Deduct stock
-			for _, orderItem := range orderItems {
-				if err := tx.Model(&models.Product{}).
-					Where("id = ?", orderItem.ProductID).
-					Update("stock", gorm.Expr("stock - ?", orderItem.Quantity)).Error; err != nil {
-					log.Println("failed to deduct stock for product:", orderItem.ProductID, err)
+			if refundReason != "" {
+				log.Println("payment", payment.ID, "for order", order.ID, "needs a refund:", refundReason)
+				if err := tx.Model(&payment).
+					Updates(map[string]interface{}{
+						"status":         "refund_due",
+						"failure_reason": refundReason,
+						"mpesa_receipt":  receipt,
+						"phone":          phone,
+						"amount_cents":   amount * 100,
+						"updated_at":     time.Now(),
+					}).Error; err != nil {
+					log.Println("db update payment err:", err)
 					tx.Rollback()
 					return c.Status(fiber.StatusInternalServerError).SendString("internal server error")
 				}
+				if err := tx.Commit().Error; err != nil {
+					log.Println("failed to commit transaction:", err)
+					return c.Status(fiber.StatusInternalServerError).SendString("internal server error")
+				}
+				return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
+			}
+
+			// Update Payment status
+			if err := tx.Model(&payment).
+				Updates(map[string]interface{}{
+					"status":        "paid",
+					"mpesa_receipt": receipt,
+					"phone":         phone,
+					"amount_cents":  amount * 100,
+					"updated_at":    time.Now(),
+				}).Error; err != nil {
+				log.Println("db update payment err:", err)
+				tx.Rollback()
+				return c.Status(fiber.StatusInternalServerError).SendString("internal server error")
 			}
 
-			// Clear cart
-			if err := tx.Where("user_id = ?", order.BuyerID).Delete(&models.CartItem{}).Error; err != nil {
+			// Clear cart, keeping items saved for later
+			if err := tx.Where("user_id = ? AND saved_for_later = ?", order.BuyerID, false).Delete(&models.CartItem{}).Error; err != nil {
 				log.Println("failed to clear cart for user:", order.BuyerID, err)
 				tx.Rollback()
 				return c.Status(fiber.StatusInternalServerError).SendString("internal server error")
@@ -152,21 +213,18 @@ func StkCallbackHandler(dbConn *gorm.DB) fiber.Handler {
 				return c.Status(fiber.StatusNotFound).SendString("payment not found for failed STK")
 			}
 
-			_ = tx.Model(&payment).
-				Updates(map[string]interface{}{
-					"status":     "failed",
-					"updated_at": time.Now(),
-				}).Error
-
-			var order models.Order
-			if err := tx.Where("id = ?", payment.OrderID).First(&order).Error; err != nil {
-				log.Println("order not found for failed payment_id:", payment.ID, err)
-				tx.Rollback()
-				return c.Status(fiber.StatusNotFound).SendString("order not found for failed payment")
+			// Only the prompt failed: the order stays pending, holding its stock,
+			// so the buyer can be prompted again until its hold runs out. A
+			// superseded or settled payment is left as it is.
+			if payment.Status == "initiated" || payment.Status == "pending" {
+				_ = tx.Model(&payment).
+					Updates(map[string]interface{}{
+						"status":         "failed",
+						"failure_reason": sc.ResultDesc,
+						"updated_at":     time.Now(),
+					}).Error
 			}
 
-			_ = tx.Model(&order).Updates(models.Order{Status: "failed", UpdatedAt: time.Now()}).Error
-
 			if err := tx.Commit().Error; err != nil {
 				log.Println("failed to commit transaction for failed STK:", err)
 				return c.Status(fiber.StatusInternalServerError).SendString("internal server error")
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -26,6 +27,10 @@ type StkRequest struct {
 	TransactionDesc   string `json:"TransactionDesc"`
 }
 
+// ErrSTKRejected means M-Pesa refused the request itself (e.g. a bad phone
+// number or amount); sending it again will not help
+var ErrSTKRejected = errors.New("mpesa rejected the stk push")
+
 func InitiateSTK(phone string, amount int, accountRef, orderID string) (checkoutRequestID string, err error) {
 	token, err := GetAccessToken()
 	if err != nil {
@@ -72,6 +77,9 @@ func InitiateSTK(phone string, amount int, accountRef, orderID string) (checkout
 		log.Println("M-Pesa STK Response:", r)
 	}
 
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusTooManyRequests {
+		return "", fmt.Errorf("%w: %v", ErrSTKRejected, r)
+	}
 	if resp.StatusCode != 200 {
 		return "", fmt.Errorf("mpesa stk push error: %v", r)
 	}
@@ -1,50 +1,76 @@
 package payments
 
 import (
-	"fmt"
+	"errors"
+	"log"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 
 	"trumall/internal/models"
-	"trumall/mpesa"
+	"trumall/internal/services"
+	"trumall/internal/validation"
 )
 
+// CreateMpesaPaymentHandler sends the buyer an M-Pesa prompt for one of their
+// pending orders, e.g. to retry after a declined or timed-out prompt. A prompt
+// still open for the same phone is returned instead of sending another.
 func CreateMpesaPaymentHandler(dbConn *gorm.DB) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		user := c.Locals("user").(models.User)
+
 		var body struct {
-			OrderID string `json:"order_id"`
-			Phone   string `json:"phone"`
+			OrderID uuid.UUID `json:"order_id"`
+			Phone   string    `json:"phone"`
 		}
 		if err := c.BodyParser(&body); err != nil {
-			return c.Status(400).SendString("invalid request")
+			return c.Status(400).JSON(fiber.Map{"error": "invalid request"})
 		}
 
-		// lookup order, get amount
-		var order models.Order
-		if err := dbConn.First(&order, "id = ?", body.OrderID).Error; err != nil {
-			return c.Status(404).SendString("order not found")
+		// Pre-fill the M-Pesa number from the buyer's verified phone
+		if body.Phone == "" && user.Phone != nil && user.PhoneVerifiedAt != nil {
+			body.Phone = *user.Phone
 		}
-
-		checkoutID, err := mpesa.InitiateSTK(body.Phone, int(order.TotalCents/100), order.ID.String(), order.ID.String())
+		if body.Phone == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "phone number required for M-Pesa payment"})
+		}
+		phone, err := validation.NormalizePhone(body.Phone)
 		if err != nil {
-			return c.Status(500).SendString(fmt.Sprintf("stk push failed: %v", err))
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
 
-		// Save payment record
-		payment := models.Payment{
-			OrderID:           order.ID,
-			Provider:          "mpesa",
-			AmountCents:       order.TotalCents,
-			Currency:          "KES",
-			Status:            "pending",
-			CheckoutRequestID: &checkoutID,
-			Phone:             &body.Phone,
+		payment, reused, err := services.NewPaymentService(dbConn).StartMpesa(user.ID, body.OrderID, phone)
+		switch {
+		case errors.Is(err, services.ErrOrderNotFound):
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, services.ErrOrderNotPayable), errors.Is(err, services.ErrPaymentInProgress):
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			log.Printf("Error starting M-Pesa payment for order %s: %v", body.OrderID, err)
+			return c.Status(500).JSON(fiber.Map{"error": "failed to start payment"})
 		}
-		if err := dbConn.Create(&payment).Error; err != nil {
-			return c.Status(500).SendString("failed to save payment")
+
+		if payment.Status == "failed" {
+			reason := "unknown error"
+			if payment.FailureReason != nil {
+				reason = *payment.FailureReason
+			}
+			return c.Status(502).JSON(fiber.Map{
+				"error":      "failed to send the M-Pesa payment prompt: " + reason,
+				"order_id":   payment.OrderID,
+				"payment_id": payment.ID,
+			})
 		}
 
-		return c.JSON(payment)
+		return c.JSON(fiber.Map{
+			"order_id":            payment.OrderID,
+			"payment_id":          payment.ID,
+			"payment_status":      payment.Status,            // "pending" once the prompt is sent, "initiated" while it is retried
+			"checkout_request_id": payment.CheckoutRequestID, // null until the prompt is sent
+			"amount_cents":        payment.AmountCents,
+			"phone":               phone,
+			"reused":              reused, // true when an open prompt was returned rather than a new one sent
+		})
 	}
 }
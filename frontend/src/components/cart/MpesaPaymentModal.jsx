@@ -11,7 +11,7 @@
  * To use: Rename this file to MpesaPaymentModal.jsx
  */
 
-import React, { useState, useEffect, useCallback } from "react";
+import React, { useState, useEffect, useCallback, useRef } from "react";
 import {
   Phone,
   Loader2,
@@ -39,6 +39,15 @@ const MpesaPaymentModal = ({
   const [paymentMethod, setPaymentMethod] = useState("");
   const [isWaitingForConfirmation, setIsWaitingForConfirmation] =
     useState(false);
+  // Idempotency-Key for the current checkout attempt. A double tap or a retry
+  // after a dropped connection sends the same key, so the server replays the
+  // first order instead of placing a second one and sending another STK push.
+  const checkoutKeyRef = useRef(null);
+
+  // Prices that changed since the items were added, from a 409 at checkout,
+  // and the new cart total once the buyer has accepted it
+  const [priceChanges, setPriceChanges] = useState(null);
+  const [acceptedCartTotal, setAcceptedCartTotal] = useState(null);
 
   // Address states
   const [addresses, setAddresses] = useState([]);
@@ -49,6 +58,8 @@ const MpesaPaymentModal = ({
   const [selectedShippingMethod, setSelectedShippingMethod] = useState(null);
   const [shippingCost, setShippingCost] = useState(0);
   const [estimatedDelivery, setEstimatedDelivery] = useState("");
+  const [deliveryWindow, setDeliveryWindow] = useState(null);
+  const [shippingQuoteId, setShippingQuoteId] = useState("");
   const [isLoadingShipping, setIsLoadingShipping] = useState(false);
   const [shippingError, setShippingError] = useState("");
 
@@ -171,6 +182,8 @@ const MpesaPaymentModal = ({
 
       setShippingCost(response.data.shipping_cost_cents || 0);
       setEstimatedDelivery(response.data.estimated_delivery || "");
+      setDeliveryWindow(response.data.delivery_window || null);
+      setShippingQuoteId(response.data.quote_id || "");
 
       if (response.data.is_free_shipping) {
         showToast("Free shipping applied!", "success");
@@ -179,6 +192,7 @@ const MpesaPaymentModal = ({
       console.error("Error calculating shipping:", error);
       setShippingError("Failed to calculate shipping cost");
       setShippingCost(0);
+      setShippingQuoteId("");
     } finally {
       setIsLoadingShipping(false);
     }
@@ -189,6 +203,8 @@ const MpesaPaymentModal = ({
     setShippingError,
     setShippingCost,
     setEstimatedDelivery,
+    setDeliveryWindow,
+    setShippingQuoteId,
     showToast,
   ]);
 
@@ -201,6 +217,8 @@ const MpesaPaymentModal = ({
       setPhoneError("");
       setPaymentMethod("");
       setShippingError("");
+      setPriceChanges(null);
+      setAcceptedCartTotal(null);
     }
   }, [showModal, fetchAddresses]);
 
@@ -245,7 +263,7 @@ const MpesaPaymentModal = ({
     }
   };
 
-  const handleCheckout = async () => {
+  const handleCheckout = async (acceptedTotalCents = acceptedCartTotal) => {
     if (!paymentMethod) {
       showToast("Please select a payment method", "error");
       return;
@@ -265,6 +283,9 @@ const MpesaPaymentModal = ({
         return;
       }
 
+      if (!checkoutKeyRef.current) {
+        checkoutKeyRef.current = crypto.randomUUID();
+      }
       setIsProcessing(true);
       try {
         const formattedPhone = formatPhone(phoneNumber);
@@ -276,24 +297,32 @@ const MpesaPaymentModal = ({
             phone: formattedPhone,
             address_id: selectedAddressId,
             shipping_method: selectedShippingMethod.method_code,
+            shipping_quote_id: shippingQuoteId,
+            ...(acceptedTotalCents != null && {
+              accepted_cart_total_cents: acceptedTotalCents,
+            }),
           },
           {
             headers: {
               Authorization: `Bearer ${localStorage.getItem("token")}`,
+              "Idempotency-Key": checkoutKeyRef.current,
             },
           }
         );
+        checkoutKeyRef.current = null;
 
         const {
           order_id,
           checkout_request_id,
           shipping_cost_cents,
           estimated_delivery,
+          delivery_window,
         } = response.data;
 
         // Update shipping info from response
         setShippingCost(shipping_cost_cents);
         setEstimatedDelivery(estimated_delivery);
+        setDeliveryWindow(delivery_window || null);
 
         showToast(
           "STK Push sent! Check your phone to complete payment.",
@@ -352,6 +381,27 @@ const MpesaPaymentModal = ({
         }, 3000);
       } catch (err) {
         console.error("Checkout error:", err);
+        // The server keeps the response to a key unless it was a conflict or a
+        // server error; a fresh attempt after one it kept needs a new key
+        const status = err.response?.status;
+        if (status && status < 500 && status !== 409) {
+          checkoutKeyRef.current = null;
+        }
+        // Quote expired or cart changed: fetch a fresh shipping quote
+        if (status === 409) {
+          calculateShippingCost();
+        }
+        // Prices moved since the items were added: show the changes and let
+        // the buyer accept the new total rather than failing the payment
+        if (status === 409 && err.response.data?.price_changes) {
+          setPriceChanges({
+            changes: err.response.data.price_changes,
+            cartTotalCents: err.response.data.cart_total_cents,
+          });
+          setAcceptedCartTotal(null);
+          setIsProcessing(false);
+          return;
+        }
         onPaymentError(err);
         showToast(
           err.response?.data?.error ||
@@ -380,7 +430,22 @@ const MpesaPaymentModal = ({
 
   if (!showModal) return null;
 
-  const finalTotal = totalAmount + shippingCost / 100;
+  const cartTotal =
+    acceptedCartTotal != null ? acceptedCartTotal / 100 : totalAmount;
+  const finalTotal = cartTotal + shippingCost / 100;
+
+  const formatAmount = (amount) =>
+    new Intl.NumberFormat("en-US", {
+      style: "currency",
+      currency: currency,
+    }).format(amount);
+
+  const acceptPriceChanges = () => {
+    const total = priceChanges.cartTotalCents;
+    setAcceptedCartTotal(total);
+    setPriceChanges(null);
+    handleCheckout(total);
+  };
 
   return (
     <div className="fixed inset-0 bg-black/50 backdrop-blur-sm flex items-center justify-center z-[10000] p-4">
@@ -515,7 +580,7 @@ const MpesaPaymentModal = ({
               {new Intl.NumberFormat("en-US", {
                 style: "currency",
                 currency: currency,
-              }).format(totalAmount)}
+              }).format(cartTotal)}
             </span>
           </div>
 
@@ -560,11 +625,20 @@ const MpesaPaymentModal = ({
                 Estimated Delivery:
               </span>
               <span className="font-semibold text-gray-900">
-                {new Date(estimatedDelivery).toLocaleDateString("en-US", {
-                  month: "short",
-                  day: "numeric",
-                  year: "numeric",
-                })}
+                {deliveryWindow &&
+                deliveryWindow.earliest !== deliveryWindow.latest
+                  ? `${new Date(deliveryWindow.earliest).toLocaleDateString(
+                      "en-US",
+                      { weekday: "short", month: "short", day: "numeric" }
+                    )} – ${new Date(deliveryWindow.latest).toLocaleDateString(
+                      "en-US",
+                      { weekday: "short", month: "short", day: "numeric" }
+                    )}`
+                  : new Date(estimatedDelivery).toLocaleDateString("en-US", {
+                      month: "short",
+                      day: "numeric",
+                      year: "numeric",
+                    })}
               </span>
             </div>
           )}
@@ -638,6 +712,43 @@ const MpesaPaymentModal = ({
           </div>
         )}
 
+        {/* Price Changes */}
+        {priceChanges && (
+          <div className="mb-6 p-3 bg-yellow-50 border border-yellow-200 rounded-lg text-sm">
+            <div className="flex items-center gap-2 font-medium text-yellow-800 mb-2">
+              <AlertCircle className="w-4 h-4" />
+              Prices in your cart have changed
+            </div>
+            <ul className="space-y-1 text-gray-700 mb-3">
+              {priceChanges.changes.map((change) => (
+                <li
+                  key={change.cart_item_id}
+                  className="flex justify-between gap-2"
+                >
+                  <span className="truncate">{change.title}</span>
+                  <span className="whitespace-nowrap">
+                    <span className="line-through text-gray-400 mr-1">
+                      {formatAmount(change.old_price_cents / 100)}
+                    </span>
+                    {formatAmount(change.new_price_cents / 100)}
+                  </span>
+                </li>
+              ))}
+            </ul>
+            <div className="flex justify-between font-medium text-gray-900 mb-3">
+              <span>New cart total</span>
+              <span>{formatAmount(priceChanges.cartTotalCents / 100)}</span>
+            </div>
+            <button
+              onClick={acceptPriceChanges}
+              disabled={isProcessing || isLoadingShipping}
+              className="w-full px-4 py-2 rounded-lg font-medium bg-green-600 text-white hover:bg-green-700 disabled:opacity-50 disabled:cursor-not-allowed transition-colors"
+            >
+              Accept new prices and pay
+            </button>
+          </div>
+        )}
+
         {/* Modal Actions */}
         <div className="flex gap-3">
           <button
@@ -648,9 +759,10 @@ const MpesaPaymentModal = ({
             Cancel
           </button>
           <button
-            onClick={handleCheckout}
+            onClick={() => handleCheckout()}
             disabled={
               isProcessing ||
+              !!priceChanges ||
               isWaitingForConfirmation ||
               isLoadingShipping ||
               !paymentMethod ||
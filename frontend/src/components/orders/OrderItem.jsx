@@ -72,7 +72,8 @@ const OrderItem = ({ order }) => {
                 order.status
               )}`}
             >
-              {order.status.charAt(0).toUpperCase() + order.status.slice(1)}
+              {order.status.charAt(0).toUpperCase() +
+                order.status.slice(1).replace(/_/g, " ")}
             </div>
             <div className="text-right">
               <p className="font-bold text-xl text-gray-900">
@@ -135,19 +136,30 @@ const OrderItem = ({ order }) => {
             <p className="text-gray-600 text-sm mb-1">
               Estimated delivery:{" "}
               <span className="font-medium">
-                {/* Placeholder for estimated delivery, as it's not in backend data */}
-                N/A
+                {order.estimated_delivery_earliest
+                  ? `${formatDate(order.estimated_delivery_earliest)} – ${formatDate(order.estimated_delivery)}`
+                  : order.estimated_delivery
+                  ? formatDate(order.estimated_delivery)
+                  : "N/A"}
               </span>
             </p>
-            {/* Placeholder for tracking number, as it's not in backend data */}
-            {/* order.trackingNumber && (
-              <p className="text-gray-600 text-sm">
-                Tracking:{" "}
-                <span className="font-mono text-blue-600">
-                  {order.trackingNumber}
-                </span>
-              </p>
-            )*/}
+            {(order.shipments || []).map((shipment) => (
+              <div key={shipment.id} className="mt-2">
+                <p className="text-gray-600 text-sm">
+                  {shipment.carrier_name} tracking:{" "}
+                  <span className="font-mono text-blue-600">
+                    {shipment.tracking_number}
+                  </span>
+                </p>
+                {(shipment.events || []).slice(0, 3).map((event) => (
+                  <p key={event.id} className="text-gray-500 text-xs ml-2">
+                    {formatDate(event.occurred_at)} ·{" "}
+                    {event.description || event.status.replace(/_/g, " ")}
+                    {event.location && ` (${event.location})`}
+                  </p>
+                ))}
+              </div>
+            ))}
           </div>
 
           <div className="p-4 bg-green-50 rounded-xl border border-green-100">
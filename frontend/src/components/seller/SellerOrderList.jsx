@@ -1,6 +1,32 @@
 import React from "react";
 import { ShoppingBag, Package, Clock, CheckCircle, Truck } from "lucide-react";
 
+// Status changes sellers can make by hand; payment alone makes an order paid
+const nextStatuses = {
+  pending: ["cancelled"],
+  paid: ["processing", "shipped", "ready_for_pickup"],
+  processing: ["shipped", "ready_for_pickup"],
+  shipped: ["ready_for_pickup", "delivered"],
+  ready_for_pickup: ["delivered"],
+};
+
+const statusLabels = {
+  pending: "Pending",
+  paid: "Paid",
+  processing: "Processing",
+  shipped: "Shipped",
+  ready_for_pickup: "Ready for pickup",
+  delivered: "Delivered",
+  cancelled: "Cancelled",
+};
+
+const statusOptions = (order) => [
+  order.status,
+  ...(nextStatuses[order.status] || []).filter(
+    (status) => status !== "ready_for_pickup" || order.pickup_point_id
+  ),
+];
+
 const SellerOrderList = ({
   loadingOrders,
   orders,
@@ -64,12 +90,14 @@ const SellerOrderList = ({
                 <select
                   value={order.status}
                   onChange={(e) => handleStatusChange(order.id, e.target.value)}
-                  className="w-full sm:w-auto px-3 sm:px-4 py-2 rounded-lg border-2 border-slate-200 bg-white text-slate-700 text-sm sm:text-base font-medium focus:outline-none focus:border-orange-500 transition-colors cursor-pointer"
+                  disabled={statusOptions(order).length === 1}
+                  className="w-full sm:w-auto px-3 sm:px-4 py-2 rounded-lg border-2 border-slate-200 bg-white text-slate-700 text-sm sm:text-base font-medium focus:outline-none focus:border-orange-500 transition-colors cursor-pointer disabled:cursor-default disabled:opacity-70"
                 >
-                  <option value="pending">Pending</option>
-                  <option value="processing">Processing</option>
-                  <option value="shipped">Shipped</option>
-                  <option value="delivered">Delivered</option>
+                  {statusOptions(order).map((status) => (
+                    <option key={status} value={status}>
+                      {statusLabels[status] || status}
+                    </option>
+                  ))}
                 </select>
               </div>
 
@@ -111,7 +111,11 @@ const SellerDashboardPage = () => {
       showToast("Order status updated successfully!");
     } catch (error) {
       console.error("Error updating order status:", error);
-      showToast("Failed to update order status. Please try again.", "error");
+      showToast(
+        error.response?.data?.error ||
+          "Failed to update order status. Please try again.",
+        "error"
+      );
     }
   };
 